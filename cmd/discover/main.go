@@ -1,34 +1,81 @@
-// Command discover broadcasts for QTM instances on the local network.
+// Command discover broadcasts for QTM instances on the local network. With
+// -watch it keeps probing and reports QTM hosts as they come and go.
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/mlveggo/qualisys-go/pkg/discover"
 )
 
 func main() {
-	port := flag.Int("port", 4545, "local UDP port to receive discovery responses on")
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run holds the body of main so that log.Fatal is reached only after every
+// deferred cleanup has run. Calling log.Fatal directly would skip them.
+func run() error {
+	port := flag.Int("port", 4545, "local UDP port to receive discovery responses on; 0 picks a free one")
 	timeout := flag.Duration("timeout", 1*time.Second, "how long to wait for responses")
+	interfaces := flag.String("interface", "", "comma separated network interfaces to broadcast on; all when empty")
+	targets := flag.String("target", "", "comma separated addresses to probe, host or host:port")
+	watch := flag.Duration("watch", 0, "keep probing at this interval and report hosts appearing and disappearing")
 	flag.Parse()
 
 	discovery := discover.NewDiscovery(uint16(*port), *timeout)
+	discovery.Interfaces = splitList(*interfaces)
+	discovery.Targets = splitList(*targets)
 	// A command is the right place to write to the process logger; the library
 	// reports malformed replies through this hook instead of logging itself.
 	discovery.OnMalformedResponse = func(addr string, err error) {
 		log.Printf("ignoring response from %s: %v", addr, err)
 	}
-	responses, err := discovery.Discover()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *watch > 0 {
+		return discovery.Watch(ctx, *watch, func(e discover.Event) {
+			switch e.Kind {
+			case discover.Changed:
+				log.Printf("%v: %v (was %v)", e.Kind, &e.Response, &e.Previous)
+			case discover.Failed:
+				log.Printf("%v: %v", e.Kind, e.Err)
+			default:
+				log.Printf("%v: %v", e.Kind, &e.Response)
+			}
+		})
+	}
+
+	responses, err := discovery.DiscoverContext(ctx)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	if len(responses) == 0 {
 		log.Println("No QTM instances responded")
-		return
+		return nil
 	}
 	for _, response := range responses {
-		log.Println(response)
+		log.Println(&response)
+	}
+	return nil
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
 	}
+	return out
 }
@@ -0,0 +1,156 @@
+// Command gateway holds one QTM connection and fans its frames out to any
+// number of browser clients over WebSocket.
+//
+// Clients connect to ws://<listen>/stream and choose components, rate and
+// framing with query parameters; see package gateway for the details. Stream
+// health is served for Prometheus at http://<listen>/metrics.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/pkg/discover"
+	"github.com/mlveggo/qualisys-go/pkg/fanout"
+	"github.com/mlveggo/qualisys-go/pkg/gateway"
+	"github.com/mlveggo/qualisys-go/pkg/metrics"
+	"github.com/mlveggo/qualisys-go/pkg/settings"
+)
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run holds the body of main so that log.Fatal is reached only after every
+// deferred cleanup has run. Calling log.Fatal directly would skip them.
+func run() error {
+	addr := flag.String("addr", "", "QTM address; discovered by broadcast when empty")
+	qtm := flag.String("qtm", "", "QTM to pick by discovery when -addr is empty: a host name, or terms such as cameras>=8,version>=2024.2")
+	port := flag.Int("port", qualisys.DefaultBasePort, "QTM base port")
+	listen := flag.String("listen", ":8080", "HTTP address to serve WebSocket clients on")
+	components := flag.String("components", "3D,6D", "components to stream from QTM; clients may pick a subset")
+	useUDP := flag.Bool("udp", false, "stream data over UDP instead of the TCP control connection")
+	flag.Parse()
+
+	comps, err := qualisys.ParseComponentTypes(*components)
+	if err != nil {
+		return err
+	}
+	if len(comps) == 0 {
+		return errors.New("no components given")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	sel, err := discover.ParseSelector(*qtm)
+	if err != nil {
+		return err
+	}
+
+	hub := fanout.NewHub()
+	defer hub.Close()
+	handler := gateway.NewHandler(hub, comps...)
+	handler.OnError = func(remote string, err error) {
+		log.Printf("client %s: %v", remote, err)
+	}
+
+	reg := metrics.NewRegistry()
+	mux := http.NewServeMux()
+	mux.Handle("/stream", handler)
+	mux.Handle("/metrics", reg)
+	srv := &http.Server{Addr: *listen, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Serving WebSocket clients on %s/stream", *listen)
+		serveErr <- srv.ListenAndServe()
+	}()
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	// Keep the HTTP side up across QTM restarts: clients stay connected and
+	// simply see frames resume once the upstream connection is rebuilt.
+	const retryDelay = 2 * time.Second
+	for {
+		err := stream(ctx, *addr, *port, sel, comps, *useUDP, hub, handler, reg)
+		if ctx.Err() != nil {
+			log.Println("Shutting down")
+			return nil
+		}
+		log.Printf("QTM stream ended: %v; retrying in %v", err, retryDelay)
+		select {
+		case err := <-serveErr:
+			return err
+		case <-ctx.Done():
+			return nil
+		case <-time.After(retryDelay):
+		}
+	}
+}
+
+// stream connects to QTM, publishes frames into hub until the connection fails
+// or ctx is cancelled, and refreshes the names clients see.
+func stream(
+	ctx context.Context,
+	addr string,
+	basePort int,
+	sel discover.Selector,
+	comps []qualisys.ComponentType,
+	useUDP bool,
+	hub *fanout.Hub,
+	handler *gateway.Handler,
+	reg *metrics.Registry,
+) error {
+	rt, err := qualisys.DialAddr(ctx, addr, basePort, sel, qualisys.WithMetrics(reg))
+	if err != nil {
+		return err
+	}
+	defer rt.Disconnect()
+	major, minor := rt.Version()
+	log.Printf("Connected to %v using RT protocol version %d.%d", rt.RemoteAddr(), major, minor)
+
+	xml, err := rt.GetParameters(qualisys.ParameterType3D, qualisys.ParameterType6D)
+	if err != nil {
+		return err
+	}
+	var names gateway.Names
+	if names.Labels, err = settings.Parse3DLabelsFromXML(xml); err != nil {
+		return err
+	}
+	if names.Bodies, err = settings.Parse6DBodyNamesFromXML(xml); err != nil {
+		return err
+	}
+	handler.SetNames(names)
+
+	receive := rt.Receive
+	if useUDP {
+		udpPort, err := rt.EnableUDPStream(0)
+		if err != nil {
+			return err
+		}
+		if err := rt.StreamFramesUDP(
+			qualisys.StreamRateTypeAllFrames, 0, udpPort, "", qualisys.ComponentOptions{}, comps...,
+		); err != nil {
+			return err
+		}
+		receive = rt.ReceiveUDP
+	} else if err := rt.StreamFramesAll(comps...); err != nil {
+		return err
+	}
+	defer func() { _ = rt.StreamFramesStop() }()
+
+	return fanout.Run(ctx, receive, hub)
+}
@@ -0,0 +1,153 @@
+// Command mjpeg holds one QTM connection streaming camera images and serves
+// them to browsers as Motion JPEG.
+//
+// Every camera with image settings is enabled on startup. The index page at
+// http://<listen>/ shows a live preview of each; a single camera plays at
+// http://<listen>/camera/<id>. See package mjpeg for the query parameters.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/pkg/discover"
+	"github.com/mlveggo/qualisys-go/pkg/fanout"
+	"github.com/mlveggo/qualisys-go/pkg/mjpeg"
+	"github.com/mlveggo/qualisys-go/pkg/settings"
+)
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run holds the body of main so that log.Fatal is reached only after every
+// deferred cleanup has run. Calling log.Fatal directly would skip them.
+func run() error {
+	addr := flag.String("addr", "", "QTM address; discovered by broadcast when empty")
+	qtm := flag.String("qtm", "", "QTM to pick by discovery when -addr is empty: a host name, or terms such as cameras>=8,version>=2024.2")
+	port := flag.Int("port", qualisys.DefaultBasePort, "QTM base port")
+	password := flag.String("password", "", "password for TakeControl")
+	listen := flag.String("listen", ":8080", "HTTP address to serve the preview on")
+	rate := flag.Int("rate", 10, "frequency in Hz to stream images from QTM at; 0 streams every frame")
+	quality := flag.Int("quality", 0, "JPEG quality for re-encoded raw images, 1 to 100")
+	jpegFormat := flag.Bool("jpeg", false, "have the cameras send JPEG, saving bandwidth and re-encoding")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	sel, err := discover.ParseSelector(*qtm)
+	if err != nil {
+		return err
+	}
+
+	hub := fanout.NewHub()
+	defer hub.Close()
+	handler := mjpeg.NewHandler(hub)
+	handler.Quality = *quality
+	handler.OnError = func(remote string, err error) {
+		log.Printf("client %s: %v", remote, err)
+	}
+
+	srv := &http.Server{Addr: *listen, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Serving camera previews on %s", *listen)
+		serveErr <- srv.ListenAndServe()
+	}()
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	// Keep the HTTP side up across QTM restarts: open previews stay connected
+	// and simply see images resume once the upstream connection is rebuilt.
+	const retryDelay = 2 * time.Second
+	for {
+		err := stream(ctx, *addr, *port, sel, *password, *rate, *jpegFormat, hub, handler)
+		if ctx.Err() != nil {
+			log.Println("Shutting down")
+			return nil
+		}
+		log.Printf("QTM stream ended: %v; retrying in %v", err, retryDelay)
+		select {
+		case err := <-serveErr:
+			return err
+		case <-ctx.Done():
+			return nil
+		case <-time.After(retryDelay):
+		}
+	}
+}
+
+// stream connects to QTM, enables the cameras' images, and publishes frames
+// into hub until the connection fails or ctx is cancelled.
+func stream(
+	ctx context.Context,
+	addr string,
+	basePort int,
+	sel discover.Selector,
+	password string,
+	rate int,
+	jpegFormat bool,
+	hub *fanout.Hub,
+	handler *mjpeg.Handler,
+) error {
+	rt, err := qualisys.DialAddr(ctx, addr, basePort, sel)
+	if err != nil {
+		return err
+	}
+	defer rt.Disconnect()
+	major, minor := rt.Version()
+	log.Printf("Connected to %v using RT protocol version %d.%d", rt.RemoteAddr(), major, minor)
+
+	xml, err := rt.GetParameters(qualisys.ParameterTypeImage)
+	if err != nil {
+		return err
+	}
+	cameras, err := settings.ParseImageCamerasFromXML(xml)
+	if err != nil {
+		return err
+	}
+	for i := range cameras {
+		cameras[i].Enabled = true
+		if jpegFormat {
+			cameras[i].Format = "JPG"
+		}
+	}
+	inner, err := settings.ImageSettingsXML(cameras)
+	if err != nil {
+		return err
+	}
+	if err := rt.TakeControl(password); err != nil {
+		return err
+	}
+	err = rt.SetParameters(inner)
+	_ = rt.ReleaseControl()
+	if err != nil {
+		return err
+	}
+	handler.SetCameras(cameras)
+
+	if rate > 0 {
+		err = rt.StreamFrames(qualisys.StreamRateTypeFrequency, rate, qualisys.ComponentTypeImage)
+	} else {
+		err = rt.StreamFramesAll(qualisys.ComponentTypeImage)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rt.StreamFramesStop() }()
+
+	return fanout.Run(ctx, rt.Receive, hub)
+}
@@ -0,0 +1,169 @@
+// Command oscbridge streams QTM data to an OSC receiver such as Max/MSP,
+// TouchDesigner or Ableton over UDP.
+//
+// Addresses default to /qtm/3d/<label>, /qtm/6d/<body>,
+// /qtm/skeleton/<skeleton>/<segment> and /qtm/analog/<device>/<channel>; see
+// package osc for the placeholders the -marker, -body, -segment and -analog
+// templates accept.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/pkg/discover"
+	"github.com/mlveggo/qualisys-go/pkg/osc"
+)
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run holds the body of main so that log.Fatal is reached only after every
+// deferred cleanup has run. Calling log.Fatal directly would skip them.
+func run() error {
+	addr := flag.String("addr", "", "QTM address; discovered by broadcast when empty")
+	qtm := flag.String("qtm", "", "QTM to pick by discovery when -addr is empty: a host name, or terms such as cameras>=8,version>=2024.2")
+	port := flag.Int("port", qualisys.DefaultBasePort, "QTM base port")
+	target := flag.String("target", "127.0.0.1:9000", "OSC receiver address")
+	components := flag.String("components", "3D,6D", "components to stream, e.g. 3D,6D,Skeleton,Analog")
+	marker := flag.String("marker", osc.DefaultAddresses.Marker, "address template for 3D markers; empty disables")
+	body := flag.String("body", osc.DefaultAddresses.Body, "address template for 6D bodies; empty disables")
+	segment := flag.String("segment", osc.DefaultAddresses.Segment, "address template for skeleton segments; empty disables")
+	analog := flag.String("analog", osc.DefaultAddresses.Analog, "address template for analog channels; empty disables")
+	immediate := flag.Bool("immediate", false, "send bundles with the 'immediately' time tag instead of frame times")
+	flag.Parse()
+
+	comps, err := qualisys.ParseComponentTypes(*components)
+	if err != nil {
+		return err
+	}
+	if len(comps) == 0 {
+		return errors.New("no components given")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	sel, err := discover.ParseSelector(*qtm)
+	if err != nil {
+		return err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", *target)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	bridge := osc.NewBridge(conn)
+	bridge.Addresses = osc.Addresses{Marker: *marker, Body: *body, Segment: *segment, Analog: *analog}
+	bridge.Immediate = *immediate
+	log.Printf("Sending OSC to %s", *target)
+
+	const retryDelay = 2 * time.Second
+	for {
+		err := stream(ctx, *addr, *port, sel, comps, bridge)
+		if ctx.Err() != nil {
+			log.Println("Shutting down")
+			return nil
+		}
+		log.Printf("QTM stream ended: %v; retrying in %v", err, retryDelay)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(retryDelay):
+		}
+	}
+}
+
+// parametersFor lists the settings sections that name what comps carry.
+func parametersFor(comps []qualisys.ComponentType) []qualisys.ParameterType {
+	var params []qualisys.ParameterType
+	for _, c := range comps {
+		var p qualisys.ParameterType
+		switch c {
+		case qualisys.ComponentType3D, qualisys.ComponentType3DResidual:
+			p = qualisys.ParameterType3D
+		case qualisys.ComponentType6D, qualisys.ComponentType6DResidual,
+			qualisys.ComponentType6DEuler, qualisys.ComponentType6DEulerResidual:
+			p = qualisys.ParameterType6D
+		case qualisys.ComponentTypeSkeleton:
+			p = qualisys.ParameterTypeSkeleton
+		case qualisys.ComponentTypeAnalog, qualisys.ComponentTypeAnalogSingle:
+			p = qualisys.ParameterTypeAnalog
+		default:
+			continue
+		}
+		if !slices.Contains(params, p) {
+			params = append(params, p)
+		}
+	}
+	return params
+}
+
+// stream connects to QTM and forwards frames until the connection fails or ctx
+// is cancelled.
+func stream(ctx context.Context, addr string, basePort int, sel discover.Selector, comps []qualisys.ComponentType, bridge *osc.Bridge) error {
+	rt, err := qualisys.DialAddr(ctx, addr, basePort, sel)
+	if err != nil {
+		return err
+	}
+	defer rt.Disconnect()
+	major, minor := rt.Version()
+	log.Printf("Connected to %v using RT protocol version %d.%d", rt.RemoteAddr(), major, minor)
+
+	if params := parametersFor(comps); len(params) > 0 {
+		xml, err := rt.GetParameters(params...)
+		if err != nil {
+			return err
+		}
+		names, err := osc.NamesFromXML(xml)
+		if err != nil {
+			return err
+		}
+		bridge.SetNames(names)
+	}
+
+	if err := rt.StreamFramesAll(comps...); err != nil {
+		return err
+	}
+	defer func() { _ = rt.StreamFramesStop() }()
+
+	// Receive gives up after the read timeout, which is also what lets the
+	// loop notice an interrupt while QTM is idle.
+	for ctx.Err() == nil {
+		p, err := rt.Receive()
+		if err != nil {
+			if qualisys.IsTimeout(err) {
+				continue
+			}
+			return err
+		}
+		if p.Type == qualisys.PacketTypeEvent && p.Event == qualisys.EventTypeCameraSettingsChanged {
+			log.Println("Settings changed; reconnecting to pick up new names")
+			return nil
+		}
+		if p.Type != qualisys.PacketTypeData {
+			continue
+		}
+		if err := bridge.Send(&p.Data); err != nil {
+			// A receiver that is not running yet makes UDP writes fail with
+			// "connection refused"; keep streaming until it appears.
+			log.Println("send:", err)
+		}
+	}
+	return ctx.Err()
+}
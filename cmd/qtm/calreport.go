@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/pkg/calibration"
+)
+
+func runCalReport(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	var cfg config
+	cfg.register(fs)
+	t := calibration.DefaultThresholds
+	previous := fs.String("previous", "", "compare against the calibration result in this file")
+	fs.Float64Var(&t.MaxResidual, "max-residual", t.MaxResidual, "flag cameras whose residual exceeds this many mm; 0 disables")
+	fs.Float64Var(&t.MaxResidualIncrease, "max-increase", t.MaxResidualIncrease, "flag cameras whose residual grew by more than this many mm")
+	fs.Float64Var(&t.MaxMovement, "max-move", t.MaxMovement, "flag cameras that moved more than this many mm")
+	fs.Float64Var(&t.MaxRotation, "max-rotation", t.MaxRotation, "flag cameras that turned more than this many degrees")
+	asJSON := fs.Bool("json", false, "print the report as one line of JSON")
+	logFile := fs.String("log", "", "also append the report as a line of JSON to this file")
+	rest, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 1 {
+		return usagef("calreport takes at most one file")
+	}
+
+	var doc string
+	if len(rest) == 1 {
+		b, err := os.ReadFile(rest[0])
+		if err != nil {
+			return err
+		}
+		doc = string(b)
+	} else {
+		rt, err := cfg.connect(ctx)
+		if err != nil {
+			return err
+		}
+		defer rt.Disconnect()
+		if doc, err = rt.GetParameters(qualisys.ParameterTypeCalibration); err != nil {
+			return err
+		}
+	}
+	c, err := calibration.Parse(doc)
+	if err != nil {
+		return err
+	}
+	var prev *calibration.Calibration
+	if *previous != "" {
+		b, err := os.ReadFile(*previous)
+		if err != nil {
+			return err
+		}
+		if prev, err = calibration.Parse(string(b)); err != nil {
+			return fmt.Errorf("%s: %w", *previous, err)
+		}
+	}
+
+	r := calibration.NewReport(c, prev, t)
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if *logFile != "" {
+		f, err := os.OpenFile(*logFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(line)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if *asJSON {
+		_, err = os.Stdout.Write(line)
+	} else {
+		err = r.WriteText(os.Stdout)
+	}
+	if err != nil {
+		return err
+	}
+	if n := len(r.Flagged()); n > 0 {
+		return fmt.Errorf("calibration: %d of %d cameras flagged", n, len(r.Cameras))
+	}
+	return nil
+}
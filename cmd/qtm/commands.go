@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/pkg/discover"
+	"github.com/mlveggo/qualisys-go/pkg/settings"
+)
+
+func runDiscover(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	port := fs.Int("port", 0, "local UDP port to receive replies on; 0 picks a free one")
+	timeout := fs.Duration("timeout", qualisys.DefaultDiscoveryTimeout, "how long to wait for replies")
+	interfaces := fs.String("interface", "", "comma separated network interfaces to broadcast on; all when empty")
+	targets := fs.String("target", "", "comma separated addresses to probe, host or host:port")
+	watch := fs.Duration("watch", 0, "keep probing at this interval and report hosts appearing and disappearing")
+	if rest, err := parse(fs, args); err != nil {
+		return err
+	} else if len(rest) > 0 {
+		return usagef("discover takes no arguments")
+	}
+
+	d := discover.NewDiscovery(uint16(*port), *timeout)
+	d.Interfaces = splitList(*interfaces)
+	d.Targets = splitList(*targets)
+	if *watch > 0 {
+		return d.Watch(ctx, *watch, func(e discover.Event) {
+			if e.Kind == discover.Failed {
+				log.Print(e.Err)
+				return
+			}
+			fmt.Printf("%-11v %v\n", e.Kind, &e.Response)
+		})
+	}
+	responses, err := d.DiscoverContext(ctx)
+	if err != nil {
+		return err
+	}
+	for i := range responses {
+		fmt.Println(&responses[i])
+	}
+	return nil
+}
+
+func runStatus(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	var cfg config
+	cfg.register(fs)
+	if rest, err := parse(fs, args); err != nil {
+		return err
+	} else if len(rest) > 0 {
+		return usagef("status takes no arguments")
+	}
+	rt, err := cfg.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer rt.Disconnect()
+
+	version, err := rt.GetQTMVersion()
+	if err != nil {
+		return err
+	}
+	state, err := rt.GetState()
+	if err != nil {
+		return err
+	}
+	xml, err := rt.GetParameters(qualisys.ParameterTypeGeneral)
+	if err != nil {
+		return err
+	}
+	general, err := settings.ParseGeneralFromXML(xml)
+	if err != nil {
+		return err
+	}
+	major, minor := rt.Version()
+	fmt.Printf("QTM:       %s\n", version)
+	fmt.Printf("Protocol:  %d.%d\n", major, minor)
+	fmt.Printf("State:     %v\n", state)
+	fmt.Printf("Frequency: %g Hz\n", general.Frequency)
+	fmt.Printf("Cameras:   %d\n", len(general.Cameras))
+	for _, c := range general.Cameras {
+		fmt.Printf("  %3d  %-20s %-10s %s\n", c.ID, c.Model, c.Serial, c.Mode)
+	}
+	return nil
+}
+
+func runControl(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	var cfg config
+	cfg.register(fs)
+	rtFromFile := fs.Bool("rtfromfile", false, "start: play the loaded file back as real time data")
+	overwrite := fs.Bool("overwrite", false, "save: replace an existing file")
+	rest, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) == 0 {
+		return usagef("control needs an action")
+	}
+	action, operands := rest[0], rest[1:]
+	want := 0
+	switch action {
+	case "start", "stop", "new", "close", "trig":
+	case "save", "load", "event":
+		want = 1
+	default:
+		return usagef("unknown control action %q", action)
+	}
+	if len(operands) != want {
+		return usagef("control %s takes %d argument(s)", action, want)
+	}
+
+	rt, err := cfg.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer rt.Disconnect()
+	return cfg.withControl(rt, func() error {
+		switch action {
+		case "start":
+			return rt.Start(*rtFromFile)
+		case "stop":
+			return rt.Stop()
+		case "new":
+			return rt.New()
+		case "close":
+			return rt.Close()
+		case "save":
+			return rt.Save(operands[0], *overwrite)
+		case "load":
+			return rt.Load(operands[0])
+		case "trig":
+			return rt.Trig()
+		default:
+			return rt.SetQTMEvent(operands[0])
+		}
+	})
+}
+
+func runCalibrate(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	var cfg config
+	cfg.register(fs)
+	refine := fs.Bool("refine", false, "refine the current calibration instead of calibrating anew")
+	wait := fs.Duration("wait", qualisys.DefaultCalibrationTimeout, "how long to wait for the calibration to finish")
+	out := fs.String("o", "", "write the calibration result to this file instead of standard output")
+	if rest, err := parse(fs, args); err != nil {
+		return err
+	} else if len(rest) > 0 {
+		return usagef("calibrate takes no arguments")
+	}
+	rt, err := cfg.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer rt.Disconnect()
+
+	var result string
+	if err := cfg.withControl(rt, func() error {
+		result, err = rt.Calibrate(*refine, *wait)
+		return err
+	}); err != nil {
+		return err
+	}
+	if *out != "" {
+		return os.WriteFile(*out, []byte(result), 0o644)
+	}
+	fmt.Println(result)
+	return nil
+}
+
+func runLed(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	var cfg config
+	cfg.register(fs)
+	rest, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 3 {
+		return usagef("led needs a camera, a mode and a color")
+	}
+	camera, err := strconv.Atoi(rest[0])
+	if err != nil {
+		return usagef("camera %q is not a number", rest[0])
+	}
+	var mode qualisys.LedMode
+	switch strings.ToLower(rest[1]) {
+	case "on":
+		mode = qualisys.LedModeOn
+	case "off":
+		mode = qualisys.LedModeOff
+	case "pulsing":
+		mode = qualisys.LedModePulsing
+	default:
+		return usagef("unknown LED mode %q", rest[1])
+	}
+	var color qualisys.LedColor
+	switch strings.ToLower(rest[2]) {
+	case "amber":
+		color = qualisys.LedColorAmber
+	case "green":
+		color = qualisys.LedColorGreen
+	case "all":
+		color = qualisys.LedColorAll
+	default:
+		return usagef("unknown LED color %q", rest[2])
+	}
+
+	rt, err := cfg.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer rt.Disconnect()
+	return cfg.withControl(rt, func() error { return rt.Led(camera, mode, color) })
+}
+
+func runCapture(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	var cfg config
+	cfg.register(fs)
+	format := fs.String("format", "c3d", "file format: c3d or qtm")
+	out := fs.String("o", "", "file to write; capture.c3d or capture.qtm when empty")
+	idle := fs.Duration("idle", qualisys.DefaultFileTimeout, "give up when the transfer stalls for this long")
+	quiet := fs.Bool("q", false, "do not report progress")
+	rest, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 || rest[0] != "download" {
+		return usagef("capture needs the action download")
+	}
+	path := *out
+	if path == "" {
+		path = "capture." + *format
+	}
+	var download func(rt *qualisys.Protocol, ctx context.Context, path string, opts qualisys.DownloadOptions) (int64, error)
+	switch *format {
+	case "c3d":
+		download = (*qualisys.Protocol).DownloadCaptureC3DFile
+	case "qtm":
+		download = (*qualisys.Protocol).DownloadCaptureQTMFile
+	default:
+		return usagef("unknown capture format %q", *format)
+	}
+
+	rt, err := cfg.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer rt.Disconnect()
+	opts := qualisys.DownloadOptions{IdleTimeout: *idle}
+	if !*quiet {
+		opts.Progress = func(received, total int64) {
+			fmt.Fprintf(os.Stderr, "\r%.1f of %.1f MB", float64(received)/1e6, float64(total)/1e6)
+		}
+	}
+	// The file is written beside path and renamed into place once verified,
+	// so a failed download leaves an existing file at path untouched.
+	start := time.Now()
+	n, err := download(rt, ctx, path, opts)
+	if !*quiet {
+		fmt.Fprintln(os.Stderr)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %d bytes to %s in %v\n", n, path, time.Since(start).Round(time.Millisecond))
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/pkg/rig"
+)
+
+func runLayout(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	var cfg config
+	cfg.register(fs)
+	format := fs.String("format", "", "json, gltf or obj; taken from the -o extension when empty, else json")
+	depth := fs.Float64("depth", rig.DefaultDepth, "how far the frustums reach, in mm")
+	out := fs.String("o", "", "write to this file instead of standard output")
+	rest, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 1 {
+		return usagef("layout takes at most one file")
+	}
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(*out)), ".")
+	}
+	var write func(r *rig.Rig, w io.Writer) error
+	switch *format {
+	case "", "json":
+		write = func(r *rig.Rig, w io.Writer) error { return r.WriteJSON(w) }
+	case "gltf":
+		write = func(r *rig.Rig, w io.Writer) error { return r.WriteGLTF(w, *depth) }
+	case "obj":
+		write = func(r *rig.Rig, w io.Writer) error { return r.WriteOBJ(w, *depth) }
+	default:
+		return usagef("unknown layout format %q", *format)
+	}
+
+	var doc string
+	if len(rest) == 1 {
+		b, err := os.ReadFile(rest[0])
+		if err != nil {
+			return err
+		}
+		doc = string(b)
+	} else {
+		rt, err := cfg.connect(ctx)
+		if err != nil {
+			return err
+		}
+		defer rt.Disconnect()
+		if doc, err = rt.GetParameters(qualisys.ParameterTypeGeneral, qualisys.ParameterTypeCalibration); err != nil {
+			return err
+		}
+	}
+	r, err := rig.Parse(doc)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		return write(r, os.Stdout)
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	err = write(r, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
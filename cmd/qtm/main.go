@@ -0,0 +1,251 @@
+// Command qtm drives QTM from the command line: discovery, status, streaming,
+// capture control, calibration, its quality and camera layout, settings and
+// capture download.
+//
+//	qtm discover
+//	qtm status -qtm lab2
+//	qtm stream -addr 192.168.0.10 -components 3D,6D -format json
+//	qtm monitor -components 3D,6DResidual,Analog,Force
+//	qtm control start -password secret
+//	qtm calreport -previous yesterday.xml -log calibration.jsonl
+//	qtm layout -o rig.gltf
+//	qtm settings diff -addr 192.168.0.10 saved.xml
+//	qtm capture download -format c3d -o walk.c3d
+//	qtm session -var participant=P01 -var trial=3 gait.json
+//	qtm record -components 6D session.qtmtrace
+//	qtm replay -format json session.qtmtrace
+//
+// Every subcommand that talks to QTM takes the same -addr, -qtm, -port,
+// -password and -timeout flags. Run "qtm help" for the list of subcommands and
+// "qtm <subcommand> -h" for their flags.
+//
+// The exit status tells failures apart for scripts: 2 for bad usage or an
+// invalid session script, 3 when QTM could not be found or reached or the
+// connection broke, 4 for protocol failures such as timeouts, undecodable
+// packets and downloads that are not the file asked for, 5 when QTM refused a
+// command, and 1 for anything else.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/pkg/discover"
+	"github.com/mlveggo/qualisys-go/pkg/packets"
+	"github.com/mlveggo/qualisys-go/pkg/session"
+	"github.com/mlveggo/qualisys-go/pkg/trace"
+)
+
+const (
+	exitFailure    = 1
+	exitUsage      = 2
+	exitConnection = 3
+	exitProtocol   = 4
+	exitRejected   = 5
+)
+
+// usageError is a mistake on the command line.
+type usageError struct{ msg string }
+
+func (e usageError) Error() string { return e.msg }
+
+func usagef(format string, args ...any) error {
+	return usageError{fmt.Sprintf(format, args...)}
+}
+
+// connectionError marks a failure to find or reach QTM.
+type connectionError struct{ err error }
+
+func (e connectionError) Error() string { return e.err.Error() }
+func (e connectionError) Unwrap() error { return e.err }
+
+// exitCode maps an error to the exit status documented above.
+func exitCode(err error) int {
+	var usage usageError
+	var conn connectionError
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &usage), errors.Is(err, session.ErrInvalid):
+		return exitUsage
+	case errors.Is(err, qualisys.ErrRejected):
+		return exitRejected
+	case errors.Is(err, qualisys.ErrTimeout), errors.Is(err, qualisys.ErrTruncated),
+		errors.Is(err, qualisys.ErrVersionNotSupported), errors.Is(err, packets.ErrShortPacket),
+		errors.Is(err, trace.ErrFormat), errors.Is(err, qualisys.ErrInvalidFile):
+		return exitProtocol
+	case errors.As(err, &conn), errors.Is(err, qualisys.ErrNotConnected),
+		errors.Is(err, io.EOF), errors.As(err, &netErr):
+		return exitConnection
+	}
+	return exitFailure
+}
+
+// config is the connection configuration every subcommand shares.
+type config struct {
+	addr     string
+	selector string
+	port     int
+	password string
+	timeout  time.Duration
+}
+
+func (c *config) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.addr, "addr", "", "QTM address; discovered by broadcast when empty")
+	fs.StringVar(&c.selector, "qtm", "", "QTM to pick by discovery when -addr is empty: a host name, or terms such as cameras>=8,version>=2024.2")
+	fs.IntVar(&c.port, "port", qualisys.DefaultBasePort, "QTM base port")
+	fs.StringVar(&c.password, "password", "", "password for TakeControl")
+	fs.DurationVar(&c.timeout, "timeout", qualisys.DefaultConnectTimeout, "time allowed for connecting")
+}
+
+// connect finds QTM if need be and connects to it. There is no fallback to
+// this machine: a script should fail rather than act on a QTM it did not ask
+// for.
+func (c *config) connect(ctx context.Context, opts ...qualisys.Option) (*qualisys.Protocol, error) {
+	opts = append([]qualisys.Option{qualisys.WithConnectTimeout(c.timeout)}, opts...)
+	sel, err := discover.ParseSelector(c.selector)
+	if err != nil {
+		return nil, usageError{err.Error()}
+	}
+	rt, err := qualisys.DialAddr(ctx, c.addr, c.port, sel, opts...)
+	if err != nil {
+		if errors.Is(err, qualisys.ErrVersionNotSupported) {
+			return nil, err
+		}
+		return nil, connectionError{err}
+	}
+	return rt, nil
+}
+
+// withControl runs fn holding control of QTM, releasing it afterwards.
+func (c *config) withControl(rt *qualisys.Protocol, fn func() error) error {
+	if err := rt.TakeControl(c.password); err != nil {
+		return err
+	}
+	defer func() { _ = rt.ReleaseControl() }()
+	return fn()
+}
+
+type subcommand struct {
+	name    string
+	args    string
+	summary string
+	// run registers its flags on fs, parses args with parse and does its
+	// work.
+	run func(ctx context.Context, fs *flag.FlagSet, args []string) error
+}
+
+var subcommands = []subcommand{
+	{"discover", "", "list the QTM hosts on the network", runDiscover},
+	{"status", "", "show the QTM version, state and cameras", runStatus},
+	{"stream", "", "print streamed frames", runStream},
+	{"monitor", "", "show a live dashboard of the stream", runMonitor},
+	{"control", "start|stop|new|close|save FILE|load FILE|trig|event LABEL", "control the measurement", runControl},
+	{"calibrate", "", "calibrate the cameras and print the result", runCalibrate},
+	{"calreport", "[FILE]", "report on calibration quality", runCalReport},
+	{"layout", "[FILE]", "export the camera layout as JSON, glTF or OBJ", runLayout},
+	{"led", "CAMERA on|off|pulsing amber|green|all", "set a camera's LEDs", runLed},
+	{"settings", "get [SECTION...] | set FILE | diff FILE", "read, write or compare settings", runSettings},
+	{"capture", "download", "download the current capture", runCapture},
+	{"session", "SCRIPT", "run a scripted capture session", runSession},
+	{"record", "FILE", "record a stream to a trace file", runRecord},
+	{"replay", "FILE", "print the frames of a recorded trace", runReplay},
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: qtm <subcommand> [flags] [arguments]")
+	fmt.Fprintln(w)
+	for _, s := range subcommands {
+		fmt.Fprintf(w, "  %-10s %s\n", s.name, s.summary)
+	}
+}
+
+// flagSet returns a flag set for the subcommand whose parse errors come back
+// as usage errors rather than exiting.
+func (s subcommand) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("qtm "+s.name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: qtm %s [flags] %s\n", s.name, s.args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses a subcommand's flags, which may come before or after its
+// positional arguments, and returns the positional arguments.
+func parse(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, err
+			}
+			return nil, usageError{err.Error()}
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("qtm: ")
+	err := run(os.Args[1:])
+	if err != nil {
+		log.Print(err)
+	}
+	os.Exit(exitCode(err))
+}
+
+// run holds the body of main so that os.Exit is reached only after every
+// deferred cleanup has run. Calling os.Exit directly would skip them.
+func run(args []string) error {
+	if len(args) == 0 {
+		usage(os.Stderr)
+		return usagef("no subcommand given")
+	}
+	name := args[0]
+	if name == "help" || name == "-h" || name == "-help" || name == "--help" {
+		usage(os.Stdout)
+		return nil
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	for _, s := range subcommands {
+		if s.name == name {
+			err := s.run(ctx, s.flagSet(), args[1:])
+			if errors.Is(err, flag.ErrHelp) {
+				return nil
+			}
+			return err
+		}
+	}
+	usage(os.Stderr)
+	return usagef("unknown subcommand %q", name)
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/pkg/monitor"
+)
+
+// terminalWidth is the width shells export in COLUMNS, or the monitor's
+// default when they do not.
+func terminalWidth() int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	return monitor.DefaultWidth
+}
+
+func runMonitor(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	var cfg config
+	var sf streamFlags
+	cfg.register(fs)
+	sf.register(fs, "3D,6DResidual")
+	refresh := fs.Duration("refresh", 100*time.Millisecond, "how often to redraw the screen")
+	width := fs.Int("width", terminalWidth(), "terminal width in columns")
+	if rest, err := parse(fs, args); err != nil {
+		return err
+	} else if len(rest) > 0 {
+		return usagef("monitor takes no arguments")
+	}
+
+	rt, err := cfg.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer rt.Disconnect()
+	m := monitor.New()
+	if err := setNames(rt, m); err != nil {
+		return err
+	}
+	state, err := rt.GetState()
+	if err != nil {
+		return err
+	}
+	m.SetState(state)
+
+	fmt.Print(monitor.ClearScreen + monitor.HideCursor)
+	defer fmt.Print(monitor.ShowCursor)
+	var drawn time.Time
+	return sf.stream(ctx, rt, func(p *qualisys.Packet) error {
+		now := time.Now()
+		m.Update(p, now)
+		if p.Type == qualisys.PacketTypeEvent && p.Event == qualisys.EventTypeCameraSettingsChanged {
+			if err := setNames(rt, m); err != nil {
+				return err
+			}
+		}
+		// Idle reads come at least once a read timeout, so a stalled stream
+		// still redraws and shows how long it has been quiet.
+		if now.Sub(drawn) < *refresh && !p.EndOfData() {
+			return nil
+		}
+		drawn = now
+		return monitor.Draw(os.Stdout, m.Snapshot(), *width)
+	})
+}
+
+func setNames(rt *qualisys.Protocol, m *monitor.Monitor) error {
+	n, err := names(rt)
+	if err != nil {
+		return err
+	}
+	m.SetNames(n.Labels, n.Bodies)
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mlveggo/qualisys-go/pkg/session"
+)
+
+func runSession(ctx context.Context, fs *flag.FlagSet, args []string) (err error) {
+	var cfg config
+	cfg.register(fs)
+	vars := map[string]string{}
+	fs.Func("var", "variable for the script as name=value; repeatable", func(s string) error {
+		name, value, ok := strings.Cut(s, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("want name=value, got %q", s)
+		}
+		vars[name] = value
+		return nil
+	})
+	logPath := fs.String("log", "", "also append the session log to this file")
+	rest, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return usagef("session needs a script file")
+	}
+	script, err := session.Load(rest[0])
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if *logPath != "" {
+		f, err := os.OpenFile(*logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		out = io.MultiWriter(os.Stdout, f)
+	}
+
+	rt, err := cfg.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer rt.Disconnect()
+	r := &session.Runner{
+		Vars: vars,
+		Dir:  filepath.Dir(rest[0]),
+		Log:  func(e session.Entry) { fmt.Fprintln(out, e) },
+	}
+	_, err = r.Run(ctx, rt, script)
+	return err
+}
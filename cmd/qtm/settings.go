@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/pkg/settings"
+)
+
+// sections maps the names settings get accepts to parameter types.
+var sections = map[string]qualisys.ParameterType{
+	"all":         qualisys.ParameterTypeAll,
+	"general":     qualisys.ParameterTypeGeneral,
+	"calibration": qualisys.ParameterTypeCalibration,
+	"3d":          qualisys.ParameterType3D,
+	"6d":          qualisys.ParameterType6D,
+	"analog":      qualisys.ParameterTypeAnalog,
+	"force":       qualisys.ParameterTypeForce,
+	"image":       qualisys.ParameterTypeImage,
+	"gazevector":  qualisys.ParameterTypeGazeVector,
+	"eyetracker":  qualisys.ParameterTypeEyeTracker,
+	"skeleton":    qualisys.ParameterTypeSkeleton,
+}
+
+func runSettings(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	var cfg config
+	cfg.register(fs)
+	rest, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) == 0 {
+		return usagef("settings needs an action")
+	}
+	action, operands := rest[0], rest[1:]
+
+	var types []qualisys.ParameterType
+	var file []byte
+	switch action {
+	case "get":
+		for _, name := range operands {
+			t, ok := sections[strings.ToLower(name)]
+			if !ok {
+				return usagef("unknown settings section %q", name)
+			}
+			types = append(types, t)
+		}
+	case "set", "diff":
+		if len(operands) != 1 {
+			return usagef("settings %s needs a file", action)
+		}
+		if file, err = os.ReadFile(operands[0]); err != nil {
+			return err
+		}
+	default:
+		return usagef("unknown settings action %q", action)
+	}
+
+	rt, err := cfg.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer rt.Disconnect()
+	switch action {
+	case "get":
+		current, err := rt.GetParameters(types...)
+		if err != nil {
+			return err
+		}
+		fmt.Println(current)
+		return nil
+	case "set":
+		inner, err := settings.Fragment(string(file))
+		if err != nil {
+			return err
+		}
+		return cfg.withControl(rt, func() error { return rt.SetParameters(inner) })
+	}
+	current, err := rt.GetParameters(qualisys.ParameterTypeAll)
+	if err != nil {
+		return err
+	}
+	return diffSettings(os.Stdout, current, string(file))
+}
+
+// flatten turns a settings document into path/value pairs, one per element
+// text and attribute, such as "The_3D/Label[2]/Name". Repeated elements are
+// numbered from 1 and wrapper elements are left out of the paths, so a
+// GetParameters reply and a QTM_Settings file compare alike.
+func flatten(doc string) (map[string]string, error) {
+	out := map[string]string{}
+	dec := xml.NewDecoder(strings.NewReader(doc))
+	type level struct {
+		path   string
+		counts map[string]int
+		text   strings.Builder
+	}
+	stack := []*level{{counts: map[string]int{}}}
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			return out, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("settings: %w", err)
+		}
+		top := stack[len(stack)-1]
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if settings.IsWrapper(t.Name.Local) {
+				continue
+			}
+			top.counts[t.Name.Local]++
+			path := t.Name.Local
+			if n := top.counts[t.Name.Local]; n > 1 {
+				path = fmt.Sprintf("%s[%d]", path, n)
+			}
+			if top.path != "" {
+				path = top.path + "/" + path
+			}
+			for _, a := range t.Attr {
+				out[path+"/@"+a.Name.Local] = a.Value
+			}
+			stack = append(stack, &level{path: path, counts: map[string]int{}})
+		case xml.EndElement:
+			if settings.IsWrapper(t.Name.Local) {
+				continue
+			}
+			if text := strings.TrimSpace(top.text.String()); text != "" {
+				out[top.path] = text
+			}
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			top.text.Write(t)
+		}
+	}
+}
+
+// diffSettings writes the settings that differ between current and file: a
+// "-" line with the value in QTM and a "+" line with the value in the file.
+// Settings the file does not mention are not reported, since SetParameters
+// leaves them alone.
+func diffSettings(w io.Writer, current, file string) error {
+	have, err := flatten(current)
+	if err != nil {
+		return err
+	}
+	want, err := flatten(file)
+	if err != nil {
+		return err
+	}
+	paths := make([]string, 0, len(want))
+	for path := range want {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	for _, path := range paths {
+		old, ok := have[path]
+		if ok && old == want[path] {
+			continue
+		}
+		if ok {
+			fmt.Fprintf(w, "- %s: %s\n", path, old)
+		}
+		fmt.Fprintf(w, "+ %s: %s\n", path, want[path])
+	}
+	return nil
+}
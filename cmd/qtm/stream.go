@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/pkg/gateway"
+	"github.com/mlveggo/qualisys-go/pkg/settings"
+	"github.com/mlveggo/qualisys-go/pkg/trace"
+)
+
+// streamFlags are the flags stream and record share.
+type streamFlags struct {
+	components string
+	udp        bool
+	rate       int
+	frames     int
+	duration   time.Duration
+}
+
+// register adds the flags, streaming components unless told otherwise.
+func (s *streamFlags) register(fs *flag.FlagSet, components string) {
+	fs.StringVar(&s.components, "components", components, "comma separated components to stream, such as 3D,6DEuler,Analog")
+	fs.BoolVar(&s.udp, "udp", false, "receive frames over UDP instead of TCP")
+	fs.IntVar(&s.rate, "rate", 0, "frequency in Hz to stream at; 0 streams every frame")
+	fs.IntVar(&s.frames, "frames", 0, "stop after this many frames; 0 runs until interrupted")
+	fs.DurationVar(&s.duration, "duration", 0, "stop after this long; 0 runs until interrupted")
+}
+
+// stream starts streaming with the flags' settings and hands every packet to
+// handle, idle reads included, until ctx is cancelled, a limit is reached or
+// QTM shuts down.
+func (s *streamFlags) stream(ctx context.Context, rt *qualisys.Protocol, handle func(*qualisys.Packet) error) error {
+	components, err := qualisys.ParseComponentTypes(s.components)
+	if err != nil {
+		return usageError{err.Error()}
+	}
+	rateType, rate := qualisys.StreamRateTypeAllFrames, 0
+	if s.rate > 0 {
+		rateType, rate = qualisys.StreamRateTypeFrequency, s.rate
+	}
+	receive := rt.Receive
+	if s.udp {
+		udpPort, err := rt.EnableUDPStream(0)
+		if err != nil {
+			return err
+		}
+		err = rt.StreamFramesUDP(rateType, rate, udpPort, "", qualisys.ComponentOptions{}, components...)
+		if err != nil {
+			return err
+		}
+		receive = rt.ReceiveUDP
+	} else if err := rt.StreamFrames(rateType, rate, components...); err != nil {
+		return err
+	}
+	defer func() { _ = rt.StreamFramesStop() }()
+
+	if s.duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.duration)
+		defer cancel()
+	}
+	frames := 0
+	for ctx.Err() == nil && (s.frames == 0 || frames < s.frames) {
+		p, err := receive()
+		if err != nil {
+			return err
+		}
+		if err := handle(p); err != nil {
+			return err
+		}
+		if p.IsPacketData() {
+			frames++
+		}
+		if p.Type == qualisys.PacketTypeEvent && p.Event == qualisys.EventTypeQTMShuttingDown {
+			return nil
+		}
+	}
+	return nil
+}
+
+// names reads the marker labels and body names JSON frames are labelled with.
+func names(rt *qualisys.Protocol) (gateway.Names, error) {
+	var n gateway.Names
+	xml, err := rt.GetParameters(qualisys.ParameterType3D, qualisys.ParameterType6D)
+	if err != nil {
+		return n, err
+	}
+	return n, updateNames(&n, xml)
+}
+
+// updateNames sets the labels and body names a settings document holds,
+// keeping those of sections it lacks.
+func updateNames(n *gateway.Names, xml string) error {
+	labels, err := settings.Parse3DLabelsFromXML(xml)
+	if err != nil {
+		return err
+	}
+	bodies, err := settings.Parse6DBodyNamesFromXML(xml)
+	if err != nil {
+		return err
+	}
+	if len(labels) > 0 {
+		n.Labels = labels
+	}
+	if len(bodies) > 0 {
+		n.Bodies = bodies
+	}
+	return nil
+}
+
+// printer writes packets as text or as JSON lines.
+type printer struct {
+	w     io.Writer
+	json  bool
+	names gateway.Names
+}
+
+func newPrinter(format string) (*printer, error) {
+	switch format {
+	case "text":
+		return &printer{w: os.Stdout}, nil
+	case "json":
+		return &printer{w: os.Stdout, json: true}, nil
+	}
+	return nil, usagef("unknown output format %q", format)
+}
+
+func (pr *printer) print(p *qualisys.Packet) error {
+	switch p.Type {
+	case qualisys.PacketTypeEvent:
+		if pr.json {
+			_, err := fmt.Fprintf(pr.w, "{\"type\":\"event\",\"event\":%q}\n", p.Event)
+			return err
+		}
+		_, err := fmt.Fprintln(pr.w, "Event:", p.Event)
+		return err
+	case qualisys.PacketTypeData:
+		if pr.json {
+			b, err := gateway.EncodeJSON(&p.Data, pr.names)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(pr.w, "%s\n", b)
+			return err
+		}
+		for _, c := range p.Data.Components {
+			if _, err := fmt.Fprintf(pr.w, "Frame %d: %v\n", p.Data.Frame, c); err != nil {
+				return err
+			}
+		}
+		for _, unknown := range p.Data.UnknownComponentTypes() {
+			if _, err := fmt.Fprintf(pr.w, "Frame %d: undecodable component type %d\n", p.Data.Frame, unknown); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func runStream(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	var cfg config
+	var sf streamFlags
+	cfg.register(fs)
+	sf.register(fs, "3D")
+	format := fs.String("format", "text", "output format: text, or json for one object per line")
+	if rest, err := parse(fs, args); err != nil {
+		return err
+	} else if len(rest) > 0 {
+		return usagef("stream takes no arguments")
+	}
+	pr, err := newPrinter(*format)
+	if err != nil {
+		return err
+	}
+
+	rt, err := cfg.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer rt.Disconnect()
+	if pr.json {
+		if pr.names, err = names(rt); err != nil {
+			return err
+		}
+	}
+	return sf.stream(ctx, rt, pr.print)
+}
+
+func runRecord(ctx context.Context, fs *flag.FlagSet, args []string) (err error) {
+	var cfg config
+	var sf streamFlags
+	cfg.register(fs)
+	sf.register(fs, "3D")
+	rest, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return usagef("record needs a trace file")
+	}
+
+	f, err := os.Create(rest[0])
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	tw := trace.NewWriter(f)
+	rt, err := cfg.connect(ctx, qualisys.WithTracer(tw))
+	if err != nil {
+		return err
+	}
+	defer rt.Disconnect()
+	// The labels and names go into the trace too, so replay can label JSON
+	// frames the way stream does.
+	if _, err := names(rt); err != nil {
+		return err
+	}
+	frames := 0
+	start := time.Now()
+	err = sf.stream(ctx, rt, func(p *qualisys.Packet) error {
+		if p.IsPacketData() {
+			frames++
+		}
+		return tw.Err()
+	})
+	fmt.Fprintf(os.Stderr, "Recorded %d frames in %v\n", frames, time.Since(start).Round(time.Millisecond))
+	return err
+}
+
+func runReplay(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	format := fs.String("format", "text", "output format: text, or json for one object per line")
+	realtime := fs.Bool("realtime", false, "print packets at the pace they were recorded")
+	rest, err := parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 1 {
+		return usagef("replay needs a trace file")
+	}
+	pr, err := newPrinter(*format)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(rest[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tr, err := trace.NewReader(f)
+	if err != nil {
+		return err
+	}
+	var first, replayStart time.Time
+	for ctx.Err() == nil {
+		r, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if r.Direction != qualisys.DirectionReceived {
+			continue
+		}
+		var p qualisys.Packet
+		if err := p.UnmarshalBinary(r.Data); err != nil {
+			return err
+		}
+		if *realtime {
+			if first.IsZero() {
+				first, replayStart = r.Time, time.Now()
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(time.Until(replayStart.Add(r.Time.Sub(first)))):
+			}
+		}
+		if p.Type == qualisys.PacketTypeXML {
+			// Settings read while recording; they name the frames after them.
+			if err := updateNames(&pr.names, p.XMLResponse); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := pr.print(&p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+// Command relay connects to QTM once and re-serves the RT protocol to any
+// number of downstream clients.
+//
+// Point tools at the relay's host and -serve-port exactly as they would point
+// them at QTM. The relay listens on the little-endian port, serve-port + 1.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/pkg/discover"
+	"github.com/mlveggo/qualisys-go/pkg/fanout"
+	"github.com/mlveggo/qualisys-go/pkg/relay"
+)
+
+// errSettingsChanged ends a session so the cached settings are fetched again.
+var errSettingsChanged = errors.New("QTM settings changed")
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run holds the body of main so that log.Fatal is reached only after every
+// deferred cleanup has run. Calling log.Fatal directly would skip them.
+func run() error {
+	addr := flag.String("addr", "", "QTM address; discovered by broadcast when empty")
+	qtm := flag.String("qtm", "", "QTM to pick by discovery when -addr is empty: a host name, or terms such as cameras>=8,version>=2024.2")
+	port := flag.Int("port", qualisys.DefaultBasePort, "QTM base port")
+	servePort := flag.Int("serve-port", qualisys.DefaultBasePort, "base port downstream clients use for the relay")
+	components := flag.String("components", "3D,6D", "components to stream from QTM; clients may pick a subset")
+	flag.Parse()
+
+	comps, err := qualisys.ParseComponentTypes(*components)
+	if err != nil {
+		return err
+	}
+	if len(comps) == 0 {
+		return errors.New("no components given")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	sel, err := discover.ParseSelector(*qtm)
+	if err != nil {
+		return err
+	}
+
+	var lc net.ListenConfig
+	listen := fmt.Sprintf(":%d", *servePort+1)
+	ln, err := lc.Listen(ctx, "tcp", listen)
+	if err != nil {
+		return err
+	}
+
+	hub := fanout.NewHub()
+	defer hub.Close()
+	server := relay.NewServer(hub)
+	server.OnError = func(remote string, err error) {
+		log.Printf("client %s: %v", remote, err)
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Serving RT clients on %s (base port %d)", listen, *servePort)
+		serveErr <- server.Serve(ctx, ln)
+	}()
+
+	const retryDelay = 2 * time.Second
+	for {
+		err := stream(ctx, *addr, *port, sel, comps, hub, server)
+		if ctx.Err() != nil {
+			log.Println("Shutting down")
+			return <-serveErr
+		}
+		delay := retryDelay
+		if errors.Is(err, errSettingsChanged) {
+			delay = 0
+		}
+		log.Printf("QTM stream ended: %v; reconnecting in %v", err, delay)
+		select {
+		case err := <-serveErr:
+			return err
+		case <-ctx.Done():
+			return <-serveErr
+		case <-time.After(delay):
+		}
+	}
+}
+
+// stream connects to QTM, refreshes the relay's snapshot and publishes frames
+// into hub until the connection fails, the settings change, or ctx is
+// cancelled.
+func stream(
+	ctx context.Context,
+	addr string,
+	basePort int,
+	sel discover.Selector,
+	comps []qualisys.ComponentType,
+	hub *fanout.Hub,
+	server *relay.Server,
+) error {
+	rt, err := qualisys.DialAddr(ctx, addr, basePort, sel)
+	if err != nil {
+		return err
+	}
+	defer rt.Disconnect()
+	major, minor := rt.Version()
+	log.Printf("Connected to %v using RT protocol version %d.%d", rt.RemoteAddr(), major, minor)
+
+	up, err := relay.FetchUpstream(rt, comps...)
+	if err != nil {
+		return err
+	}
+	server.SetUpstream(up)
+	log.Printf("Cached %d settings sections from %s", len(up.Parameters), up.QTMVersion)
+
+	if err := rt.StreamFramesAll(comps...); err != nil {
+		return err
+	}
+	defer func() { _ = rt.StreamFramesStop() }()
+
+	// The snapshot is only valid until QTM's settings change. Downstream
+	// clients still see the event, and the next session refreshes the cache.
+	changed := false
+	receive := func() (*qualisys.Packet, error) {
+		if changed {
+			return nil, errSettingsChanged
+		}
+		p, err := rt.Receive()
+		if err == nil && p.Type == qualisys.PacketTypeEvent && p.Event == qualisys.EventTypeCameraSettingsChanged {
+			changed = true
+		}
+		return p, err
+	}
+	return fanout.Run(ctx, receive, hub)
+}
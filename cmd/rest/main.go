@@ -0,0 +1,115 @@
+// Command rest serves a JSON control API for QTM over HTTP.
+//
+// See package rest for the endpoints. For example, after starting the command:
+//
+//	curl -X POST localhost:8080/control -d '{"password":""}'
+//	curl -X POST localhost:8080/measurement/start
+//	curl localhost:8080/state
+//
+// The API has no authentication and lets its callers take control of QTM,
+// start and save captures and change settings, so by default it only listens
+// on this machine. To serve other hosts, bind a wider address explicitly, such
+// as -listen :8080 for every interface or -listen 192.168.0.5:8080 for one,
+// on a network where everyone who can reach the port may drive QTM.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/pkg/discover"
+	"github.com/mlveggo/qualisys-go/pkg/rest"
+)
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run holds the body of main so that log.Fatal is reached only after every
+// deferred cleanup has run. Calling log.Fatal directly would skip them.
+func run() error {
+	addr := flag.String("addr", "", "QTM address; discovered by broadcast when empty")
+	qtm := flag.String("qtm", "", "QTM to pick by discovery when -addr is empty: a host name, or terms such as cameras>=8,version>=2024.2")
+	port := flag.Int("port", qualisys.DefaultBasePort, "QTM base port")
+	listen := flag.String("listen", "localhost:8080", "HTTP address to serve the API on; use :8080 to serve every interface")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	sel, err := discover.ParseSelector(*qtm)
+	if err != nil {
+		return err
+	}
+
+	// Each QTM connection gets a fresh rest.Server; between connections the
+	// API answers 503 so clients can tell QTM is away rather than time out.
+	var current atomic.Pointer[rest.Server]
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api := current.Load()
+		if api == nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"error":"not connected to QTM"}` + "\n"))
+			return
+		}
+		api.ServeHTTP(w, r)
+	})
+
+	srv := &http.Server{Addr: *listen, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Serving the control API on %s", *listen)
+		serveErr <- srv.ListenAndServe()
+	}()
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	const retryDelay = 2 * time.Second
+	for {
+		err := serve(ctx, *addr, *port, sel, &current)
+		if ctx.Err() != nil {
+			log.Println("Shutting down")
+			return nil
+		}
+		log.Printf("QTM connection ended: %v; retrying in %v", err, retryDelay)
+		select {
+		case err := <-serveErr:
+			return err
+		case <-ctx.Done():
+			return nil
+		case <-time.After(retryDelay):
+		}
+	}
+}
+
+// serve connects to QTM and publishes an API for the connection in current
+// until the connection fails or ctx is cancelled.
+func serve(ctx context.Context, addr string, basePort int, sel discover.Selector, current *atomic.Pointer[rest.Server]) error {
+	rt, err := qualisys.DialAddr(ctx, addr, basePort, sel)
+	if err != nil {
+		return err
+	}
+	defer rt.Disconnect()
+	major, minor := rt.Version()
+	log.Printf("Connected to %v using RT protocol version %d.%d", rt.RemoteAddr(), major, minor)
+
+	api := rest.NewServer(rt)
+	defer api.Close()
+	current.Store(api)
+	defer current.Store(nil)
+	return api.Run(ctx)
+}
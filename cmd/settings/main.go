@@ -1,15 +1,20 @@
 // Command settings connects to QTM, enables all image cameras and streams the
-// resulting images.
+// resulting images, printing them or, with -out, saving them as one numbered
+// image sequence per camera.
+//
+//	go run ./cmd/settings -out images -format jpeg
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
-	"strings"
-	"time"
 
 	qualisys "github.com/mlveggo/qualisys-go"
 	"github.com/mlveggo/qualisys-go/pkg/discover"
+	"github.com/mlveggo/qualisys-go/pkg/imaging"
+	"github.com/mlveggo/qualisys-go/pkg/settings"
 )
 
 func main() {
@@ -22,48 +27,58 @@ func main() {
 // deferred cleanup has run. Calling log.Fatal directly would skip them.
 func run() error {
 	addr := flag.String("addr", "", "QTM address; discovered by broadcast when empty")
+	qtm := flag.String("qtm", "", "QTM to pick by discovery when -addr is empty: a host name, or terms such as cameras>=8,version>=2024.2")
 	port := flag.Int("port", qualisys.DefaultBasePort, "QTM base port")
 	password := flag.String("password", "", "password for TakeControl")
+	out := flag.String("out", "", "write the images to this directory instead of printing them")
+	format := flag.String("format", "png", "file format for -out: png or jpeg")
 	flag.Parse()
 
-	ip, basePort := *addr, *port
-	if ip == "" {
-		ip, basePort = "127.0.0.1", qualisys.DefaultBasePort
-		discovery := discover.NewDiscovery(4545, 1*time.Second)
-		if responses, err := discovery.Discover(); err == nil {
-			for _, response := range responses {
-				log.Println("Using the first QTM found:", response)
-				ip, basePort = response.Address, response.BasePort
-				break
-			}
+	var sink *imaging.FileSink
+	if *out != "" {
+		switch *format {
+		case "png":
+			sink = imaging.NewFileSink(*out, imaging.FormatPNG)
+		case "jpeg", "jpg":
+			sink = imaging.NewFileSink(*out, imaging.FormatJPEG)
+		default:
+			return fmt.Errorf("unknown image format %q", *format)
 		}
 	}
 
-	rt := qualisys.NewProtocol(ip, basePort)
-	defer rt.Disconnect()
+	sel, err := discover.ParseSelector(*qtm)
+	if err != nil {
+		return err
+	}
 
-	log.Printf("Connecting to %s:%d", ip, basePort)
-	if err := rt.Connect(); err != nil {
+	rt, err := qualisys.DialAddr(context.Background(), *addr, *port, sel)
+	if err != nil {
 		return err
 	}
+	defer rt.Disconnect()
 	major, minor := rt.Version()
-	log.Printf("Connected using RT protocol version %d.%d", major, minor)
+	log.Printf("Connected to %v using RT protocol version %d.%d", rt.RemoteAddr(), major, minor)
 
 	xml, err := rt.GetParameters(qualisys.ParameterTypeImage)
 	if err != nil {
 		return err
 	}
+	cameras, err := settings.ParseImageCamerasFromXML(xml)
+	if err != nil {
+		return err
+	}
+	for i := range cameras {
+		cameras[i].Enabled = true
+	}
+	inner, err := settings.ImageSettingsXML(cameras)
+	if err != nil {
+		return err
+	}
 	if err := rt.TakeControl(*password); err != nil {
 		return err
 	}
 	defer func() { _ = rt.ReleaseControl() }()
 
-	// The settings root element is named after the negotiated protocol version,
-	// so it must not be hard-coded. StripParametersElement removes whichever
-	// version was actually agreed on.
-	inner := rt.StripParametersElement(xml)
-	inner = strings.ReplaceAll(inner, "<Enabled>false</Enabled>", "<Enabled>true</Enabled>")
-
 	if err := rt.SetParameters(inner); err != nil {
 		return err
 	}
@@ -79,6 +94,12 @@ func run() error {
 		if p.EndOfData() {
 			continue
 		}
+		if sink != nil {
+			if err := sink.WriteFrame(p.Data.Frame, p.Data.Images()); err != nil {
+				return err
+			}
+			continue
+		}
 		for _, c := range p.Data.Components {
 			log.Printf("Frame %d: %v", p.Data.Frame, c)
 		}
@@ -6,13 +6,14 @@ import (
 	"errors"
 	"flag"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
 	qualisys "github.com/mlveggo/qualisys-go"
 	"github.com/mlveggo/qualisys-go/pkg/discover"
+	"github.com/mlveggo/qualisys-go/pkg/trace"
 )
 
 func handlePacket(p *qualisys.Packet) bool {
@@ -35,20 +36,6 @@ func handlePacket(p *qualisys.Packet) bool {
 	return true
 }
 
-func findQTM() (string, int) {
-	discovery := discover.NewDiscovery(4545, 1*time.Second)
-	responses, err := discovery.Discover()
-	if err != nil {
-		log.Println("discovery failed:", err)
-		return "127.0.0.1", qualisys.DefaultBasePort
-	}
-	for _, response := range responses {
-		log.Println("Using the first QTM found:", response)
-		return response.Address, response.BasePort
-	}
-	return "127.0.0.1", qualisys.DefaultBasePort
-}
-
 func main() {
 	if err := run(); err != nil {
 		log.Fatal(err)
@@ -59,28 +46,48 @@ func main() {
 // deferred cleanup has run. Calling log.Fatal directly would skip them.
 func run() error {
 	addr := flag.String("addr", "", "QTM address; discovered by broadcast when empty")
+	qtm := flag.String("qtm", "", "QTM to pick by discovery when -addr is empty: a host name, or terms such as cameras>=8,version>=2024.2")
 	port := flag.Int("port", qualisys.DefaultBasePort, "QTM base port")
 	useUDP := flag.Bool("udp", false, "stream data over UDP instead of the TCP control connection")
 	channels := flag.String("analog-channels", "", "restrict analog streaming to these channels, e.g. 1,3,5-8")
+	verbose := flag.Bool("v", false, "log the protocol exchange with QTM to stderr")
+	traceFile := flag.String("trace", "", "record every packet to this file; read it with cmd/tracedump")
 	flag.Parse()
 
-	ip, basePort := *addr, *port
-	if ip == "" {
-		ip, basePort = findQTM()
-	}
-
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	rt := qualisys.NewProtocol(ip, basePort)
-	defer rt.Disconnect()
+	sel, err := discover.ParseSelector(*qtm)
+	if err != nil {
+		return err
+	}
 
-	log.Printf("Connecting to %s:%d", ip, basePort)
-	if err := rt.Connect(); err != nil {
+	var protocolOpts []qualisys.Option
+	if *verbose {
+		handler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+		protocolOpts = append(protocolOpts, qualisys.WithLogger(slog.New(handler)))
+	}
+	if *traceFile != "" {
+		f, err := os.Create(*traceFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		tw := trace.NewWriter(f)
+		defer func() {
+			if err := tw.Err(); err != nil {
+				log.Println(err)
+			}
+		}()
+		protocolOpts = append(protocolOpts, qualisys.WithTracer(tw))
+	}
+	rt, err := qualisys.DialAddr(ctx, *addr, *port, sel, protocolOpts...)
+	if err != nil {
 		return err
 	}
+	defer rt.Disconnect()
 	major, minor := rt.Version()
-	log.Printf("Connected using RT protocol version %d.%d", major, minor)
+	log.Printf("Connected to %v using RT protocol version %d.%d", rt.RemoteAddr(), major, minor)
 
 	if version, err := rt.GetQTMVersion(); err == nil {
 		log.Println("QTM version:", version)
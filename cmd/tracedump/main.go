@@ -0,0 +1,173 @@
+// Command tracedump prints a packet trace recorded with package trace, or
+// converts it to pcapng for Wireshark.
+//
+//	go run ./cmd/streaming -trace session.qtmtrace
+//	go run ./cmd/tracedump session.qtmtrace
+//	go run ./cmd/tracedump -pcapng session.pcapng session.qtmtrace
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/pkg/trace"
+)
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run holds the body of main so that log.Fatal is reached only after every
+// deferred cleanup has run. Calling log.Fatal directly would skip them.
+func run() error {
+	pcapng := flag.String("pcapng", "", "write the trace as pcapng to this file instead of printing it")
+	fullXML := flag.Bool("xml", false, "print XML packets in full rather than their first line")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] trace-file\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := trace.NewReader(f)
+	if err != nil {
+		return err
+	}
+
+	if *pcapng != "" {
+		return convert(r, *pcapng)
+	}
+
+	var start time.Time
+	for {
+		rec, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if start.IsZero() {
+			start = rec.Time
+		}
+		printRecord(rec, rec.Time.Sub(start), *fullXML)
+	}
+}
+
+func convert(r *trace.Reader, path string) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	pw, err := trace.NewPcapngWriter(out)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	n := 0
+	for {
+		rec, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			out.Close()
+			return err
+		}
+		if err := pw.WriteRecord(rec); err != nil {
+			out.Close()
+			return err
+		}
+		n++
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	log.Printf("Wrote %d packets to %s", n, path)
+	return nil
+}
+
+func printRecord(rec trace.Record, offset time.Duration, fullXML bool) {
+	arrow := "<-"
+	if rec.Direction == qualisys.DirectionSent {
+		arrow = "->"
+	}
+	fmt.Printf("%12.6f %s %s %s %d bytes\n", offset.Seconds(), arrow, rec.Network, rec.Remote, len(rec.Data))
+
+	// Packets are decoded as little endian, the byte order of every port but
+	// the big-endian one.
+	if t, size, ok := fileHeader(rec.Data); ok {
+		fmt.Printf("    %v of %d bytes, contents not traced\n", t, size-8)
+		return
+	}
+	var p qualisys.Packet
+	if err := p.UnmarshalBinary(rec.Data); err != nil {
+		fmt.Printf("    undecodable: %v\n", err)
+		fmt.Print(indent(hex.Dump(rec.Data[:min(len(rec.Data), 256)])))
+		return
+	}
+	switch p.Type {
+	case qualisys.PacketTypeCommand:
+		fmt.Printf("    Command %q\n", p.CommandResponse)
+	case qualisys.PacketTypeError:
+		fmt.Printf("    Error %q\n", p.ErrorResponse)
+	case qualisys.PacketTypeEvent:
+		fmt.Printf("    Event %v\n", p.Event)
+	case qualisys.PacketTypeXML:
+		xml := p.XMLResponse
+		if !fullXML {
+			if first, _, more := strings.Cut(xml, "\n"); more {
+				xml = first + " ..."
+			}
+		}
+		fmt.Printf("    XML %s\n", xml)
+	case qualisys.PacketTypeData:
+		fmt.Printf("    Data frame %d timestamp %d\n", p.Data.Frame, p.Data.Timestamp)
+		for _, c := range p.Data.Components {
+			fmt.Print(indent(fmt.Sprintln(c)))
+		}
+	case qualisys.PacketTypeC3DFile, qualisys.PacketTypeQTMFile:
+		fmt.Printf("    %v of %d bytes\n", p.Type, len(p.File.File))
+	default:
+		fmt.Printf("    %v\n", p.Type)
+	}
+}
+
+// fileHeader recognises the record of a downloaded capture file, of which the
+// tracer only keeps the 8 byte header, and returns the file's type and the
+// packet size the header announces.
+func fileHeader(data []byte) (qualisys.PacketType, uint32, bool) {
+	if len(data) != 8 {
+		return 0, 0, false
+	}
+	size := binary.LittleEndian.Uint32(data[0:4])
+	t := qualisys.PacketType(binary.LittleEndian.Uint32(data[4:8]))
+	if size <= 8 || (t != qualisys.PacketTypeC3DFile && t != qualisys.PacketTypeQTMFile) {
+		return 0, 0, false
+	}
+	return t, size, true
+}
+
+func indent(s string) string {
+	s = strings.TrimRight(s, "\n")
+	return "      " + strings.ReplaceAll(s, "\n", "\n      ") + "\n"
+}
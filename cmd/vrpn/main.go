@@ -0,0 +1,142 @@
+// Command vrpn serves QTM rigid bodies as VRPN trackers, replacing a separate
+// VRPN server next to QTM.
+//
+// Every 6D body is a tracker named after the body, with spaces replaced by
+// underscores, so a client opens "wand@<host>". With -tracker, all bodies are
+// also sensors of one device of that name.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/pkg/discover"
+	"github.com/mlveggo/qualisys-go/pkg/fanout"
+	"github.com/mlveggo/qualisys-go/pkg/settings"
+	"github.com/mlveggo/qualisys-go/pkg/vrpn"
+)
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run holds the body of main so that log.Fatal is reached only after every
+// deferred cleanup has run. Calling log.Fatal directly would skip them.
+func run() error {
+	addr := flag.String("addr", "", "QTM address; discovered by broadcast when empty")
+	qtm := flag.String("qtm", "", "QTM to pick by discovery when -addr is empty: a host name, or terms such as cameras>=8,version>=2024.2")
+	port := flag.Int("port", qualisys.DefaultBasePort, "QTM base port")
+	listen := flag.String("listen", fmt.Sprintf(":%d", vrpn.DefaultPort), "TCP and UDP address to serve VRPN clients on")
+	tracker := flag.String("tracker", "", "also serve every body as a sensor of one tracker with this name")
+	euler := flag.Bool("euler", false, "stream 6DEuler instead of 6D (assumes QTM's default Euler convention)")
+	flag.Parse()
+
+	component := qualisys.ComponentType6D
+	if *euler {
+		component = qualisys.ComponentType6DEuler
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	sel, err := discover.ParseSelector(*qtm)
+	if err != nil {
+		return err
+	}
+
+	var lc net.ListenConfig
+	tcp, err := lc.Listen(ctx, "tcp", *listen)
+	if err != nil {
+		return err
+	}
+	udp, err := lc.ListenPacket(ctx, "udp", *listen)
+	if err != nil {
+		tcp.Close()
+		return err
+	}
+
+	hub := fanout.NewHub()
+	defer hub.Close()
+	server := vrpn.NewServer(hub)
+	server.Tracker = *tracker
+	server.OnError = func(remote string, err error) {
+		log.Printf("client %s: %v", remote, err)
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Serving VRPN clients on %s", *listen)
+		serveErr <- server.Serve(ctx, tcp, udp)
+	}()
+
+	const retryDelay = 2 * time.Second
+	for {
+		err := stream(ctx, *addr, *port, sel, component, hub, server)
+		if ctx.Err() != nil {
+			log.Println("Shutting down")
+			return <-serveErr
+		}
+		log.Printf("QTM stream ended: %v; retrying in %v", err, retryDelay)
+		select {
+		case err := <-serveErr:
+			if err == nil {
+				err = errors.New("vrpn server stopped")
+			}
+			return err
+		case <-ctx.Done():
+			return <-serveErr
+		case <-time.After(retryDelay):
+		}
+	}
+}
+
+// stream connects to QTM, publishes frames into hub until the connection fails
+// or ctx is cancelled, and refreshes the body names.
+func stream(
+	ctx context.Context,
+	addr string,
+	basePort int,
+	sel discover.Selector,
+	component qualisys.ComponentType,
+	hub *fanout.Hub,
+	server *vrpn.Server,
+) error {
+	rt, err := qualisys.DialAddr(ctx, addr, basePort, sel)
+	if err != nil {
+		return err
+	}
+	defer rt.Disconnect()
+	major, minor := rt.Version()
+	log.Printf("Connected to %v using RT protocol version %d.%d", rt.RemoteAddr(), major, minor)
+
+	xml, err := rt.GetParameters(qualisys.ParameterType6D)
+	if err != nil {
+		return err
+	}
+	bodies, err := settings.Parse6DBodyNamesFromXML(xml)
+	if err != nil {
+		return err
+	}
+	server.SetNames(bodies)
+	for _, b := range bodies {
+		log.Printf("Serving tracker %s", vrpn.DeviceName(b))
+	}
+
+	if err := rt.StreamFramesAll(component); err != nil {
+		return err
+	}
+	defer func() { _ = rt.StreamFramesStop() }()
+
+	return fanout.Run(ctx, rt.Receive, hub)
+}
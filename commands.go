@@ -1,7 +1,10 @@
 package qualisys
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"log/slog"
 	"net"
 	"strconv"
 	"strings"
@@ -25,9 +28,14 @@ func (rt *Protocol) sendString(s string, t PacketType) error {
 	rt.order.PutUint32(data[4:8], uint32(t))
 	copy(data[packetHeaderSize:], s)
 	// The final byte is already zero, providing the terminator.
+	rt.lastCommand = commandName(s)
+	rt.swallowed = rt.swallowed[:0]
 	if _, err := rt.conn.Write(data); err != nil {
+		rt.log(slog.LevelWarn, "send failed", loggedCommand(s, t), slog.Any("error", err))
 		return fmt.Errorf("write failed: %w", err)
 	}
+	rt.log(slog.LevelDebug, "command sent", loggedCommand(s, t))
+	rt.traceTCP(DirectionSent, data)
 	return nil
 }
 
@@ -48,10 +56,13 @@ func (rt *Protocol) sendXML(cmd string) error {
 // SendCommand sends a raw command and returns QTM's response string. It is
 // exposed so callers can reach protocol features this SDK has not wrapped yet.
 func (rt *Protocol) SendCommand(cmd string) (string, error) {
+	start := time.Now()
 	if err := rt.sendCommand(cmd); err != nil {
+		rt.observeCommand(cmd, start, err)
 		return "", err
 	}
 	p, err := rt.receiveSkippingEvents(DefaultCommandTimeout)
+	rt.observeCommand(cmd, start, err)
 	if err != nil {
 		return "", fmt.Errorf("sendcommand %q: %w", cmd, err)
 	}
@@ -106,11 +117,12 @@ func (rt *Protocol) CheckLicense(licenseCode string) error {
 //
 // The previous implementation only sent the command and left the reply for
 // whoever called Receive next, so there was no way to actually read the state.
-func (rt *Protocol) GetState() (EventType, error) {
+func (rt *Protocol) GetState() (_ EventType, err error) {
 	cmd := "GetState"
 	if rt.majorVersion == 1 && rt.minorVersion <= 9 {
 		cmd = "GetLastEvent"
 	}
+	defer func(start time.Time) { rt.observeCommand(cmd, start, err) }(time.Now())
 	if err := rt.sendCommand(cmd); err != nil {
 		return EventTypeNone, fmt.Errorf("getstate: %w", err)
 	}
@@ -198,6 +210,35 @@ func componentName(c ComponentType) (string, bool) {
 	return "", false
 }
 
+// ParseComponentType maps a component name to its type. It accepts the token
+// QTM uses on the wire ("6DEulerRes", "2DLin") as well as the String form
+// ("6DEulerResidual", "2DLinearized"), case-insensitively, so command lines and
+// URLs can name components either way.
+func ParseComponentType(s string) (ComponentType, error) {
+	for c := ComponentType3D; c <= ComponentTypeEyeTracker; c++ {
+		name, _ := componentName(c)
+		if strings.EqualFold(s, name) || strings.EqualFold(s, c.String()) {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown component %q", s)
+}
+
+// ParseComponentTypes parses a comma or space separated component list, such
+// as "3D,6DRes" or "3D 6DRes".
+func ParseComponentTypes(s string) ([]ComponentType, error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' })
+	out := make([]ComponentType, 0, len(fields))
+	for _, f := range fields {
+		c, err := ParseComponentType(f)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
 // componentString renders a component list with any applicable options.
 func componentString(opts ComponentOptions, components ...ComponentType) (string, error) {
 	parts := make([]string, 0, len(components))
@@ -373,6 +414,13 @@ const maxUDPDatagramSize = 65536
 // Each QTM UDP datagram carries exactly one complete packet, so unlike the TCP
 // path there is no reassembly to do.
 func (rt *Protocol) ReceiveUDP() (*Packet, error) {
+	p, err := rt.receiveUDP()
+	rt.observeReceive(p, err)
+	rt.logReceive(p, err, "udp")
+	return p, err
+}
+
+func (rt *Protocol) receiveUDP() (*Packet, error) {
 	if rt.udpConn == nil {
 		return &Packet{Type: PacketTypeNone}, fmt.Errorf("receiveudp: %w: call EnableUDPStream first", ErrNotConnected)
 	}
@@ -387,13 +435,16 @@ func (rt *Protocol) ReceiveUDP() (*Packet, error) {
 	if rt.udpBuffer == nil {
 		rt.udpBuffer = make([]byte, maxUDPDatagramSize)
 	}
-	n, _, err := rt.udpConn.ReadFromUDP(rt.udpBuffer)
+	n, from, err := rt.udpConn.ReadFromUDP(rt.udpBuffer)
 	if err != nil {
 		if isTimeout(err) {
 			return &Packet{Type: PacketTypeNoMoreData}, nil
 		}
 		return &Packet{Type: PacketTypeNone}, fmt.Errorf("receiveudp: read: %w", err)
 	}
+	if rt.tracer != nil {
+		rt.tracer.TracePacket(time.Now(), DirectionReceived, "udp", rt.udpConn.LocalAddr(), from, rt.udpBuffer[:n])
+	}
 	if n < packetHeaderSize {
 		return &Packet{Type: PacketTypeNone}, fmt.Errorf("receiveudp: datagram too short (%d bytes)", n)
 	}
@@ -493,7 +544,9 @@ func (rt *Protocol) LoadProject(path string) error {
 // The previous version only sent the command and returned; the file packet that
 // followed was left for an unsuspecting Receive caller, and even then
 // FilePacket decoding discarded the content. This waits for the transfer and
-// returns the bytes.
+// returns the bytes. The whole file is held in memory, so a file larger than
+// the maximum packet size (see WithMaxPacketSize) fails and disconnects;
+// DownloadCaptureC3D writes it out as it arrives instead, whatever its size.
 func (rt *Protocol) GetCaptureC3D() (*FilePacket, error) {
 	return rt.getCapture("GetCaptureC3D", PacketTypeC3DFile)
 }
@@ -504,36 +557,27 @@ func (rt *Protocol) GetCaptureQTM() (*FilePacket, error) {
 }
 
 func (rt *Protocol) getCapture(cmd string, want PacketType) (*FilePacket, error) {
-	if err := rt.sendAndWaitForResponse(rt.sendCommand, cmd, []string{"Sending capture"}); err != nil {
-		return nil, fmt.Errorf("%s: %w", strings.ToLower(cmd), err)
-	}
-	p, err := rt.receiveSkippingEvents(DefaultFileTimeout)
-	if err != nil {
-		return nil, fmt.Errorf("%s: %w", strings.ToLower(cmd), err)
-	}
-	if p.Type != want {
-		return nil, fmt.Errorf("%s: expected packet type %v, got %v", strings.ToLower(cmd), want, p.Type)
+	var b bytes.Buffer
+	d := &download{ctx: context.Background(), w: &b, limit: rt.maxPacketSize}
+	if _, err := rt.runDownload(d, cmd, want); err != nil {
+		return nil, err
 	}
-	file := p.File
-	return &file, nil
+	return &FilePacket{Size: uint32(b.Len()), Type: FileType(want), File: b.Bytes()}, nil
 }
 
-// SaveCaptureC3D downloads the current capture and writes it to path.
+// SaveCaptureC3D downloads the current capture and writes it to path as it
+// arrives. The file only appears at path once the download is complete and
+// verified. DownloadCaptureC3DFile does the same with a context and options.
 func (rt *Protocol) SaveCaptureC3D(path string) error {
-	f, err := rt.GetCaptureC3D()
-	if err != nil {
-		return err
-	}
-	return f.WriteFile(path)
+	_, err := rt.DownloadCaptureC3DFile(context.Background(), path, DownloadOptions{})
+	return err
 }
 
-// SaveCaptureQTM downloads the current capture and writes it to path.
+// SaveCaptureQTM downloads the current capture and writes it to path. See
+// SaveCaptureC3D.
 func (rt *Protocol) SaveCaptureQTM(path string) error {
-	f, err := rt.GetCaptureQTM()
-	if err != nil {
-		return err
-	}
-	return f.WriteFile(path)
+	_, err := rt.DownloadCaptureQTMFile(context.Background(), path, DownloadOptions{})
+	return err
 }
 
 func (rt *Protocol) Trig() error {
@@ -573,6 +617,11 @@ func (rt *Protocol) Reprocess() error {
 // the calibration result was never read and the next Receive would return it
 // unexpectedly. Calibration also takes minutes, far longer than the one second
 // read deadline that used to apply, so it could not have completed anyway.
+//
+// The wait is WaitForEventFunc's: events arriving meanwhile are kept for a
+// WaitForEvent afterwards, QTM closing the connection or shutting down ends it
+// with an error wrapping ErrNotConnected, and running out of time with one
+// wrapping ErrTimeout.
 func (rt *Protocol) Calibrate(refine bool, timeout time.Duration) (string, error) {
 	if timeout <= 0 {
 		timeout = DefaultCalibrationTimeout
@@ -585,22 +634,14 @@ func (rt *Protocol) Calibrate(refine bool, timeout time.Duration) (string, error
 		return "", fmt.Errorf("calibrate: %w", err)
 	}
 
-	deadline := time.Now().Add(timeout)
-	for time.Now().Before(deadline) {
-		p, err := rt.ReceiveTimeout(time.Until(deadline))
-		if err != nil {
-			return "", fmt.Errorf("calibrate: %w", err)
-		}
-		switch p.Type {
-		case PacketTypeXML:
-			return p.XMLResponse, nil
-		case PacketTypeEvent:
-			if p.Event == EventTypeConnectionClosed {
-				return "", fmt.Errorf("calibrate: connection closed during calibration")
-			}
-		}
+	// Events during the calibration are kept for a WaitForEvent afterwards.
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	p, err := rt.receiveUntil(ctx, func(p *Packet) bool { return p.Type == PacketTypeXML }, rt.swallowEvent)
+	if err != nil {
+		return "", fmt.Errorf("calibrate: waiting for calibration result: %w", err)
 	}
-	return "", fmt.Errorf("calibrate: %w waiting for calibration result", ErrTimeout)
+	return p.XMLResponse, nil
 }
 
 //go:generate stringer -type LedMode -trimprefix LedMode
@@ -639,7 +680,8 @@ func (rt *Protocol) Quit() error {
 
 // sendAndWaitForResponse sends a string and waits for one of the expected
 // command responses, skipping any event packets that arrive first.
-func (rt *Protocol) sendAndWaitForResponse(sender senderType, s string, expectedResponses []string) error {
+func (rt *Protocol) sendAndWaitForResponse(sender senderType, s string, expectedResponses []string) (err error) {
+	defer func(start time.Time) { rt.observeCommand(s, start, err) }(time.Now())
 	if err := sender(s); err != nil {
 		return err
 	}
@@ -653,7 +695,7 @@ func (rt *Protocol) sendAndWaitForResponse(sender senderType, s string, expected
 		}
 	}
 	if p.CommandResponse == "" && p.ErrorResponse != "" {
-		return fmt.Errorf("unexpected error response (%s)", p.ErrorResponse)
+		return fmt.Errorf("%w: unexpected error response (%s)", ErrRejected, p.ErrorResponse)
 	}
-	return fmt.Errorf("unexpected response (%s), wanted one of %v", p.CommandResponse, expectedResponses)
+	return fmt.Errorf("%w: unexpected response (%s), wanted one of %v", ErrRejected, p.CommandResponse, expectedResponses)
 }
@@ -0,0 +1,49 @@
+package qualisys
+
+import (
+	"context"
+	"time"
+
+	"github.com/mlveggo/qualisys-go/pkg/discover"
+)
+
+// DefaultDiscoveryTimeout is how long Dial waits for discovery replies when
+// given no Discovery of its own.
+const DefaultDiscoveryTimeout = 1 * time.Second
+
+// Dial finds the QTM that sel selects by discovery, then creates a Protocol
+// for it with opts and connects. The zero Selector requires exactly one QTM to
+// answer; several answering is discover.ErrAmbiguous rather than a guess. A
+// nil d discovers from a free local port with DefaultDiscoveryTimeout.
+//
+//	rt, err := qualisys.Dial(ctx, nil, discover.Selector{Hostname: "lab2"})
+func Dial(ctx context.Context, d *discover.Discovery, sel discover.Selector, opts ...Option) (*Protocol, error) {
+	if d == nil {
+		d = discover.NewDiscovery(0, DefaultDiscoveryTimeout)
+	}
+	response, err := d.Find(ctx, sel)
+	if err != nil {
+		return nil, err
+	}
+	rt := NewProtocol(response.Address, response.BasePort, opts...)
+	if err := rt.Connect(); err != nil {
+		return nil, err
+	}
+	return rt, nil
+}
+
+// DialAddr connects to the QTM at addr and basePort when addr is set, and
+// otherwise, as Dial does, to the one sel selects by discovery. It suits
+// programs that take either an address or a selector, and lets them
+// reconnect with the same arguments. Nothing falls back to this machine: with
+// no addr and no QTM answering, the error wraps discover.ErrNoMatch.
+func DialAddr(ctx context.Context, addr string, basePort int, sel discover.Selector, opts ...Option) (*Protocol, error) {
+	if addr == "" {
+		return Dial(ctx, nil, sel, opts...)
+	}
+	rt := NewProtocol(addr, basePort, opts...)
+	if err := rt.Connect(); err != nil {
+		return nil, err
+	}
+	return rt, nil
+}
@@ -0,0 +1,241 @@
+package qualisys
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DownloadOptions configure DownloadCaptureC3D and DownloadCaptureQTM.
+type DownloadOptions struct {
+	// IdleTimeout is how long the transfer may stall, waiting for the file
+	// to start or for its next bytes, before it fails with ErrTimeout.
+	// There is no limit on the transfer as a whole. Zero uses
+	// DefaultFileTimeout.
+	IdleTimeout time.Duration
+	// Progress, when set, is called as the file arrives with the bytes
+	// received so far and the size of the file.
+	Progress func(received, total int64)
+}
+
+// downloadChunk is how much of a file is read from the socket at a time.
+const downloadChunk = 256 << 10
+
+// c3dHeaderSize is the length of the header block a C3D file starts with.
+const c3dHeaderSize = 512
+
+// download is a capture download in progress.
+type download struct {
+	ctx  context.Context
+	w    io.Writer
+	opts DownloadOptions
+	// limit, when positive, is the largest file packet accepted, header
+	// included.
+	limit int
+	// head keeps the start of the file for verification.
+	head []byte
+	// total is the size of the file once its header has arrived.
+	total    int64
+	received int64
+	written  int64
+	// writeErr is the first failure writing to w. The rest of the file is
+	// still read, so the connection stays in step with QTM.
+	writeErr error
+}
+
+// receive reads the body of a file packet whose header receive has read,
+// passing it on to the writer as it arrives. Only the header goes to the trace:
+// the file can run to gigabytes.
+func (d *download) receive(rt *Protocol, size int, ptype PacketType) (*Packet, error) {
+	rt.traceTCP(DirectionReceived, rt.buffer[:packetHeaderSize])
+	total := int64(size - packetHeaderSize)
+	d.total = total
+	if d.limit > 0 && size > d.limit {
+		return &Packet{Type: PacketTypeNone},
+			fmt.Errorf("receive: packet size %d exceeds limit %d", size, d.limit)
+	}
+	if b, ok := d.w.(*bytes.Buffer); ok {
+		b.Grow(int(total))
+	}
+	buf := make([]byte, min(downloadChunk, total))
+	for d.received < total {
+		if err := rt.setReadDeadline(d.opts.IdleTimeout); err != nil {
+			return &Packet{Type: PacketTypeNone}, fmt.Errorf("receive: set deadline: %w", err)
+		}
+		if err := d.ctx.Err(); err != nil {
+			return &Packet{Type: PacketTypeNone},
+				fmt.Errorf("receive: %w: download abandoned after %d of %d bytes: %w", ErrTruncated, d.received, total, err)
+		}
+		n, err := rt.conn.Read(buf[:min(int64(len(buf)), total-d.received)])
+		if n > 0 {
+			d.accept(buf[:n], total)
+		}
+		switch {
+		case err == nil:
+		case isTimeout(err) && d.ctx.Err() != nil:
+			return &Packet{Type: PacketTypeNone},
+				fmt.Errorf("receive: %w: download abandoned after %d of %d bytes: %w", ErrTruncated, d.received, total, d.ctx.Err())
+		case isTimeout(err):
+			// The rest of the file may still arrive and be misread as
+			// packets, so the connection is as unusable as for any other
+			// truncated packet.
+			return &Packet{Type: PacketTypeNone},
+				fmt.Errorf("receive: %w: %w: file stalled after %d of %d bytes", ErrTruncated, ErrTimeout, d.received, total)
+		case errors.Is(err, io.EOF):
+			return &Packet{Type: PacketTypeNone},
+				fmt.Errorf("receive: %w: connection closed after %d of %d bytes", ErrTruncated, d.received, total)
+		default:
+			return &Packet{Type: PacketTypeNone}, fmt.Errorf("receive: read file: %w", err)
+		}
+	}
+	return &Packet{
+		Size:  size,
+		Type:  ptype,
+		order: rt.order,
+		File:  FilePacket{Size: uint32(total), Type: FileType(ptype)},
+	}, nil
+}
+
+func (d *download) accept(b []byte, total int64) {
+	if keep := c3dHeaderSize - len(d.head); keep > 0 {
+		d.head = append(d.head, b[:min(keep, len(b))]...)
+	}
+	d.received += int64(len(b))
+	if d.writeErr == nil {
+		n, err := d.w.Write(b)
+		d.written += int64(n)
+		d.writeErr = err
+	}
+	if d.opts.Progress != nil {
+		d.opts.Progress(d.received, total)
+	}
+}
+
+// verify checks the file is what was asked for. A C3D file starts with a 512
+// byte header block whose second byte is always 0x50. The QTM file format is
+// not published, so a QTM file is only checked for being there at all; that
+// every byte arrived is checked for both by reading exactly the size QTM
+// announced.
+func (d *download) verify(t FileType) error {
+	if d.received == 0 {
+		return fmt.Errorf("%w: empty %v file", ErrInvalidFile, t)
+	}
+	if t == FileTypeC3D && (len(d.head) < c3dHeaderSize || d.head[1] != 0x50) {
+		return fmt.Errorf("%w: no C3D header", ErrInvalidFile)
+	}
+	return nil
+}
+
+// DownloadCaptureC3D downloads the current capture as a C3D file, writing it
+// to w as it arrives, and returns the number of bytes written.
+//
+// Unlike GetCaptureC3D it holds no more than a small buffer of the file in
+// memory and is not bound by the maximum packet size, so it suits long, high
+// frequency captures. The transfer only times out when it stalls for
+// opts.IdleTimeout. The file is verified once it is complete: an error
+// wrapping ErrInvalidFile means w received something other than a C3D file.
+//
+// If w fails, the rest of the file is still read and discarded, leaving the
+// connection usable, and the write error is returned. Cancelling ctx ends the
+// download at once, even before the file starts, and a stall ends it after
+// opts.IdleTimeout. Either way the Protocol disconnects, since the rest of the
+// file would be misread as packets; Connect again to carry on. The error wraps
+// ErrTruncated when part of the file had arrived.
+func (rt *Protocol) DownloadCaptureC3D(ctx context.Context, w io.Writer, opts DownloadOptions) (int64, error) {
+	return rt.downloadCapture(ctx, "GetCaptureC3D", PacketTypeC3DFile, w, opts)
+}
+
+// DownloadCaptureQTM downloads the current capture as a QTM file. See
+// DownloadCaptureC3D.
+func (rt *Protocol) DownloadCaptureQTM(ctx context.Context, w io.Writer, opts DownloadOptions) (int64, error) {
+	return rt.downloadCapture(ctx, "GetCaptureQTM", PacketTypeQTMFile, w, opts)
+}
+
+func (rt *Protocol) downloadCapture(ctx context.Context, cmd string, want PacketType, w io.Writer, opts DownloadOptions) (int64, error) {
+	return rt.runDownload(&download{ctx: ctx, w: w, opts: opts}, cmd, want)
+}
+
+// runDownload sends cmd and receives the file it answers with through d.
+func (rt *Protocol) runDownload(d *download, cmd string, want PacketType) (int64, error) {
+	name := strings.ToLower(cmd)
+	if d.opts.IdleTimeout <= 0 {
+		d.opts.IdleTimeout = DefaultFileTimeout
+	}
+	if err := d.ctx.Err(); err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	if !rt.IsConnected() {
+		return 0, fmt.Errorf("%s: %w", name, ErrNotConnected)
+	}
+	rt.download = d
+	defer func() { rt.download = nil }()
+	// Cancelling ctx unblocks the read in progress, whether for the reply,
+	// the file header or the file, by moving its deadline to now rather than
+	// waiting out the timeout.
+	conn := rt.conn
+	stop := context.AfterFunc(d.ctx, func() { _ = conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	err := rt.sendAndWaitForResponse(rt.sendCommand, cmd, []string{"Sending capture"})
+	var p *Packet
+	if err == nil {
+		p, err = rt.receiveSkippingEvents(d.opts.IdleTimeout)
+	}
+	if err != nil && (d.received < d.total || d.ctx.Err() != nil) {
+		// The rest of the file, or all of it, is still on its way and
+		// would be misread as packets.
+		rt.Disconnect()
+	}
+	if err != nil {
+		return d.written, fmt.Errorf("%s: %w", name, err)
+	}
+	if p.Type != want {
+		return d.written, fmt.Errorf("%s: expected packet type %v, got %v", name, want, p.Type)
+	}
+	if d.writeErr != nil {
+		return d.written, fmt.Errorf("%s: write: %w", name, d.writeErr)
+	}
+	if err := d.verify(FileType(want)); err != nil {
+		return d.written, fmt.Errorf("%s: %w", name, err)
+	}
+	return d.written, nil
+}
+
+// DownloadCaptureC3DFile downloads the current capture as a C3D file to path,
+// with DownloadCaptureC3D's options and cancellation, and returns its size.
+// The download goes to a temporary file beside path that is renamed into place
+// once verified, so a failed or cancelled download leaves no partial file and
+// an existing file at path untouched.
+func (rt *Protocol) DownloadCaptureC3DFile(ctx context.Context, path string, opts DownloadOptions) (int64, error) {
+	return rt.downloadCaptureFile(ctx, "GetCaptureC3D", PacketTypeC3DFile, path, opts)
+}
+
+// DownloadCaptureQTMFile downloads the current capture as a QTM file to path.
+// See DownloadCaptureC3DFile.
+func (rt *Protocol) DownloadCaptureQTMFile(ctx context.Context, path string, opts DownloadOptions) (int64, error) {
+	return rt.downloadCaptureFile(ctx, "GetCaptureQTM", PacketTypeQTMFile, path, opts)
+}
+
+func (rt *Protocol) downloadCaptureFile(ctx context.Context, cmd string, want PacketType, path string, opts DownloadOptions) (int64, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", strings.ToLower(cmd), err)
+	}
+	n, err := rt.downloadCapture(ctx, cmd, want, f, opts)
+	if cerr := f.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("%s: %w", strings.ToLower(cmd), cerr)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return n, err
+}
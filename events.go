@@ -0,0 +1,112 @@
+package qualisys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Event is an event QTM sent and when it arrived.
+type Event struct {
+	Type EventType
+	Time time.Time
+}
+
+// swallowEvent records an event read while waiting for a command response, so
+// that a WaitForEvent following the command still sees it.
+//
+// QTM does not order its events against command replies: the CaptureStarted
+// that Start causes can arrive before "Starting measurement", and would then
+// be lost to a caller waiting for it next.
+func (rt *Protocol) swallowEvent(p *Packet) {
+	rt.swallowed = append(rt.swallowed, Event{Type: p.Event, Time: time.Now()})
+	rt.logSwallowed(p)
+}
+
+// WaitForEvent blocks until QTM sends one of events and returns it. See
+// WaitForEventFunc.
+//
+//	if err := rt.Start(false); err != nil { ... }
+//	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+//	defer cancel()
+//	e, err := rt.WaitForEvent(ctx, qualisys.EventTypeCaptureStarted)
+func (rt *Protocol) WaitForEvent(ctx context.Context, events ...EventType) (Event, error) {
+	return rt.WaitForEventFunc(ctx, func(e EventType) bool { return slices.Contains(events, e) })
+}
+
+// WaitForEventFunc blocks until QTM sends an event match accepts, ctx is done
+// or the connection fails. Events that arrived while the response to the last
+// command was awaited count, as do those arriving from then on; anything
+// older does not, so a stale CaptureStopped cannot end the wait for the next
+// one.
+//
+// It works while streaming over TCP as well as when idle, but the data frames
+// and other packets read while waiting are discarded. When ctx's deadline
+// passes the error wraps both ErrTimeout and context.DeadlineExceeded. QTM
+// shutting down or closing the connection ends the wait with an error
+// wrapping ErrNotConnected, unless match accepts that event.
+func (rt *Protocol) WaitForEventFunc(ctx context.Context, match func(EventType) bool) (Event, error) {
+	for len(rt.swallowed) > 0 {
+		e := rt.swallowed[0]
+		rt.swallowed = rt.swallowed[1:]
+		if match(e.Type) {
+			return e, nil
+		}
+	}
+	p, err := rt.receiveUntil(ctx, func(p *Packet) bool {
+		return p.Type == PacketTypeEvent && match(p.Event)
+	}, nil)
+	if p == nil {
+		return Event{}, fmt.Errorf("waitforevent: %w", err)
+	}
+	e := Event{Type: p.Event, Time: time.Now()}
+	if err != nil {
+		return e, fmt.Errorf("waitforevent: %w", err)
+	}
+	return e, nil
+}
+
+// receiveUntil reads packets until accept takes one, ctx is done or the
+// connection fails. Events accept refuses are passed to skipped when it is
+// set, and other packets are discarded. QTM shutting down or closing the
+// connection, unless accepted, ends the wait with that event's packet and an
+// error wrapping ErrNotConnected. When ctx's deadline passes the error wraps
+// both ErrTimeout and context.DeadlineExceeded.
+func (rt *Protocol) receiveUntil(ctx context.Context, accept func(*Packet) bool, skipped func(*Packet)) (*Packet, error) {
+	// Short reads keep the wait responsive to ctx even when the Protocol
+	// was configured to block.
+	poll := rt.readTimeout
+	if poll <= 0 {
+		poll = DefaultReadTimeout
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				return nil, fmt.Errorf("%w: %w", ErrTimeout, err)
+			}
+			return nil, err
+		}
+		wait := poll
+		if deadline, ok := ctx.Deadline(); ok {
+			wait = max(min(wait, time.Until(deadline)), time.Millisecond)
+		}
+		p, err := rt.ReceiveTimeout(wait)
+		if err != nil {
+			return nil, err
+		}
+		if accept(p) {
+			return p, nil
+		}
+		if p.Type != PacketTypeEvent {
+			continue
+		}
+		if p.Event == EventTypeConnectionClosed || p.Event == EventTypeQTMShuttingDown {
+			return p, fmt.Errorf("%w: QTM sent %v", ErrNotConnected, p.Event)
+		}
+		if skipped != nil {
+			skipped(p)
+		}
+	}
+}
@@ -13,9 +13,11 @@ package qualisys_test
 // every deferred call.
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
+	"os"
 	"time"
 
 	qualisys "github.com/mlveggo/qualisys-go"
@@ -258,3 +260,28 @@ func ExampleProtocol_SaveCaptureC3D() {
 		return
 	}
 }
+
+// Stream a long capture to disk with progress, failing only if it stalls.
+func ExampleProtocol_DownloadCaptureC3D() {
+	rt := qualisys.NewProtocol("192.168.0.10", qualisys.DefaultBasePort)
+	if err := rt.Connect(); err != nil {
+		log.Fatal(err)
+	}
+	defer rt.Disconnect()
+
+	f, err := os.Create("walk.c3d")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer f.Close()
+	_, err = rt.DownloadCaptureC3D(context.Background(), f, qualisys.DownloadOptions{
+		IdleTimeout: time.Minute,
+		Progress: func(received, total int64) {
+			fmt.Printf("\r%d of %d bytes", received, total)
+		},
+	})
+	if err != nil {
+		log.Println(err)
+	}
+}
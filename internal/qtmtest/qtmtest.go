@@ -0,0 +1,93 @@
+// Package qtmtest fakes the QTM end of an RT protocol connection for the tests
+// of packages built on the SDK.
+package qtmtest
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+)
+
+// Packet encodes a little-endian packet of type t.
+func Packet(t qualisys.PacketType, payload []byte) []byte {
+	b := make([]byte, 8+len(payload))
+	binary.LittleEndian.PutUint32(b[0:4], uint32(len(b)))
+	binary.LittleEndian.PutUint32(b[4:8], uint32(t))
+	copy(b[8:], payload)
+	return b
+}
+
+// Text encodes a packet carrying the null-terminated string s.
+func Text(t qualisys.PacketType, s string) []byte { return Packet(t, append([]byte(s), 0)) }
+
+// Event encodes an event packet.
+func Event(e qualisys.EventType) []byte { return Packet(qualisys.PacketTypeEvent, []byte{byte(e)}) }
+
+// Handler answers one command with the bytes it returns. It may also write to
+// conn itself, to send part of a reply before blocking, or close it.
+type Handler func(conn net.Conn, cmd string) []byte
+
+// Listen starts a fake QTM on a loopback port and returns its address. It
+// serves the first connection, answering the Version and GetState commands
+// every Connect sends and passing the rest to h. It stops when the test ends.
+func Listen(t testing.TB, h Handler) net.Addr {
+	t.Helper()
+	var lc net.ListenConfig
+	ln, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		serve(conn, h)
+	}()
+	return ln.Addr()
+}
+
+func serve(conn net.Conn, h Handler) {
+	defer conn.Close()
+	_, _ = conn.Write(Text(qualisys.PacketTypeCommand, "QTM RT Interface connected"))
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		body := make([]byte, binary.LittleEndian.Uint32(header[0:4])-8)
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return
+		}
+		cmd := strings.TrimRight(string(body), "\x00")
+		var out []byte
+		switch {
+		case strings.HasPrefix(cmd, "Version "):
+			out = Text(qualisys.PacketTypeCommand, "Version set to "+strings.TrimPrefix(cmd, "Version "))
+		case cmd == "GetState":
+			out = Event(qualisys.EventTypeConnected)
+		default:
+			out = h(conn, cmd)
+		}
+		_, _ = conn.Write(out)
+	}
+}
+
+// Connect connects a Protocol to the QTM, real or fake, listening at addr and
+// disconnects it when the test ends.
+func Connect(t testing.TB, addr net.Addr) *qualisys.Protocol {
+	t.Helper()
+	// Protocol connects to base port + 1, the little-endian port.
+	rt := qualisys.NewProtocol("127.0.0.1", addr.(*net.TCPAddr).Port-1)
+	if err := rt.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(rt.Disconnect)
+	return rt
+}
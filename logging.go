@@ -0,0 +1,68 @@
+package qualisys
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+)
+
+// WithLogger logs the Protocol's activity to l: connection attempts and the
+// version negotiation at Info, each command sent and each response, event
+// swallowed while waiting for a response at Debug, and packets that could not
+// be read or decoded at Warn. Streamed data frames are not logged.
+//
+// Without this option the Protocol logs nothing, as a library should not write
+// to a logger the caller has not handed it.
+func WithLogger(l *slog.Logger) Option {
+	return func(p *Protocol) { p.logger = l }
+}
+
+func (rt *Protocol) log(level slog.Level, msg string, attrs ...slog.Attr) {
+	if rt.logger == nil {
+		return
+	}
+	rt.logger.LogAttrs(context.Background(), level, msg, attrs...)
+}
+
+// loggedCommand is cmd as it may appear in a log: without TakeControl's
+// password, and with settings XML reduced to its length.
+func loggedCommand(cmd string, t PacketType) slog.Attr {
+	if t == PacketTypeXML {
+		return slog.Int("xmlBytes", len(cmd))
+	}
+	if name, _, ok := strings.Cut(cmd, " "); ok && name == "TakeControl" {
+		cmd = name + " ***"
+	}
+	return slog.String("command", cmd)
+}
+
+// logReceive reports responses and read failures. Data packets and events are
+// left to the caller, who receives them; timeouts are routine.
+func (rt *Protocol) logReceive(p *Packet, err error, transport string) {
+	if rt.logger == nil {
+		return
+	}
+	switch {
+	case p.Size == 0:
+		if err != nil && !errors.Is(err, ErrNotConnected) {
+			rt.log(slog.LevelWarn, "receive failed", slog.String("transport", transport), slog.Any("error", err))
+		}
+	case p.Type == PacketTypeCommand:
+		rt.log(slog.LevelDebug, "response received", slog.String("response", p.CommandResponse))
+	case p.Type == PacketTypeError:
+		rt.log(slog.LevelDebug, "error response received", slog.String("response", p.ErrorResponse))
+	case p.Type == PacketTypeXML:
+		rt.log(slog.LevelDebug, "XML response received", slog.Int("bytes", len(p.XMLResponse)))
+	case p.Type == PacketTypeC3DFile, p.Type == PacketTypeQTMFile:
+		rt.log(slog.LevelDebug, "file received", slog.String("type", p.Type.String()), slog.Int("bytes", int(p.File.Size)))
+	}
+}
+
+// logSwallowed reports an event read while waiting for a command response.
+// Receive callers never see these, so without the log a capture starting or
+// stopping mid-command leaves no trace.
+func (rt *Protocol) logSwallowed(p *Packet) {
+	rt.log(slog.LevelDebug, "event swallowed while waiting for response",
+		slog.String("event", p.Event.String()), slog.String("command", rt.lastCommand))
+}
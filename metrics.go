@@ -0,0 +1,65 @@
+package qualisys
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// Metrics receives counts and timings from a Protocol. Methods are called on
+// the goroutine driving the Protocol, so an implementation shared by several
+// Protocols, such as one kept across reconnects, must be safe for concurrent
+// use. Package metrics provides one that serves the Prometheus text format.
+type Metrics interface {
+	// Connected is called after each successful Connect.
+	Connected()
+	// PacketReceived is called for every packet read from TCP or UDP,
+	// including error packets. p.Size is the packet's size on the wire.
+	PacketReceived(p *Packet)
+	// ReceiveFailed is called when reading a packet fails: a truncated or
+	// undecodable packet, or a broken connection. Read timeouts are not
+	// failures and are not reported, nor are reads on a Protocol that is not
+	// connected.
+	ReceiveFailed(err error)
+	// CommandCompleted is called when a command that waits for QTM's reply
+	// finishes, or fails to be sent. command is the command name without
+	// arguments, so passwords and file names never reach the metrics.
+	CommandCompleted(command string, d time.Duration, err error)
+}
+
+// WithMetrics reports packet, error and command statistics to m.
+func WithMetrics(m Metrics) Option {
+	return func(p *Protocol) { p.metrics = m }
+}
+
+// commandName reduces a command to its name, the first word. SetParameters is
+// the one command sent as XML rather than text.
+func commandName(cmd string) string {
+	if strings.HasPrefix(cmd, "<") {
+		return "SetParameters"
+	}
+	name, _, _ := strings.Cut(cmd, " ")
+	return name
+}
+
+// observeReceive reports the outcome of a read. A packet with a size was read
+// off the wire, even when it comes with an error, as error packets do; an
+// empty one is a timeout, or a failure if err is set. Polling a disconnected
+// Protocol reads nothing and fails nothing, as for logging.
+func (rt *Protocol) observeReceive(p *Packet, err error) {
+	if rt.metrics == nil {
+		return
+	}
+	switch {
+	case p.Size > 0:
+		rt.metrics.PacketReceived(p)
+	case err != nil && !errors.Is(err, ErrNotConnected):
+		rt.metrics.ReceiveFailed(err)
+	}
+}
+
+func (rt *Protocol) observeCommand(cmd string, start time.Time, err error) {
+	if rt.metrics != nil {
+		rt.metrics.CommandCompleted(commandName(cmd), time.Since(start), err)
+	}
+}
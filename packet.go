@@ -2,8 +2,10 @@ package qualisys
 
 import (
 	"bytes"
+	"encoding"
 	"encoding/binary"
 	"fmt"
+	"strings"
 
 	"github.com/mlveggo/qualisys-go/pkg/packets"
 )
@@ -31,6 +33,17 @@ const (
 	EventTypeNone
 )
 
+// ParseEventType maps an event name, as String formats it, to its type,
+// ignoring case, so scripts and command lines can name events.
+func ParseEventType(s string) (EventType, error) {
+	for e := EventTypeConnected; e <= EventTypeNone; e++ {
+		if strings.EqualFold(s, e.String()) {
+			return e, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown event %q", s)
+}
+
 //go:generate stringer -type PacketType -trimprefix PacketType
 type PacketType uint32
 
@@ -203,6 +216,59 @@ func (d *DataPacket) UnmarshalBinary(data []byte) error {
 	return nil
 }
 
+// MarshalBinary encodes the frame in the layout UnmarshalBinary reads: the
+// payload of a PacketTypeData packet, without the 8 byte packet header.
+//
+// Every component must be one this SDK decoded or an UnknownComponent, whose
+// raw bytes are written back untouched.
+func (d *DataPacket) MarshalBinary() ([]byte, error) {
+	order := d.order
+	if order == nil {
+		order = binary.LittleEndian
+	}
+	b := make([]byte, dataPacketHeaderSize)
+	order.PutUint64(b[0:8], d.Timestamp)
+	order.PutUint32(b[8:12], d.Frame)
+	order.PutUint32(b[12:16], uint32(len(d.Components)))
+	for i, obj := range d.Components {
+		ctype, _ := componentTypeOf(obj)
+		m, ok := obj.(encoding.BinaryMarshaler)
+		if !ok {
+			return nil, fmt.Errorf("datapacket: component %d (%T) cannot be encoded", i, obj)
+		}
+		payload, err := m.MarshalBinary()
+		if err != nil {
+			return nil, fmt.Errorf("datapacket: component %d (%v): %w", i, ctype, err)
+		}
+		var header [componentHeaderSize]byte
+		order.PutUint32(header[0:4], uint32(componentHeaderSize+len(payload)))
+		order.PutUint32(header[4:8], uint32(ctype))
+		b = append(b, header[:]...)
+		b = append(b, payload...)
+	}
+	return b, nil
+}
+
+// Select returns a copy of the frame holding only the components of the given
+// types, in their original order. The components themselves are shared, not
+// copied, so the result must be treated as read-only like the original.
+//
+// This is how a fan-out server hands each client the subset it asked for while
+// QTM streams the union once.
+func (d *DataPacket) Select(types ...ComponentType) DataPacket {
+	out := DataPacket{Timestamp: d.Timestamp, Frame: d.Frame, order: d.order}
+	for _, obj := range d.Components {
+		ctype, _ := componentTypeOf(obj)
+		for _, want := range types {
+			if ctype == want {
+				out.Components = append(out.Components, obj)
+				break
+			}
+		}
+	}
+	return out
+}
+
 // UnknownComponent holds a component this SDK cannot decode.
 //
 // Newer QTM releases add components. Keeping the payload instead of failing the
@@ -219,6 +285,10 @@ func (u *UnknownComponent) UnmarshalBinary(data []byte) error {
 	return nil
 }
 
+func (u UnknownComponent) MarshalBinary() ([]byte, error) {
+	return u.Data, nil
+}
+
 func (u UnknownComponent) String() string {
 	return fmt.Sprintf("UnknownComponent{type: %d, %d bytes}", int(u.Type), len(u.Data))
 }
@@ -468,3 +538,41 @@ func (p *Packet) UnmarshalBinary(data []byte) error {
 	}
 	return nil
 }
+
+// MarshalBinary encodes a complete packet including its 8 byte header, in the
+// packet's byte order. It is the inverse of UnmarshalBinary, and lets a server
+// built on this SDK answer RT protocol clients with the same types it decodes.
+func (p *Packet) MarshalBinary() ([]byte, error) {
+	order := p.byteOrder()
+	var payload []byte
+	switch p.Type {
+	case PacketTypeError:
+		payload = append([]byte(p.ErrorResponse), 0)
+	case PacketTypeCommand:
+		payload = append([]byte(p.CommandResponse), 0)
+	case PacketTypeXML:
+		payload = append([]byte(p.XMLResponse), 0)
+	case PacketTypeData:
+		// The frame header follows the packet's byte order, whatever order
+		// the DataPacket itself was decoded with.
+		d := p.Data
+		d.order = order
+		b, err := d.MarshalBinary()
+		if err != nil {
+			return nil, fmt.Errorf("packet: %w", err)
+		}
+		payload = b
+	case PacketTypeC3DFile, PacketTypeQTMFile:
+		payload = p.File.File
+	case PacketTypeEvent:
+		payload = []byte{byte(p.Event)}
+	case PacketTypeNoMoreData, PacketTypeNone, PacketTypeDiscover:
+	default:
+		return nil, fmt.Errorf("packet: cannot encode packet type %v", p.Type)
+	}
+	b := make([]byte, packetHeaderSize+len(payload))
+	order.PutUint32(b[0:4], uint32(len(b)))
+	order.PutUint32(b[4:8], uint32(p.Type))
+	copy(b[packetHeaderSize:], payload)
+	return b, nil
+}
@@ -364,3 +364,96 @@ func TestReceiveUDPDecodesDatagram(t *testing.T) {
 func netDialUDP(port int) (netConn, error) {
 	return dialUDPLoopback(port)
 }
+
+func TestDataPacketMarshalRoundTrip(t *testing.T) {
+	const futureComponent ComponentType = 99
+	payload := dataFrame(1234, 42,
+		component(ComponentType3D, marker3DPayload(2)),
+		component(futureComponent, []byte{1, 2, 3, 4}),
+	)
+	var d DataPacket
+	if err := d.UnmarshalBinary(payload); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	got, err := d.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	if string(got) != string(payload) {
+		t.Errorf("re-encoded frame differs:\n got %v\nwant %v", got, payload)
+	}
+}
+
+func TestDataPacketSelectKeepsRequestedComponents(t *testing.T) {
+	payload := dataFrame(1, 2,
+		component(ComponentType3D, marker3DPayload(1)),
+		component(ComponentTypeSkeleton, make([]byte, 4)),
+	)
+	var d DataPacket
+	if err := d.UnmarshalBinary(payload); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	sel := d.Select(ComponentTypeSkeleton, ComponentType6D)
+	if sel.Frame != 2 || len(sel.Components) != 1 || sel.Skeletons() == nil {
+		t.Errorf("Select = %+v, want only the skeleton component", sel)
+	}
+	if len(d.Components) != 2 {
+		t.Error("Select modified the original frame")
+	}
+}
+
+func TestPacketMarshalRoundTrip(t *testing.T) {
+	for _, p := range []Packet{
+		{Type: PacketTypeCommand, CommandResponse: "Version set to 1.28"},
+		{Type: PacketTypeXML, XMLResponse: "<x/>"},
+		{Type: PacketTypeEvent, Event: EventTypeCaptureStarted},
+		{Type: PacketTypeNoMoreData},
+	} {
+		b, err := p.MarshalBinary()
+		if err != nil {
+			t.Fatalf("marshal %v: %v", p.Type, err)
+		}
+		var got Packet
+		if err := got.UnmarshalBinary(b); err != nil {
+			t.Fatalf("unmarshal %v: %v", p.Type, err)
+		}
+		if got.Size != len(b) || got.Type != p.Type || got.CommandResponse != p.CommandResponse ||
+			got.XMLResponse != p.XMLResponse || got.Event != p.Event {
+			t.Errorf("round trip of %v gave %+v", p.Type, got)
+		}
+	}
+}
+
+func TestParseComponentType(t *testing.T) {
+	cases := map[string]ComponentType{
+		"3D":              ComponentType3D,
+		"6deulerres":      ComponentType6DEulerResidual,
+		"6DEulerResidual": ComponentType6DEulerResidual,
+		"2DLin":           ComponentType2DLinearized,
+		"3DNoLabelsRes":   ComponentType3DNoLabelsResidual,
+		"Skeleton":        ComponentTypeSkeleton,
+	}
+	for s, want := range cases {
+		if got, err := ParseComponentType(s); err != nil || got != want {
+			t.Errorf("ParseComponentType(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+	if _, err := ParseComponentType("7D"); err == nil {
+		t.Error("expected an error for an unknown component")
+	}
+	got, err := ParseComponentTypes("3D, 6D Analog")
+	if err != nil || len(got) != 3 || got[2] != ComponentTypeAnalog {
+		t.Errorf("ParseComponentTypes = %v, %v", got, err)
+	}
+}
+
+func TestParseEventType(t *testing.T) {
+	for e := EventTypeConnected; e <= EventTypeNone; e++ {
+		if got, err := ParseEventType(strings.ToLower(e.String())); err != nil || got != e {
+			t.Errorf("ParseEventType(%q) = %v, %v", e, got, err)
+		}
+	}
+	if _, err := ParseEventType("CaptureExploded"); err == nil {
+		t.Error("expected an error for an unknown event")
+	}
+}
@@ -70,7 +70,7 @@ func (rt *Protocol) GetParameters(parameters ...ParameterType) (string, error) {
 // Unlike the previous implementation this skips event packets while waiting.
 // QTM emits events asynchronously, so an event arriving between the request and
 // the reply used to make GetParameters return an empty string with no error.
-func (rt *Protocol) GetParametersWithOptions(opts ParameterOptions, parameters ...ParameterType) (string, error) {
+func (rt *Protocol) GetParametersWithOptions(opts ParameterOptions, parameters ...ParameterType) (_ string, err error) {
 	if !rt.IsConnected() {
 		return "", fmt.Errorf("getparameters: %w", ErrNotConnected)
 	}
@@ -91,6 +91,7 @@ func (rt *Protocol) GetParametersWithOptions(opts ParameterOptions, parameters .
 	}
 
 	cmd := "GetParameters " + strings.Join(names, " ")
+	defer func(start time.Time) { rt.observeCommand(cmd, start, err) }(time.Now())
 	if err := rt.sendCommand(cmd); err != nil {
 		return "", fmt.Errorf("getparameters: %w", err)
 	}
@@ -104,8 +105,10 @@ func (rt *Protocol) GetParametersWithOptions(opts ParameterOptions, parameters .
 		switch p.Type {
 		case PacketTypeXML:
 			return p.XMLResponse, nil
+		case PacketTypeEvent:
+			rt.swallowEvent(p)
 		case PacketTypeError:
-			return "", fmt.Errorf("getparameters: %s", p.ErrorResponse)
+			return "", fmt.Errorf("getparameters: %w: %s", ErrRejected, p.ErrorResponse)
 		}
 	}
 	return "", fmt.Errorf("getparameters: %w waiting for XML response", ErrTimeout)
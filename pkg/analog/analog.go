@@ -0,0 +1,369 @@
+// Package analog reassembles streamed analog samples into continuous signals
+// aligned with camera frames.
+//
+// Each data frame carries the analog samples captured since the previous one,
+// several per frame when the board runs faster than the cameras, together with
+// the board's running SampleNumber. An Aligner keeps a window of recent samples
+// per device and channel, fills samples lost with dropped frames with NaN, and
+// answers questions in frame terms:
+//
+//	xml, _ := rt.GetParameters(qualisys.ParameterTypeGeneral, qualisys.ParameterTypeAnalog)
+//	a, _ := analog.NewAlignerFromXML(xml)
+//	...
+//	gaps := a.Add(p.Data.Frame, p.Data.Analog())
+//	emg := a.DeviceByName("EMG")
+//	v, ok := emg.At(frame, 3, emg.Channel("Biceps")) // fourth sample of frame
+//	mean, ok := emg.FrameMean(frame, 0)               // resampled to the camera rate
+package analog
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/mlveggo/qualisys-go/pkg/packets"
+	"github.com/mlveggo/qualisys-go/pkg/settings"
+)
+
+// DefaultHistory is how much signal a Device keeps when Aligner.History is
+// zero.
+const DefaultHistory = 10 * time.Second
+
+// fallbackCapacity is the window, in samples, for a device whose frequency is
+// unknown.
+const fallbackCapacity = 100_000
+
+// Gap is a run of samples that never arrived, typically because the frames
+// carrying them were dropped.
+type Gap struct {
+	Device uint32
+	// From is the sample number of the first missing sample.
+	From  uint32
+	Count uint32
+}
+
+// Aligner reassembles the analog component of consecutive frames. It is not
+// safe for concurrent use.
+type Aligner struct {
+	// CameraFrequency is the camera capture rate in Hz. It maps frames to
+	// sample numbers for frames whose analog data was not seen.
+	CameraFrequency float64
+	// History is how much signal each Device keeps; zero means DefaultHistory.
+	// Set it before the first Add.
+	History time.Duration
+
+	devices []*Device
+}
+
+// NewAligner prepares an Aligner for the devices in the Analog settings.
+// Devices that appear in the stream without settings are still tracked, only
+// without names or a frequency.
+func NewAligner(cameraFrequency float64, devices []settings.AnalogDevice) *Aligner {
+	a := &Aligner{CameraFrequency: cameraFrequency}
+	for _, d := range devices {
+		dev := &Device{ID: d.ID, Name: d.Name, Frequency: d.Frequency, Min: d.Range.Min, Max: d.Range.Max}
+		for _, ch := range d.Channels {
+			dev.Labels = append(dev.Labels, ch.Label)
+			dev.Units = append(dev.Units, ch.Unit)
+		}
+		a.devices = append(a.devices, dev)
+	}
+	return a
+}
+
+// NewAlignerFromXML builds an Aligner from settings XML holding the General
+// and Analog sections.
+func NewAlignerFromXML(xml string) (*Aligner, error) {
+	general, err := settings.ParseGeneralFromXML(xml)
+	if err != nil {
+		return nil, err
+	}
+	devices, err := settings.ParseAnalogDevicesFromXML(xml)
+	if err != nil {
+		return nil, err
+	}
+	return NewAligner(general.Frequency, devices), nil
+}
+
+// Devices returns every device seen in the settings or the stream.
+func (a *Aligner) Devices() []*Device {
+	return a.devices
+}
+
+// Device returns the device with the given ID, or nil.
+func (a *Aligner) Device(id uint32) *Device {
+	for _, d := range a.devices {
+		if d.ID == id {
+			return d
+		}
+	}
+	return nil
+}
+
+// DeviceByName returns the device with the given name, or nil.
+func (a *Aligner) DeviceByName(name string) *Device {
+	for _, d := range a.devices {
+		if d.Name == name {
+			return d
+		}
+	}
+	return nil
+}
+
+// Add appends the samples a frame carried and returns the gaps found before
+// them. c may be nil, for frames without analog data.
+func (a *Aligner) Add(frame uint32, c *packets.ComponentAnalog) []Gap {
+	if c == nil {
+		return nil
+	}
+	var gaps []Gap
+	for _, ad := range c.AnalogDevices {
+		d := a.Device(ad.ID)
+		if d == nil {
+			d = &Device{ID: ad.ID}
+			a.devices = append(a.devices, d)
+		}
+		if d.capacity == 0 {
+			d.capacity = a.capacity(d)
+		}
+		d.cameraFrequency = a.CameraFrequency
+		if g, ok := d.add(frame, ad); ok {
+			gaps = append(gaps, g)
+		}
+	}
+	return gaps
+}
+
+func (a *Aligner) capacity(d *Device) int {
+	history := a.History
+	if history <= 0 {
+		history = DefaultHistory
+	}
+	if d.Frequency <= 0 {
+		return fallbackCapacity
+	}
+	return max(int(history.Seconds()*d.Frequency), 1)
+}
+
+// frameStart records the sample number a frame's samples began at.
+type frameStart struct {
+	frame  uint32
+	sample uint32
+	count  int
+}
+
+// Device is the retained signal of one analog board.
+type Device struct {
+	ID        uint32
+	Name      string
+	Frequency float64
+	// Labels and Units are per channel, from the settings.
+	Labels []string
+	Units  []string
+	// Min and Max are the input range from the settings.
+	Min, Max float64
+
+	cameraFrequency float64
+	capacity        int
+	started         bool
+	// start is the sample number of values[ch][0]; next the one expected
+	// after the last retained sample.
+	start  uint32
+	next   uint32
+	values [][]float32
+	frames []frameStart
+}
+
+func (d *Device) add(frame uint32, ad packets.AnalogDevice) (Gap, bool) {
+	count := 0
+	if len(ad.Channels) > 0 {
+		count = len(ad.Channels[0].Samples)
+	}
+	if count == 0 {
+		return Gap{}, false
+	}
+
+	var gap Gap
+	found := false
+	switch {
+	case !d.started || len(ad.Channels) != len(d.values):
+		d.reset(ad.SampleNumber, len(ad.Channels))
+	case ad.SampleNumber == d.next:
+	case int32(ad.SampleNumber-d.next) > 0 && int(ad.SampleNumber-d.next) < d.capacity:
+		gap = Gap{Device: d.ID, From: d.next, Count: ad.SampleNumber - d.next}
+		found = true
+		nan := float32(math.NaN())
+		for ch := range d.values {
+			for i := uint32(0); i < gap.Count; i++ {
+				d.values[ch] = append(d.values[ch], nan)
+			}
+		}
+	default:
+		// The sample number went backwards or leapt further than the window:
+		// a new capture, RT from file restarting, or a long outage. None of
+		// the old signal lines up with what follows.
+		d.reset(ad.SampleNumber, len(ad.Channels))
+	}
+
+	for ch, c := range ad.Channels {
+		for i := 0; i < count; i++ {
+			v := float32(math.NaN())
+			if i < len(c.Samples) {
+				v = c.Samples[i].Value
+			}
+			d.values[ch] = append(d.values[ch], v)
+		}
+	}
+	d.next = ad.SampleNumber + uint32(count)
+	if n := len(d.frames); n > 0 && d.frames[n-1].frame >= frame {
+		// Frame numbers restarted while sample numbers ran on.
+		d.frames = d.frames[:0]
+	}
+	d.frames = append(d.frames, frameStart{frame: frame, sample: ad.SampleNumber, count: count})
+	d.trim()
+	return gap, found
+}
+
+func (d *Device) reset(sample uint32, channels int) {
+	d.started = true
+	d.start, d.next = sample, sample
+	d.values = make([][]float32, channels)
+	d.frames = d.frames[:0]
+}
+
+// trim drops samples, and the frames that began with them, beyond capacity.
+func (d *Device) trim() {
+	if len(d.values) == 0 {
+		return
+	}
+	drop := len(d.values[0]) - d.capacity
+	if drop <= 0 {
+		return
+	}
+	for ch := range d.values {
+		d.values[ch] = d.values[ch][drop:]
+	}
+	d.start += uint32(drop)
+	i := 0
+	for i < len(d.frames) && int32(d.frames[i].sample-d.start) < 0 {
+		i++
+	}
+	d.frames = d.frames[i:]
+}
+
+// Channel returns the index of the channel with the given label, or -1.
+func (d *Device) Channel(label string) int {
+	return slices.Index(d.Labels, label)
+}
+
+// Span returns the sample numbers of the first retained sample and one past
+// the last.
+func (d *Device) Span() (first, end uint32) {
+	return d.start, d.next
+}
+
+// Sample returns the value of sample number n on channel ch. It is false for
+// samples outside the retained window and for samples lost in a gap.
+func (d *Device) Sample(n uint32, ch int) (float32, bool) {
+	if ch < 0 || ch >= len(d.values) {
+		return 0, false
+	}
+	i := int64(int32(n - d.start))
+	if i < 0 || i >= int64(len(d.values[ch])) {
+		return 0, false
+	}
+	v := d.values[ch][i]
+	return v, !math.IsNaN(float64(v))
+}
+
+// Signal returns a copy of the retained signal on channel ch, starting at
+// the first sample number Span reports. Lost samples are NaN.
+func (d *Device) Signal(ch int) []float32 {
+	if ch < 0 || ch >= len(d.values) {
+		return nil
+	}
+	return slices.Clone(d.values[ch])
+}
+
+// SamplesPerFrame is the number of analog samples per camera frame: the
+// ratio of the device and camera frequencies when both are known, otherwise
+// the count the most recent frame carried.
+func (d *Device) SamplesPerFrame() float64 {
+	if d.Frequency > 0 && d.cameraFrequency > 0 {
+		return d.Frequency / d.cameraFrequency
+	}
+	if n := len(d.frames); n > 0 {
+		return float64(d.frames[n-1].count)
+	}
+	return 0
+}
+
+// FrameSample returns the sample number of a frame's first sample and how
+// many samples belong to it. Frames whose analog data arrived answer exactly;
+// others, such as dropped frames, are extrapolated from the nearest frame
+// that did arrive. It is false when nothing has arrived yet.
+func (d *Device) FrameSample(frame uint32) (first uint32, count int, ok bool) {
+	if len(d.frames) == 0 {
+		return 0, 0, false
+	}
+	i := sort.Search(len(d.frames), func(i int) bool { return d.frames[i].frame >= frame })
+	if i < len(d.frames) && d.frames[i].frame == frame {
+		return d.frames[i].sample, d.frames[i].count, true
+	}
+	ref := d.frames[max(i-1, 0)]
+	ratio := d.SamplesPerFrame()
+	// The offset is negative for a frame before the first one received.
+	// Converting a negative float to uint32 differs between platforms, so it
+	// goes through int64 and then wraps like any other sample number.
+	offset := func(frame int64) uint32 {
+		return uint32(int64(math.Round(float64(frame-int64(ref.frame)) * ratio)))
+	}
+	first = ref.sample + offset(int64(frame))
+	next := ref.sample + offset(int64(frame)+1)
+	return first, int(int32(next - first)), true
+}
+
+// At returns subsample k of frame on channel ch: the value at frame N,
+// subsample k.
+func (d *Device) At(frame uint32, k, ch int) (float32, bool) {
+	first, count, ok := d.FrameSample(frame)
+	if !ok || k < 0 || k >= count {
+		return 0, false
+	}
+	return d.Sample(first+uint32(k), ch)
+}
+
+// FrameMean resamples channel ch to the camera rate: the mean of the samples
+// belonging to frame, skipping lost ones. Averaging over the frame rather than
+// picking one sample keeps noise above the camera's Nyquist frequency from
+// aliasing into the result. It is false when none of the frame's samples are
+// available.
+func (d *Device) FrameMean(frame uint32, ch int) (float64, bool) {
+	first, count, ok := d.FrameSample(frame)
+	if !ok {
+		return 0, false
+	}
+	var sum float64
+	n := 0
+	for k := 0; k < count; k++ {
+		if v, ok := d.Sample(first+uint32(k), ch); ok {
+			sum += float64(v)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, false
+	}
+	return sum / float64(n), true
+}
+
+// Clipped reports whether v sits at or beyond the device's input range,
+// meaning the signal saturated and the true value is unknown. Without a range
+// in the settings nothing is clipped.
+func (d *Device) Clipped(v float32) bool {
+	if d.Min >= d.Max {
+		return false
+	}
+	return float64(v) <= d.Min || float64(v) >= d.Max
+}
@@ -0,0 +1,128 @@
+package analog_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/mlveggo/qualisys-go/pkg/analog"
+	"github.com/mlveggo/qualisys-go/pkg/packets"
+)
+
+const settingsXML = `<QTM_Parameters_Ver_1.28>
+<General><Frequency>100</Frequency></General>
+<Analog><Device>
+  <Device_ID>2</Device_ID><Device_Name>EMG</Device_Name><Channels>2</Channels>
+  <Frequency>400</Frequency><Range><Min>-5</Min><Max>5</Max></Range>
+  <Channel><Label>Biceps</Label><Unit>V</Unit></Channel>
+  <Channel><Label>Triceps</Label><Unit>V</Unit></Channel>
+</Device></Analog>
+</QTM_Parameters_Ver_1.28>`
+
+// frame builds the analog component of one frame: four samples per channel,
+// channel c of sample number s holding s + 1000*c.
+func frame(sampleNumber uint32) *packets.ComponentAnalog {
+	dev := packets.AnalogDevice{ID: 2, SampleNumber: sampleNumber, Channels: make([]packets.AnalogChannel, 2)}
+	for c := range dev.Channels {
+		for k := uint32(0); k < 4; k++ {
+			dev.Channels[c].Samples = append(dev.Channels[c].Samples,
+				packets.AnalogSample{Value: float32(sampleNumber + k + 1000*uint32(c))})
+		}
+	}
+	return &packets.ComponentAnalog{AnalogDevices: []packets.AnalogDevice{dev}}
+}
+
+func TestAlignerReassemblesFrames(t *testing.T) {
+	a, err := analog.NewAlignerFromXML(settingsXML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emg := a.DeviceByName("EMG")
+	if emg == nil || emg.Frequency != 400 || emg.Channel("Triceps") != 1 || a.CameraFrequency != 100 {
+		t.Fatalf("settings not applied: %+v", emg)
+	}
+
+	// Frames 10 to 12 arrive, 13 is dropped, 14 arrives.
+	for i, f := range []uint32{10, 11, 12, 14} {
+		gaps := a.Add(f, frame(40+4*(f-10)))
+		if f == 14 {
+			if len(gaps) != 1 || gaps[0] != (analog.Gap{Device: 2, From: 52, Count: 4}) {
+				t.Errorf("gaps = %+v", gaps)
+			}
+		} else if len(gaps) != 0 {
+			t.Errorf("frame %d (#%d): unexpected gaps %+v", f, i, gaps)
+		}
+	}
+
+	if first, end := emg.Span(); first != 40 || end != 60 {
+		t.Errorf("span = %d..%d", first, end)
+	}
+	if v, ok := emg.At(11, 2, 1); !ok || v != 1046 {
+		t.Errorf("frame 11 subsample 2 of Triceps = %v, %v", v, ok)
+	}
+	// The dropped frame maps to the samples the gap lost.
+	if first, count, ok := emg.FrameSample(13); !ok || first != 52 || count != 4 {
+		t.Errorf("frame 13 = %d+%d, %v", first, count, ok)
+	}
+	if _, ok := emg.At(13, 0, 0); ok {
+		t.Error("a lost sample was reported")
+	}
+	// Frames before the first one received are extrapolated backwards.
+	if first, count, ok := emg.FrameSample(8); !ok || first != 32 || count != 4 {
+		t.Errorf("frame 8 = %d+%d, %v", first, count, ok)
+	}
+	if m, ok := emg.FrameMean(12, 0); !ok || m != 49.5 {
+		t.Errorf("frame 12 mean = %v, %v", m, ok)
+	}
+	signal := emg.Signal(0)
+	if len(signal) != 20 || signal[0] != 40 || !math.IsNaN(float64(signal[12])) || signal[19] != 59 {
+		t.Errorf("signal = %v", signal)
+	}
+	if !emg.Clipped(5) || emg.Clipped(4.9) {
+		t.Error("range not applied")
+	}
+}
+
+func TestAlignerRestartsOnNewCapture(t *testing.T) {
+	a := analog.NewAligner(100, nil)
+	a.Add(1, frame(1000))
+	a.Add(2, frame(1004))
+	if gaps := a.Add(1, frame(0)); len(gaps) != 0 {
+		t.Errorf("restart reported as gaps %+v", gaps)
+	}
+	d := a.Device(2)
+	if d == nil {
+		t.Fatal("device from the stream was not tracked")
+	}
+	if first, end := d.Span(); first != 0 || end != 4 {
+		t.Errorf("span after restart = %d..%d", first, end)
+	}
+	// Without a device frequency the ratio comes from the samples per frame.
+	if got := d.SamplesPerFrame(); got != 4 {
+		t.Errorf("SamplesPerFrame = %v", got)
+	}
+	// Before sample 0 the sample numbers wrap, as they do in the stream.
+	if first, count, ok := d.FrameSample(0); !ok || first != math.MaxUint32-3 || count != 4 {
+		t.Errorf("frame 0 = %d+%d, %v", first, count, ok)
+	}
+}
+
+func TestAlignerKeepsHistoryBounded(t *testing.T) {
+	a, err := analog.NewAlignerFromXML(settingsXML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.History = analog.DefaultHistory / 1000 // 10 ms, 4 samples at 400 Hz
+	for f := uint32(0); f < 5; f++ {
+		a.Add(f, frame(4*f))
+	}
+	d := a.Device(2)
+	if first, end := d.Span(); first != 16 || end != 20 {
+		t.Errorf("span = %d..%d", first, end)
+	}
+	if _, _, ok := d.FrameSample(4); !ok {
+		t.Error("the latest frame was trimmed")
+	}
+	if _, ok := d.At(0, 0, 0); ok {
+		t.Error("a trimmed sample was reported")
+	}
+}
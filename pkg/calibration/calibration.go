@@ -0,0 +1,196 @@
+// Package calibration decodes QTM camera calibration results and reports on
+// their quality.
+//
+// Protocol.Calibrate returns the result of a new calibration as XML, and
+// GetParameters with ParameterTypeCalibration returns the current one in the
+// same form. Parse decodes either:
+//
+//	doc, err := rt.Calibrate(false, 5*time.Minute)
+//	if err != nil { ... }
+//	c, err := calibration.Parse(doc)
+//	if err != nil { ... }
+//	r := calibration.NewReport(c, previous, calibration.DefaultThresholds)
+//	r.WriteText(os.Stdout)
+//
+// Lengths are in millimetres, as QTM reports them.
+package calibration
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/mlveggo/qualisys-go/pkg/packets"
+)
+
+// ErrNoCalibration is returned by Parse for a document without a calibration
+// element, such as the settings of a system never calibrated.
+var ErrNoCalibration = errors.New("calibration: no calibration in document")
+
+// Calibration is one calibration of the camera system.
+type Calibration struct {
+	// Source is the path of the calibration file on the QTM machine.
+	Source string `xml:"source,attr"`
+	// Created is as QTM wrote it, in the QTM machine's local time; see Time.
+	Created    string `xml:"created,attr"`
+	QTMVersion string `xml:"qtm-version,attr"`
+	// Type is "regular", "refine" or "fixed".
+	Type          string   `xml:"type,attr"`
+	WandLength    float64  `xml:"wandLength,attr"`
+	MaximumFrames int      `xml:"maximumFrames,attr"`
+	Results       Results  `xml:"results"`
+	Cameras       []Camera `xml:"cameras>camera"`
+}
+
+// Results describes how well the wand was measured: the standard deviation
+// of its length over the calibration and the spread between the shortest and
+// longest reading.
+type Results struct {
+	StdDev     float64 `xml:"std-dev,attr"`
+	MinMaxDiff float64 `xml:"min-max-diff,attr"`
+}
+
+// Camera is the calibration of one camera. A camera that could not be
+// calibrated has Calibrated false and Message saying why.
+type Camera struct {
+	Active     bool   `xml:"active,attr"`
+	Calibrated bool   `xml:"calibrated,attr"`
+	Message    string `xml:"message,attr"`
+	// PointCount is how many wand points the camera contributed, and
+	// AvgResidual their mean residual.
+	PointCount   int       `xml:"point-count,attr"`
+	AvgResidual  float64   `xml:"avg-residual,attr"`
+	Serial       string    `xml:"serial,attr"`
+	Model        string    `xml:"model,attr"`
+	ViewRotation int       `xml:"viewrotation,attr"`
+	FOVMarker    FOV       `xml:"fov_marker"`
+	FOVMarkerMax FOV       `xml:"fov_marker_max"`
+	FOVVideo     FOV       `xml:"fov_video"`
+	FOVVideoMax  FOV       `xml:"fov_video_max"`
+	Transform    Transform `xml:"transform"`
+	Intrinsic    Intrinsic `xml:"intrinsic"`
+}
+
+// FOV is an image area in sensor pixels, edges included.
+type FOV struct {
+	Left   int `xml:"left,attr"`
+	Top    int `xml:"top,attr"`
+	Right  int `xml:"right,attr"`
+	Bottom int `xml:"bottom,attr"`
+}
+
+// Transform places a camera in the lab: its position and the row-major
+// matrix rotating camera coordinates into lab coordinates.
+type Transform struct {
+	X   float64 `xml:"x,attr"`
+	Y   float64 `xml:"y,attr"`
+	Z   float64 `xml:"z,attr"`
+	R11 float64 `xml:"r11,attr"`
+	R12 float64 `xml:"r12,attr"`
+	R13 float64 `xml:"r13,attr"`
+	R21 float64 `xml:"r21,attr"`
+	R22 float64 `xml:"r22,attr"`
+	R23 float64 `xml:"r23,attr"`
+	R31 float64 `xml:"r31,attr"`
+	R32 float64 `xml:"r32,attr"`
+	R33 float64 `xml:"r33,attr"`
+}
+
+// Position returns the camera position.
+func (t Transform) Position() [3]float64 { return [3]float64{t.X, t.Y, t.Z} }
+
+// Matrix returns the rotation matrix in row-major order.
+func (t Transform) Matrix() [9]float64 {
+	return [9]float64{t.R11, t.R12, t.R13, t.R21, t.R22, t.R23, t.R31, t.R32, t.R33}
+}
+
+// Quaternion returns the rotation as a unit quaternion.
+func (t Transform) Quaternion() packets.Rotation {
+	var b packets.BodyMatrix
+	for i, v := range t.Matrix() {
+		b.Rotation[i] = float32(v)
+	}
+	return b.Quaternion()
+}
+
+// Intrinsic is the lens model of a camera. FocalLength is the lens focal
+// length in millimetres; the other values are in sensor subpixel units as QTM
+// reports them. QTM spells the tangential coefficients "tangental".
+type Intrinsic struct {
+	FocalLength          float64 `xml:"focallength,attr"`
+	SensorMinU           float64 `xml:"sensorMinU,attr"`
+	SensorMaxU           float64 `xml:"sensorMaxU,attr"`
+	SensorMinV           float64 `xml:"sensorMinV,attr"`
+	SensorMaxV           float64 `xml:"sensorMaxV,attr"`
+	FocalLengthU         float64 `xml:"focalLengthU,attr"`
+	FocalLengthV         float64 `xml:"focalLengthV,attr"`
+	CenterPointU         float64 `xml:"centerPointU,attr"`
+	CenterPointV         float64 `xml:"centerPointV,attr"`
+	Skew                 float64 `xml:"skew,attr"`
+	RadialDistortion1    float64 `xml:"radialDistortion1,attr"`
+	RadialDistortion2    float64 `xml:"radialDistortion2,attr"`
+	RadialDistortion3    float64 `xml:"radialDistortion3,attr"`
+	TangentalDistortion1 float64 `xml:"tangentalDistortion1,attr"`
+	TangentalDistortion2 float64 `xml:"tangentalDistortion2,attr"`
+}
+
+// Parse decodes the first calibration element of doc, whichever element
+// wraps it.
+func Parse(doc string) (*Calibration, error) {
+	dec := xml.NewDecoder(strings.NewReader(doc))
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			return nil, ErrNoCalibration
+		}
+		if err != nil {
+			return nil, fmt.Errorf("calibration: %w", err)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || !strings.EqualFold(start.Name.Local, "calibration") {
+			continue
+		}
+		var c Calibration
+		if err := dec.DecodeElement(&c, &start); err != nil {
+			return nil, fmt.Errorf("calibration: %w", err)
+		}
+		return &c, nil
+	}
+}
+
+// Time parses Created. QTM does not say which time zone it is in, so the
+// result is in loc.
+func (c *Calibration) Time(loc *time.Location) (time.Time, error) {
+	return time.ParseInLocation("2006-01-02 15:04:05", c.Created, loc)
+}
+
+// Camera returns the camera with the given serial number, or nil.
+func (c *Calibration) Camera(serial string) *Camera {
+	for i := range c.Cameras {
+		if c.Cameras[i].Serial == serial {
+			return &c.Cameras[i]
+		}
+	}
+	return nil
+}
+
+// AvgResidual returns the residual of the calibrated cameras averaged over
+// all their points, or NaN when no camera was calibrated.
+func (c *Calibration) AvgResidual() float64 {
+	var sum float64
+	var points int
+	for _, cam := range c.Cameras {
+		if cam.Calibrated {
+			sum += cam.AvgResidual * float64(cam.PointCount)
+			points += cam.PointCount
+		}
+	}
+	if points == 0 {
+		return math.NaN()
+	}
+	return sum / float64(points)
+}
@@ -0,0 +1,136 @@
+package calibration_test
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mlveggo/qualisys-go/pkg/calibration"
+)
+
+// result is a Calibrate reply for a three camera system whose third camera
+// saw too little of the wand.
+const result = `<?xml version="1.0" encoding="UTF-8"?>
+<QTM_Calibration>
+  <calibration source="C:\Calibrations\cal0042.qca" created="2026-10-19 08:15:02" qtm-version="2024.2 (build 1234)" type="regular" wandLength="601.0000" maximumFrames="1000">
+    <results std-dev="0.2900" min-max-diff="1.1000"/>
+    <cameras>
+      <camera active="1" calibrated="true" message="" point-count="4000" avg-residual="0.4000" serial="28001" model="Miqus M3" viewrotation="0">
+        <fov_marker left="0" top="0" right="1823" bottom="1087"/>
+        <fov_marker_max left="0" top="0" right="1823" bottom="1087"/>
+        <fov_video left="0" top="0" right="1823" bottom="1087"/>
+        <fov_video_max left="0" top="0" right="1823" bottom="1087"/>
+        <transform x="1000" y="2000" z="2500" r11="1" r12="0" r13="0" r21="0" r22="1" r23="0" r31="0" r32="0" r33="1"/>
+        <intrinsic focallength="8.5" sensorMinU="0" sensorMaxU="116672" sensorMinV="0" sensorMaxV="69568" focalLengthU="139000" focalLengthV="139100" centerPointU="58336" centerPointV="34784" skew="0" radialDistortion1="0.1" radialDistortion2="-0.2" radialDistortion3="0.05" tangentalDistortion1="0.001" tangentalDistortion2="-0.002"/>
+      </camera>
+      <camera active="1" calibrated="true" message="" point-count="1000" avg-residual="1.4000" serial="28002" model="Miqus M3" viewrotation="0">
+        <transform x="-1000" y="2000" z="2500" r11="0" r12="-1" r13="0" r21="1" r22="0" r23="0" r31="0" r32="0" r33="1"/>
+      </camera>
+      <camera active="1" calibrated="false" message="Too few wand points" point-count="12" avg-residual="" serial="28003" model="Miqus M5" viewrotation="0"/>
+    </cameras>
+  </calibration>
+</QTM_Calibration>`
+
+// previous is yesterday's calibration, from GetParameters: the second camera
+// has since been turned by 90 degrees and moved 10 mm, the third added and a
+// fourth removed.
+const previous = `<QTM_Parameters_Ver_1.25>
+  <calibration created="2026-10-18 08:10:00" type="regular" wandLength="601.0000">
+    <results std-dev="0.2500" min-max-diff="1.0000"/>
+    <cameras>
+      <camera active="1" calibrated="true" point-count="4000" avg-residual="0.3000" serial="28001" model="Miqus M3">
+        <transform x="1000" y="2000" z="2500" r11="1" r12="0" r13="0" r21="0" r22="1" r23="0" r31="0" r32="0" r33="1"/>
+      </camera>
+      <camera active="1" calibrated="true" point-count="3000" avg-residual="0.3500" serial="28002" model="Miqus M3">
+        <transform x="-1000" y="2010" z="2500" r11="1" r12="0" r13="0" r21="0" r22="1" r23="0" r31="0" r32="0" r33="1"/>
+      </camera>
+      <camera active="1" calibrated="true" point-count="3000" avg-residual="0.3500" serial="28004" model="Miqus M3"/>
+    </cameras>
+  </calibration>
+</QTM_Parameters_Ver_1.25>`
+
+func parse(t *testing.T, doc string) *calibration.Calibration {
+	t.Helper()
+	c, err := calibration.Parse(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestParse(t *testing.T) {
+	c := parse(t, result)
+	if c.WandLength != 601 || c.Results.StdDev != 0.29 || c.Type != "regular" || len(c.Cameras) != 3 {
+		t.Fatalf("calibration %+v", c)
+	}
+	cam := c.Cameras[0]
+	if !cam.Calibrated || cam.PointCount != 4000 || cam.AvgResidual != 0.4 || cam.FOVMarker.Right != 1823 {
+		t.Errorf("camera %+v", cam)
+	}
+	if cam.Transform.Position() != [3]float64{1000, 2000, 2500} || cam.Intrinsic.FocalLength != 8.5 ||
+		cam.Intrinsic.TangentalDistortion2 != -0.002 {
+		t.Errorf("transform %+v, intrinsic %+v", cam.Transform, cam.Intrinsic)
+	}
+	if q := c.Cameras[1].Transform.Quaternion(); math.Abs(float64(q.Z)-math.Sqrt(0.5)) > 1e-6 {
+		t.Errorf("quaternion %+v, want 90 degrees about Z", q)
+	}
+	if failed := c.Camera("28003"); failed == nil || failed.Calibrated || failed.Message != "Too few wand points" {
+		t.Errorf("failed camera %+v", failed)
+	}
+	// Point-weighted: (0.4*4000 + 1.4*1000) / 5000.
+	if got := c.AvgResidual(); math.Abs(got-0.6) > 1e-9 {
+		t.Errorf("AvgResidual = %v", got)
+	}
+	if created, err := c.Time(time.UTC); err != nil || created.Hour() != 8 {
+		t.Errorf("Time = %v, %v", created, err)
+	}
+
+	if _, err := calibration.Parse("<QTM_Parameters_Ver_1.25><General/></QTM_Parameters_Ver_1.25>"); !errors.Is(err, calibration.ErrNoCalibration) {
+		t.Errorf("no calibration: %v", err)
+	}
+}
+
+func TestReport(t *testing.T) {
+	r := calibration.NewReport(parse(t, result), parse(t, previous), calibration.DefaultThresholds)
+	if len(r.Cameras) != 4 {
+		t.Fatalf("%d cameras", len(r.Cameras))
+	}
+	flags := map[string][]calibration.Flag{}
+	for _, cr := range r.Cameras {
+		flags[cr.Serial] = cr.Flags
+	}
+	want := map[string][]calibration.Flag{
+		"28001": nil,
+		"28002": {calibration.FlagResidual, calibration.FlagResidualIncrease, calibration.FlagMoved, calibration.FlagRotated},
+		"28003": {calibration.FlagNotCalibrated, calibration.FlagAdded},
+		"28004": {calibration.FlagMissing},
+	}
+	for serial, f := range want {
+		if !slices.Equal(flags[serial], f) {
+			t.Errorf("camera %s flags %q, want %q", serial, flags[serial], f)
+		}
+	}
+	if p := r.Cameras[1].Previous; p == nil || math.Abs(p.Moved-10) > 1e-9 || math.Abs(p.Rotated-90) > 1e-9 {
+		t.Errorf("change %+v", p)
+	}
+	if r.OK() || len(r.Flagged()) != 3 {
+		t.Errorf("flagged %d", len(r.Flagged()))
+	}
+
+	var text strings.Builder
+	if err := r.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"was 0.330 mm on 2026-10-18", "Too few wand points", "3 of 4 cameras flagged"} {
+		if !strings.Contains(text.String(), s) {
+			t.Errorf("report lacks %q:\n%s", s, text.String())
+		}
+	}
+	if _, err := json.Marshal(r); err != nil {
+		t.Errorf("json: %v", err)
+	}
+}
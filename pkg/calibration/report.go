@@ -0,0 +1,244 @@
+package calibration
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"text/tabwriter"
+)
+
+// Thresholds decide which cameras a Report flags. A zero field disables its
+// check.
+type Thresholds struct {
+	// MaxResidual is the highest acceptable average residual of a camera.
+	MaxResidual float64 `json:"maxResidual,omitempty"`
+	// MaxResidualIncrease is how much a camera's residual may grow over the
+	// previous calibration.
+	MaxResidualIncrease float64 `json:"maxResidualIncrease,omitempty"`
+	// MaxMovement is how far a camera may have moved since the previous
+	// calibration, and MaxRotation how far it may have turned, in degrees.
+	// A camera that moved was likely knocked, and the lab's recordings since
+	// may be suspect.
+	MaxMovement float64 `json:"maxMovement,omitempty"`
+	MaxRotation float64 `json:"maxRotation,omitempty"`
+}
+
+// DefaultThresholds are a starting point for a lab-sized system. Tighten
+// them once a few weeks of reports show what the system normally achieves.
+var DefaultThresholds = Thresholds{
+	MaxResidual:         1,
+	MaxResidualIncrease: 0.25,
+	MaxMovement:         5,
+	MaxRotation:         0.5,
+}
+
+// Flag is a reason a Report singles out a camera.
+type Flag string
+
+const (
+	FlagNotCalibrated    Flag = "not calibrated"
+	FlagResidual         Flag = "residual over threshold"
+	FlagResidualIncrease Flag = "residual increased"
+	FlagMoved            Flag = "moved"
+	FlagRotated          Flag = "rotated"
+	// FlagAdded and FlagMissing mark a camera only the current or only the
+	// previous calibration has.
+	FlagAdded   Flag = "added"
+	FlagMissing Flag = "missing"
+)
+
+// Change compares a camera with its previous calibration.
+type Change struct {
+	Residual float64 `json:"residual"`
+	// ResidualChange is the current residual less the previous one.
+	ResidualChange float64 `json:"residualChange"`
+	// Moved is the distance between the positions, Rotated the angle
+	// between the orientations in degrees.
+	Moved   float64 `json:"moved"`
+	Rotated float64 `json:"rotated"`
+}
+
+// CameraReport is one camera's line of a Report.
+type CameraReport struct {
+	Serial     string  `json:"serial"`
+	Model      string  `json:"model,omitempty"`
+	Calibrated bool    `json:"calibrated"`
+	Message    string  `json:"message,omitempty"`
+	Points     int     `json:"points"`
+	Residual   float64 `json:"residual"`
+	// Previous is nil without a previous calibration of the camera.
+	Previous *Change `json:"previous,omitempty"`
+	Flags    []Flag  `json:"flags,omitempty"`
+}
+
+// Report summarises a calibration's quality for a QA log. It encodes to JSON
+// as is.
+type Report struct {
+	Created     string  `json:"created"`
+	Type        string  `json:"type,omitempty"`
+	QTMVersion  string  `json:"qtmVersion,omitempty"`
+	WandLength  float64 `json:"wandLength"`
+	StdDev      float64 `json:"stdDev"`
+	MinMaxDiff  float64 `json:"minMaxDiff"`
+	AvgResidual float64 `json:"avgResidual"`
+	// PreviousCreated and PreviousAvgResidual describe the calibration
+	// compared against, if any.
+	PreviousCreated     string         `json:"previousCreated,omitempty"`
+	PreviousAvgResidual float64        `json:"previousAvgResidual,omitempty"`
+	Thresholds          Thresholds     `json:"thresholds"`
+	Cameras             []CameraReport `json:"cameras"`
+}
+
+// NewReport reports on c, comparing it with prev unless prev is nil. Cameras
+// are matched by serial number and listed in c's order, followed by any only
+// prev has.
+func NewReport(c, prev *Calibration, t Thresholds) *Report {
+	r := &Report{
+		Created:     c.Created,
+		Type:        c.Type,
+		QTMVersion:  c.QTMVersion,
+		WandLength:  c.WandLength,
+		StdDev:      c.Results.StdDev,
+		MinMaxDiff:  c.Results.MinMaxDiff,
+		AvgResidual: zeroNaN(c.AvgResidual()),
+		Thresholds:  t,
+	}
+	if prev != nil {
+		r.PreviousCreated = prev.Created
+		r.PreviousAvgResidual = zeroNaN(prev.AvgResidual())
+	}
+	for _, cam := range c.Cameras {
+		cr := CameraReport{
+			Serial:     cam.Serial,
+			Model:      cam.Model,
+			Calibrated: cam.Calibrated,
+			Message:    cam.Message,
+			Points:     cam.PointCount,
+			Residual:   cam.AvgResidual,
+		}
+		if !cam.Calibrated {
+			cr.Flags = append(cr.Flags, FlagNotCalibrated)
+		} else if t.MaxResidual > 0 && cam.AvgResidual > t.MaxResidual {
+			cr.Flags = append(cr.Flags, FlagResidual)
+		}
+		if prev != nil {
+			if old := prev.Camera(cam.Serial); old == nil {
+				cr.Flags = append(cr.Flags, FlagAdded)
+			} else if cam.Calibrated && old.Calibrated {
+				ch := compare(cam, *old)
+				cr.Previous = &ch
+				if t.MaxResidualIncrease > 0 && ch.ResidualChange > t.MaxResidualIncrease {
+					cr.Flags = append(cr.Flags, FlagResidualIncrease)
+				}
+				if t.MaxMovement > 0 && ch.Moved > t.MaxMovement {
+					cr.Flags = append(cr.Flags, FlagMoved)
+				}
+				if t.MaxRotation > 0 && ch.Rotated > t.MaxRotation {
+					cr.Flags = append(cr.Flags, FlagRotated)
+				}
+			}
+		}
+		r.Cameras = append(r.Cameras, cr)
+	}
+	if prev != nil {
+		for _, old := range prev.Cameras {
+			if c.Camera(old.Serial) == nil {
+				r.Cameras = append(r.Cameras, CameraReport{
+					Serial: old.Serial,
+					Model:  old.Model,
+					Flags:  []Flag{FlagMissing},
+				})
+			}
+		}
+	}
+	return r
+}
+
+// zeroNaN keeps NaN, which JSON cannot encode, out of a Report.
+func zeroNaN(v float64) float64 {
+	if math.IsNaN(v) {
+		return 0
+	}
+	return v
+}
+
+func compare(cur, old Camera) Change {
+	p, q := cur.Transform.Position(), old.Transform.Position()
+	a, b := cur.Transform.Matrix(), old.Transform.Matrix()
+	// The angle of the rotation taking one orientation to the other follows
+	// from the trace of a^T b.
+	var trace float64
+	for i := range 3 {
+		for k := range 3 {
+			trace += a[k*3+i] * b[k*3+i]
+		}
+	}
+	cos := max(-1, min(1, (trace-1)/2))
+	return Change{
+		Residual:       old.AvgResidual,
+		ResidualChange: cur.AvgResidual - old.AvgResidual,
+		Moved:          math.Sqrt((p[0]-q[0])*(p[0]-q[0]) + (p[1]-q[1])*(p[1]-q[1]) + (p[2]-q[2])*(p[2]-q[2])),
+		Rotated:        math.Acos(cos) * 180 / math.Pi,
+	}
+}
+
+// Flagged returns the cameras with at least one flag.
+func (r *Report) Flagged() []CameraReport {
+	var out []CameraReport
+	for _, cr := range r.Cameras {
+		if len(cr.Flags) > 0 {
+			out = append(out, cr)
+		}
+	}
+	return out
+}
+
+// OK reports whether no camera is flagged.
+func (r *Report) OK() bool { return len(r.Flagged()) == 0 }
+
+// WriteText writes the report as a table for people to read.
+func (r *Report) WriteText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Calibration %s", r.Created)
+	if r.Type != "" {
+		fmt.Fprintf(&b, " (%s)", r.Type)
+	}
+	fmt.Fprintf(&b, "\nWand length %.2f mm, std dev %.3f mm, min-max %.3f mm\n", r.WandLength, r.StdDev, r.MinMaxDiff)
+	fmt.Fprintf(&b, "Average residual %.3f mm", r.AvgResidual)
+	if r.PreviousCreated != "" {
+		fmt.Fprintf(&b, ", was %.3f mm on %s", r.PreviousAvgResidual, r.PreviousCreated)
+	}
+	b.WriteString("\n\n")
+
+	tw := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERIAL\tMODEL\tPOINTS\tRESIDUAL\tCHANGE\tMOVED\tROTATED\tFLAGS")
+	for _, cr := range r.Cameras {
+		residual, change, moved, rotated := "-", "-", "-", "-"
+		if cr.Calibrated {
+			residual = fmt.Sprintf("%.3f", cr.Residual)
+		}
+		if p := cr.Previous; p != nil {
+			change = fmt.Sprintf("%+.3f", p.ResidualChange)
+			moved = fmt.Sprintf("%.1f", p.Moved)
+			rotated = fmt.Sprintf("%.2f", p.Rotated)
+		}
+		flags := make([]string, len(cr.Flags))
+		for i, f := range cr.Flags {
+			flags[i] = string(f)
+		}
+		if cr.Message != "" {
+			flags = append(flags, cr.Message)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n",
+			cr.Serial, cr.Model, cr.Points, residual, change, moved, rotated, strings.Join(flags, ", "))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if n := len(r.Flagged()); n > 0 {
+		fmt.Fprintf(&b, "\n%d of %d cameras flagged\n", n, len(r.Cameras))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -22,7 +22,10 @@ func combineIPAndMask(n *net.IPNet) (net.IP, error) {
 	return ip, nil
 }
 
-func getBroadcastAddresses() ([]string, error) {
+// getBroadcastAddresses returns the IPv4 broadcast address of every up,
+// non-loopback interface, or of only the named interfaces when names is not
+// empty. Naming an interface that does not exist is an error.
+func getBroadcastAddresses(names []string) ([]string, error) {
 	var ips []string
 	// list of system network interfaces
 	// https://golang.org/pkg/net/#Interfaces
@@ -30,9 +33,17 @@ func getBroadcastAddresses() ([]string, error) {
 	if err != nil {
 		return ips, err
 	}
+	for _, name := range names {
+		if !slices.ContainsFunc(interfaces, func(intf net.Interface) bool { return intf.Name == name }) {
+			return nil, fmt.Errorf("discover: no interface named %q", name)
+		}
+	}
 	// mapping between network interface name and index
 	// https://golang.org/pkg/net/#Interface
 	for _, intf := range interfaces {
+		if len(names) > 0 && !slices.Contains(names, intf.Name) {
+			continue
+		}
 		// skip down interface & check next intf
 		if intf.Flags&net.FlagUp == 0 {
 			continue
@@ -50,32 +61,18 @@ func getBroadcastAddresses() ([]string, error) {
 		// network end point address
 		// https://golang.org/pkg/net/#Addr
 		for _, addr := range addrs {
-			var ip net.IP
-
-			// Addr type switch required as a result of IPNet & IPAddr return in
-			// https://golang.org/src/net/interface_windows.go?h=interfaceAddrTable
-			switch v := addr.(type) {
-			// net.IPNet satisfies Addr interface
-			// since it contains Network() & String()
-			// https://golang.org/pkg/net/#IPNet
-			case *net.IPNet:
-				ip = v.IP
-			// net.IPAddr satisfies Addr interface
-			// since it contains Network() & String()
-			// https://golang.org/pkg/net/#IPAddr
-			case *net.IPAddr:
-				ip = v.IP
-			}
+			// Only a net.IPNet carries the mask the broadcast address is
+			// derived from; a bare net.IPAddr, as Windows may return, cannot
+			// be broadcast to.
+			n, ok := addr.(*net.IPNet)
 			// skip loopback & check next addr
-			if ip == nil || ip.IsLoopback() {
+			if !ok || n.IP.IsLoopback() {
 				continue
 			}
-			// convert IP IPv4 address to 4-byte
-			ip = ip.To4()
-			if ip == nil {
+			if n.IP.To4() == nil {
 				continue // not an ipv4 address
 			}
-			ipb, err := combineIPAndMask(addr.(*net.IPNet))
+			ipb, err := combineIPAndMask(n)
 			if err == nil {
 				// return IP address as string
 				ips = append(ips, ipb.String())
@@ -104,10 +101,27 @@ func (dr *Response) String() string {
 	)
 }
 
+// DefaultDiscoveryPort is the UDP port QTM listens for discovery probes on.
+const DefaultDiscoveryPort = 22226
+
 type Discovery struct {
 	receivePort uint16
 	timeout     time.Duration
 
+	// Interfaces, if set, restricts the broadcast to the named network
+	// interfaces, such as "eth1", instead of every up, non-loopback one.
+	Interfaces []string
+	// Targets are further addresses to probe, each a host or host:port; the
+	// port defaults to DefaultDiscoveryPort. A unicast address reaches a QTM
+	// host across routers, where broadcasts do not go; a broadcast address
+	// reaches one subnet. When Targets is set and Interfaces is not, only the
+	// targets are probed.
+	Targets []string
+	// Misses is how many consecutive Watch rounds a host may fail to answer
+	// before it is reported gone. Zero means DefaultMisses. A single lost UDP
+	// reply should not make a machine flicker off a dashboard.
+	Misses int
+
 	// OnMalformedResponse, if set, is called for every reply that could not be
 	// decoded, with the address it arrived from. Discovery continues either
 	// way: one bad reply must not hide every other QTM on the network. Leaving
@@ -119,48 +133,87 @@ type Discovery struct {
 	OnMalformedResponse func(addr string, err error)
 }
 
+// NewDiscovery returns a Discovery that waits timeout for replies on
+// receivePort. A receivePort of zero lets the system pick a free port, which
+// avoids clashing with another discovery running on the same machine.
 func NewDiscovery(receivePort uint16, timeout time.Duration) *Discovery {
 	return &Discovery{receivePort: receivePort, timeout: timeout}
 }
 
+// Discover is DiscoverContext without a context.
 func (d *Discovery) Discover() ([]Response, error) {
-	const broadcastPort string = "22226"
-	ips, err := getBroadcastAddresses()
+	return d.DiscoverContext(context.Background())
+}
+
+// targets returns the addresses to send probes to.
+func (d *Discovery) targets() ([]*net.UDPAddr, error) {
+	var ips []string
+	if len(d.Interfaces) > 0 || len(d.Targets) == 0 {
+		var err error
+		if ips, err = getBroadcastAddresses(d.Interfaces); err != nil {
+			return nil, err
+		}
+	}
+	var addrs []*net.UDPAddr
+	for _, ip := range ips {
+		addrs = append(addrs, &net.UDPAddr{IP: net.ParseIP(ip), Port: DefaultDiscoveryPort})
+	}
+	for _, target := range d.Targets {
+		hostport := target
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			hostport = net.JoinHostPort(target, strconv.Itoa(DefaultDiscoveryPort))
+		}
+		addr, err := net.ResolveUDPAddr("udp4", hostport)
+		if err != nil {
+			return nil, fmt.Errorf("discover resolve udp address: %w", err)
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
+// DiscoverContext probes for QTM hosts and returns those that answer within
+// the timeout, without duplicates. If ctx ends first, the hosts found so far
+// are returned with ctx's error.
+func (d *Discovery) DiscoverContext(ctx context.Context) ([]Response, error) {
+	addrs, err := d.targets()
 	if err != nil {
 		return nil, err
 	}
-	// ListenConfig.ListenPacket rather than net.ListenPacket: identical
-	// behavior, but it is the form that can carry a context once one is
-	// plumbed through the public API. Discover takes none today.
 	var lc net.ListenConfig
-	conn, err := lc.ListenPacket(context.Background(), "udp", ":"+strconv.Itoa(int(d.receivePort)))
+	conn, err := lc.ListenPacket(ctx, "udp", ":"+strconv.Itoa(int(d.receivePort)))
 	if err != nil {
 		return nil, err
 	}
-	defer func() {
-		if conn != nil {
-			conn.Close()
-		}
-	}()
-	data := make([]byte, 10)
-	binary.LittleEndian.PutUint32(data, 10)
-	binary.LittleEndian.PutUint32(data[4:8], 7)
-	binary.BigEndian.PutUint16(data[8:10], d.receivePort)
-	for _, ip := range ips {
-		ipp := net.JoinHostPort(ip, broadcastPort)
-		addr, err := net.ResolveUDPAddr("udp", ipp)
-		if err != nil {
-			return nil, fmt.Errorf("discover resolve udp address: %w", err)
-		}
-		_, err = conn.WriteTo(data, addr)
-		if err != nil {
+	defer conn.Close()
+	// Cancelling ctx cuts the read deadline short, which ends the loop below
+	// the same way the timeout does.
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetReadDeadline(time.Now()) })
+	defer stop()
+
+	// The probe names the port to reply to, which is only known after
+	// binding when the system picked it.
+	replyPort := d.receivePort
+	if a, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		replyPort = uint16(a.Port)
+	}
+	data := make([]byte, probeSize)
+	binary.LittleEndian.PutUint32(data, probeSize)
+	binary.LittleEndian.PutUint32(data[4:8], packetTypeDiscover)
+	binary.BigEndian.PutUint16(data[8:10], replyPort)
+	for _, addr := range addrs {
+		if _, err := conn.WriteTo(data, addr); err != nil {
 			return nil, fmt.Errorf("discover write to connection: %w", err)
 		}
 	}
-	b := make([]byte, 1024)
+	b := make([]byte, maxResponseSize)
 	if err := conn.SetReadDeadline(time.Now().Add(d.timeout)); err != nil {
 		return nil, fmt.Errorf("discover: setreaddeadline: %w", err)
 	}
+	if ctx.Err() != nil {
+		// AfterFunc may have fired before the deadline above replaced it.
+		return nil, ctx.Err()
+	}
 	responses := make([]Response, 0, 1)
 	for {
 		size, addr, err := conn.ReadFrom(b)
@@ -191,7 +244,42 @@ func (d *Discovery) Discover() ([]Response, error) {
 			responses = append(responses, dr)
 		}
 	}
-	return responses, nil
+	return responses, ctx.Err()
+}
+
+// Discovery packets are framed like RT packets: a little-endian size and
+// type, then the payload.
+const (
+	probeSize            = 10
+	packetTypeDiscover   = 7
+	discoveryHeaderBytes = 8
+	// maxResponseSize is the longest reply DiscoverContext reads whole.
+	maxResponseSize = 1024
+)
+
+// MarshalBinary encodes the response as QTM sends it: the header, then
+// "hostname, version, N cameras" NUL-terminated, then the base port. Address
+// is not part of it; the receiver takes it from the sender.
+func (dr *Response) MarshalBinary() ([]byte, error) {
+	if dr.BasePort < 0 || dr.BasePort > 0xFFFF {
+		return nil, fmt.Errorf("discover: base port %d out of range", dr.BasePort)
+	}
+	for _, s := range []string{dr.Hostname, dr.QtmVersion} {
+		if strings.ContainsAny(s, ",\x00") {
+			return nil, fmt.Errorf("discover: %q contains a comma or NUL", s)
+		}
+	}
+	info := fmt.Sprintf("%s, %s, %d cameras\x00", dr.Hostname, dr.QtmVersion, dr.Cameras)
+	size := discoveryHeaderBytes + len(info) + 2
+	if size > maxResponseSize {
+		return nil, fmt.Errorf("discover: response of %d bytes is longer than the %d a client reads", size, maxResponseSize)
+	}
+	data := make([]byte, size)
+	binary.LittleEndian.PutUint32(data, uint32(size))
+	binary.LittleEndian.PutUint32(data[4:8], packetTypeDiscover)
+	copy(data[discoveryHeaderBytes:], info)
+	binary.BigEndian.PutUint16(data[size-2:], uint16(dr.BasePort))
+	return data, nil
 }
 
 func (dr *Response) UnmarshalBinary(data []byte) error {
@@ -1,9 +1,13 @@
 package discover_test
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
+	"net"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/mlveggo/qualisys-go/pkg/discover"
 )
@@ -60,3 +64,237 @@ func TestUnmarshalBinaryRejectsMalformedInfo(t *testing.T) {
 		t.Error("expected an error for a malformed information field")
 	}
 }
+
+// responder serves r on a loopback port until the test ends or the returned
+// cancel is called, and returns the address to probe.
+func responder(t *testing.T, r *discover.Responder) (string, context.CancelFunc) {
+	t.Helper()
+	var lc net.ListenConfig
+	conn, err := lc.ListenPacket(context.Background(), "udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- r.Serve(ctx, conn) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+	})
+	return conn.LocalAddr().String(), cancel
+}
+
+func TestResponseRoundTrip(t *testing.T) {
+	want := discover.Response{Hostname: "Lab", QtmVersion: "QTM 2025.1", Cameras: 12, BasePort: 22222}
+	b, err := want.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := binary.LittleEndian.Uint32(b); int(size) != len(b) {
+		t.Errorf("size field %d, packet %d bytes", size, len(b))
+	}
+	var got discover.Response
+	if err := got.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	bad := discover.Response{Hostname: "a,b"}
+	if _, err := bad.MarshalBinary(); err == nil {
+		t.Error("a host name with a comma was encoded")
+	}
+}
+
+func TestResponderRejectsUnencodableResponse(t *testing.T) {
+	long := discover.Response{Hostname: strings.Repeat("x", 2000), QtmVersion: "QTM 2025.1", BasePort: 22222}
+	if _, err := discover.NewResponder(long); err == nil {
+		t.Error("NewResponder accepted a host name too long to send")
+	}
+	good := discover.Response{Hostname: "sim", QtmVersion: "QTM 2025.1", Cameras: 8, BasePort: 22222}
+	r, err := discover.NewResponder(good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetResponse(long); err == nil {
+		t.Error("SetResponse accepted a host name too long to send")
+	}
+	if r.Response() != good {
+		t.Errorf("response after a rejected change = %+v", r.Response())
+	}
+}
+
+func TestDiscoverContextTargets(t *testing.T) {
+	want := discover.Response{Address: "127.0.0.1", Hostname: "Lab", QtmVersion: "QTM 2025.1", Cameras: 12, BasePort: 22222}
+	r, err := discover.NewResponder(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	malformed := make(chan string, 1)
+	r.OnMalformedRequest = func(addr string, err error) { malformed <- addr }
+	target, _ := responder(t, r)
+	d := discover.NewDiscovery(0, 200*time.Millisecond)
+	d.Targets = []string{target}
+	responses, err := d.DiscoverContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(responses) != 1 || responses[0] != want {
+		t.Errorf("responses = %+v", responses)
+	}
+
+	var dialer net.Dialer
+	junk, err := dialer.DialContext(context.Background(), "udp4", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer junk.Close()
+	if _, err := junk.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case addr := <-malformed:
+		if addr != "127.0.0.1" {
+			t.Errorf("malformed probe reported from %q", addr)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("a malformed probe went unreported")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := d.DiscoverContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled discovery: %v", err)
+	}
+
+	d.Interfaces = []string{"no-such-interface"}
+	if _, err := d.Discover(); err == nil {
+		t.Error("an unknown interface was accepted")
+	}
+}
+
+func TestWatchReportsChanges(t *testing.T) {
+	r, err := discover.NewResponder(discover.Response{Hostname: "Lab", QtmVersion: "QTM 2025.1", Cameras: 12, BasePort: 22222})
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, stopResponder := responder(t, r)
+
+	d := discover.NewDiscovery(0, 30*time.Millisecond)
+	d.Targets = []string{target}
+	events := make(chan discover.Event, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- d.Watch(ctx, 50*time.Millisecond, func(e discover.Event) { events <- e }) }()
+
+	next := func() discover.Event {
+		t.Helper()
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(2 * time.Second):
+			t.Fatal("no event")
+			return discover.Event{}
+		}
+	}
+	if e := next(); e.Kind != discover.Appeared || e.Response.Cameras != 12 {
+		t.Errorf("first event = %+v", e)
+	}
+	changed := r.Response()
+	changed.Cameras = 14
+	if err := r.SetResponse(changed); err != nil {
+		t.Fatal(err)
+	}
+	if e := next(); e.Kind != discover.Changed || e.Response.Cameras != 14 || e.Previous.Cameras != 12 {
+		t.Errorf("second event = %v %+v", e.Kind, e)
+	}
+	stopResponder()
+	if e := next(); e.Kind != discover.Disappeared || e.Response.Hostname != "Lab" {
+		t.Errorf("third event = %v %+v", e.Kind, e)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("Watch returned %v", err)
+	}
+}
+
+func TestWatchSurvivesFailedRounds(t *testing.T) {
+	// Holding the receive port makes every round fail to bind.
+	var lc net.ListenConfig
+	taken, err := lc.ListenPacket(context.Background(), "udp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	d := discover.NewDiscovery(uint16(taken.LocalAddr().(*net.UDPAddr).Port), 10*time.Millisecond)
+	d.Targets = []string{"127.0.0.1:22226"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	failed := 0
+	err = d.Watch(ctx, 10*time.Millisecond, func(e discover.Event) {
+		if e.Kind != discover.Failed || e.Err == nil {
+			t.Errorf("event %v %+v", e.Kind, e)
+		}
+		if failed++; failed == 3 {
+			cancel()
+		}
+	})
+	if err != nil || failed != 3 {
+		t.Errorf("Watch returned %v after %d failed rounds", err, failed)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	lab1 := discover.Response{Address: "10.0.1.5", Hostname: "LAB1", QtmVersion: "QTM 2024.2 20100", Cameras: 8, BasePort: 22222}
+	lab1b := lab1
+	lab1b.Address = "10.0.9.5" // the same QTM reached on its second network card
+	lab2 := discover.Response{Address: "10.0.2.5", Hostname: "lab2", QtmVersion: "QTM 2025.1 32300", Cameras: 24, BasePort: 22222}
+	all := []discover.Response{lab1, lab1b, lab2}
+
+	for _, tc := range []struct {
+		selector string
+		want     discover.Response
+		err      error
+	}{
+		{"lab1", lab1, nil},
+		{"host=Lab2", lab2, nil},
+		{"cameras>=10", lab2, nil},
+		{"version<2025", lab1, nil},
+		{"version=2024.2", lab1, nil},
+		{"version>=2024.2, cameras>=8", discover.Response{}, discover.ErrAmbiguous},
+		{"", discover.Response{}, discover.ErrAmbiguous},
+		{"lab3", discover.Response{}, discover.ErrNoMatch},
+	} {
+		sel, err := discover.ParseSelector(tc.selector)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.selector, err)
+		}
+		got, err := discover.Select(all, sel)
+		if !errors.Is(err, tc.err) || got != tc.want {
+			t.Errorf("%q: got %+v, %v", tc.selector, got, err)
+		}
+	}
+	if got, err := discover.Select([]discover.Response{lab1, lab1b}, discover.Selector{}); err != nil || got != lab1 {
+		t.Errorf("one QTM on two addresses: %+v, %v", got, err)
+	}
+
+	sel := discover.Selector{Hostname: "lab2", MinCameras: 8, Version: ">=2025"}
+	if parsed, err := discover.ParseSelector(sel.String()); err != nil || parsed != sel {
+		t.Errorf("ParseSelector(%q) = %+v, %v", sel.String(), parsed, err)
+	}
+	if parsed, err := discover.ParseSelector("versionlab"); err != nil || parsed != (discover.Selector{Hostname: "versionlab"}) {
+		t.Errorf("host named versionlab: %+v, %v", parsed, err)
+	}
+	if got := (discover.Selector{Version: "2025.1"}).String(); got != "version=2025.1" {
+		t.Errorf("version without an operator formats as %q", got)
+	}
+	for _, bad := range []string{"cameras>=many", "version>=new", "version=", "cams=3"} {
+		if _, err := discover.ParseSelector(bad); err == nil {
+			t.Errorf("%q was accepted", bad)
+		}
+	}
+}
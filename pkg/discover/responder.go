@@ -0,0 +1,120 @@
+package discover
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Responder answers discovery probes the way QTM does, so that a simulated RT
+// server can be found like a real one:
+//
+//	var lc net.ListenConfig
+//	conn, _ := lc.ListenPacket(ctx, "udp4", ":22226")
+//	r, err := discover.NewResponder(discover.Response{
+//		Hostname: "sim", QtmVersion: "QTM 2025.1", Cameras: 8, BasePort: 22222,
+//	})
+//	if err != nil { ... }
+//	go r.Serve(ctx, conn)
+//
+// It is safe for concurrent use.
+type Responder struct {
+	// OnMalformedRequest, if set, is called for every datagram that is not a
+	// discovery probe, with the address it arrived from. Such datagrams are
+	// ignored either way.
+	OnMalformedRequest func(addr string, err error)
+
+	mu       sync.RWMutex
+	response Response
+	// reply is response encoded, ready to send.
+	reply []byte
+}
+
+// NewResponder answers with response. Its Address is ignored; clients take
+// the address from the reply itself. It fails if response cannot be encoded,
+// for example when the host name is too long.
+func NewResponder(response Response) (*Responder, error) {
+	r := &Responder{}
+	if err := r.SetResponse(response); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// SetResponse replaces the answer, for example when the simulated camera
+// count changes. Watchers report the change on their next round. If response
+// cannot be encoded it returns the error and the previous answer stays.
+func (r *Responder) SetResponse(response Response) error {
+	reply, err := response.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.response, r.reply = response, reply
+	return nil
+}
+
+// Response returns the current answer.
+func (r *Responder) Response() Response {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.response
+}
+
+// parseProbe returns the port a probe asks to be answered on.
+func parseProbe(data []byte) (uint16, error) {
+	if len(data) != probeSize {
+		return 0, fmt.Errorf("discover: probe of %d bytes, want %d", len(data), probeSize)
+	}
+	if size := binary.LittleEndian.Uint32(data); size != probeSize {
+		return 0, fmt.Errorf("discover: probe size field %d, want %d", size, probeSize)
+	}
+	if typ := binary.LittleEndian.Uint32(data[4:8]); typ != packetTypeDiscover {
+		return 0, fmt.Errorf("discover: packet type %d is not a discovery probe", typ)
+	}
+	port := binary.BigEndian.Uint16(data[8:10])
+	if port == 0 {
+		return 0, errors.New("discover: probe asks for a reply on port 0")
+	}
+	return port, nil
+}
+
+// Serve answers probes arriving on conn until ctx is cancelled, and closes
+// conn when it returns. Each reply goes to the probe's source address, at the
+// port the probe names, as QTM's do.
+func (r *Responder) Serve(ctx context.Context, conn net.PacketConn) error {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	b := make([]byte, 64)
+	for {
+		n, from, err := conn.ReadFrom(b)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		src, ok := from.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		port, err := parseProbe(b[:n])
+		if err != nil {
+			if r.OnMalformedRequest != nil {
+				r.OnMalformedRequest(src.IP.String(), err)
+			}
+			continue
+		}
+		r.mu.RLock()
+		reply := r.reply
+		r.mu.RUnlock()
+		// A reply that cannot be sent concerns only the client that asked.
+		_, _ = conn.WriteTo(reply, &net.UDPAddr{IP: src.IP, Port: int(port), Zone: src.Zone})
+	}
+}
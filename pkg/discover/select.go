@@ -0,0 +1,233 @@
+package discover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrNoMatch is returned when no QTM host that answered fits a Selector.
+	ErrNoMatch = errors.New("discover: no QTM matches")
+	// ErrAmbiguous is returned when more than one QTM host fits a Selector.
+	// Connecting to an arbitrary one of them is how a client ends up on the
+	// wrong system.
+	ErrAmbiguous = errors.New("discover: more than one QTM matches")
+)
+
+// Selector picks one QTM host out of those that answer discovery. Every set
+// field must match; the zero Selector matches any host, so it selects the only
+// one answering.
+type Selector struct {
+	// Hostname matches the host name, ignoring case.
+	Hostname string
+	// MinCameras is the fewest cameras the host must have.
+	MinCameras int
+	// Version constrains the QTM version: an operator, one of >=, >, <=, <
+	// or =, and a dotted version such as 2024.2. Without an operator, = is
+	// assumed. Only the components given are compared, so "=2025" matches
+	// 2025.1 and 2025.2 alike.
+	Version string
+}
+
+// ParseSelector parses a comma separated list of terms: host=NAME,
+// cameras>=N and version followed by an operator and a version, as in
+// "host=lab2,cameras>=8,version>=2024.2". A term without a key is a host name.
+// An empty string is the zero Selector.
+func ParseSelector(s string) (Selector, error) {
+	var sel Selector
+	for _, term := range splitAndTrimStrings(s, ",") {
+		switch {
+		case term == "":
+		case strings.HasPrefix(term, "host="):
+			sel.Hostname = strings.TrimPrefix(term, "host=")
+		case strings.HasPrefix(term, "cameras>="):
+			n, err := strconv.Atoi(strings.TrimPrefix(term, "cameras>="))
+			if err != nil {
+				return Selector{}, fmt.Errorf("discover: selector %q: %w", term, err)
+			}
+			sel.MinCameras = n
+		case isVersionTerm(term):
+			sel.Version = strings.TrimPrefix(term, "version")
+			if _, _, err := parseConstraint(sel.Version); err != nil {
+				return Selector{}, err
+			}
+		case strings.ContainsAny(term, "=<>"):
+			return Selector{}, fmt.Errorf("discover: selector %q: unknown term", term)
+		default:
+			sel.Hostname = term
+		}
+	}
+	return sel, nil
+}
+
+// isVersionTerm reports whether term is "version" followed by an operator, so
+// a host named, say, versionlab is still a host name.
+func isVersionTerm(term string) bool {
+	rest, ok := strings.CutPrefix(term, "version")
+	rest = strings.TrimSpace(rest)
+	return ok && rest != "" && strings.ContainsRune("=<>", rune(rest[0]))
+}
+
+// String formats the selector as ParseSelector accepts it.
+func (s Selector) String() string {
+	var terms []string
+	if s.Hostname != "" {
+		terms = append(terms, "host="+s.Hostname)
+	}
+	if s.MinCameras > 0 {
+		terms = append(terms, "cameras>="+strconv.Itoa(s.MinCameras))
+	}
+	if v := strings.TrimSpace(s.Version); v != "" {
+		if !strings.ContainsRune("=<>", rune(v[0])) {
+			v = "=" + v
+		}
+		terms = append(terms, "version"+v)
+	}
+	return strings.Join(terms, ",")
+}
+
+var versionNumber = regexp.MustCompile(`\d+(\.\d+)*`)
+
+// parseVersion returns the numeric components of the first dotted number in
+// s, so "QTM 2025.1 32300" gives [2025 1].
+func parseVersion(s string) []int {
+	m := versionNumber.FindString(s)
+	if m == "" {
+		return nil
+	}
+	var v []int
+	for _, part := range strings.Split(m, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil
+		}
+		v = append(v, n)
+	}
+	return v
+}
+
+func parseConstraint(c string) (op string, v []int, err error) {
+	c = strings.TrimSpace(c)
+	for _, candidate := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(c, candidate) {
+			op, c = candidate, strings.TrimSpace(c[len(candidate):])
+			break
+		}
+	}
+	if op == "" {
+		op = "="
+	}
+	if !versionNumber.MatchString(c) || versionNumber.FindString(c) != c {
+		return "", nil, fmt.Errorf("discover: invalid version constraint %q", c)
+	}
+	return op, parseVersion(c), nil
+}
+
+// compareVersions compares a with b over b's components.
+func compareVersions(a, b []int) int {
+	for i, y := range b {
+		x := 0
+		if i < len(a) {
+			x = a[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// Match reports whether r fits the selector. A host whose version cannot be
+// read never fits a version constraint.
+func (s Selector) Match(r Response) bool {
+	if s.Hostname != "" && !strings.EqualFold(s.Hostname, r.Hostname) {
+		return false
+	}
+	if r.Cameras < s.MinCameras {
+		return false
+	}
+	if s.Version != "" {
+		op, want, err := parseConstraint(s.Version)
+		have := parseVersion(r.QtmVersion)
+		if err != nil || have == nil {
+			return false
+		}
+		c := compareVersions(have, want)
+		switch op {
+		case ">=":
+			return c >= 0
+		case ">":
+			return c > 0
+		case "<=":
+			return c <= 0
+		case "<":
+			return c < 0
+		default:
+			return c == 0
+		}
+	}
+	return true
+}
+
+// sameInstance reports whether a and b are one QTM answering on two of its
+// addresses, as a machine with two network cards on the probed networks does.
+func sameInstance(a, b Response) bool {
+	a.Address, b.Address = "", ""
+	return a == b
+}
+
+// Select returns the one response that fits sel. It fails with ErrNoMatch or
+// ErrAmbiguous, naming the hosts that answered or matched.
+func Select(responses []Response, sel Selector) (Response, error) {
+	var matches []Response
+	for _, r := range responses {
+		if sel.Match(r) && !slices.ContainsFunc(matches, func(m Response) bool { return sameInstance(m, r) }) {
+			matches = append(matches, r)
+		}
+	}
+	switch len(matches) {
+	case 1:
+		return matches[0], nil
+	case 0:
+		return Response{}, fmt.Errorf("%w%s; %s", ErrNoMatch, sel.quoted(), describe(responses, "answered"))
+	default:
+		return Response{}, fmt.Errorf("%w%s; %s", ErrAmbiguous, sel.quoted(), describe(matches, "matching"))
+	}
+}
+
+// quoted is the selector for error messages, which need nothing for the zero
+// Selector.
+func (s Selector) quoted() string {
+	if s == (Selector{}) {
+		return ""
+	}
+	return fmt.Sprintf(" %q", s.String())
+}
+
+func describe(responses []Response, verb string) string {
+	if len(responses) == 0 {
+		return "none " + verb
+	}
+	hosts := make([]string, len(responses))
+	for i := range responses {
+		hosts[i] = responses[i].String()
+	}
+	return verb + ": " + strings.Join(hosts, "; ")
+}
+
+// Find discovers QTM hosts and returns the one sel selects.
+func (d *Discovery) Find(ctx context.Context, sel Selector) (Response, error) {
+	responses, err := d.DiscoverContext(ctx)
+	if err != nil {
+		return Response{}, err
+	}
+	return Select(responses, sel)
+}
@@ -0,0 +1,125 @@
+package discover
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// DefaultMisses is how many consecutive rounds a host may miss before Watch
+// reports it gone, unless Discovery.Misses says otherwise.
+const DefaultMisses = 2
+
+//go:generate stringer -type EventKind
+
+type EventKind int
+
+const (
+	// Appeared is reported the first time a host answers.
+	Appeared EventKind = iota + 1
+	// Disappeared is reported once a host has missed Misses rounds in a row.
+	Disappeared
+	// Changed is reported when a host answers with a different host name, QTM
+	// version or camera count than before.
+	Changed
+	// Failed is reported when a round of probing fails, with the error in
+	// Event.Err. Hosts are neither added nor missed for that round.
+	Failed
+)
+
+// Event is a change in the set of QTM hosts answering discovery.
+type Event struct {
+	Kind EventKind
+	// Response is the host's latest answer; for Disappeared, its last one.
+	Response Response
+	// Previous is the answer Response replaced, for Changed only.
+	Previous Response
+	// Err is why the round failed, for Failed only.
+	Err error
+}
+
+// hostKey identifies a QTM instance. Two instances on one machine differ in
+// base port.
+type hostKey struct {
+	address  string
+	basePort int
+}
+
+type watched struct {
+	response Response
+	missed   int
+}
+
+// Watch probes for QTM hosts every interval and calls handle for each host
+// that appears, changes or disappears. The first round reports every host
+// found as Appeared. A round that fails, say because an interface went down,
+// is reported as Failed and the next round tried as usual. Watch runs until ctx
+// is done, returning nil when it is cancelled. An interval shorter than the
+// timeout is raised to it.
+//
+// handle runs on Watch's goroutine; a slow handle delays the next round.
+func (d *Discovery) Watch(ctx context.Context, interval time.Duration, handle func(Event)) error {
+	misses := d.Misses
+	if misses <= 0 {
+		misses = DefaultMisses
+	}
+	interval = max(interval, d.timeout)
+	hosts := make(map[hostKey]*watched)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		responses, err := d.DiscoverContext(ctx)
+		if ctx.Err() != nil {
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return nil
+			}
+			return ctx.Err()
+		}
+		if err != nil {
+			handle(Event{Kind: Failed, Err: err})
+		} else {
+			update(hosts, responses, misses, handle)
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return nil
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// update brings hosts up to date with a round's responses, reporting each
+// host that appears, changes or has now missed too many rounds.
+func update(hosts map[hostKey]*watched, responses []Response, misses int, handle func(Event)) {
+	seen := make(map[hostKey]bool, len(responses))
+	for _, r := range responses {
+		key := hostKey{r.Address, r.BasePort}
+		seen[key] = true
+		h, ok := hosts[key]
+		switch {
+		case !ok:
+			hosts[key] = &watched{response: r}
+			handle(Event{Kind: Appeared, Response: r})
+		case h.response != r:
+			prev := h.response
+			h.response, h.missed = r, 0
+			handle(Event{Kind: Changed, Response: r, Previous: prev})
+		default:
+			h.missed = 0
+		}
+	}
+	for key, h := range hosts {
+		if seen[key] {
+			continue
+		}
+		if h.missed++; h.missed >= misses {
+			delete(hosts, key)
+			handle(Event{Kind: Disappeared, Response: h.response})
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package fanout
+
+import (
+	"fmt"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+)
+
+// Decimator thins a frame stream client side, with the same rate semantics as
+// the StreamFrames command: every frame, a target frequency, or every Nth
+// frame. It lets each consumer of a shared stream pick its own rate while QTM
+// streams once at the highest rate anyone needs.
+type Decimator struct {
+	rate  qualisys.StreamRateType
+	value int
+
+	count   int
+	started bool
+	// origin is the timestamp the frequency schedule counts from, and n
+	// the number of periods since then that have been kept.
+	origin uint64
+	n      uint64
+}
+
+// NewDecimator validates the rate the way StreamFrames would.
+func NewDecimator(rate qualisys.StreamRateType, value int) (*Decimator, error) {
+	switch rate {
+	case qualisys.StreamRateTypeAllFrames:
+	case qualisys.StreamRateTypeFrequency, qualisys.StreamRateTypeFrequencyDivisor:
+		if value <= 0 {
+			return nil, fmt.Errorf("decimator: %v needs a positive value, got %d", rate, value)
+		}
+	default:
+		return nil, fmt.Errorf("decimator: invalid rate type %d", int(rate))
+	}
+	return &Decimator{rate: rate, value: value}, nil
+}
+
+// Keep reports whether frame d should be passed on.
+//
+// Frequency decimation works on the frame timestamp, which QTM reports in
+// microseconds, rather than on wall-clock arrival time, so network jitter does
+// not turn a steady 100 Hz capture into an uneven 30 Hz stream. Frames are
+// kept on a schedule of whole periods from the first one, rather than a period
+// after the last frame kept, so a rate that does not divide the source's is
+// still met on average: 30 Hz from 100 Hz keeps 30 frames a second, not 25.
+func (dec *Decimator) Keep(d *qualisys.DataPacket) bool {
+	switch dec.rate {
+	case qualisys.StreamRateTypeFrequencyDivisor:
+		keep := dec.count%dec.value == 0
+		dec.count++
+		return keep
+	case qualisys.StreamRateTypeFrequency:
+		period := uint64(1_000_000 / dec.value)
+		next := dec.at(dec.n)
+		switch {
+		case !dec.started:
+		case d.Timestamp < next:
+			// A timestamp far behind the schedule means QTM restarted the
+			// measurement, not that the frame is early.
+			if next-d.Timestamp <= period {
+				return false
+			}
+		case d.Timestamp-next < period:
+			dec.n++
+			return true
+		}
+		// Start the schedule afresh after a restart or a gap, rather than
+		// passing on a burst of frames to catch up.
+		dec.started = true
+		dec.origin, dec.n = d.Timestamp, 1
+		return true
+	}
+	return true
+}
+
+// at is the timestamp of the nth period of the frequency schedule.
+func (dec *Decimator) at(n uint64) uint64 {
+	return dec.origin + n*1_000_000/uint64(dec.value)
+}
@@ -0,0 +1,158 @@
+// Package fanout distributes packets from a single QTM connection to any number
+// of consumers.
+//
+// QTM serves every RT client from the capture machine, so ten browser tabs or
+// ten lab tools each opening their own stream multiplies the load on the one
+// computer that can least afford it. A Hub lets one Protocol receive and many
+// consumers read, each at its own pace: a slow consumer loses its oldest queued
+// packets rather than stalling the others.
+package fanout
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+)
+
+// DefaultBuffer is the per-subscription queue length used when Subscribe is
+// given a non-positive size.
+const DefaultBuffer = 16
+
+// Hub broadcasts packets to subscriptions. It is safe for concurrent use.
+//
+// Published packets are shared between all subscribers, so consumers must treat
+// them as read-only. Every packet Protocol returns is freshly allocated and its
+// decoded components own their memory, so sharing them is otherwise safe.
+type Hub struct {
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscription is one consumer's queue of packets.
+type Subscription struct {
+	hub     *Hub
+	ch      chan *qualisys.Packet
+	dropped atomic.Uint64
+}
+
+// C returns the channel packets arrive on. It is closed when the subscription
+// or the hub is closed.
+func (s *Subscription) C() <-chan *qualisys.Packet {
+	return s.ch
+}
+
+// Dropped reports how many packets were discarded because the consumer fell
+// behind.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close unsubscribes and closes the channel. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	if _, ok := s.hub.subs[s]; ok {
+		delete(s.hub.subs, s)
+		close(s.ch)
+	}
+}
+
+// Subscribe registers a consumer with a queue of buffer packets. Subscribing to
+// a closed hub returns a subscription whose channel is already closed.
+func (h *Hub) Subscribe(buffer int) *Subscription {
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	s := &Subscription{hub: h, ch: make(chan *qualisys.Packet, buffer)}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(s.ch)
+		return s
+	}
+	h.subs[s] = struct{}{}
+	return s
+}
+
+// Len reports the number of live subscriptions.
+func (h *Hub) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+// Publish hands p to every subscription without blocking. A subscription whose
+// queue is full has its oldest packet dropped to make room: for live motion
+// data the newest frame is the one worth having.
+func (h *Hub) Publish(p *qualisys.Packet) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		select {
+		case s.ch <- p:
+			continue
+		default:
+		}
+		// Only Publish sends, and it holds the lock, so after taking one
+		// packet out there is guaranteed room unless the consumer raced us to
+		// it -- in which case there is room anyway.
+		select {
+		case <-s.ch:
+			s.dropped.Add(1)
+		default:
+		}
+		select {
+		case s.ch <- p:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// Close closes every subscription. Later Publish calls are no-ops.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	h.closed = true
+	for s := range h.subs {
+		delete(h.subs, s)
+		close(s.ch)
+	}
+}
+
+// Run reads packets with receive and publishes them until ctx is cancelled or
+// receive fails. Pass Protocol.Receive or Protocol.ReceiveUDP. Idle reads
+// (PacketTypeNoMoreData) are not published.
+//
+// Run returns nil when ctx is cancelled and the receive error otherwise; it
+// does not close the hub, since a caller reconnecting to QTM will want to keep
+// its subscribers.
+func Run(ctx context.Context, receive func() (*qualisys.Packet, error), h *Hub) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			if errors.Is(err, context.Canceled) {
+				return nil
+			}
+			return err
+		}
+		p, err := receive()
+		if err != nil {
+			return err
+		}
+		if p.EndOfData() {
+			continue
+		}
+		h.Publish(p)
+	}
+}
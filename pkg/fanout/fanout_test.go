@@ -0,0 +1,153 @@
+package fanout_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/pkg/fanout"
+)
+
+func frame(n uint32, timestamp uint64) *qualisys.Packet {
+	return &qualisys.Packet{
+		Type: qualisys.PacketTypeData,
+		Data: qualisys.DataPacket{Frame: n, Timestamp: timestamp},
+	}
+}
+
+func TestHubDeliversToEverySubscriber(t *testing.T) {
+	h := fanout.NewHub()
+	a, b := h.Subscribe(4), h.Subscribe(4)
+	h.Publish(frame(1, 0))
+	for _, s := range []*fanout.Subscription{a, b} {
+		if p := <-s.C(); p.Data.Frame != 1 {
+			t.Errorf("got frame %d, want 1", p.Data.Frame)
+		}
+	}
+}
+
+func TestHubDropsOldestForSlowSubscriber(t *testing.T) {
+	h := fanout.NewHub()
+	s := h.Subscribe(2)
+	for i := uint32(1); i <= 5; i++ {
+		h.Publish(frame(i, 0))
+	}
+	if got := s.Dropped(); got != 3 {
+		t.Errorf("Dropped = %d, want 3", got)
+	}
+	// The newest frames survive, not the oldest.
+	if p := <-s.C(); p.Data.Frame != 4 {
+		t.Errorf("first queued frame = %d, want 4", p.Data.Frame)
+	}
+	if p := <-s.C(); p.Data.Frame != 5 {
+		t.Errorf("second queued frame = %d, want 5", p.Data.Frame)
+	}
+}
+
+func TestHubCloseClosesSubscriptions(t *testing.T) {
+	h := fanout.NewHub()
+	s := h.Subscribe(1)
+	s.Close()
+	s.Close() // idempotent
+	if h.Len() != 0 {
+		t.Errorf("Len = %d after Close, want 0", h.Len())
+	}
+	other := h.Subscribe(1)
+	h.Close()
+	if _, ok := <-other.C(); ok {
+		t.Error("subscription channel still open after hub Close")
+	}
+	h.Publish(frame(1, 0)) // must not panic on a closed hub
+	if _, ok := <-h.Subscribe(1).C(); ok {
+		t.Error("subscribing to a closed hub should yield a closed channel")
+	}
+}
+
+func TestRunSkipsIdleReadsAndStopsOnError(t *testing.T) {
+	h := fanout.NewHub()
+	s := h.Subscribe(4)
+	errBoom := errors.New("boom")
+	script := []*qualisys.Packet{{Type: qualisys.PacketTypeNoMoreData}, frame(7, 0)}
+	receive := func() (*qualisys.Packet, error) {
+		if len(script) == 0 {
+			return &qualisys.Packet{Type: qualisys.PacketTypeNone}, errBoom
+		}
+		p := script[0]
+		script = script[1:]
+		return p, nil
+	}
+	if err := fanout.Run(context.Background(), receive, h); !errors.Is(err, errBoom) {
+		t.Errorf("Run = %v, want the receive error", err)
+	}
+	if p := <-s.C(); p.Data.Frame != 7 {
+		t.Errorf("got frame %d, want 7", p.Data.Frame)
+	}
+	select {
+	case p := <-s.C():
+		t.Errorf("unexpected extra packet %v", p.Type)
+	default:
+	}
+}
+
+func TestDecimatorFrequencyUsesTimestamps(t *testing.T) {
+	dec, err := fanout.NewDecimator(qualisys.StreamRateTypeFrequency, 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 100 Hz source: every fourth frame should survive.
+	kept := 0
+	for i := uint64(0); i < 100; i++ {
+		if dec.Keep(&frame(uint32(i), i*10_000).Data) {
+			kept++
+		}
+	}
+	if kept != 25 {
+		t.Errorf("kept %d frames of 100 at 100 Hz, want 25", kept)
+	}
+	// A measurement restart rewinds the timestamp; it must not stall output.
+	if !dec.Keep(&frame(0, 0).Data) {
+		t.Error("frame after a timestamp reset was dropped")
+	}
+}
+
+func TestDecimatorFrequencyNotADivisor(t *testing.T) {
+	dec, err := fanout.NewDecimator(qualisys.StreamRateTypeFrequency, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 30 Hz from 100 Hz: the schedule is met on average, not rounded down
+	// to every fourth frame.
+	kept := 0
+	for i := uint64(0); i < 1000; i++ {
+		if dec.Keep(&frame(uint32(i), i*10_000).Data) {
+			kept++
+		}
+	}
+	if kept != 300 {
+		t.Errorf("kept %d frames of 1000 at 100 Hz, want 300", kept)
+	}
+	// After a gap the schedule restarts rather than catching up.
+	if !dec.Keep(&frame(2000, 20_000_000).Data) || dec.Keep(&frame(2001, 20_010_000).Data) {
+		t.Error("frames after a gap not kept on a fresh schedule")
+	}
+}
+
+func TestDecimatorDivisor(t *testing.T) {
+	dec, err := fanout.NewDecimator(qualisys.StreamRateTypeFrequencyDivisor, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kept []uint32
+	for i := uint32(0); i < 7; i++ {
+		if dec.Keep(&frame(i, 0).Data) {
+			kept = append(kept, i)
+		}
+	}
+	if len(kept) != 3 || kept[0] != 0 || kept[1] != 3 || kept[2] != 6 {
+		t.Errorf("kept %v, want [0 3 6]", kept)
+	}
+	if _, err := fanout.NewDecimator(qualisys.StreamRateTypeFrequency, 0); err == nil {
+		t.Error("expected an error for a zero frequency")
+	}
+}
@@ -0,0 +1,191 @@
+// Package filter smooths streamed marker, analog and 6DOF data in real time.
+//
+// All filters are causal, so they can run on a live stream, and are designed
+// at runtime from a cutoff and the sample rate:
+//
+//	lp, _ := filter.LowPass(4, 6, 100) // 4th order Butterworth, 6 Hz at 100 Hz
+//	markers := filter.NewMarkers(func() filter.Filter { return lp.Clone() })
+//	...
+//	markers.Apply(&p.Data) // smooths the frame's 3D markers in place
+//
+// Occluded markers arrive as NaN. The adapters pass NaN through untouched and
+// restart the marker's filters, so a marker that reappears is not smeared
+// towards its last position before the gap.
+package filter
+
+import (
+	"fmt"
+	"math"
+)
+
+// Filter processes one signal a sample at a time.
+type Filter interface {
+	Filter(x float64) float64
+	// Reset forgets the signal's history.
+	Reset()
+}
+
+// Biquad is a second-order IIR section in transposed direct form II, with
+// the coefficients normalized so that a0 is 1.
+type Biquad struct {
+	B0, B1, B2 float64
+	A1, A2     float64
+
+	z1, z2 float64
+}
+
+func (q *Biquad) process(x float64) float64 {
+	y := q.B0*x + q.z1
+	q.z1 = q.B1*x - q.A1*y + q.z2
+	q.z2 = q.B2*x - q.A2*y
+	return y
+}
+
+// prime sets the state the section would settle in after a constant input x
+// and returns its output.
+func (q *Biquad) prime(x float64) float64 {
+	y := x * (q.B0 + q.B1 + q.B2) / (1 + q.A1 + q.A2)
+	q.z2 = q.B2*x - q.A2*y
+	q.z1 = q.B1*x - q.A1*y + q.z2
+	return y
+}
+
+// Cascade is a chain of biquad sections, the form every filter in this
+// package is designed as. The first sample after creation or Reset primes the
+// sections as if that value had always been the input, which avoids the
+// startup transient of filters that begin from zero. A NaN sample yields NaN
+// and resets the cascade.
+type Cascade struct {
+	Sections []Biquad
+
+	primed bool
+}
+
+func (c *Cascade) Filter(x float64) float64 {
+	if math.IsNaN(x) {
+		c.Reset()
+		return x
+	}
+	if !c.primed {
+		c.primed = true
+		for i := range c.Sections {
+			x = c.Sections[i].prime(x)
+		}
+		return x
+	}
+	for i := range c.Sections {
+		x = c.Sections[i].process(x)
+	}
+	return x
+}
+
+func (c *Cascade) Reset() {
+	c.primed = false
+	for i := range c.Sections {
+		c.Sections[i].z1, c.Sections[i].z2 = 0, 0
+	}
+}
+
+// Clone returns a cascade with the same coefficients and no history, for
+// filtering another signal with the same design.
+func (c *Cascade) Clone() *Cascade {
+	return &Cascade{Sections: append([]Biquad(nil), c.Sections...)}
+}
+
+func checkFrequency(name string, f, rate float64) error {
+	if rate <= 0 {
+		return fmt.Errorf("filter: sample rate %v Hz is not positive", rate)
+	}
+	if f <= 0 || f >= rate/2 {
+		return fmt.Errorf("filter: %s %v Hz outside (0, %v) for sample rate %v Hz", name, f, rate/2, rate)
+	}
+	return nil
+}
+
+// butterworth designs an order n Butterworth low- or high-pass filter by the
+// bilinear transform, as n/2 biquads with the Butterworth pole Qs and, for odd
+// orders, one first-order section.
+func butterworth(n int, cutoff, rate float64, high bool) (*Cascade, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("filter: order %d is less than 1", n)
+	}
+	if err := checkFrequency("cutoff", cutoff, rate); err != nil {
+		return nil, err
+	}
+	w0 := 2 * math.Pi * cutoff / rate
+	sin, cos := math.Sincos(w0)
+	c := &Cascade{}
+	for k := 1; k <= n/2; k++ {
+		q := 1 / (2 * math.Sin(float64(2*k-1)*math.Pi/float64(2*n)))
+		alpha := sin / (2 * q)
+		a0 := 1 + alpha
+		s := Biquad{A1: -2 * cos / a0, A2: (1 - alpha) / a0}
+		if high {
+			s.B0, s.B1, s.B2 = (1+cos)/2/a0, -(1+cos)/a0, (1+cos)/2/a0
+		} else {
+			s.B0, s.B1, s.B2 = (1-cos)/2/a0, (1-cos)/a0, (1-cos)/2/a0
+		}
+		c.Sections = append(c.Sections, s)
+	}
+	if n%2 == 1 {
+		k := math.Tan(w0 / 2)
+		s := Biquad{A1: (k - 1) / (k + 1)}
+		if high {
+			s.B0, s.B1 = 1/(k+1), -1/(k+1)
+		} else {
+			s.B0, s.B1 = k/(k+1), k/(k+1)
+		}
+		c.Sections = append(c.Sections, s)
+	}
+	return c, nil
+}
+
+// LowPass designs an order n Butterworth low-pass filter with the -3 dB point
+// at cutoff Hz for a signal sampled at rate Hz.
+func LowPass(n int, cutoff, rate float64) (*Cascade, error) {
+	return butterworth(n, cutoff, rate, false)
+}
+
+// HighPass designs an order n Butterworth high-pass filter with the -3 dB
+// point at cutoff Hz, for example to remove the offset and drift of an EMG
+// channel.
+func HighPass(n int, cutoff, rate float64) (*Cascade, error) {
+	return butterworth(n, cutoff, rate, true)
+}
+
+// BandPass designs a band-pass filter as an order n Butterworth high-pass at
+// low Hz followed by an order n low-pass at high Hz. Cascading the two keeps
+// the design simple and is accurate for pass bands an octave or wider.
+func BandPass(n int, low, high, rate float64) (*Cascade, error) {
+	if low >= high {
+		return nil, fmt.Errorf("filter: band %v to %v Hz is empty", low, high)
+	}
+	hp, err := HighPass(n, low, rate)
+	if err != nil {
+		return nil, err
+	}
+	lp, err := LowPass(n, high, rate)
+	if err != nil {
+		return nil, err
+	}
+	hp.Sections = append(hp.Sections, lp.Sections...)
+	return hp, nil
+}
+
+// Notch designs a second-order notch removing freq Hz, typically mains hum at
+// 50 or 60 Hz. q sets the width: the rejected band is freq/q Hz wide at -3 dB.
+func Notch(freq, q, rate float64) (*Cascade, error) {
+	if err := checkFrequency("notch frequency", freq, rate); err != nil {
+		return nil, err
+	}
+	if q <= 0 {
+		return nil, fmt.Errorf("filter: notch Q %v is not positive", q)
+	}
+	sin, cos := math.Sincos(2 * math.Pi * freq / rate)
+	alpha := sin / (2 * q)
+	a0 := 1 + alpha
+	return &Cascade{Sections: []Biquad{{
+		B0: 1 / a0, B1: -2 * cos / a0, B2: 1 / a0,
+		A1: -2 * cos / a0, A2: (1 - alpha) / a0,
+	}}}, nil
+}
@@ -0,0 +1,244 @@
+package filter_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/pkg/filter"
+	"github.com/mlveggo/qualisys-go/pkg/packets"
+)
+
+// amplitude runs a sine of freq Hz through f for two seconds and returns the
+// peak output over the last half second, once the filter has settled.
+func amplitude(f filter.Filter, freq, rate float64) float64 {
+	peak := 0.0
+	n := int(2 * rate)
+	for i := 0; i < n; i++ {
+		y := f.Filter(math.Sin(2 * math.Pi * freq * float64(i) / rate))
+		if i > n*3/4 {
+			peak = max(peak, math.Abs(y))
+		}
+	}
+	return peak
+}
+
+func TestButterworthResponse(t *testing.T) {
+	for _, order := range []int{1, 2, 4, 5} {
+		lp, err := filter.LowPass(order, 10, 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := amplitude(lp.Clone(), 10, 1000); math.Abs(got-math.Sqrt(0.5)) > 0.01 {
+			t.Errorf("order %d low-pass at cutoff: gain %v, want -3 dB", order, got)
+		}
+		if got := amplitude(lp.Clone(), 1, 1000); math.Abs(got-1) > 0.01 {
+			t.Errorf("order %d low-pass in pass band: gain %v", order, got)
+		}
+		// An order n Butterworth falls by 6n dB per octave well past cutoff.
+		if got, want := amplitude(lp.Clone(), 80, 1000), math.Pow(10.0/80, float64(order)); got > want*1.5 {
+			t.Errorf("order %d low-pass in stop band: gain %v, want about %v", order, got, want)
+		}
+	}
+
+	hp, err := filter.HighPass(2, 20, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 500; i++ {
+		hp.Filter(5)
+	}
+	if got := hp.Filter(5); math.Abs(got) > 1e-9 {
+		t.Errorf("high-pass kept an offset of %v", got)
+	}
+
+	bp, err := filter.BandPass(2, 20, 200, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := amplitude(bp.Clone(), 63, 1000); math.Abs(got-1) > 0.05 {
+		t.Errorf("band-pass centre gain %v", got)
+	}
+	if got := amplitude(bp.Clone(), 2, 1000); got > 0.05 {
+		t.Errorf("band-pass below band gain %v", got)
+	}
+}
+
+func TestNotch(t *testing.T) {
+	n, err := filter.Notch(50, 10, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := amplitude(n.Clone(), 50, 1000); got > 0.01 {
+		t.Errorf("mains passed with gain %v", got)
+	}
+	if got := amplitude(n.Clone(), 10, 1000); math.Abs(got-1) > 0.02 {
+		t.Errorf("signal passed with gain %v", got)
+	}
+}
+
+func TestDesignRejectsBadParameters(t *testing.T) {
+	if _, err := filter.LowPass(2, 500, 1000); err == nil {
+		t.Error("cutoff at Nyquist accepted")
+	}
+	if _, err := filter.HighPass(0, 10, 1000); err == nil {
+		t.Error("order 0 accepted")
+	}
+	if _, err := filter.BandPass(2, 100, 50, 1000); err == nil {
+		t.Error("empty band accepted")
+	}
+	if _, err := filter.Notch(50, 0, 1000); err == nil {
+		t.Error("zero Q accepted")
+	}
+}
+
+func TestMarkersHandleOcclusion(t *testing.T) {
+	lp, err := filter.LowPass(2, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := filter.NewMarkers(func() filter.Filter { return lp.Clone() })
+	nan := float32(math.NaN())
+	frame := func(p packets.Point) *packets.Component3D {
+		c := &packets.Component3D{Markers: []packets.Marker{{Point: p}, {Point: packets.Point{X: 1}}}}
+		m.Apply(&qualisys.DataPacket{Components: []qualisys.IDataObject{c}})
+		return c
+	}
+
+	// A still marker is not disturbed, from the very first frame.
+	for i := 0; i < 10; i++ {
+		if c := frame(packets.Point{X: 100, Y: 200, Z: 300}); c.Markers[0].Point != (packets.Point{X: 100, Y: 200, Z: 300}) {
+			t.Fatalf("frame %d: %v", i, c.Markers[0].Point)
+		}
+	}
+	// A step is smoothed.
+	if c := frame(packets.Point{X: 200, Y: 200, Z: 300}); c.Markers[0].Point.X <= 100 || c.Markers[0].Point.X >= 150 {
+		t.Errorf("step filtered to %v", c.Markers[0].Point)
+	}
+	if c := frame(packets.Point{X: nan, Y: nan, Z: nan}); !math.IsNaN(float64(c.Markers[0].Point.X)) {
+		t.Errorf("occluded marker filtered to %v", c.Markers[0].Point)
+	}
+	// After the gap the marker restarts where it reappears rather than
+	// gliding from where it was lost.
+	if c := frame(packets.Point{X: 500, Y: 0, Z: 0}); c.Markers[0].Point != (packets.Point{X: 500}) {
+		t.Errorf("reappeared marker filtered to %v", c.Markers[0].Point)
+	}
+}
+
+func TestMarkersFilterEachComponentSeparately(t *testing.T) {
+	lp, err := filter.LowPass(2, 5, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := filter.NewMarkers(func() filter.Filter { return lp.Clone() })
+	// A frame carrying 3D and 3DRes holds every marker twice; filtering both
+	// copies through the same state would advance it twice a frame.
+	frame := func(x float32) (*packets.Component3D, *packets.Component3DResidual) {
+		c := &packets.Component3D{Markers: []packets.Marker{{Point: packets.Point{X: x}}}}
+		r := &packets.Component3DResidual{Markers: []packets.Marker{{Point: packets.Point{X: x}}}}
+		m.Apply(&qualisys.DataPacket{Components: []qualisys.IDataObject{c, r}})
+		return c, r
+	}
+	frame(100)
+	for i := 0; i < 3; i++ {
+		c, r := frame(200)
+		if c.Markers[0].Point != r.Markers[0].Point {
+			t.Fatalf("frame %d: 3D %v, 3DRes %v", i, c.Markers[0].Point, r.Markers[0].Point)
+		}
+	}
+}
+
+func TestAnalogPerChannel(t *testing.T) {
+	a := filter.NewAnalog(func(device uint32, channel int) filter.Filter {
+		if channel == 1 {
+			return nil
+		}
+		hp, err := filter.HighPass(1, 10, 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hp
+	})
+	c := &packets.ComponentAnalog{AnalogDevices: []packets.AnalogDevice{{
+		ID: 1,
+		Channels: []packets.AnalogChannel{
+			{Samples: []packets.AnalogSample{{Value: 2}, {Value: 3}}},
+			{Samples: []packets.AnalogSample{{Value: 2}, {Value: 3}}},
+		},
+	}}}
+	a.Apply(&qualisys.DataPacket{Components: []qualisys.IDataObject{c}})
+	ch := c.AnalogDevices[0].Channels
+	if ch[0].Samples[0].Value != 0 || ch[0].Samples[1].Value <= 0 || ch[0].Samples[1].Value >= 1 {
+		t.Errorf("filtered channel = %v", ch[0].Samples)
+	}
+	if ch[1].Samples[0].Value != 2 || ch[1].Samples[1].Value != 3 {
+		t.Errorf("unfiltered channel = %v", ch[1].Samples)
+	}
+}
+
+func TestOneEuroReducesJitterAndFollowsMotion(t *testing.T) {
+	f := filter.NewOneEuro(filter.OneEuroParams{MinCutoff: 1, Beta: 0.1, DerivativeCutoff: 1})
+	var worst float64
+	for i := 0; i < 100; i++ {
+		jitter := 0.5
+		if i%2 == 1 {
+			jitter = -0.5
+		}
+		if i > 50 {
+			worst = max(worst, math.Abs(f.Filter(time.Duration(i)*10*time.Millisecond, 10+jitter)-10))
+		} else {
+			f.Filter(time.Duration(i)*10*time.Millisecond, 10+jitter)
+		}
+	}
+	if worst > 0.1 {
+		t.Errorf("jitter of ±0.5 left ±%v", worst)
+	}
+	// A fast movement raises the cutoff, so the output catches up quickly.
+	var y float64
+	for i := 100; i < 110; i++ {
+		y = f.Filter(time.Duration(i)*10*time.Millisecond, 10+float64(i-99)*50)
+	}
+	if target := 10 + 11*50.0; target-y > 60 {
+		t.Errorf("lagging %v behind a 5000 unit/s movement", target-y)
+	}
+}
+
+func TestBodiesSmoothRotation(t *testing.T) {
+	b := filter.NewBodies(filter.DefaultPositionParams, filter.DefaultRotationParams)
+	apply := func(ts uint64, body packets.BodyMatrix) packets.BodyMatrix {
+		c := &packets.Component6D{Bodies: []packets.BodyMatrix{body}}
+		b.Apply(&qualisys.DataPacket{Timestamp: ts, Components: []qualisys.IDataObject{c}})
+		return c.Bodies[0]
+	}
+	identity := packets.BodyMatrix{Point: packets.Point{X: 1}, Rotation: [9]float32{1, 0, 0, 0, 1, 0, 0, 0, 1}}
+	apply(0, identity)
+	// A sudden 90 degree turn about Z is followed only part of the way.
+	turned := packets.BodyMatrix{Point: packets.Point{X: 1}, Rotation: [9]float32{0, -1, 0, 1, 0, 0, 0, 0, 1}}
+	got := apply(10_000, turned)
+	q := got.Quaternion()
+	angle := 2 * math.Atan2(float64(q.Z), float64(q.W)) * 180 / math.Pi
+	if angle <= 1 || angle >= 89 {
+		t.Errorf("filtered turn of %v degrees", angle)
+	}
+	if got.Point != identity.Point {
+		t.Errorf("still position moved to %v", got.Point)
+	}
+
+	nan := float32(math.NaN())
+	lost := packets.BodyMatrix{Point: packets.Point{X: nan, Y: nan, Z: nan}}
+	for i := range lost.Rotation {
+		lost.Rotation[i] = nan
+	}
+	if got := apply(20_000, lost); !math.IsNaN(float64(got.Point.X)) || !math.IsNaN(float64(got.Rotation[0])) {
+		t.Errorf("untracked body filtered to %+v", got)
+	}
+	// Tracking restarts at the new pose.
+	got = apply(30_000, turned)
+	for i, v := range got.Rotation {
+		if math.Abs(float64(v-turned.Rotation[i])) > 1e-6 {
+			t.Errorf("reacquired body filtered to %v", got.Rotation)
+			break
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package filter
+
+import (
+	"math"
+	"time"
+
+	"github.com/mlveggo/qualisys-go/pkg/packets"
+)
+
+// OneEuroParams tunes a one-euro filter (Casiez et al., CHI 2012), an
+// adaptive low-pass whose cutoff rises with the signal's speed: slow movement
+// is smoothed heavily, removing jitter, while fast movement passes with little
+// lag.
+type OneEuroParams struct {
+	// MinCutoff is the cutoff in Hz when the signal is still. Lower it to
+	// remove more jitter.
+	MinCutoff float64
+	// Beta is how much the cutoff rises per unit of speed. Raise it to reduce
+	// lag during fast movement.
+	Beta float64
+	// DerivativeCutoff is the cutoff in Hz used to smooth the speed estimate.
+	DerivativeCutoff float64
+}
+
+// Starting points for 6DOF poses, with positions in millimetres and speeds
+// in mm/s and rad/s respectively.
+var (
+	DefaultPositionParams = OneEuroParams{MinCutoff: 1, Beta: 0.01, DerivativeCutoff: 1}
+	DefaultRotationParams = OneEuroParams{MinCutoff: 1, Beta: 0.5, DerivativeCutoff: 1}
+)
+
+// smoothing is the exponential smoothing factor of a first-order low-pass at
+// cutoff Hz for a step of dt seconds.
+func smoothing(cutoff, dt float64) float64 {
+	tau := 1 / (2 * math.Pi * cutoff)
+	return 1 / (1 + tau/dt)
+}
+
+// OneEuro filters one signal with irregular sample times.
+type OneEuro struct {
+	OneEuroParams
+
+	started bool
+	last    time.Duration
+	x, dx   float64
+}
+
+// NewOneEuro returns a one-euro filter with the given parameters.
+func NewOneEuro(p OneEuroParams) *OneEuro {
+	return &OneEuro{OneEuroParams: p}
+}
+
+// Filter processes x sampled at time t, measured from any fixed origin such
+// as the stream's timestamps. A sample no later than the previous one returns
+// the previous output. A NaN sample yields NaN and resets the filter.
+func (f *OneEuro) Filter(t time.Duration, x float64) float64 {
+	if math.IsNaN(x) {
+		f.Reset()
+		return x
+	}
+	if !f.started {
+		f.started, f.last, f.x, f.dx = true, t, x, 0
+		return x
+	}
+	if t <= f.last {
+		return f.x
+	}
+	dt := (t - f.last).Seconds()
+	f.last = t
+	f.dx += smoothing(f.DerivativeCutoff, dt) * ((x-f.x)/dt - f.dx)
+	cutoff := f.MinCutoff + f.Beta*math.Abs(f.dx)
+	f.x += smoothing(cutoff, dt) * (x - f.x)
+	return f.x
+}
+
+func (f *OneEuro) Reset() {
+	f.started = false
+}
+
+// Pose filters a 6DOF pose with one-euro filters: one per position
+// coordinate and, for the rotation, a spherical interpolation towards each new
+// orientation driven by the angular speed.
+type Pose struct {
+	Position OneEuroParams
+	Rotation OneEuroParams
+
+	point   [3]OneEuro
+	started bool
+	last    time.Duration
+	q       quat
+	speed   float64
+}
+
+// NewPose returns a pose filter with the given position and rotation
+// parameters.
+func NewPose(position, rotation OneEuroParams) *Pose {
+	return &Pose{Position: position, Rotation: rotation}
+}
+
+// Filter processes a pose sampled at time t. A pose with a NaN coordinate or
+// rotation, which is how QTM marks an untracked body, is returned as is and
+// resets the filter.
+func (p *Pose) Filter(t time.Duration, pos packets.Point, rot packets.Rotation) (packets.Point, packets.Rotation) {
+	q := quatOf(rot)
+	if math.IsNaN(float64(pos.X+pos.Y+pos.Z)) || q.isNaN() {
+		p.Reset()
+		return pos, rot
+	}
+	for i := range p.point {
+		p.point[i].OneEuroParams = p.Position
+	}
+	out := packets.Point{
+		X: float32(p.point[0].Filter(t, float64(pos.X))),
+		Y: float32(p.point[1].Filter(t, float64(pos.Y))),
+		Z: float32(p.point[2].Filter(t, float64(pos.Z))),
+	}
+
+	q = q.normalized()
+	if !p.started {
+		p.started, p.last, p.q, p.speed = true, t, q, 0
+		return out, rot
+	}
+	if t <= p.last {
+		return out, p.q.rotation()
+	}
+	dt := (t - p.last).Seconds()
+	p.last = t
+	// q and -q are the same orientation; take the one nearer the previous
+	// output so the interpolation follows the short arc.
+	if p.q.dot(q) < 0 {
+		q = q.scale(-1)
+	}
+	speed := p.q.angle(q) / dt
+	p.speed += smoothing(p.Rotation.DerivativeCutoff, dt) * (speed - p.speed)
+	p.q = slerp(p.q, q, smoothing(p.Rotation.MinCutoff+p.Rotation.Beta*p.speed, dt))
+	return out, p.q.rotation()
+}
+
+func (p *Pose) Reset() {
+	p.started = false
+	for i := range p.point {
+		p.point[i].Reset()
+	}
+}
+
+type quat struct {
+	x, y, z, w float64
+}
+
+func quatOf(r packets.Rotation) quat {
+	return quat{float64(r.X), float64(r.Y), float64(r.Z), float64(r.W)}
+}
+
+func (q quat) rotation() packets.Rotation {
+	return packets.Rotation{X: float32(q.x), Y: float32(q.y), Z: float32(q.z), W: float32(q.w)}
+}
+
+func (q quat) isNaN() bool {
+	return math.IsNaN(q.x + q.y + q.z + q.w)
+}
+
+func (q quat) dot(r quat) float64 {
+	return q.x*r.x + q.y*r.y + q.z*r.z + q.w*r.w
+}
+
+func (q quat) scale(s float64) quat {
+	return quat{q.x * s, q.y * s, q.z * s, q.w * s}
+}
+
+func (q quat) add(r quat) quat {
+	return quat{q.x + r.x, q.y + r.y, q.z + r.z, q.w + r.w}
+}
+
+func (q quat) normalized() quat {
+	n := math.Sqrt(q.dot(q))
+	if n == 0 {
+		return quat{w: 1}
+	}
+	return q.scale(1 / n)
+}
+
+// angle is the rotation angle, in radians, between two unit quaternions in
+// the same hemisphere.
+func (q quat) angle(r quat) float64 {
+	return 2 * math.Acos(min(q.dot(r), 1))
+}
+
+// slerp interpolates from a to b, unit quaternions in the same hemisphere, by
+// the fraction t along the arc.
+func slerp(a, b quat, t float64) quat {
+	d := min(a.dot(b), 1)
+	if d > 0.9995 {
+		// Nearly parallel: the arc is a line and sin(theta) too small to
+		// divide by.
+		return a.add(b.add(a.scale(-1)).scale(t)).normalized()
+	}
+	theta := math.Acos(d)
+	sin := math.Sin(theta)
+	return a.scale(math.Sin((1-t)*theta) / sin).add(b.scale(math.Sin(t*theta) / sin))
+}
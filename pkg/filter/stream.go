@@ -0,0 +1,192 @@
+package filter
+
+import (
+	"math"
+	"time"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/pkg/packets"
+)
+
+// markerKey identifies a marker across frames: its index for labeled
+// markers, whose position in the component is their label, or its ID for
+// unidentified ones. The component is part of the key because a frame can
+// carry the same markers twice, with and without residuals, and each copy
+// must feed its own filters.
+type markerKey struct {
+	component qualisys.ComponentType
+	n         uint32
+}
+
+// Markers filters every coordinate of the 3D markers in a frame, keeping a
+// separate Filter per marker and axis. It handles the labeled components, by
+// position in the label list, and the unidentified ones, by marker ID. It is
+// not safe for concurrent use.
+type Markers struct {
+	// New makes the filter for one coordinate of one marker.
+	New func() Filter
+
+	filters map[markerKey]*[3]Filter
+}
+
+// NewMarkers returns a marker adapter drawing its filters from newFilter.
+func NewMarkers(newFilter func() Filter) *Markers {
+	return &Markers{New: newFilter, filters: make(map[markerKey]*[3]Filter)}
+}
+
+// Apply replaces the marker positions in d with their filtered values. A
+// marker with any NaN coordinate is occluded: it is left NaN and its filters
+// are reset, to restart when it reappears.
+func (m *Markers) Apply(d *qualisys.DataPacket) {
+	for _, obj := range d.Components {
+		switch c := obj.(type) {
+		case *packets.Component3D:
+			m.apply(c.Markers, qualisys.ComponentType3D, true)
+		case *packets.Component3DResidual:
+			m.apply(c.Markers, qualisys.ComponentType3DResidual, true)
+		case *packets.Component3DNoLabels:
+			m.apply(c.Markers, qualisys.ComponentType3DNoLabels, false)
+		case *packets.Component3DNoLabelsResidual:
+			m.apply(c.Markers, qualisys.ComponentType3DNoLabelsResidual, false)
+		}
+	}
+}
+
+func (m *Markers) apply(markers []packets.Marker, component qualisys.ComponentType, labeled bool) {
+	for i := range markers {
+		key := markerKey{component: component, n: markers[i].ID}
+		if labeled {
+			key.n = uint32(i)
+		}
+		f := m.filters[key]
+		if f == nil {
+			f = &[3]Filter{m.New(), m.New(), m.New()}
+			m.filters[key] = f
+		}
+		p := &markers[i].Point
+		if math.IsNaN(float64(p.X)) || math.IsNaN(float64(p.Y)) || math.IsNaN(float64(p.Z)) {
+			for _, axis := range f {
+				axis.Reset()
+			}
+			continue
+		}
+		p.X = float32(f[0].Filter(float64(p.X)))
+		p.Y = float32(f[1].Filter(float64(p.Y)))
+		p.Z = float32(f[2].Filter(float64(p.Z)))
+	}
+}
+
+type channelKey struct {
+	device  uint32
+	channel int
+}
+
+// Analog filters every sample of the analog channels in a frame, in order,
+// with a Filter per device and channel. Devices usually run at different rates,
+// so the filter is made knowing which one it serves. It handles both the
+// multi-sample and the single-sample component and is not safe for concurrent
+// use.
+type Analog struct {
+	// New makes the filter for one channel, or returns nil to leave the
+	// channel unfiltered.
+	New func(device uint32, channel int) Filter
+
+	filters map[channelKey]Filter
+}
+
+// NewAnalog returns an analog adapter drawing its filters from newFilter.
+func NewAnalog(newFilter func(device uint32, channel int) Filter) *Analog {
+	return &Analog{New: newFilter, filters: make(map[channelKey]Filter)}
+}
+
+// Apply replaces the analog samples in d with their filtered values. A NaN
+// sample, which the single-sample component uses for a channel without a new
+// value, is left NaN and resets the channel's filter.
+func (a *Analog) Apply(d *qualisys.DataPacket) {
+	for _, obj := range d.Components {
+		switch c := obj.(type) {
+		case *packets.ComponentAnalog:
+			a.apply(c.AnalogDevices)
+		case *packets.ComponentAnalogSingle:
+			a.apply(c.AnalogDevices)
+		}
+	}
+}
+
+func (a *Analog) apply(devices []packets.AnalogDevice) {
+	for _, dev := range devices {
+		for ch, channel := range dev.Channels {
+			key := channelKey{dev.ID, ch}
+			f, ok := a.filters[key]
+			if !ok {
+				f = a.New(dev.ID, ch)
+				a.filters[key] = f
+			}
+			if f == nil {
+				continue
+			}
+			for i, s := range channel.Samples {
+				if math.IsNaN(float64(s.Value)) {
+					f.Reset()
+					continue
+				}
+				channel.Samples[i].Value = float32(f.Filter(float64(s.Value)))
+			}
+		}
+	}
+}
+
+// Bodies smooths the 6DOF bodies in a frame with a one-euro Pose filter per
+// body, using the frame timestamps as sample times so that dropped frames
+// are accounted for. It handles the rotation matrix components; an Euler
+// component would have to be converted back to the project's angle
+// convention and is left alone. It is not safe for concurrent use.
+type Bodies struct {
+	Position OneEuroParams
+	Rotation OneEuroParams
+
+	// poses are kept per component, as a frame can carry the same bodies
+	// twice, with and without residuals.
+	poses map[qualisys.ComponentType][]*Pose
+}
+
+// NewBodies returns a body adapter with the given position and rotation
+// parameters, such as DefaultPositionParams and DefaultRotationParams.
+func NewBodies(position, rotation OneEuroParams) *Bodies {
+	return &Bodies{Position: position, Rotation: rotation}
+}
+
+// Apply replaces the body poses in d with their filtered values. Untracked
+// bodies are left NaN and their filters reset.
+func (b *Bodies) Apply(d *qualisys.DataPacket) {
+	t := time.Duration(d.Timestamp) * time.Microsecond
+	for _, obj := range d.Components {
+		switch c := obj.(type) {
+		case *packets.Component6D:
+			b.apply(t, c.Bodies, qualisys.ComponentType6D)
+		case *packets.Component6DResidual:
+			b.apply(t, c.Bodies, qualisys.ComponentType6DResidual)
+		}
+	}
+}
+
+func (b *Bodies) apply(t time.Duration, bodies []packets.BodyMatrix, component qualisys.ComponentType) {
+	if b.poses == nil {
+		b.poses = make(map[qualisys.ComponentType][]*Pose)
+	}
+	poses := b.poses[component]
+	for len(poses) < len(bodies) {
+		poses = append(poses, NewPose(b.Position, b.Rotation))
+	}
+	b.poses[component] = poses
+	for i := range bodies {
+		pose := poses[i]
+		pose.Position, pose.Rotation = b.Position, b.Rotation
+		point, rot := pose.Filter(t, bodies[i].Point, bodies[i].Quaternion())
+		if math.IsNaN(float64(rot.W)) {
+			continue
+		}
+		bodies[i].Point = point
+		bodies[i].Rotation = rot.Matrix()
+	}
+}
@@ -0,0 +1,180 @@
+package force
+
+import (
+	"github.com/mlveggo/qualisys-go/pkg/packets"
+	"github.com/mlveggo/qualisys-go/pkg/settings"
+)
+
+//go:generate stringer -type EventKind
+
+type EventKind int
+
+const (
+	FootStrike EventKind = iota + 1
+	ToeOff
+)
+
+// Event is a change of contact on a plate.
+type Event struct {
+	Plate uint32
+	Kind  EventKind
+	// Sample is the plate sample number at which the load crossed the
+	// threshold.
+	Sample uint32
+}
+
+// Detector finds foot strikes and toe-offs in one plate's load with
+// hysteresis: contact begins when the load rises above StrikeThreshold and
+// ends when it falls below ToeOffThreshold. An event is reported only once the
+// load has stayed past the threshold for MinSamples samples, which rejects
+// single-sample spikes, and it carries the sample number of the crossing.
+type Detector struct {
+	StrikeThreshold float64
+	ToeOffThreshold float64
+	MinSamples      int
+
+	loaded   bool
+	pending  bool
+	crossing uint32
+	run      int
+}
+
+// NewDetector returns a Detector with the default thresholds.
+func NewDetector() *Detector {
+	return &Detector{StrikeThreshold: DefaultStrikeThreshold, ToeOffThreshold: DefaultToeOffThreshold, MinSamples: 1}
+}
+
+// Loaded reports whether the plate is currently in contact.
+func (d *Detector) Loaded() bool {
+	return d.loaded
+}
+
+// Update feeds the load of sample number n and returns an event when the
+// contact state changes.
+func (d *Detector) Update(n uint32, load float64) (Event, bool) {
+	crossed := load > d.StrikeThreshold
+	if d.loaded {
+		crossed = load < d.ToeOffThreshold
+	}
+	if !crossed {
+		d.pending = false
+		return Event{}, false
+	}
+	if !d.pending {
+		d.pending = true
+		d.crossing = n
+		d.run = 0
+	}
+	d.run++
+	if d.run < max(d.MinSamples, 1) {
+		return Event{}, false
+	}
+	d.pending = false
+	d.loaded = !d.loaded
+	kind := ToeOff
+	if d.loaded {
+		kind = FootStrike
+	}
+	return Event{Kind: kind, Sample: d.crossing}, true
+}
+
+// Processor converts the Force component of every frame and detects contact
+// events per plate. It is not safe for concurrent use.
+type Processor struct {
+	// COPThreshold is the load below which the centre of pressure is not
+	// trusted.
+	COPThreshold float64
+	// StrikeThreshold, ToeOffThreshold and MinSamples configure the Detector
+	// of each plate. Set them before the first Add.
+	StrikeThreshold float64
+	ToeOffThreshold float64
+	MinSamples      int
+
+	plates    []*Plate
+	detectors map[uint32]*Detector
+}
+
+// NewProcessor prepares a Processor for the plates in the Force settings.
+// Plates that stream without settings are processed as if their coordinate
+// system were the lab's and their lengths in millimetres.
+func NewProcessor(f settings.Force) (*Processor, error) {
+	p := &Processor{
+		COPThreshold:    DefaultCOPThreshold,
+		StrikeThreshold: DefaultStrikeThreshold,
+		ToeOffThreshold: DefaultToeOffThreshold,
+		MinSamples:      1,
+		detectors:       make(map[uint32]*Detector),
+	}
+	for _, s := range f.Plates {
+		plate, err := NewPlate(s, f.UnitLength)
+		if err != nil {
+			return nil, err
+		}
+		p.plates = append(p.plates, plate)
+	}
+	return p, nil
+}
+
+// NewProcessorFromXML builds a Processor from settings XML holding the Force
+// section.
+func NewProcessorFromXML(xml string) (*Processor, error) {
+	f, err := settings.ParseForceFromXML(xml)
+	if err != nil {
+		return nil, err
+	}
+	return NewProcessor(f)
+}
+
+// Plates returns every plate seen in the settings or the stream.
+func (p *Processor) Plates() []*Plate {
+	return p.plates
+}
+
+// Plate returns the plate with the given ID, or nil.
+func (p *Processor) Plate(id uint32) *Plate {
+	for _, plate := range p.plates {
+		if plate.ID == id {
+			return plate
+		}
+	}
+	return nil
+}
+
+// Detector returns the event detector of a plate, or nil before the plate's
+// first sample.
+func (p *Processor) Detector(id uint32) *Detector {
+	return p.detectors[id]
+}
+
+// Add converts the samples a frame carried and returns them with the contact
+// events they produced. c may be nil, for frames without force data; pass
+// the ForceSingle component converted with (*packets.ComponentForce)(single).
+func (p *Processor) Add(c *packets.ComponentForce) ([]Sample, []Event) {
+	if c == nil {
+		return nil, nil
+	}
+	var samples []Sample
+	var events []Event
+	for _, fp := range c.ForcePlates {
+		plate := p.Plate(fp.ID)
+		if plate == nil {
+			plate = identityPlate(fp.ID)
+			p.plates = append(p.plates, plate)
+		}
+		d := p.detectors[fp.ID]
+		if d == nil {
+			d = &Detector{StrikeThreshold: p.StrikeThreshold, ToeOffThreshold: p.ToeOffThreshold, MinSamples: p.MinSamples}
+			p.detectors[fp.ID] = d
+		}
+		for i, fs := range fp.Samples {
+			s := plate.Process(fs, p.COPThreshold)
+			s.Number = fp.Number + uint32(i)
+			samples = append(samples, s)
+			if e, ok := d.Update(s.Number, s.Load); ok {
+				e.Plate = fp.ID
+				events = append(events, e)
+			}
+		}
+	}
+	return samples, events
+}
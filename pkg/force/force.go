@@ -0,0 +1,201 @@
+// Package force turns streamed force plate data into lab-frame quantities for
+// gait analysis: force and moment in lab coordinates, the centre of pressure
+// gated on load, the free moment, and foot strike and toe-off events.
+//
+// QTM streams each plate's samples in the plate's own coordinate system. The
+// Force settings place the plate in the lab with its four corners, from which
+// a Plate derives the rotation and translation to the lab:
+//
+//	xml, _ := rt.GetParameters(qualisys.ParameterTypeForce)
+//	proc, _ := force.NewProcessorFromXML(xml)
+//	...
+//	samples, events := proc.Add(p.Data.Force())
+//	for _, e := range events {
+//		fmt.Println(e.Plate, e.Kind, e.Sample) // e.g. 1 FootStrike 48213
+//	}
+package force
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/mlveggo/qualisys-go/pkg/packets"
+	"github.com/mlveggo/qualisys-go/pkg/settings"
+)
+
+// Default thresholds, in newtons of load normal to the plate. A foot strike
+// needs the load to rise above DefaultStrikeThreshold and a toe-off to fall
+// below DefaultToeOffThreshold; the band between them keeps noise around a
+// single threshold from toggling the contact state.
+const (
+	DefaultCOPThreshold    = 20.0
+	DefaultStrikeThreshold = 20.0
+	DefaultToeOffThreshold = 10.0
+)
+
+// DefaultMomentArmScale converts centre of pressure coordinates in
+// millimetres to metres, matching moments streamed in N·m.
+const DefaultMomentArmScale = 0.001
+
+// millimetres maps the length units the Force settings name to millimetres,
+// the unit of the lab coordinate system.
+var millimetres = map[string]float64{
+	"": 1, "mm": 1, "millimeter": 1, "millimetre": 1,
+	"cm": 10, "centimeter": 10, "centimetre": 10,
+	"m": 1000, "meter": 1000, "metre": 1000,
+}
+
+// ErrDegenerate is returned for a plate whose corners do not span a plane.
+var ErrDegenerate = errors.New("force: plate corners do not span a plane")
+
+// Vec is a vector in lab coordinates.
+type Vec struct {
+	X, Y, Z float64
+}
+
+func fromSettings(v settings.Vector) Vec { return Vec{v.X, v.Y, v.Z} }
+
+func (a Vec) Add(b Vec) Vec       { return Vec{a.X + b.X, a.Y + b.Y, a.Z + b.Z} }
+func (a Vec) Sub(b Vec) Vec       { return Vec{a.X - b.X, a.Y - b.Y, a.Z - b.Z} }
+func (a Vec) Scale(s float64) Vec { return Vec{a.X * s, a.Y * s, a.Z * s} }
+func (a Vec) Dot(b Vec) float64   { return a.X*b.X + a.Y*b.Y + a.Z*b.Z }
+func (a Vec) Norm() float64       { return math.Sqrt(a.Dot(a)) }
+func (a Vec) Cross(b Vec) Vec {
+	return Vec{a.Y*b.Z - a.Z*b.Y, a.Z*b.X - a.X*b.Z, a.X*b.Y - a.Y*b.X}
+}
+
+func (a Vec) String() string {
+	return fmt.Sprintf("(%.3f, %.3f, %.3f)", a.X, a.Y, a.Z)
+}
+
+var nanVec = Vec{math.NaN(), math.NaN(), math.NaN()}
+
+// Plate is one force plate's placement in the lab.
+//
+// The plate coordinate system has its origin at the centre of the top surface,
+// X pointing from the edge through corners 2 and 3 towards the edge through
+// corners 1 and 4, and Y from the edge through corners 3 and 4 towards the
+// edge through corners 1 and 2; Z completes a right-handed system. This is the
+// corner numbering of QTM's force plate location dialog, with corner 1 in the
+// +X +Y quadrant.
+type Plate struct {
+	ID   uint32
+	Name string
+	Type string
+	// Origin is the plate origin and X, Y and Z its unit axes, all in lab
+	// coordinates.
+	Origin  Vec
+	X, Y, Z Vec
+	// SensorOrigin is the calibrated point the streamed moments are measured
+	// about, in plate coordinates and the plate's length unit. It usually
+	// lies a little below the centre of the top surface.
+	SensorOrigin Vec
+	// LengthScale converts the plate's length unit, the unit of streamed
+	// centres of pressure, to the lab's millimetres.
+	LengthScale float64
+	// MomentArmScale converts the plate's length unit to the length unit of
+	// the moments when computing the free moment.
+	MomentArmScale float64
+}
+
+// NewPlate derives a plate's lab placement from its settings. unitLength is
+// the Force settings' length unit; empty means millimetres. Moments are taken
+// to be streamed in N·m.
+func NewPlate(s settings.ForcePlate, unitLength string) (*Plate, error) {
+	scale, ok := millimetres[strings.ToLower(unitLength)]
+	if !ok {
+		return nil, fmt.Errorf("force: plate %d: unknown length unit %q", s.PlateID(), unitLength)
+	}
+	c1 := fromSettings(s.Location.Corner1)
+	c2 := fromSettings(s.Location.Corner2)
+	c3 := fromSettings(s.Location.Corner3)
+	c4 := fromSettings(s.Location.Corner4)
+
+	x := c1.Add(c4).Sub(c2.Add(c3))
+	y := c1.Add(c2).Sub(c3.Add(c4))
+	z := x.Cross(y)
+	if x.Norm() < 1e-9 || z.Norm() < 1e-9*x.Norm()*y.Norm() {
+		return nil, fmt.Errorf("%w: plate %d", ErrDegenerate, s.PlateID())
+	}
+	x = x.Scale(1 / x.Norm())
+	z = z.Scale(1 / z.Norm())
+	return &Plate{
+		ID:             s.PlateID(),
+		Name:           s.Name,
+		Type:           s.Type,
+		Origin:         c1.Add(c2).Add(c3).Add(c4).Scale(0.25),
+		X:              x,
+		Y:              z.Cross(x),
+		Z:              z,
+		SensorOrigin:   fromSettings(s.Origin),
+		LengthScale:    scale,
+		MomentArmScale: scale * DefaultMomentArmScale,
+	}, nil
+}
+
+// identityPlate stands in for plates that stream without settings: the plate
+// and lab coordinate systems are taken to coincide.
+func identityPlate(id uint32) *Plate {
+	return &Plate{
+		ID: id, X: Vec{1, 0, 0}, Y: Vec{0, 1, 0}, Z: Vec{0, 0, 1},
+		LengthScale: 1, MomentArmScale: DefaultMomentArmScale,
+	}
+}
+
+// Direction rotates a plate-frame vector, such as a force, into the lab.
+func (p *Plate) Direction(v packets.Point) Vec {
+	return p.X.Scale(float64(v.X)).Add(p.Y.Scale(float64(v.Y))).Add(p.Z.Scale(float64(v.Z)))
+}
+
+// Position maps a plate-frame position in the plate's length unit, such as
+// the centre of pressure, into the lab.
+func (p *Plate) Position(v packets.Point) Vec {
+	return p.Origin.Add(p.Direction(v).Scale(p.LengthScale))
+}
+
+// Sample is one force sample in lab terms.
+type Sample struct {
+	Plate uint32
+	// Number is the plate's running sample number.
+	Number uint32
+	Force  Vec
+	Moment Vec
+	// Load is the force normal to the plate. Its magnitude is used, as plate
+	// manufacturers disagree on whether Z points into the plate or out of it.
+	Load float64
+	// COP is the centre of pressure in the lab. Below the COP threshold the
+	// position is dominated by noise divided by a small force; COPValid is
+	// then false and COP and FreeMoment are NaN.
+	COP      Vec
+	COPValid bool
+	// FreeMoment is the moment about the plate normal through the centre of
+	// pressure: the twisting torque the foot applies, in the unit of the
+	// streamed moments.
+	FreeMoment float64
+}
+
+// Process converts one sample. copThreshold is the load below which the
+// centre of pressure is not trusted.
+func (p *Plate) Process(s packets.ForceSample, copThreshold float64) Sample {
+	out := Sample{
+		Plate:  p.ID,
+		Force:  p.Direction(s.Force),
+		Moment: p.Direction(s.Moment),
+		Load:   math.Abs(float64(s.Force.Z)),
+	}
+	if out.Load < copThreshold || out.Load == 0 {
+		out.COP = nanVec
+		out.FreeMoment = math.NaN()
+		return out
+	}
+	out.COP = p.Position(s.CenterOfPressure)
+	out.COPValid = true
+	// The streamed moment is about the sensor origin; moving it to the
+	// centre of pressure removes the part the force's lever arm produces.
+	cx := (float64(s.CenterOfPressure.X) - p.SensorOrigin.X) * p.MomentArmScale
+	cy := (float64(s.CenterOfPressure.Y) - p.SensorOrigin.Y) * p.MomentArmScale
+	out.FreeMoment = float64(s.Moment.Z) - (cx*float64(s.Force.Y) - cy*float64(s.Force.X))
+	return out
+}
@@ -0,0 +1,158 @@
+package force_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/mlveggo/qualisys-go/pkg/force"
+	"github.com/mlveggo/qualisys-go/pkg/packets"
+	"github.com/mlveggo/qualisys-go/pkg/settings"
+)
+
+// The plate is 600 by 400 mm, centred at (1000, 500, 0) and turned so that its
+// X axis points along lab Y and its Y axis along lab -X.
+const settingsXML = `<QTM_Parameters_Ver_1.28>
+<Force><Unit_Length>mm</Unit_Length><Unit_Force>N</Unit_Force>
+<Plate>
+  <Force_Plate_Index>1</Force_Plate_Index><Name>Left</Name><Type>Kistler</Type>
+  <Frequency>1000</Frequency><Length>600</Length><Width>400</Width>
+  <Location>
+    <Corner1><X>800</X><Y>800</Y><Z>0</Z></Corner1>
+    <Corner2><X>800</X><Y>200</Y><Z>0</Z></Corner2>
+    <Corner3><X>1200</X><Y>200</Y><Z>0</Z></Corner3>
+    <Corner4><X>1200</X><Y>800</Y><Z>0</Z></Corner4>
+  </Location>
+</Plate>
+</Force>
+</QTM_Parameters_Ver_1.28>`
+
+func near(a, b force.Vec) bool {
+	d := a.Sub(b)
+	return d.Norm() < 1e-6
+}
+
+func TestPlateTransformsToLab(t *testing.T) {
+	proc, err := force.NewProcessorFromXML(settingsXML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plate := proc.Plate(1)
+	if plate == nil || plate.Name != "Left" || plate.Type != "Kistler" {
+		t.Fatalf("plate = %+v", plate)
+	}
+	if !near(plate.Origin, force.Vec{X: 1000, Y: 500}) || !near(plate.X, force.Vec{Y: 1}) ||
+		!near(plate.Y, force.Vec{X: -1}) || !near(plate.Z, force.Vec{Z: 1}) {
+		t.Fatalf("placement = %v %v %v %v", plate.Origin, plate.X, plate.Y, plate.Z)
+	}
+
+	samples, _ := proc.Add(&packets.ComponentForce{ForcePlates: []packets.ForcePlate{{
+		ID: 1, Number: 7, Samples: []packets.ForceSample{
+			{Force: packets.Point{X: 10, Z: 700}, Moment: packets.Point{Z: 5}, CenterOfPressure: packets.Point{X: 100, Y: 50}},
+			{Force: packets.Point{X: 1, Z: -5}, CenterOfPressure: packets.Point{X: 9000}},
+		},
+	}}})
+	if len(samples) != 2 {
+		t.Fatalf("got %d samples", len(samples))
+	}
+	s := samples[0]
+	if s.Plate != 1 || s.Number != 7 || s.Load != 700 || !s.COPValid {
+		t.Errorf("sample = %+v", s)
+	}
+	if !near(s.Force, force.Vec{Y: 10, Z: 700}) || !near(s.COP, force.Vec{X: 950, Y: 600}) {
+		t.Errorf("force %v at %v", s.Force, s.COP)
+	}
+	// 5 N·m less the lever arm of 10 N along X at 50 mm along Y.
+	if math.Abs(s.FreeMoment-5.5) > 1e-6 {
+		t.Errorf("free moment = %v", s.FreeMoment)
+	}
+
+	light := samples[1]
+	if light.Number != 8 || light.COPValid || !math.IsNaN(light.COP.X) || !math.IsNaN(light.FreeMoment) {
+		t.Errorf("COP below threshold = %+v", light)
+	}
+}
+
+func TestDegeneratePlate(t *testing.T) {
+	var s settings.ForcePlate
+	s.ID = 3
+	if _, err := force.NewPlate(s, ""); !errors.Is(err, force.ErrDegenerate) {
+		t.Errorf("got %v, want ErrDegenerate", err)
+	}
+}
+
+func TestPlateUnitsAndSensorOrigin(t *testing.T) {
+	// A plate aligned with the lab, lengths in metres, with its sensor origin
+	// off the centre of the top surface.
+	plate := settings.ForcePlate{Index: 2}
+	plate.Location.Corner1 = settings.Vector{X: 300, Y: 200}
+	plate.Location.Corner2 = settings.Vector{X: -300, Y: 200}
+	plate.Location.Corner3 = settings.Vector{X: -300, Y: -200}
+	plate.Location.Corner4 = settings.Vector{X: 300, Y: -200}
+	plate.Origin = settings.Vector{X: 0.01, Y: -0.02, Z: -0.04}
+	proc, err := force.NewProcessor(settings.Force{UnitLength: "m", Plates: []settings.ForcePlate{plate}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples, _ := proc.Add(&packets.ComponentForce{ForcePlates: []packets.ForcePlate{{
+		ID: 2, Samples: []packets.ForceSample{{
+			Force:            packets.Point{X: 10, Z: 700},
+			Moment:           packets.Point{Z: 5},
+			CenterOfPressure: packets.Point{X: 0.1, Y: 0.05},
+		}},
+	}}})
+	// Streamed values are float32, so metres scaled up carry some rounding.
+	if len(samples) != 1 || samples[0].COP.Sub(force.Vec{X: 100, Y: 50}).Norm() > 1e-3 {
+		t.Fatalf("samples = %+v", samples)
+	}
+	// The lever arm is measured from the sensor origin: 0.07 m along Y.
+	if got := samples[0].FreeMoment; math.Abs(got-5.7) > 1e-5 {
+		t.Errorf("free moment = %v, want 5.7", got)
+	}
+
+	if _, err := force.NewPlate(plate, "furlong"); err == nil {
+		t.Error("an unknown length unit was accepted")
+	}
+}
+
+func TestDetectorHysteresis(t *testing.T) {
+	d := force.NewDetector()
+	d.MinSamples = 2
+	loads := []float64{
+		0, 25, 5, // a one-sample spike is ignored
+		30, 40, 200, // strike at 3
+		15, 12, 15, // inside the band: still loaded
+		8, 30, // a one-sample dip is ignored
+		9, 2, 0, // toe-off at 11
+	}
+	var events []force.Event
+	for i, load := range loads {
+		if e, ok := d.Update(uint32(i), load); ok {
+			events = append(events, e)
+		}
+	}
+	want := []force.Event{{Kind: force.FootStrike, Sample: 3}, {Kind: force.ToeOff, Sample: 11}}
+	if len(events) != len(want) || events[0] != want[0] || events[1] != want[1] {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+	if d.Loaded() {
+		t.Error("still loaded after toe-off")
+	}
+}
+
+func TestProcessorEventsCarryPlate(t *testing.T) {
+	proc, err := force.NewProcessor(settings.Force{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	single := packets.ComponentForceSingle{ForcePlates: []packets.ForcePlate{{
+		ID: 4, Number: 100, Samples: []packets.ForceSample{{Force: packets.Point{Z: -600}}},
+	}}}
+	_, events := proc.Add((*packets.ComponentForce)(&single))
+	if len(events) != 1 || events[0] != (force.Event{Plate: 4, Kind: force.FootStrike, Sample: 100}) {
+		t.Errorf("events = %v", events)
+	}
+	if proc.Plate(4) == nil || proc.Detector(4) == nil || !proc.Detector(4).Loaded() {
+		t.Error("plate from the stream was not tracked")
+	}
+}
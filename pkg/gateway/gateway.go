@@ -0,0 +1,268 @@
+// Package gateway serves a shared QTM stream to browsers over WebSocket.
+//
+// One process holds the RT connection and publishes into a fanout.Hub; every
+// WebSocket client subscribes to that hub and picks its own components, rate
+// and framing with query parameters:
+//
+//	ws://host:8080/stream?components=6D,3D&rate=30&format=json
+//
+// The parameters are:
+//
+//   - components: comma separated names as accepted by
+//     qualisys.ParseComponentTypes. Defaults to everything upstream streams.
+//   - rate: a target frequency in Hz, or divisor: keep every Nth frame.
+//     Defaults to every frame.
+//   - format: "json" (default) or "binary".
+//
+// The first message on every connection is a JSON "hello" describing the
+// selected components and the marker label and rigid body names. After that,
+// JSON clients receive one text message per frame, and binary clients receive
+// each frame as a complete RT protocol data packet, exactly as QTM would send
+// it, so an existing RT decoder can read it. QTM events are always sent as JSON
+// text messages in both modes.
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/pkg/fanout"
+)
+
+// DefaultWriteTimeout bounds how long one message may take to reach a client.
+// A browser tab that stops reading is disconnected rather than kept around.
+const DefaultWriteTimeout = 5 * time.Second
+
+// Names carries the settings-derived names that make JSON frames readable. The
+// order matches the order QTM streams markers and bodies in, so index N here
+// names marker or body N of a frame.
+type Names struct {
+	Labels []string `json:"labels"`
+	Bodies []string `json:"bodies"`
+}
+
+// Handler upgrades requests to WebSocket and streams frames from a hub. It is
+// safe for concurrent use.
+type Handler struct {
+	hub        *fanout.Hub
+	components []qualisys.ComponentType
+
+	// WriteTimeout overrides DefaultWriteTimeout when positive.
+	WriteTimeout time.Duration
+	// Buffer is the per-client queue length; see fanout.Hub.Subscribe.
+	Buffer int
+	// OnError, if set, is told about client connections that ended with an
+	// error. As elsewhere in this SDK, the library never logs by itself.
+	OnError func(remoteAddr string, err error)
+
+	mu    sync.RWMutex
+	names Names
+}
+
+// NewHandler serves frames published on hub. components lists what the
+// upstream stream carries; clients may request any subset. An empty list
+// places no restriction on what clients ask for.
+func NewHandler(hub *fanout.Hub, components ...qualisys.ComponentType) *Handler {
+	return &Handler{hub: hub, components: components}
+}
+
+// SetNames replaces the label and body names, for example after QTM reports
+// EventTypeCameraSettingsChanged and the caller has re-read the settings.
+// Clients connecting afterwards see the new names.
+func (h *Handler) SetNames(n Names) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.names = n
+}
+
+func (h *Handler) currentNames() Names {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.names
+}
+
+// clientOptions is what a client asked for in its query string.
+type clientOptions struct {
+	components []qualisys.ComponentType
+	binary     bool
+	decimator  *fanout.Decimator
+}
+
+func (h *Handler) parseOptions(r *http.Request) (clientOptions, error) {
+	q := r.URL.Query()
+	opts := clientOptions{components: h.components}
+
+	if s := q.Get("components"); s != "" {
+		cs, err := qualisys.ParseComponentTypes(s)
+		if err != nil {
+			return opts, err
+		}
+		for _, c := range cs {
+			if len(h.components) > 0 && !slices.Contains(h.components, c) {
+				return opts, fmt.Errorf("component %v is not being streamed", c)
+			}
+		}
+		opts.components = cs
+	}
+
+	switch q.Get("format") {
+	case "", "json":
+	case "binary":
+		opts.binary = true
+	default:
+		return opts, fmt.Errorf("unknown format %q", q.Get("format"))
+	}
+
+	rate, value := qualisys.StreamRateTypeAllFrames, 0
+	if s := q.Get("rate"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			return opts, fmt.Errorf("rate: %w", err)
+		}
+		rate, value = qualisys.StreamRateTypeFrequency, v
+	}
+	if s := q.Get("divisor"); s != "" {
+		if rate != qualisys.StreamRateTypeAllFrames {
+			return opts, errors.New("rate and divisor are mutually exclusive")
+		}
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			return opts, fmt.Errorf("divisor: %w", err)
+		}
+		rate, value = qualisys.StreamRateTypeFrequencyDivisor, v
+	}
+	dec, err := fanout.NewDecimator(rate, value)
+	if err != nil {
+		return opts, err
+	}
+	opts.decimator = dec
+	return opts, nil
+}
+
+type helloMessage struct {
+	Type       string   `json:"type"`
+	Components []string `json:"components"`
+	Format     string   `json:"format"`
+	Names
+}
+
+type eventMessage struct {
+	Type  string `json:"type"`
+	Event string `json:"event"`
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	opts, err := h.parseOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	conn, err := upgrade(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	if err := h.serve(conn, opts); err != nil && h.OnError != nil {
+		h.OnError(r.RemoteAddr, err)
+	}
+}
+
+func (h *Handler) serve(conn *wsConn, opts clientOptions) error {
+	timeout := h.WriteTimeout
+	if timeout <= 0 {
+		timeout = DefaultWriteTimeout
+	}
+	names := h.currentNames()
+
+	hello := helloMessage{Type: "hello", Format: "json", Names: names}
+	if opts.binary {
+		hello.Format = "binary"
+	}
+	for _, c := range opts.components {
+		hello.Components = append(hello.Components, c.String())
+	}
+	b, err := json.Marshal(hello)
+	if err != nil {
+		return err
+	}
+	if err := conn.writeFrame(opText, b, timeout); err != nil {
+		return err
+	}
+
+	sub := h.hub.Subscribe(h.Buffer)
+	defer sub.Close()
+
+	// The read loop exists to answer pings and notice the client leaving.
+	// Clients have nothing to tell us, so data messages are discarded.
+	closed := make(chan error, 1)
+	go func() {
+		for {
+			if _, _, err := conn.readMessage(); err != nil {
+				closed <- err
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case err := <-closed:
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		case p, ok := <-sub.C():
+			if !ok {
+				// The hub shut down: say goodbye properly.
+				return conn.writeFrame(opClose, []byte{0x03, 0xE9}, timeout) // 1001 going away
+			}
+			if err := h.send(conn, p, opts, names, timeout); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (h *Handler) send(conn *wsConn, p *qualisys.Packet, opts clientOptions, names Names, timeout time.Duration) error {
+	switch p.Type {
+	case qualisys.PacketTypeEvent:
+		b, err := json.Marshal(eventMessage{Type: "event", Event: p.Event.String()})
+		if err != nil {
+			return err
+		}
+		return conn.writeFrame(opText, b, timeout)
+	case qualisys.PacketTypeData:
+	default:
+		return nil
+	}
+
+	if !opts.decimator.Keep(&p.Data) {
+		return nil
+	}
+	frame := p.Data
+	if len(opts.components) > 0 {
+		frame = p.Data.Select(opts.components...)
+	}
+	if opts.binary {
+		out := qualisys.Packet{Type: qualisys.PacketTypeData, Data: frame}
+		b, err := out.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		return conn.writeFrame(opBinary, b, timeout)
+	}
+	b, err := EncodeJSON(&frame, names)
+	if err != nil {
+		return err
+	}
+	return conn.writeFrame(opText, b, timeout)
+}
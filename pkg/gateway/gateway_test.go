@@ -0,0 +1,209 @@
+package gateway_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/pkg/fanout"
+	"github.com/mlveggo/qualisys-go/pkg/gateway"
+	"github.com/mlveggo/qualisys-go/pkg/packets"
+)
+
+// wsClient is just enough of a WebSocket client to drive the handler.
+type wsClient struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func dialWS(t *testing.T, srv *httptest.Server, query string) *wsClient {
+	t.Helper()
+	var d net.Dialer
+	conn, err := d.DialContext(context.Background(), "tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	req := "GET /stream?" + query + " HTTP/1.1\r\n" +
+		"Host: test\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := conn.Write([]byte(req)); err != nil {
+		t.Fatalf("write handshake: %v", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("read handshake: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d, want 101", resp.StatusCode)
+	}
+	// The accept value for this key is the worked example from RFC 6455.
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Sec-WebSocket-Accept = %q", got)
+	}
+	return &wsClient{conn: conn, br: br}
+}
+
+func (c *wsClient) read(t *testing.T) (byte, []byte) {
+	t.Helper()
+	if err := c.conn.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	var h [2]byte
+	if _, err := io.ReadFull(c.br, h[:]); err != nil {
+		t.Fatalf("read frame: %v", err)
+	}
+	n := uint64(h[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			t.Fatal(err)
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			t.Fatal(err)
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		t.Fatal(err)
+	}
+	return h[0] & 0x0F, payload
+}
+
+// waitForSubscriber blocks until the handler has subscribed, so frames
+// published afterwards are guaranteed to reach it.
+func waitForSubscriber(t *testing.T, hub *fanout.Hub, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for hub.Len() < n {
+		if time.Now().After(deadline) {
+			t.Fatal("client never subscribed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func testFrame(n uint32) *qualisys.Packet {
+	nan := float32(math.NaN())
+	return &qualisys.Packet{Type: qualisys.PacketTypeData, Data: qualisys.DataPacket{
+		Frame:     n,
+		Timestamp: uint64(n) * 10_000,
+		Components: []qualisys.IDataObject{
+			&packets.Component3D{Markers: []packets.Marker{
+				{Point: packets.Point{X: 1, Y: 2, Z: 3}},
+				{Point: packets.Point{X: nan, Y: nan, Z: nan}},
+			}},
+			&packets.Component6D{Bodies: []packets.BodyMatrix{{Point: packets.Point{X: 4}}}},
+		},
+	}}
+}
+
+func newServer(t *testing.T) (*fanout.Hub, *httptest.Server) {
+	hub := fanout.NewHub()
+	h := gateway.NewHandler(hub, qualisys.ComponentType3D, qualisys.ComponentType6D)
+	h.SetNames(gateway.Names{Labels: []string{"head", "toe"}, Bodies: []string{"wand"}})
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	t.Cleanup(hub.Close)
+	return hub, srv
+}
+
+func TestGatewayJSONFrames(t *testing.T) {
+	hub, srv := newServer(t)
+	c := dialWS(t, srv, "components=3D")
+
+	op, hello := c.read(t)
+	if op != 0x1 || !strings.Contains(string(hello), `"bodies":["wand"]`) {
+		t.Fatalf("hello = %d %s", op, hello)
+	}
+	waitForSubscriber(t, hub, 1)
+	hub.Publish(testFrame(1))
+	hub.Publish(&qualisys.Packet{Type: qualisys.PacketTypeEvent, Event: qualisys.EventTypeCaptureStarted})
+
+	_, b := c.read(t)
+	var frame struct {
+		Frame      uint32                     `json:"frame"`
+		Components map[string]json.RawMessage `json:"components"`
+	}
+	if err := json.Unmarshal(b, &frame); err != nil {
+		t.Fatalf("frame is not valid JSON (%v): %s", err, b)
+	}
+	if frame.Frame != 1 {
+		t.Errorf("frame = %d, want 1", frame.Frame)
+	}
+	if _, ok := frame.Components["6D"]; ok {
+		t.Error("6D was sent although the client only asked for 3D")
+	}
+	// An occluded marker is NaN, which must become null rather than break
+	// the encoding, and markers carry their settings labels.
+	markers := string(frame.Components["3D"])
+	if !strings.Contains(markers, `"label":"toe","position":{"x":null`) {
+		t.Errorf("3D = %s", markers)
+	}
+
+	_, ev := c.read(t)
+	if string(ev) != `{"type":"event","event":"CaptureStarted"}` {
+		t.Errorf("event = %s", ev)
+	}
+}
+
+func TestGatewayBinaryFramesAreRTPackets(t *testing.T) {
+	hub, srv := newServer(t)
+	c := dialWS(t, srv, "format=binary&divisor=2")
+	c.read(t) // hello
+	waitForSubscriber(t, hub, 1)
+	for i := uint32(0); i < 3; i++ {
+		hub.Publish(testFrame(i))
+	}
+
+	for _, want := range []uint32{0, 2} {
+		op, b := c.read(t)
+		if op != 0x2 {
+			t.Fatalf("opcode = %d, want binary", op)
+		}
+		var p qualisys.Packet
+		if err := p.UnmarshalBinary(b); err != nil {
+			t.Fatalf("binary frame does not decode as an RT packet: %v", err)
+		}
+		if p.Data.Frame != want {
+			t.Errorf("frame = %d, want %d", p.Data.Frame, want)
+		}
+		if p.Data.Bodies6D() == nil || p.Data.Markers3D() == nil {
+			t.Errorf("components = %v", p.Data.Components)
+		}
+	}
+}
+
+func TestGatewayRejectsBadRequests(t *testing.T) {
+	_, srv := newServer(t)
+	for _, q := range []string{"components=Skeleton", "format=xml", "rate=30&divisor=2", "rate=0"} {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, srv.URL+"/stream?"+q, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", q, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+package gateway
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/pkg/packets"
+)
+
+// jsonFloat renders NaN and infinities as null. QTM reports an occluded marker
+// or an untracked body as NaN, which encoding/json refuses to encode at all, so
+// a single hidden marker would otherwise fail the whole frame.
+type jsonFloat float32
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	v := float64(f)
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return []byte("null"), nil
+	}
+	return strconv.AppendFloat(nil, v, 'g', -1, 32), nil
+}
+
+type jsonPoint struct {
+	X jsonFloat `json:"x"`
+	Y jsonFloat `json:"y"`
+	Z jsonFloat `json:"z"`
+}
+
+func point(p packets.Point) jsonPoint {
+	return jsonPoint{X: jsonFloat(p.X), Y: jsonFloat(p.Y), Z: jsonFloat(p.Z)}
+}
+
+type jsonMarker struct {
+	Label    string     `json:"label,omitempty"`
+	ID       *uint32    `json:"id,omitempty"`
+	Position jsonPoint  `json:"position"`
+	Residual *jsonFloat `json:"residual,omitempty"`
+}
+
+type jsonMarkers struct {
+	Droprate      uint16       `json:"droprate"`
+	OutOfSyncRate uint16       `json:"outOfSyncRate"`
+	Markers       []jsonMarker `json:"markers"`
+}
+
+type jsonBody struct {
+	Name     string      `json:"name,omitempty"`
+	Position jsonPoint   `json:"position"`
+	Rotation []jsonFloat `json:"rotation,omitempty"`
+	Angles   []jsonFloat `json:"angles,omitempty"`
+	Residual *jsonFloat  `json:"residual,omitempty"`
+}
+
+type jsonBodies struct {
+	Droprate      uint16     `json:"droprate"`
+	OutOfSyncRate uint16     `json:"outOfSyncRate"`
+	Bodies        []jsonBody `json:"bodies"`
+}
+
+type jsonAnalogDevice struct {
+	ID           uint32        `json:"id"`
+	SampleNumber uint32        `json:"sampleNumber"`
+	Channels     [][]jsonFloat `json:"channels"`
+}
+
+type jsonForceSample struct {
+	Force            jsonPoint `json:"force"`
+	Moment           jsonPoint `json:"moment"`
+	CenterOfPressure jsonPoint `json:"centerOfPressure"`
+}
+
+type jsonForcePlate struct {
+	ID      uint32            `json:"id"`
+	Number  uint32            `json:"number"`
+	Samples []jsonForceSample `json:"samples"`
+}
+
+type jsonSegment struct {
+	ID       uint32      `json:"id"`
+	Position jsonPoint   `json:"position"`
+	Rotation []jsonFloat `json:"rotation"`
+}
+
+type jsonFrame struct {
+	Type       string         `json:"type"`
+	Frame      uint32         `json:"frame"`
+	Timestamp  uint64         `json:"timestamp"`
+	Components map[string]any `json:"components"`
+}
+
+func floats(v ...float32) []jsonFloat {
+	out := make([]jsonFloat, len(v))
+	for i, f := range v {
+		out[i] = jsonFloat(f)
+	}
+	return out
+}
+
+func residual(f float32) *jsonFloat {
+	r := jsonFloat(f)
+	return &r
+}
+
+func markers(c packets.Component3D, labels []string, withID, withResidual bool) jsonMarkers {
+	out := jsonMarkers{Droprate: c.Droprate, OutOfSyncRate: c.OutOfSyncRate, Markers: make([]jsonMarker, len(c.Markers))}
+	for i, m := range c.Markers {
+		jm := jsonMarker{Position: point(m.Point)}
+		if withID {
+			id := m.ID
+			jm.ID = &id
+		} else if i < len(labels) {
+			jm.Label = labels[i]
+		}
+		if withResidual {
+			jm.Residual = residual(m.Residual)
+		}
+		out.Markers[i] = jm
+	}
+	return out
+}
+
+func bodies(c packets.Component6D, names []string, withResidual bool) jsonBodies {
+	out := jsonBodies{Droprate: c.Droprate, OutOfSyncRate: c.OutOfSyncRate, Bodies: make([]jsonBody, len(c.Bodies))}
+	for i, b := range c.Bodies {
+		jb := jsonBody{Position: point(b.Point), Rotation: floats(b.Rotation[:]...)}
+		if i < len(names) {
+			jb.Name = names[i]
+		}
+		if withResidual {
+			jb.Residual = residual(b.Residual)
+		}
+		out.Bodies[i] = jb
+	}
+	return out
+}
+
+func eulerBodies(c packets.Component6DEuler, names []string, withResidual bool) jsonBodies {
+	out := jsonBodies{Droprate: c.Droprate, OutOfSyncRate: c.OutOfSyncRate, Bodies: make([]jsonBody, len(c.Bodies))}
+	for i, b := range c.Bodies {
+		jb := jsonBody{Position: point(b.Point), Angles: floats(b.Angles[:]...)}
+		if i < len(names) {
+			jb.Name = names[i]
+		}
+		if withResidual {
+			jb.Residual = residual(b.Residual)
+		}
+		out.Bodies[i] = jb
+	}
+	return out
+}
+
+func analog(c packets.ComponentAnalog) []jsonAnalogDevice {
+	out := make([]jsonAnalogDevice, len(c.AnalogDevices))
+	for i, dev := range c.AnalogDevices {
+		jd := jsonAnalogDevice{ID: dev.ID, SampleNumber: dev.SampleNumber, Channels: make([][]jsonFloat, len(dev.Channels))}
+		for ch, channel := range dev.Channels {
+			jd.Channels[ch] = make([]jsonFloat, len(channel.Samples))
+			for s, sample := range channel.Samples {
+				jd.Channels[ch][s] = jsonFloat(sample.Value)
+			}
+		}
+		out[i] = jd
+	}
+	return out
+}
+
+func force(c packets.ComponentForce) []jsonForcePlate {
+	out := make([]jsonForcePlate, len(c.ForcePlates))
+	for i, fp := range c.ForcePlates {
+		jp := jsonForcePlate{ID: fp.ID, Number: fp.Number, Samples: make([]jsonForceSample, len(fp.Samples))}
+		for s, sample := range fp.Samples {
+			jp.Samples[s] = jsonForceSample{
+				Force:            point(sample.Force),
+				Moment:           point(sample.Moment),
+				CenterOfPressure: point(sample.CenterOfPressure),
+			}
+		}
+		out[i] = jp
+	}
+	return out
+}
+
+func skeletons(c packets.ComponentSkeleton) [][]jsonSegment {
+	out := make([][]jsonSegment, len(c.Skeletons))
+	for i, sk := range c.Skeletons {
+		out[i] = make([]jsonSegment, len(sk.Segments))
+		for s, seg := range sk.Segments {
+			out[i][s] = jsonSegment{
+				ID:       seg.ID,
+				Position: point(seg.Position),
+				Rotation: floats(seg.Rotation.X, seg.Rotation.Y, seg.Rotation.Z, seg.Rotation.W),
+			}
+		}
+	}
+	return out
+}
+
+type jsonGazeVector struct {
+	SampleNumber uint32     `json:"sampleNumber"`
+	Samples      []jsonGaze `json:"samples"`
+}
+
+type jsonGaze struct {
+	Direction jsonPoint `json:"direction"`
+	Position  jsonPoint `json:"position"`
+}
+
+type jsonEyeTracker struct {
+	SampleNumber uint32        `json:"sampleNumber"`
+	Samples      [][]jsonFloat `json:"samples"`
+}
+
+func gazeVectors(c packets.ComponentGazeVector) []jsonGazeVector {
+	out := make([]jsonGazeVector, len(c.GazeVectors))
+	for i, gv := range c.GazeVectors {
+		out[i] = jsonGazeVector{SampleNumber: gv.SampleNumber, Samples: make([]jsonGaze, len(gv.Samples))}
+		for s, sample := range gv.Samples {
+			out[i].Samples[s] = jsonGaze{
+				Direction: point(packets.Point{X: sample.X, Y: sample.Y, Z: sample.Z}),
+				Position:  point(packets.Point{X: sample.PositionX, Y: sample.PositionY, Z: sample.PositionZ}),
+			}
+		}
+	}
+	return out
+}
+
+func eyeTrackers(c packets.ComponentEyeTracker) []jsonEyeTracker {
+	out := make([]jsonEyeTracker, len(c.EyeTrackers))
+	for i, et := range c.EyeTrackers {
+		out[i] = jsonEyeTracker{SampleNumber: et.SampleNumber, Samples: make([][]jsonFloat, len(et.Samples))}
+		for s, sample := range et.Samples {
+			out[i].Samples[s] = floats(sample.LeftPupilDiameter, sample.RightPupilDiameter)
+		}
+	}
+	return out
+}
+
+// EncodeJSON renders a frame as a JSON object keyed by component name, the text
+// message JSON clients receive. names label markers and bodies.
+//
+// Camera images are binary-only: base64 video in JSON is a poor trade for
+// everyone involved. The 2D components hold only integers, so they use
+// encoding/json's default rendering of the decoded struct; everything with
+// floats goes through jsonFloat.
+func EncodeJSON(d *qualisys.DataPacket, names Names) ([]byte, error) {
+	out := jsonFrame{Type: "frame", Frame: d.Frame, Timestamp: d.Timestamp, Components: map[string]any{}}
+	for _, obj := range d.Components {
+		switch c := obj.(type) {
+		case *packets.Component3D:
+			out.Components[qualisys.ComponentType3D.String()] = markers(*c, names.Labels, false, false)
+		case *packets.Component3DResidual:
+			out.Components[qualisys.ComponentType3DResidual.String()] = markers(packets.Component3D(*c), names.Labels, false, true)
+		case *packets.Component3DNoLabels:
+			out.Components[qualisys.ComponentType3DNoLabels.String()] = markers(packets.Component3D(*c), nil, true, false)
+		case *packets.Component3DNoLabelsResidual:
+			out.Components[qualisys.ComponentType3DNoLabelsResidual.String()] = markers(packets.Component3D(*c), nil, true, true)
+		case *packets.Component6D:
+			out.Components[qualisys.ComponentType6D.String()] = bodies(*c, names.Bodies, false)
+		case *packets.Component6DResidual:
+			out.Components[qualisys.ComponentType6DResidual.String()] = bodies(packets.Component6D(*c), names.Bodies, true)
+		case *packets.Component6DEuler:
+			out.Components[qualisys.ComponentType6DEuler.String()] = eulerBodies(*c, names.Bodies, false)
+		case *packets.Component6DEulerResidual:
+			out.Components[qualisys.ComponentType6DEulerResidual.String()] = eulerBodies(packets.Component6DEuler(*c), names.Bodies, true)
+		case *packets.ComponentAnalog:
+			out.Components[qualisys.ComponentTypeAnalog.String()] = analog(*c)
+		case *packets.ComponentAnalogSingle:
+			out.Components[qualisys.ComponentTypeAnalogSingle.String()] = analog(packets.ComponentAnalog(*c))
+		case *packets.ComponentForce:
+			out.Components[qualisys.ComponentTypeForce.String()] = force(*c)
+		case *packets.ComponentForceSingle:
+			out.Components[qualisys.ComponentTypeForceSingle.String()] = force(packets.ComponentForce(*c))
+		case *packets.ComponentSkeleton:
+			out.Components[qualisys.ComponentTypeSkeleton.String()] = skeletons(*c)
+		case *packets.Component2D:
+			out.Components[qualisys.ComponentType2D.String()] = c
+		case *packets.Component2DLinearized:
+			out.Components[qualisys.ComponentType2DLinearized.String()] = c
+		case *packets.ComponentGazeVector:
+			out.Components[qualisys.ComponentTypeGazeVector.String()] = gazeVectors(*c)
+		case *packets.ComponentEyeTracker:
+			out.Components[qualisys.ComponentTypeEyeTracker.String()] = eyeTrackers(*c)
+		case *packets.ComponentTimecode:
+			tcs := make([]string, len(c.Timecodes))
+			for i, tc := range c.Timecodes {
+				tcs[i] = tc.String()
+			}
+			out.Components[qualisys.ComponentTypeTimecode.String()] = tcs
+		}
+	}
+	return json.Marshal(out)
+}
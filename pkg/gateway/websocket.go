@@ -0,0 +1,222 @@
+package gateway
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// This file is a deliberately small RFC 6455 server: enough to push frames to
+// browsers and honor the control messages they send, and nothing more. It
+// exists so the SDK keeps its zero-dependency promise; there is no WebSocket
+// support in the standard library.
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Opcodes from RFC 6455 section 5.2.
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// maxClientMessage bounds what a browser may send us. Clients only ever send
+// control frames and the occasional small text message, so anything larger is
+// a misbehaving peer.
+const maxClientMessage = 64 << 10
+
+var errNotWebSocket = errors.New("not a websocket upgrade request")
+
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	// wmu serializes writes: the frame loop writes data while the read loop
+	// answers pings.
+	wmu sync.Mutex
+}
+
+// headerContainsToken reports whether a comma separated header such as
+// Connection lists token, ignoring case.
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func acceptKey(key string) string {
+	// RFC 6455 mandates SHA-1 here; it is a handshake check, not security.
+	h := sha1.New()
+	h.Write([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// upgrade performs the opening handshake and takes over the connection. On a
+// request that is not a WebSocket upgrade it writes a 400 and returns an error.
+func upgrade(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet ||
+		!headerContainsToken(r.Header, "Connection", "upgrade") ||
+		!headerContainsToken(r.Header, "Upgrade", "websocket") ||
+		key == "" {
+		http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+		return nil, errNotWebSocket
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, errNotWebSocket
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "connection cannot be upgraded", http.StatusInternalServerError)
+		return nil, errors.New("websocket: response writer does not support hijacking")
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("websocket: hijack: %w", err)
+	}
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(resp)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("websocket: handshake: %w", err)
+	}
+	return &wsConn{conn: conn, br: brw.Reader}, nil
+}
+
+// writeFrame sends a single unfragmented, unmasked frame. Servers must not mask.
+func (c *wsConn) writeFrame(op byte, payload []byte, timeout time.Duration) error {
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | op // FIN
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if timeout > 0 {
+		if err := c.conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+			return err
+		}
+	}
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return fmt.Errorf("websocket: write: %w", err)
+	}
+	return nil
+}
+
+// readMessage returns the next data message, answering pings and close frames
+// along the way. It returns io.EOF once the peer has closed.
+func (c *wsConn) readMessage() (op byte, msg []byte, err error) {
+	for {
+		fin, fop, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch fop {
+		case opPing:
+			if err := c.writeFrame(opPong, payload, time.Second); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			// Echo the status code back, as the closing handshake requires.
+			if len(payload) > 2 {
+				payload = payload[:2]
+			}
+			_ = c.writeFrame(opClose, payload, time.Second)
+			return 0, nil, io.EOF
+		case opContinuation:
+			if op == 0 {
+				return 0, nil, errors.New("websocket: continuation without a message")
+			}
+		default:
+			if op != 0 {
+				return 0, nil, errors.New("websocket: new message inside a fragmented one")
+			}
+			op = fop
+		}
+		msg = append(msg, payload...)
+		if len(msg) > maxClientMessage {
+			return 0, nil, fmt.Errorf("websocket: client message exceeds %d bytes", maxClientMessage)
+		}
+		if fin {
+			return op, msg, nil
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var h [2]byte
+	if _, err := io.ReadFull(c.br, h[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = h[0]&0x80 != 0
+	op = h[0] & 0x0F
+	if h[1]&0x80 == 0 {
+		return false, 0, nil, errors.New("websocket: client frame is not masked")
+	}
+	n := uint64(h[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > maxClientMessage {
+		return false, 0, nil, fmt.Errorf("websocket: client frame of %d bytes exceeds %d", n, maxClientMessage)
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}
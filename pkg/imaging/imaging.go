@@ -0,0 +1,119 @@
+// Package imaging decodes the camera images QTM streams into standard
+// image.Image values and writes them to disk as numbered sequences.
+//
+// An Image carries raw greyscale, raw BGR, JPEG or PNG bytes together with its
+// size and crop rectangle. Decode interprets all four:
+//
+//	for _, img := range p.Data.Images().Images {
+//		im, err := imaging.Decode(img)
+//		...
+//	}
+//
+// The crop says where on the sensor the image was taken. The camera sends only
+// that region, so the decoded image is already cropped; SensorRect places it on
+// the sensor.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/mlveggo/qualisys-go/pkg/packets"
+)
+
+// ErrFormat is returned for an image format this package cannot decode.
+var ErrFormat = errors.New("imaging: unsupported image format")
+
+// Decode converts img to an image.Image holding every pixel the camera sent.
+// Raw images are read row by row without padding, Width pixels wide and Height
+// rows high; raw BGR becomes an *image.RGBA and raw greyscale an *image.Gray
+// sharing img.Data. JPEG and PNG images are decoded as stored, whatever Width
+// and Height say.
+func Decode(img packets.Image) (image.Image, error) {
+	w, h := int(img.Width), int(img.Height)
+	switch img.Format {
+	case packets.ImageFormatTypeRawGreyscale:
+		if len(img.Data) < w*h {
+			return nil, fmt.Errorf("imaging: camera %d: %d bytes for a %dx%d greyscale image", img.ID, len(img.Data), w, h)
+		}
+		return &image.Gray{Pix: img.Data[:w*h], Stride: w, Rect: image.Rect(0, 0, w, h)}, nil
+	case packets.ImageFormatTypeRawBGR:
+		if len(img.Data) < 3*w*h {
+			return nil, fmt.Errorf("imaging: camera %d: %d bytes for a %dx%d BGR image", img.ID, len(img.Data), w, h)
+		}
+		rgba := image.NewRGBA(image.Rect(0, 0, w, h))
+		for i := 0; i < w*h; i++ {
+			rgba.Pix[4*i+0] = img.Data[3*i+2]
+			rgba.Pix[4*i+1] = img.Data[3*i+1]
+			rgba.Pix[4*i+2] = img.Data[3*i]
+			rgba.Pix[4*i+3] = 0xFF
+		}
+		return rgba, nil
+	case packets.ImageFormatTypeJPG:
+		im, err := jpeg.Decode(bytes.NewReader(img.Data))
+		if err != nil {
+			return nil, fmt.Errorf("imaging: camera %d: %w", img.ID, err)
+		}
+		return im, nil
+	case packets.ImageFormatTypePNG:
+		im, err := png.Decode(bytes.NewReader(img.Data))
+		if err != nil {
+			return nil, fmt.Errorf("imaging: camera %d: %w", img.ID, err)
+		}
+		return im, nil
+	default:
+		return nil, fmt.Errorf("%w: camera %d: %v", ErrFormat, img.ID, img.Format)
+	}
+}
+
+// SensorRect returns the part of the sensor, whose pixels span sensor, that
+// img shows. The crop values are the positions of the image edges as fractions
+// of the sensor, from 0 at the left or top to 1 at the right or bottom, and
+// describe the image rather than ask for it to be cut: the camera has already
+// sent only that region. Values that select nothing, such as the all-zero crop
+// of an image that was never cropped, select the whole sensor.
+func SensorRect(img packets.Image, sensor image.Rectangle) image.Rectangle {
+	bounds := sensor
+	if img.RightCrop <= img.LeftCrop || img.BottomCrop <= img.TopCrop {
+		return bounds
+	}
+	at := func(frac float32, lo, hi int) int {
+		v := lo + int(float64(frac)*float64(hi-lo)+0.5)
+		return min(max(v, lo), hi)
+	}
+	r := image.Rect(
+		at(img.LeftCrop, bounds.Min.X, bounds.Max.X),
+		at(img.TopCrop, bounds.Min.Y, bounds.Max.Y),
+		at(img.RightCrop, bounds.Min.X, bounds.Max.X),
+		at(img.BottomCrop, bounds.Min.Y, bounds.Max.Y),
+	)
+	if r.Empty() {
+		return bounds
+	}
+	return r
+}
+
+// JPEG returns img encoded as JPEG at the given quality, 1 to 100, or
+// jpeg.DefaultQuality when zero. A JPEG image is returned as received,
+// avoiding a decode and a lossy re-encode.
+func JPEG(img packets.Image, quality int) ([]byte, error) {
+	if img.Format == packets.ImageFormatTypeJPG {
+		return img.Data, nil
+	}
+	im, err := Decode(img)
+	if err != nil {
+		return nil, err
+	}
+	if quality == 0 {
+		quality = jpeg.DefaultQuality
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, im, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, fmt.Errorf("imaging: camera %d: %w", img.ID, err)
+	}
+	return buf.Bytes(), nil
+}
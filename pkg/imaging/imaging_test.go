@@ -0,0 +1,148 @@
+package imaging_test
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mlveggo/qualisys-go/pkg/imaging"
+	"github.com/mlveggo/qualisys-go/pkg/packets"
+)
+
+func TestDecodeRaw(t *testing.T) {
+	grey := packets.Image{ID: 1, Format: packets.ImageFormatTypeRawGreyscale, Width: 4, Height: 2,
+		Data: []byte{0, 1, 2, 3, 10, 11, 12, 13}}
+	im, err := imaging.Decode(grey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if im.Bounds() != image.Rect(0, 0, 4, 2) || im.(*image.Gray).GrayAt(2, 1).Y != 12 {
+		t.Errorf("greyscale = %v", im)
+	}
+
+	bgr := packets.Image{ID: 2, Format: packets.ImageFormatTypeRawBGR, Width: 2, Height: 1,
+		Data: []byte{255, 0, 0, 0, 128, 64}}
+	im, err = imaging.Decode(bgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rgba := im.(*image.RGBA)
+	if rgba.RGBAAt(0, 0) != (color.RGBA{B: 255, A: 255}) || rgba.RGBAAt(1, 0) != (color.RGBA{R: 64, G: 128, A: 255}) {
+		t.Errorf("BGR pixels = %v %v", rgba.RGBAAt(0, 0), rgba.RGBAAt(1, 0))
+	}
+
+	short := grey
+	short.Height = 3
+	if _, err := imaging.Decode(short); err == nil {
+		t.Error("a truncated raw image decoded")
+	}
+	if _, err := imaging.Decode(packets.Image{Format: 9}); !errors.Is(err, imaging.ErrFormat) {
+		t.Errorf("unknown format: %v", err)
+	}
+}
+
+func TestDecodeCompressed(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 100, 50))
+	for i := range src.Pix {
+		src.Pix[i] = byte(i)
+	}
+	var pngData, jpgData bytes.Buffer
+	if err := png.Encode(&pngData, src); err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(&jpgData, src, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	img := packets.Image{ID: 3, Format: packets.ImageFormatTypePNG, Width: 100, Height: 50, Data: pngData.Bytes()}
+	im, err := imaging.Decode(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := color.GrayModel.Convert(im.At(30, 20)).(color.Gray).Y; im.Bounds() != src.Bounds() || g != src.GrayAt(30, 20).Y {
+		t.Errorf("PNG %v, pixel = %d, want %d", im.Bounds(), g, src.GrayAt(30, 20).Y)
+	}
+
+	img = packets.Image{ID: 3, Format: packets.ImageFormatTypeJPG, Width: 100, Height: 50, Data: jpgData.Bytes()}
+	if im, err := imaging.Decode(img); err != nil || im.Bounds() != image.Rect(0, 0, 100, 50) {
+		t.Errorf("JPEG = %v, %v", im, err)
+	}
+}
+
+func TestCroppedCamera(t *testing.T) {
+	// The camera streams the middle half of an 8x4 sensor: 4x2 pixels, every
+	// one of which is kept.
+	img := packets.Image{ID: 4, Format: packets.ImageFormatTypeRawGreyscale, Width: 4, Height: 2,
+		LeftCrop: 0.25, TopCrop: 0.25, RightCrop: 0.75, BottomCrop: 0.75,
+		Data: []byte{0, 1, 2, 3, 10, 11, 12, 13}}
+	im, err := imaging.Decode(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if im.Bounds() != image.Rect(0, 0, 4, 2) || im.(*image.Gray).GrayAt(3, 1).Y != 13 {
+		t.Errorf("cropped camera image = %v", im)
+	}
+	if r := imaging.SensorRect(img, image.Rect(0, 0, 8, 4)); r != image.Rect(2, 1, 6, 3) {
+		t.Errorf("sensor rectangle = %v", r)
+	}
+	uncropped := img
+	uncropped.LeftCrop, uncropped.TopCrop, uncropped.RightCrop, uncropped.BottomCrop = 0, 0, 0, 0
+	if r := imaging.SensorRect(uncropped, image.Rect(0, 0, 8, 4)); r != image.Rect(0, 0, 8, 4) {
+		t.Errorf("uncropped sensor rectangle = %v", r)
+	}
+
+	// A cropped JPEG is already the region; it passes through as sent.
+	var jpgData bytes.Buffer
+	if err := jpeg.Encode(&jpgData, im, nil); err != nil {
+		t.Fatal(err)
+	}
+	img.Format, img.Data = packets.ImageFormatTypeJPG, jpgData.Bytes()
+	if got, err := imaging.JPEG(img, 0); err != nil || !bytes.Equal(got, jpgData.Bytes()) {
+		t.Errorf("cropped JPEG was re-encoded: %v", err)
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "out")
+	var pngData bytes.Buffer
+	if err := png.Encode(&pngData, image.NewGray(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	c := &packets.ComponentImage{Images: []packets.Image{
+		{ID: 1, Format: packets.ImageFormatTypePNG, Width: 8, Height: 8, Data: pngData.Bytes(),
+			LeftCrop: 0.5, TopCrop: 0, RightCrop: 1, BottomCrop: 1},
+		{ID: 2, Format: packets.ImageFormatTypeRawGreyscale, Width: 2, Height: 2, Data: []byte{1, 2, 3, 4}},
+	}}
+
+	sink := imaging.NewFileSink(dir, imaging.FormatPNG)
+	if err := sink.WriteFrame(42, c); err != nil {
+		t.Fatal(err)
+	}
+	// Images already in the sink's format are written untouched.
+	if got, err := os.ReadFile(filepath.Join(dir, "camera01_frame000042.png")); err != nil || !bytes.Equal(got, pngData.Bytes()) {
+		t.Errorf("camera 1 file: %v", err)
+	}
+	f, err := os.Open(sink.Path(2, 42))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	im, err := png.Decode(f)
+	if err != nil || im.Bounds().Dx() != 2 || color.GrayModel.Convert(im.At(1, 1)).(color.Gray).Y != 4 {
+		t.Errorf("camera 2 image = %v, %v", im, err)
+	}
+
+	jpg := imaging.NewFileSink(dir, imaging.FormatJPEG)
+	if err := jpg.WriteImage(43, c.Images[1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "camera02_frame000043.jpg")); err != nil {
+		t.Error(err)
+	}
+}
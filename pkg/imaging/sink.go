@@ -0,0 +1,113 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+
+	"github.com/mlveggo/qualisys-go/pkg/packets"
+)
+
+//go:generate stringer -type Format -trimprefix Format
+
+// Format is the file format a FileSink writes.
+type Format int
+
+const (
+	FormatPNG Format = iota + 1
+	FormatJPEG
+)
+
+func (f Format) extension() string {
+	if f == FormatJPEG {
+		return ".jpg"
+	}
+	return ".png"
+}
+
+// DefaultPattern names files by camera ID and frame number, so each camera's
+// images sort into a sequence.
+const DefaultPattern = "camera%02d_frame%06d"
+
+// FileSink writes images as numbered PNG or JPEG files, one sequence per
+// camera.
+type FileSink struct {
+	// Dir is the directory written to; it is created if missing.
+	Dir    string
+	Format Format
+	// Quality is the JPEG quality, 1 to 100; zero means jpeg.DefaultQuality.
+	Quality int
+	// Pattern is a format string taking the camera ID and the frame number,
+	// to which the extension is added. Empty means DefaultPattern.
+	Pattern string
+}
+
+// NewFileSink returns a sink writing to dir in the given format.
+func NewFileSink(dir string, format Format) *FileSink {
+	return &FileSink{Dir: dir, Format: format}
+}
+
+// Path returns the file an image of camera id and frame is written to.
+func (s *FileSink) Path(id, frame uint32) string {
+	pattern := s.Pattern
+	if pattern == "" {
+		pattern = DefaultPattern
+	}
+	return filepath.Join(s.Dir, fmt.Sprintf(pattern, id, frame)+s.Format.extension())
+}
+
+// WriteImage writes one camera image of a frame. An image that already
+// arrives in the sink's format is written as received, without decoding and
+// re-encoding it.
+func (s *FileSink) WriteImage(frame uint32, img packets.Image) error {
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return err
+	}
+	path := s.Path(img.ID, frame)
+	if s.Format == FormatPNG && img.Format == packets.ImageFormatTypePNG ||
+		s.Format == FormatJPEG && img.Format == packets.ImageFormatTypeJPG {
+		return os.WriteFile(path, img.Data, 0o644)
+	}
+
+	im, err := Decode(img)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := s.encode(f, im); err != nil {
+		f.Close()
+		return fmt.Errorf("imaging: write %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+func (s *FileSink) encode(f *os.File, im image.Image) error {
+	if s.Format == FormatJPEG {
+		quality := s.Quality
+		if quality == 0 {
+			quality = jpeg.DefaultQuality
+		}
+		return jpeg.Encode(f, im, &jpeg.Options{Quality: quality})
+	}
+	return png.Encode(f, im)
+}
+
+// WriteFrame writes every image of a frame's image component. c may be nil,
+// for frames without images. It stops at the first error.
+func (s *FileSink) WriteFrame(frame uint32, c *packets.ComponentImage) error {
+	if c == nil {
+		return nil
+	}
+	for _, img := range c.Images {
+		if err := s.WriteImage(frame, img); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+// Package metrics collects streaming health statistics from a Protocol and
+// serves them in the Prometheus text exposition format.
+//
+// Pass a Registry to every Protocol with qualisys.WithMetrics, including the
+// ones a reconnect loop creates, and mount it on an HTTP server:
+//
+//	reg := metrics.NewRegistry()
+//	http.Handle("/metrics", reg)
+//	rt := qualisys.NewProtocol(ip, port, qualisys.WithMetrics(reg))
+//
+// The exported series are:
+//
+//	qtm_packets_received_total{type}          packets read, by packet type
+//	qtm_bytes_received_total                  bytes read, headers included
+//	qtm_receive_errors_total{kind}            failed reads: truncated, decode or connection
+//	qtm_connects_total                        successful Connects
+//	qtm_reconnects_total                      Connects after the first
+//	qtm_command_duration_seconds{command}     histogram of command round trips
+//	qtm_command_errors_total{command}         commands that failed
+//	qtm_frame_gaps_total                      times the frame number skipped ahead
+//	qtm_frames_missed_total                   frames skipped over by those gaps
+//	qtm_droprate_per_mille{component}         last Droprate QTM reported
+//	qtm_out_of_sync_rate_per_mille{component} last OutOfSyncRate QTM reported
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/pkg/packets"
+)
+
+// Buckets are the upper bounds, in seconds, of the command duration
+// histogram. Commands normally answer within milliseconds; the upper buckets
+// catch QTM stalling while it loads a project or saves a file.
+var Buckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry implements qualisys.Metrics and http.Handler. It is safe for
+// concurrent use, so one Registry can be shared by a TCP and a UDP receiver
+// and kept across reconnects. The zero value is not usable; call NewRegistry.
+type Registry struct {
+	// FrameStep is the frame number increment expected between consecutive
+	// data packets; a larger increment counts as a gap. It defaults to 1,
+	// which suits streaming all frames. Set it to the divisor when streaming
+	// with StreamRateTypeFrequencyDivisor, or every frame looks like a gap.
+	FrameStep uint32
+
+	mu            sync.Mutex
+	packets       map[qualisys.PacketType]uint64
+	bytes         uint64
+	receiveErrors map[string]uint64
+	connects      uint64
+	commands      map[string]*histogram
+	frameGaps     uint64
+	framesMissed  uint64
+	lastFrame     uint32
+	haveFrame     bool
+	droprate      map[string]uint16
+	outOfSyncRate map[string]uint16
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative; the last is +Inf
+	sum    float64
+	errors uint64
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		FrameStep:     1,
+		packets:       make(map[qualisys.PacketType]uint64),
+		receiveErrors: make(map[string]uint64),
+		commands:      make(map[string]*histogram),
+		droprate:      make(map[string]uint16),
+		outOfSyncRate: make(map[string]uint16),
+	}
+}
+
+// Connected implements qualisys.Metrics. Frame numbering restarts with a new
+// connection, so the next frame is not compared with the last one seen.
+func (r *Registry) Connected() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.connects++
+	r.haveFrame = false
+}
+
+// PacketReceived implements qualisys.Metrics.
+func (r *Registry) PacketReceived(p *qualisys.Packet) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.packets[p.Type]++
+	r.bytes += uint64(p.Size)
+	if p.Type == qualisys.PacketTypeData {
+		r.observeFrame(&p.Data)
+	}
+}
+
+func (r *Registry) observeFrame(d *qualisys.DataPacket) {
+	step := max(r.FrameStep, 1)
+	// A frame number that does not move forward means a new capture or RT
+	// from file restarting, not lost frames.
+	if r.haveFrame && d.Frame > r.lastFrame+step {
+		r.frameGaps++
+		r.framesMissed += uint64(d.Frame-r.lastFrame)/uint64(step) - 1
+	}
+	r.lastFrame, r.haveFrame = d.Frame, true
+
+	for _, c := range d.Components {
+		t, drop, outOfSync, ok := rates(c)
+		if ok {
+			r.droprate[t.String()] = drop
+			r.outOfSyncRate[t.String()] = outOfSync
+		}
+	}
+}
+
+// rates returns the drop and out-of-sync rates of the components that carry
+// them.
+func rates(c qualisys.IDataObject) (t qualisys.ComponentType, drop, outOfSync uint16, ok bool) {
+	switch c := c.(type) {
+	case *packets.Component3D:
+		return qualisys.ComponentType3D, c.Droprate, c.OutOfSyncRate, true
+	case *packets.Component3DResidual:
+		return qualisys.ComponentType3DResidual, c.Droprate, c.OutOfSyncRate, true
+	case *packets.Component3DNoLabels:
+		return qualisys.ComponentType3DNoLabels, c.Droprate, c.OutOfSyncRate, true
+	case *packets.Component3DNoLabelsResidual:
+		return qualisys.ComponentType3DNoLabelsResidual, c.Droprate, c.OutOfSyncRate, true
+	case *packets.Component6D:
+		return qualisys.ComponentType6D, c.Droprate, c.OutOfSyncRate, true
+	case *packets.Component6DResidual:
+		return qualisys.ComponentType6DResidual, c.Droprate, c.OutOfSyncRate, true
+	case *packets.Component6DEuler:
+		return qualisys.ComponentType6DEuler, c.Droprate, c.OutOfSyncRate, true
+	case *packets.Component6DEulerResidual:
+		return qualisys.ComponentType6DEulerResidual, c.Droprate, c.OutOfSyncRate, true
+	case *packets.Component2D:
+		return qualisys.ComponentType2D, c.Droprate, c.OutOfSyncRate, true
+	case *packets.Component2DLinearized:
+		return qualisys.ComponentType2DLinearized, c.Droprate, c.OutOfSyncRate, true
+	}
+	return 0, 0, 0, false
+}
+
+// ReceiveFailed implements qualisys.Metrics.
+func (r *Registry) ReceiveFailed(err error) {
+	kind := "decode"
+	var netErr net.Error
+	switch {
+	case errors.Is(err, qualisys.ErrTruncated):
+		kind = "truncated"
+	case errors.Is(err, io.EOF), errors.Is(err, net.ErrClosed), errors.Is(err, os.ErrNoDeadline),
+		errors.Is(err, qualisys.ErrNotConnected), errors.As(err, &netErr):
+		kind = "connection"
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.receiveErrors[kind]++
+}
+
+// CommandCompleted implements qualisys.Metrics.
+func (r *Registry) CommandCompleted(command string, d time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h, ok := r.commands[command]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(Buckets)+1)}
+		r.commands[command] = h
+	}
+	s := d.Seconds()
+	i, _ := slices.BinarySearch(Buckets, s)
+	h.counts[i]++
+	h.sum += s
+	if err != nil {
+		h.errors++
+	}
+}
+
+// ServeHTTP writes the current values in the Prometheus text format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = r.WriteTo(w)
+}
+
+// WriteTo writes the current values in the Prometheus text format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	var b strings.Builder
+	r.write(&b)
+	r.mu.Unlock()
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func header(b *strings.Builder, name, typ, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func sortedKeys[K interface{ ~string }, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func (r *Registry) write(b *strings.Builder) {
+	header(b, "qtm_packets_received_total", "counter", "Packets received from QTM by packet type.")
+	types := make([]qualisys.PacketType, 0, len(r.packets))
+	for t := range r.packets {
+		types = append(types, t)
+	}
+	slices.Sort(types)
+	for _, t := range types {
+		fmt.Fprintf(b, "qtm_packets_received_total{type=%q} %d\n", t.String(), r.packets[t])
+	}
+
+	header(b, "qtm_bytes_received_total", "counter", "Bytes received from QTM, packet headers included.")
+	fmt.Fprintf(b, "qtm_bytes_received_total %d\n", r.bytes)
+
+	header(b, "qtm_receive_errors_total", "counter", "Failed packet reads by kind.")
+	for _, kind := range []string{"connection", "decode", "truncated"} {
+		fmt.Fprintf(b, "qtm_receive_errors_total{kind=%q} %d\n", kind, r.receiveErrors[kind])
+	}
+
+	header(b, "qtm_connects_total", "counter", "Successful connections to QTM.")
+	fmt.Fprintf(b, "qtm_connects_total %d\n", r.connects)
+	header(b, "qtm_reconnects_total", "counter", "Connections to QTM after the first.")
+	fmt.Fprintf(b, "qtm_reconnects_total %d\n", max(r.connects, 1)-1)
+
+	header(b, "qtm_command_duration_seconds", "histogram", "Time from sending a command to QTM's reply.")
+	for _, cmd := range sortedKeys(r.commands) {
+		h := r.commands[cmd]
+		var cumulative uint64
+		for i, le := range Buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(b, "qtm_command_duration_seconds_bucket{command=%q,le=\"%g\"} %d\n", cmd, le, cumulative)
+		}
+		cumulative += h.counts[len(Buckets)]
+		fmt.Fprintf(b, "qtm_command_duration_seconds_bucket{command=%q,le=\"+Inf\"} %d\n", cmd, cumulative)
+		fmt.Fprintf(b, "qtm_command_duration_seconds_sum{command=%q} %g\n", cmd, h.sum)
+		fmt.Fprintf(b, "qtm_command_duration_seconds_count{command=%q} %d\n", cmd, cumulative)
+	}
+	header(b, "qtm_command_errors_total", "counter", "Commands that failed or got an unexpected reply.")
+	for _, cmd := range sortedKeys(r.commands) {
+		fmt.Fprintf(b, "qtm_command_errors_total{command=%q} %d\n", cmd, r.commands[cmd].errors)
+	}
+
+	header(b, "qtm_frame_gaps_total", "counter", "Times the streamed frame number skipped ahead.")
+	fmt.Fprintf(b, "qtm_frame_gaps_total %d\n", r.frameGaps)
+	header(b, "qtm_frames_missed_total", "counter", "Frames skipped over by frame number gaps.")
+	fmt.Fprintf(b, "qtm_frames_missed_total %d\n", r.framesMissed)
+
+	header(b, "qtm_droprate_per_mille", "gauge", "Droprate QTM last reported, per component.")
+	for _, c := range sortedKeys(r.droprate) {
+		fmt.Fprintf(b, "qtm_droprate_per_mille{component=%q} %d\n", c, r.droprate[c])
+	}
+	header(b, "qtm_out_of_sync_rate_per_mille", "gauge", "OutOfSyncRate QTM last reported, per component.")
+	for _, c := range sortedKeys(r.outOfSyncRate) {
+		fmt.Fprintf(b, "qtm_out_of_sync_rate_per_mille{component=%q} %d\n", c, r.outOfSyncRate[c])
+	}
+}
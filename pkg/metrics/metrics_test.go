@@ -0,0 +1,88 @@
+package metrics_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/pkg/metrics"
+	"github.com/mlveggo/qualisys-go/pkg/packets"
+)
+
+func frame(n uint32, droprate uint16) *qualisys.Packet {
+	return &qualisys.Packet{Type: qualisys.PacketTypeData, Size: 100, Data: qualisys.DataPacket{
+		Frame:      n,
+		Components: []qualisys.IDataObject{&packets.Component3D{Droprate: droprate, OutOfSyncRate: 1}},
+	}}
+}
+
+func exposition(t *testing.T, r *metrics.Registry) string {
+	t.Helper()
+	var b strings.Builder
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestRegistry(t *testing.T) {
+	r := metrics.NewRegistry()
+	r.Connected()
+	r.Connected()
+	r.PacketReceived(&qualisys.Packet{Type: qualisys.PacketTypeCommand, Size: 40})
+	for _, n := range []uint32{10, 11, 14, 15, 3} { // a gap of two, then a restart
+		r.PacketReceived(frame(n, 7))
+	}
+	r.ReceiveFailed(fmt.Errorf("receive: %w: expected 20 bytes", qualisys.ErrTruncated))
+	r.ReceiveFailed(fmt.Errorf("receive: connection closed: %w", io.EOF))
+	r.ReceiveFailed(errors.New("receive: unmarshal: short component"))
+	r.ReceiveFailed(qualisys.ErrNotConnected)
+	r.ReceiveFailed(fmt.Errorf("receive: set deadline: %w", net.ErrClosed))
+	r.CommandCompleted("GetState", 3*time.Millisecond, nil)
+	r.CommandCompleted("GetState", 2*time.Second, errors.New("timeout"))
+
+	out := exposition(t, r)
+	for _, want := range []string{
+		`qtm_packets_received_total{type="Command"} 1`,
+		`qtm_packets_received_total{type="Data"} 5`,
+		`qtm_bytes_received_total 540`,
+		`qtm_receive_errors_total{kind="connection"} 3`,
+		`qtm_receive_errors_total{kind="decode"} 1`,
+		`qtm_receive_errors_total{kind="truncated"} 1`,
+		`qtm_connects_total 2`,
+		`qtm_reconnects_total 1`,
+		`qtm_command_duration_seconds_bucket{command="GetState",le="0.0025"} 0`,
+		`qtm_command_duration_seconds_bucket{command="GetState",le="0.005"} 1`,
+		`qtm_command_duration_seconds_bucket{command="GetState",le="2.5"} 2`,
+		`qtm_command_duration_seconds_bucket{command="GetState",le="+Inf"} 2`,
+		`qtm_command_duration_seconds_sum{command="GetState"} 2.003`,
+		`qtm_command_duration_seconds_count{command="GetState"} 2`,
+		`qtm_command_errors_total{command="GetState"} 1`,
+		`qtm_frame_gaps_total 1`,
+		`qtm_frames_missed_total 2`,
+		`qtm_droprate_per_mille{component="3D"} 7`,
+		`qtm_out_of_sync_rate_per_mille{component="3D"} 1`,
+		"# TYPE qtm_command_duration_seconds histogram",
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+}
+
+func TestFrameStep(t *testing.T) {
+	r := metrics.NewRegistry()
+	r.FrameStep = 4
+	for _, n := range []uint32{0, 4, 8, 16, 20} {
+		r.PacketReceived(frame(n, 0))
+	}
+	out := exposition(t, r)
+	if !strings.Contains(out, "qtm_frame_gaps_total 1\n") || !strings.Contains(out, "qtm_frames_missed_total 1\n") {
+		t.Errorf("with a frame step of 4:\n%s", out)
+	}
+}
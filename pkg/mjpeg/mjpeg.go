@@ -0,0 +1,235 @@
+// Package mjpeg serves QTM camera images to browsers as Motion JPEG.
+//
+// One process holds the RT connection streaming ComponentTypeImage and
+// publishes into a fanout.Hub. Each camera is then available as a
+// multipart/x-mixed-replace stream that an <img> element plays directly:
+//
+//	http://host:8080/camera/3?rate=10
+//
+// rate is a target frequency in Hz and defaults to every frame the camera
+// sends. Raw greyscale and BGR images are encoded to JPEG on the way out; JPEG
+// images pass through untouched. The root page lists
+// the cameras from the image settings, each with a live preview, and passes
+// its own rate parameter on to them.
+package mjpeg
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/pkg/fanout"
+	"github.com/mlveggo/qualisys-go/pkg/imaging"
+	"github.com/mlveggo/qualisys-go/pkg/settings"
+)
+
+// DefaultWriteTimeout bounds how long one image may take to reach a client.
+// A browser tab that stops reading is disconnected rather than kept around.
+const DefaultWriteTimeout = 5 * time.Second
+
+// Boundary separates the images of a stream.
+const Boundary = "qtmframe"
+
+// Handler serves the camera index and the per-camera streams from a hub. It is
+// safe for concurrent use.
+type Handler struct {
+	hub *fanout.Hub
+	mux *http.ServeMux
+
+	// Quality is the JPEG quality for re-encoded images, 1 to 100; zero
+	// means jpeg.DefaultQuality.
+	Quality int
+	// WriteTimeout overrides DefaultWriteTimeout when positive.
+	WriteTimeout time.Duration
+	// Buffer is the per-client queue length; see fanout.Hub.Subscribe.
+	Buffer int
+	// OnError, if set, is told about client streams that ended with an
+	// error. As elsewhere in this SDK, the library never logs by itself.
+	OnError func(remoteAddr string, err error)
+
+	mu      sync.RWMutex
+	cameras []settings.ImageCamera
+}
+
+// NewHandler serves images published on hub.
+func NewHandler(hub *fanout.Hub) *Handler {
+	h := &Handler{hub: hub, mux: http.NewServeMux()}
+	h.mux.HandleFunc("GET /{$}", h.serveIndex)
+	h.mux.HandleFunc("GET /camera/{id}", h.serveCamera)
+	return h
+}
+
+// SetCameras replaces the cameras listed on the index page, for example after
+// QTM reports EventTypeCameraSettingsChanged and the caller has re-read the
+// image settings. While the list is empty, any camera ID may be requested;
+// otherwise IDs not on it are answered with 404.
+func (h *Handler) SetCameras(cameras []settings.ImageCamera) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.cameras = cameras
+}
+
+func (h *Handler) currentCameras() []settings.ImageCamera {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.cameras
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>QTM cameras</title>
+<style>body{font-family:sans-serif;background:#222;color:#eee}figure{display:inline-block;margin:8px}img{max-width:480px;background:#000}</style>
+</head>
+<body>
+<h1>QTM cameras</h1>
+{{range .Cameras}}<figure>
+<img src="camera/{{.ID}}{{$.Query}}" alt="camera {{.ID}}">
+<figcaption>Camera {{.ID}}{{if .Format}}, {{.Format}}{{end}}{{if .Width}}, {{.Width}}&times;{{.Height}}{{end}}{{if not .Enabled}} (disabled){{end}}</figcaption>
+</figure>
+{{else}}<p>No cameras with image settings.</p>
+{{end}}</body>
+</html>
+`))
+
+func (h *Handler) serveIndex(w http.ResponseWriter, r *http.Request) {
+	var query string
+	if s := r.URL.Query().Get("rate"); s != "" {
+		query = "?" + url.Values{"rate": {s}}.Encode()
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_ = indexTemplate.Execute(w, struct {
+		Cameras []settings.ImageCamera
+		Query   string
+	}{h.currentCameras(), query})
+}
+
+func (h *Handler) known(id uint32) bool {
+	cameras := h.currentCameras()
+	if len(cameras) == 0 {
+		return true
+	}
+	for _, c := range cameras {
+		if c.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func parseRate(r *http.Request) (*fanout.Decimator, error) {
+	s := r.URL.Query().Get("rate")
+	if s == "" {
+		return fanout.NewDecimator(qualisys.StreamRateTypeAllFrames, 0)
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return nil, fmt.Errorf("rate: %w", err)
+	}
+	return fanout.NewDecimator(qualisys.StreamRateTypeFrequency, v)
+}
+
+func (h *Handler) serveCamera(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 32)
+	if err != nil {
+		http.Error(w, "invalid camera ID", http.StatusBadRequest)
+		return
+	}
+	if !h.known(uint32(id)) {
+		http.NotFound(w, r)
+		return
+	}
+	dec, err := parseRate(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sub := h.hub.Subscribe(h.Buffer)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+Boundary)
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+	if err := h.stream(r.Context(), w, rc, sub, uint32(id), dec); err != nil && h.OnError != nil {
+		h.OnError(r.RemoteAddr, err)
+	}
+}
+
+// stream writes camera id's images until the client leaves or the hub shuts
+// down.
+func (h *Handler) stream(
+	ctx context.Context,
+	w http.ResponseWriter,
+	rc *http.ResponseController,
+	sub *fanout.Subscription,
+	id uint32,
+	dec *fanout.Decimator,
+) error {
+	timeout := h.WriteTimeout
+	if timeout <= 0 {
+		timeout = DefaultWriteTimeout
+	}
+	for {
+		var p *qualisys.Packet
+		select {
+		case <-ctx.Done():
+			return nil
+		case pkt, ok := <-sub.C():
+			if !ok {
+				return nil
+			}
+			p = pkt
+		}
+		if p.Type != qualisys.PacketTypeData {
+			continue
+		}
+		images := p.Data.Images()
+		if images == nil {
+			continue
+		}
+		for _, img := range images.Images {
+			if img.ID != id {
+				continue
+			}
+			// Decimate only frames that carry this camera, so the rate
+			// holds even when QTM sends cameras in separate packets.
+			if !dec.Keep(&p.Data) {
+				break
+			}
+			data, err := imaging.JPEG(img, h.Quality)
+			if err != nil {
+				return err
+			}
+			// Not every ResponseWriter supports deadlines; without one the
+			// server's own WriteTimeout, if any, is the only bound.
+			_ = rc.SetWriteDeadline(time.Now().Add(timeout))
+			if _, err := fmt.Fprintf(w, "--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", Boundary, len(data)); err != nil {
+				return err
+			}
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+			if _, err := w.Write([]byte("\r\n")); err != nil {
+				return err
+			}
+			if err := rc.Flush(); err != nil {
+				return err
+			}
+			break
+		}
+	}
+}
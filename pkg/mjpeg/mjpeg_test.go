@@ -0,0 +1,130 @@
+package mjpeg_test
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/pkg/fanout"
+	"github.com/mlveggo/qualisys-go/pkg/mjpeg"
+	"github.com/mlveggo/qualisys-go/pkg/packets"
+	"github.com/mlveggo/qualisys-go/pkg/settings"
+)
+
+func imageFrame(n uint32) *qualisys.Packet {
+	grey := make([]byte, 8*4)
+	for i := range grey {
+		grey[i] = byte(8 * i)
+	}
+	return &qualisys.Packet{Type: qualisys.PacketTypeData, Data: qualisys.DataPacket{
+		Frame:     n,
+		Timestamp: uint64(n) * 10_000,
+		Components: []qualisys.IDataObject{&packets.ComponentImage{Images: []packets.Image{
+			{ID: 1, Format: packets.ImageFormatTypeJPG, Width: 1, Height: 1, RightCrop: 1, BottomCrop: 1, Data: []byte("not this one")},
+			{ID: 2, Format: packets.ImageFormatTypeRawGreyscale, Width: 8, Height: 4, RightCrop: 1, BottomCrop: 1, Data: grey},
+		}}},
+	}}
+}
+
+func TestStreamsCameraAsMultipartJPEG(t *testing.T) {
+	hub := fanout.NewHub()
+	defer hub.Close()
+	srv := httptest.NewServer(mjpeg.NewHandler(hub))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/camera/2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/x-mixed-replace" {
+		t.Fatalf("Content-Type = %q", resp.Header.Get("Content-Type"))
+	}
+
+	// The headers are flushed once the client is subscribed, so frames
+	// published from here on reach it.
+	go func() {
+		for n := uint32(1); ctx.Err() == nil; n++ {
+			hub.Publish(imageFrame(n))
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	for range 2 {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if part.Header.Get("Content-Type") != "image/jpeg" {
+			t.Errorf("part Content-Type = %q", part.Header.Get("Content-Type"))
+		}
+		b, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		im, err := jpeg.Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("part is not a JPEG: %v", err)
+		}
+		if im.Bounds() != image.Rect(0, 0, 8, 4) {
+			t.Errorf("bounds = %v", im.Bounds())
+		}
+	}
+}
+
+func TestIndexListsCameras(t *testing.T) {
+	h := mjpeg.NewHandler(fanout.NewHub())
+	h.SetCameras([]settings.ImageCamera{
+		{ID: 1, Enabled: true, Format: "RAWGrayscale", Width: 1280, Height: 1024},
+		{ID: 4},
+	})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/?rate=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	page := string(b)
+	for _, want := range []string{`src="camera/1?rate=5"`, `src="camera/4?rate=5"`, "1280&times;1024", "(disabled)"} {
+		if !strings.Contains(page, want) {
+			t.Errorf("index page lacks %q:\n%s", want, page)
+		}
+	}
+
+	for path, status := range map[string]int{
+		"/camera/3":         http.StatusNotFound,
+		"/camera/x":         http.StatusBadRequest,
+		"/camera/1?rate=0":  http.StatusBadRequest,
+		"/camera/1?rate=ab": http.StatusBadRequest,
+	} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("%s: status %d, want %d", path, resp.StatusCode, status)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package monitor
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// Escape sequences for taking over a terminal. Write ClearScreen and
+// HideCursor before the first Draw and ShowCursor on the way out.
+const (
+	ClearScreen = "\x1b[2J"
+	HideCursor  = "\x1b[?25l"
+	ShowCursor  = "\x1b[?25h"
+)
+
+const (
+	home       = "\x1b[H"
+	clearLine  = "\x1b[K"
+	clearBelow = "\x1b[J"
+	bold       = "\x1b[1m"
+	red        = "\x1b[31m"
+	green      = "\x1b[32m"
+	yellow     = "\x1b[33m"
+	reset      = "\x1b[0m"
+)
+
+// DefaultWidth is the terminal width Draw assumes when given none.
+const DefaultWidth = 80
+
+// staleAfter is how long without packets before the dashboard says so.
+const staleAfter = 2 * time.Second
+
+// Draw writes s to w as a full screen, from the top left corner, overwriting
+// the previous one in place. width is the terminal width, used to lay the
+// markers out in columns.
+func Draw(w io.Writer, s Snapshot, width int) error {
+	if width <= 0 {
+		width = DefaultWidth
+	}
+	var b bytes.Buffer
+	b.WriteString(home)
+	line := func(format string, args ...any) {
+		fmt.Fprintf(&b, format, args...)
+		b.WriteString(clearLine + "\n")
+	}
+
+	state := "unknown"
+	if s.State != 0 {
+		state = s.State.String()
+	}
+	status := fmt.Sprintf("frame %d  %.1f fps", s.Frame, s.FrameRate)
+	switch since := time.Since(s.Updated); {
+	case s.Updated.IsZero():
+		status = yellow + "waiting for data" + reset
+	case since > staleAfter:
+		status += yellow + fmt.Sprintf("  no data for %v", since.Round(time.Second)) + reset
+	}
+	event := ""
+	if s.LastEvent != 0 {
+		event = "  last event " + s.LastEvent.String()
+	}
+	line("%sQTM %s%s%s  %s", bold, state, reset, event, status)
+
+	if len(s.Rates) > 0 {
+		parts := make([]string, len(s.Rates))
+		for i, r := range s.Rates {
+			parts[i] = fmt.Sprintf("%v drop %s sync %s", r.Component, permille(r.Droprate), permille(r.OutOfSyncRate))
+		}
+		line("%s", strings.Join(parts, "   "))
+	}
+
+	if len(s.Bodies) > 0 {
+		line("")
+		line("%s%-16s %9s %9s %9s %7s %7s %7s %7s %8s%s", bold, "Body", "X", "Y", "Z", "qx", "qy", "qz", "qw", "residual", reset)
+		for _, body := range s.Bodies {
+			if !body.Tracked {
+				line("%-16s %snot tracked%s", clip(body.Name, 16), red, reset)
+				continue
+			}
+			p, q := body.Position, body.Rotation
+			line("%-16s %9.1f %9.1f %9.1f %7.3f %7.3f %7.3f %7.3f %8s",
+				clip(body.Name, 16), p.X, p.Y, p.Z, q.X, q.Y, q.Z, q.W, number(body.Residual, "%.2f"))
+		}
+	}
+
+	if s.Labelled {
+		visible := 0
+		for _, m := range s.Markers {
+			if m.Visible {
+				visible++
+			}
+		}
+		line("")
+		line("%sMarkers%s %d/%d visible", bold, reset, visible, len(s.Markers))
+		// Each cell is "● label 100%" padded to the widest label.
+		labelWidth := 0
+		for _, m := range s.Markers {
+			labelWidth = max(labelWidth, len(m.Label))
+		}
+		labelWidth = min(labelWidth, 20)
+		cellWidth := labelWidth + 8
+		perLine := max(width/cellWidth, 1)
+		var row strings.Builder
+		for i, m := range s.Markers {
+			mark, color := "○", red
+			if m.Visible {
+				mark, color = "●", green
+			}
+			fmt.Fprintf(&row, "%s%s%s %-*s %3.0f%% ", color, mark, reset, labelWidth, clip(m.Label, labelWidth), m.Visibility*100)
+			if (i+1)%perLine == 0 || i == len(s.Markers)-1 {
+				line("%s", strings.TrimRight(row.String(), " "))
+				row.Reset()
+			}
+		}
+	}
+	if s.Unlabelled > 0 {
+		line("Unlabelled markers: %d", s.Unlabelled)
+	}
+
+	if len(s.Analog) > 0 {
+		line("")
+		line("%sAnalog%s", bold, reset)
+		for _, d := range s.Analog {
+			for i, c := range d.Channels {
+				line("  device %-3d ch %-3d %10.4f  [%10.4f %10.4f] %s", d.ID, i+1, c.Last, c.Min, c.Max, bar(c, 20))
+			}
+		}
+	}
+
+	if len(s.Plates) > 0 {
+		line("")
+		line("%s%-8s %9s %9s %9s %9s %9s %9s%s", bold, "Plate", "Fx", "Fy", "Fz", "|F|", "CoP x", "CoP y", reset)
+		for _, p := range s.Plates {
+			line("%-8d %9.1f %9.1f %9.1f %9.1f %9.1f %9.1f",
+				p.ID, p.Force.X, p.Force.Y, p.Force.Z, p.Magnitude(), p.CenterOfPressure.X, p.CenterOfPressure.Y)
+		}
+	}
+
+	b.WriteString(clearBelow)
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// permille formats a rate reported in frames per thousand as a percentage.
+func permille(v uint16) string {
+	s := fmt.Sprintf("%.1f%%", float64(v)/10)
+	if v > 0 {
+		return yellow + s + reset
+	}
+	return s
+}
+
+func number(v float32, format string) string {
+	if math.IsNaN(float64(v)) {
+		return "-"
+	}
+	return fmt.Sprintf(format, v)
+}
+
+func clip(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-1]) + "…"
+	}
+	return s
+}
+
+// bar draws a channel's last value as a level against the largest magnitude
+// in its range.
+func bar(c Level, width int) string {
+	peak := math.Max(math.Abs(float64(c.Min)), math.Abs(float64(c.Max)))
+	n := 0
+	if peak > 0 {
+		n = int(math.Round(math.Abs(float64(c.Last)) / peak * float64(width)))
+	}
+	n = min(max(n, 0), width)
+	return strings.Repeat("█", n) + strings.Repeat("·", width-n)
+}
@@ -0,0 +1,442 @@
+// Package monitor keeps a running summary of a QTM stream and draws it as a
+// terminal dashboard that updates in place, using nothing but ANSI escapes.
+//
+// Feed a Monitor every packet and redraw from a Snapshot at whatever rate suits
+// the terminal:
+//
+//	m := monitor.New()
+//	m.SetNames(labels, bodies)
+//	...
+//	m.Update(p, time.Now())
+//	...
+//	monitor.Draw(os.Stdout, m.Snapshot(), 100)
+//
+// The dashboard shows the QTM state, the frame rate and the drop rates QTM
+// reports, each 6DOF body's pose and residual, which labelled markers are
+// visible, analog channel levels and force plate totals.
+package monitor
+
+import (
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/pkg/packets"
+)
+
+// DefaultWindow is the period frame rates, marker visibility and analog
+// ranges are measured over when Monitor.Window is zero.
+const DefaultWindow = time.Second
+
+// Rates are the drop and out-of-sync rates QTM last reported for a component,
+// in frames per thousand.
+type Rates struct {
+	Component     qualisys.ComponentType
+	Droprate      uint16
+	OutOfSyncRate uint16
+}
+
+// Body is the latest pose of a 6DOF body.
+type Body struct {
+	Name string
+	// Tracked is false when QTM could not find the body in the last frame;
+	// Position and Rotation are NaN then.
+	Tracked  bool
+	Position packets.Point
+	Rotation packets.Rotation
+	// Residual is NaN unless a residual component is streamed.
+	Residual float32
+}
+
+// Marker is the visibility of one labelled marker.
+type Marker struct {
+	Label   string
+	Visible bool
+	// Visibility is the fraction of frames in the last window in which the
+	// marker was visible.
+	Visibility float64
+}
+
+// Level is the state of one analog channel.
+type Level struct {
+	Last float32
+	// Min and Max span the samples of roughly the last two windows, so the
+	// range does not collapse each time a window starts.
+	Min, Max float32
+}
+
+// AnalogDevice holds the channel levels of one analog board.
+type AnalogDevice struct {
+	ID       uint32
+	Channels []Level
+}
+
+// Plate is the latest sample of a force plate, in the plate's coordinates.
+type Plate struct {
+	ID               uint32
+	Force            packets.Point
+	CenterOfPressure packets.Point
+}
+
+// Magnitude is the length of the force vector, in newtons.
+func (p Plate) Magnitude() float64 {
+	x, y, z := float64(p.Force.X), float64(p.Force.Y), float64(p.Force.Z)
+	return math.Sqrt(x*x + y*y + z*z)
+}
+
+// Snapshot is a copy of a Monitor's state, safe to keep and draw while the
+// Monitor goes on updating.
+type Snapshot struct {
+	// State is the last state QTM reported, or zero before the first.
+	State qualisys.EventType
+	// LastEvent is the last event of any kind, including those, such as
+	// EventTypeTrigger, that do not change the state.
+	LastEvent qualisys.EventType
+	Frame     uint32
+	// FrameRate is the frames per second received over the last window.
+	FrameRate float64
+	Rates     []Rates
+	// Labelled is false when the stream carries no labelled 3D markers, as
+	// opposed to every label being hidden.
+	Labelled bool
+	Markers  []Marker
+	// Unlabelled is the number of markers in the last 3DNoLabels component.
+	Unlabelled int
+	Bodies     []Body
+	Analog     []AnalogDevice
+	Plates     []Plate
+	// Updated is when the last packet arrived.
+	Updated time.Time
+}
+
+type channel struct {
+	last              float32
+	min, max          float32
+	prevMin, prevMax  float32
+	haveCur, havePrev bool
+}
+
+// Monitor accumulates a stream's state. It is safe for concurrent use, so
+// one goroutine can feed it while another draws. The zero value is not usable;
+// call New.
+type Monitor struct {
+	// Window is the measuring period; DefaultWindow when zero.
+	Window time.Duration
+
+	mu           sync.Mutex
+	labels       []string
+	bodyNames    []string
+	state        qualisys.EventType
+	lastEvent    qualisys.EventType
+	frame        uint32
+	rates        map[qualisys.ComponentType]Rates
+	windowStart  time.Time
+	frames       int
+	frameRate    float64
+	labelled     bool
+	markers      []Marker
+	seen         []int
+	markerFrames int
+	unlabelled   int
+	bodies       []Body
+	analog       map[uint32][]channel
+	plates       map[uint32]Plate
+	updated      time.Time
+}
+
+// New returns an empty Monitor.
+func New() *Monitor {
+	return &Monitor{
+		rates:  make(map[qualisys.ComponentType]Rates),
+		analog: make(map[uint32][]channel),
+		plates: make(map[uint32]Plate),
+	}
+}
+
+// SetNames sets the marker labels and body names from the 3D and 6D settings,
+// in the order QTM streams them. Call it again after
+// EventTypeCameraSettingsChanged once the settings have been re-read.
+func (m *Monitor) SetNames(labels, bodies []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.labels = slices.Clone(labels)
+	m.bodyNames = slices.Clone(bodies)
+	m.markers, m.seen, m.markerFrames = nil, nil, 0
+	m.bodies = nil
+}
+
+// SetState records a state read with Protocol.GetState, so the dashboard has
+// one before QTM sends its first event.
+func (m *Monitor) SetState(state qualisys.EventType) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state = state
+}
+
+// isState reports whether an event describes the state QTM is in rather than
+// something that happened in passing.
+func isState(e qualisys.EventType) bool {
+	switch e {
+	case qualisys.EventTypeTrigger, qualisys.EventTypeCameraSettingsChanged,
+		qualisys.EventTypeCaptureSaved, qualisys.EventTypeCaptureFetchingFinished,
+		qualisys.EventTypeNone:
+		return false
+	}
+	return true
+}
+
+// Update adds a packet received at now. Packets other than events and data
+// frames are ignored.
+func (m *Monitor) Update(p *qualisys.Packet, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch p.Type {
+	case qualisys.PacketTypeEvent:
+		m.lastEvent = p.Event
+		if isState(p.Event) {
+			m.state = p.Event
+		}
+	case qualisys.PacketTypeData:
+		m.frame = p.Data.Frame
+		m.frames++
+		for _, c := range p.Data.Components {
+			m.component(c)
+		}
+	default:
+		return
+	}
+	m.updated = now
+	m.roll(now)
+}
+
+// roll closes the measuring window once it has run its length.
+func (m *Monitor) roll(now time.Time) {
+	window := m.Window
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	if m.windowStart.IsZero() {
+		// The first frame opens the window; counting it as well would
+		// overstate the rate by one frame per window.
+		m.windowStart, m.frames = now, 0
+		return
+	}
+	elapsed := now.Sub(m.windowStart)
+	if elapsed < window {
+		return
+	}
+	m.frameRate = float64(m.frames) / elapsed.Seconds()
+	m.frames = 0
+	for i := range m.markers {
+		if m.markerFrames > 0 {
+			m.markers[i].Visibility = float64(m.seen[i]) / float64(m.markerFrames)
+		}
+		m.seen[i] = 0
+	}
+	m.markerFrames = 0
+	for _, channels := range m.analog {
+		for i := range channels {
+			c := &channels[i]
+			c.prevMin, c.prevMax, c.havePrev = c.min, c.max, c.haveCur
+			c.haveCur = false
+		}
+	}
+	m.windowStart = now
+}
+
+func visible(p packets.Point) bool {
+	return !math.IsNaN(float64(p.X))
+}
+
+func (m *Monitor) component(c qualisys.IDataObject) {
+	switch c := c.(type) {
+	case *packets.Component3D:
+		m.setRates(qualisys.ComponentType3D, c.Droprate, c.OutOfSyncRate)
+		m.labelledMarkers(c.Markers)
+	case *packets.Component3DResidual:
+		m.setRates(qualisys.ComponentType3DResidual, c.Droprate, c.OutOfSyncRate)
+		m.labelledMarkers(c.Markers)
+	case *packets.Component3DNoLabels:
+		m.setRates(qualisys.ComponentType3DNoLabels, c.Droprate, c.OutOfSyncRate)
+		m.unlabelled = len(c.Markers)
+	case *packets.Component3DNoLabelsResidual:
+		m.setRates(qualisys.ComponentType3DNoLabelsResidual, c.Droprate, c.OutOfSyncRate)
+		m.unlabelled = len(c.Markers)
+	case *packets.Component6D:
+		m.setRates(qualisys.ComponentType6D, c.Droprate, c.OutOfSyncRate)
+		m.matrixBodies(c.Bodies, false)
+	case *packets.Component6DResidual:
+		m.setRates(qualisys.ComponentType6DResidual, c.Droprate, c.OutOfSyncRate)
+		m.matrixBodies(c.Bodies, true)
+	case *packets.Component6DEuler:
+		m.setRates(qualisys.ComponentType6DEuler, c.Droprate, c.OutOfSyncRate)
+		m.eulerBodies(c.Bodies, false)
+	case *packets.Component6DEulerResidual:
+		m.setRates(qualisys.ComponentType6DEulerResidual, c.Droprate, c.OutOfSyncRate)
+		m.eulerBodies(c.Bodies, true)
+	case *packets.Component2D:
+		m.setRates(qualisys.ComponentType2D, c.Droprate, c.OutOfSyncRate)
+	case *packets.Component2DLinearized:
+		m.setRates(qualisys.ComponentType2DLinearized, c.Droprate, c.OutOfSyncRate)
+	case *packets.ComponentAnalog:
+		m.analogDevices(c.AnalogDevices)
+	case *packets.ComponentAnalogSingle:
+		m.analogDevices(c.AnalogDevices)
+	case *packets.ComponentForce:
+		m.forcePlates(c.ForcePlates)
+	case *packets.ComponentForceSingle:
+		m.forcePlates(c.ForcePlates)
+	}
+}
+
+func (m *Monitor) setRates(t qualisys.ComponentType, drop, outOfSync uint16) {
+	m.rates[t] = Rates{Component: t, Droprate: drop, OutOfSyncRate: outOfSync}
+}
+
+func (m *Monitor) labelledMarkers(markers []packets.Marker) {
+	m.labelled = true
+	if len(m.markers) != len(markers) {
+		// Labels changed under us or were never set; number the markers
+		// rather than attach the wrong names.
+		m.markers = make([]Marker, len(markers))
+		m.seen = make([]int, len(markers))
+		m.markerFrames = 0
+		for i := range m.markers {
+			if len(m.labels) == len(markers) {
+				m.markers[i].Label = m.labels[i]
+			} else {
+				m.markers[i].Label = "#" + strconv.Itoa(i+1)
+			}
+		}
+	}
+	m.markerFrames++
+	for i, mk := range markers {
+		m.markers[i].Visible = visible(mk.Point)
+		if m.markers[i].Visible {
+			m.seen[i]++
+		}
+	}
+}
+
+func (m *Monitor) body(i int, name string) *Body {
+	if i < len(m.bodyNames) {
+		name = m.bodyNames[i]
+	}
+	m.bodies[i].Name = name
+	return &m.bodies[i]
+}
+
+func (m *Monitor) resizeBodies(n int) {
+	if len(m.bodies) != n {
+		m.bodies = make([]Body, n)
+	}
+}
+
+func (m *Monitor) matrixBodies(bodies []packets.BodyMatrix, residual bool) {
+	m.resizeBodies(len(bodies))
+	for i, b := range bodies {
+		out := m.body(i, "#"+strconv.Itoa(i+1))
+		out.Tracked = visible(b.Point)
+		out.Position = b.Point
+		out.Rotation = b.Quaternion()
+		out.Residual = float32(math.NaN())
+		if residual {
+			out.Residual = b.Residual
+		}
+	}
+}
+
+func (m *Monitor) eulerBodies(bodies []packets.BodyEuler, residual bool) {
+	m.resizeBodies(len(bodies))
+	for i, b := range bodies {
+		out := m.body(i, "#"+strconv.Itoa(i+1))
+		out.Tracked = visible(b.Point)
+		out.Position = b.Point
+		out.Rotation = b.Quaternion()
+		out.Residual = float32(math.NaN())
+		if residual {
+			out.Residual = b.Residual
+		}
+	}
+}
+
+func (m *Monitor) analogDevices(devices []packets.AnalogDevice) {
+	for _, d := range devices {
+		channels := m.analog[d.ID]
+		if len(channels) != len(d.Channels) {
+			channels = make([]channel, len(d.Channels))
+			m.analog[d.ID] = channels
+		}
+		for i, ch := range d.Channels {
+			c := &channels[i]
+			for _, s := range ch.Samples {
+				v := s.Value
+				if math.IsNaN(float64(v)) {
+					continue
+				}
+				if !c.haveCur {
+					c.min, c.max, c.haveCur = v, v, true
+				}
+				c.min, c.max, c.last = min(c.min, v), max(c.max, v), v
+			}
+		}
+	}
+}
+
+func (m *Monitor) forcePlates(plates []packets.ForcePlate) {
+	for _, p := range plates {
+		if len(p.Samples) == 0 {
+			continue
+		}
+		s := p.Samples[len(p.Samples)-1]
+		m.plates[p.ID] = Plate{ID: p.ID, Force: s.Force, CenterOfPressure: s.CenterOfPressure}
+	}
+}
+
+// Snapshot returns a copy of the current state.
+func (m *Monitor) Snapshot() Snapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := Snapshot{
+		State:      m.state,
+		LastEvent:  m.lastEvent,
+		Frame:      m.frame,
+		FrameRate:  m.frameRate,
+		Labelled:   m.labelled,
+		Markers:    slices.Clone(m.markers),
+		Unlabelled: m.unlabelled,
+		Bodies:     slices.Clone(m.bodies),
+		Updated:    m.updated,
+	}
+	for _, t := range slices.Sorted(maps.Keys(m.rates)) {
+		s.Rates = append(s.Rates, m.rates[t])
+	}
+	// Before the first window closes, show what the current one has seen.
+	if m.frameRate == 0 && m.markerFrames > 0 {
+		for i := range s.Markers {
+			s.Markers[i].Visibility = float64(m.seen[i]) / float64(m.markerFrames)
+		}
+	}
+	for _, id := range slices.Sorted(maps.Keys(m.analog)) {
+		d := AnalogDevice{ID: id}
+		for _, c := range m.analog[id] {
+			l := Level{Last: c.last, Min: c.min, Max: c.max}
+			switch {
+			case c.havePrev && c.haveCur:
+				l.Min, l.Max = min(c.min, c.prevMin), max(c.max, c.prevMax)
+			case c.havePrev:
+				l.Min, l.Max = c.prevMin, c.prevMax
+			}
+			d.Channels = append(d.Channels, l)
+		}
+		s.Analog = append(s.Analog, d)
+	}
+	for _, id := range slices.Sorted(maps.Keys(m.plates)) {
+		s.Plates = append(s.Plates, m.plates[id])
+	}
+	return s
+}
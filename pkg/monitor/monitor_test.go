@@ -0,0 +1,146 @@
+package monitor_test
+
+import (
+	"math"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/pkg/monitor"
+	"github.com/mlveggo/qualisys-go/pkg/packets"
+)
+
+var nan = float32(math.NaN())
+
+func frame(n uint32, components ...qualisys.IDataObject) *qualisys.Packet {
+	return &qualisys.Packet{Type: qualisys.PacketTypeData, Data: qualisys.DataPacket{Frame: n, Components: components}}
+}
+
+func event(e qualisys.EventType) *qualisys.Packet {
+	return &qualisys.Packet{Type: qualisys.PacketTypeEvent, Event: e}
+}
+
+func markers(headVisible bool) *packets.Component3D {
+	head := packets.Point{X: 1, Y: 2, Z: 1700}
+	if !headVisible {
+		head = packets.Point{X: nan, Y: nan, Z: nan}
+	}
+	return &packets.Component3D{Droprate: 5, Markers: []packets.Marker{
+		{Point: head},
+		{Point: packets.Point{X: 10, Y: 20, Z: 1000}},
+	}}
+}
+
+func TestMonitorTracksRatesAndVisibility(t *testing.T) {
+	m := monitor.New()
+	m.SetNames([]string{"Head", "Hip"}, nil)
+	m.SetState(qualisys.EventTypeConnected)
+	start := time.Unix(1000, 0)
+
+	// 101 frames 10 ms apart close one window of a second; Head is visible
+	// in every other frame after the first.
+	for i := range 101 {
+		m.Update(frame(uint32(i), markers(i%2 == 0)), start.Add(time.Duration(i)*10*time.Millisecond))
+	}
+	m.Update(event(qualisys.EventTypeCaptureStarted), start.Add(1010*time.Millisecond))
+	m.Update(event(qualisys.EventTypeTrigger), start.Add(1020*time.Millisecond))
+
+	s := m.Snapshot()
+	if s.State != qualisys.EventTypeCaptureStarted || s.LastEvent != qualisys.EventTypeTrigger {
+		t.Errorf("state %v, last event %v", s.State, s.LastEvent)
+	}
+	if s.Frame != 100 {
+		t.Errorf("frame %d", s.Frame)
+	}
+	if math.Abs(s.FrameRate-100) > 0.01 {
+		t.Errorf("frame rate %v, want 100", s.FrameRate)
+	}
+	if len(s.Rates) != 1 || s.Rates[0].Component != qualisys.ComponentType3D || s.Rates[0].Droprate != 5 {
+		t.Errorf("rates %+v", s.Rates)
+	}
+	if len(s.Markers) != 2 {
+		t.Fatalf("markers %+v", s.Markers)
+	}
+	head, hip := s.Markers[0], s.Markers[1]
+	if head.Label != "Head" || !head.Visible || math.Abs(head.Visibility-0.5) > 0.02 {
+		t.Errorf("head %+v", head)
+	}
+	if hip.Label != "Hip" || !hip.Visible || hip.Visibility != 1 {
+		t.Errorf("hip %+v", hip)
+	}
+}
+
+func TestMonitorBodiesAnalogAndForce(t *testing.T) {
+	m := monitor.New()
+	m.SetNames(nil, []string{"wand"})
+	now := time.Unix(1000, 0)
+	m.Update(frame(1,
+		&packets.Component6DResidual{Bodies: []packets.BodyMatrix{
+			{Point: packets.Point{X: 1, Y: 2, Z: 3}, Residual: 0.4, Rotation: [9]float32{1, 0, 0, 0, 1, 0, 0, 0, 1}},
+			{Point: packets.Point{X: nan, Y: nan, Z: nan}, Residual: nan},
+		}},
+		&packets.ComponentAnalog{AnalogDevices: []packets.AnalogDevice{
+			{ID: 2, Channels: []packets.AnalogChannel{{Samples: []packets.AnalogSample{{Value: -1}, {Value: 3}, {Value: 0.5}}}}},
+		}},
+		&packets.ComponentForce{ForcePlates: []packets.ForcePlate{
+			{ID: 1, Samples: []packets.ForceSample{{}, {Force: packets.Point{X: 3, Y: 4, Z: 0}}}},
+		}},
+	), now)
+
+	s := m.Snapshot()
+	if len(s.Bodies) != 2 {
+		t.Fatalf("bodies %+v", s.Bodies)
+	}
+	wand, other := s.Bodies[0], s.Bodies[1]
+	if wand.Name != "wand" || !wand.Tracked || wand.Residual != 0.4 || wand.Rotation.W != 1 {
+		t.Errorf("wand %+v", wand)
+	}
+	if other.Name != "#2" || other.Tracked {
+		t.Errorf("second body %+v", other)
+	}
+	if len(s.Analog) != 1 || s.Analog[0].ID != 2 {
+		t.Fatalf("analog %+v", s.Analog)
+	}
+	if l := s.Analog[0].Channels[0]; l.Last != 0.5 || l.Min != -1 || l.Max != 3 {
+		t.Errorf("level %+v", l)
+	}
+	if len(s.Plates) != 1 || s.Plates[0].Magnitude() != 5 {
+		t.Errorf("plates %+v", s.Plates)
+	}
+}
+
+var escape = regexp.MustCompile("\x1b\\[[0-9;?]*[A-Za-z]")
+
+func TestDraw(t *testing.T) {
+	m := monitor.New()
+	m.SetNames([]string{"Head", "Hip"}, []string{"wand"})
+	m.Update(event(qualisys.EventTypeCaptureStarted), time.Now())
+	m.Update(frame(7, markers(false), &packets.Component6DEuler{Bodies: []packets.BodyEuler{
+		{Point: packets.Point{X: nan, Y: nan, Z: nan}},
+	}}), time.Now())
+
+	var b strings.Builder
+	if err := monitor.Draw(&b, m.Snapshot(), 80); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	if !strings.HasPrefix(out, "\x1b[H") || !strings.HasSuffix(out, "\x1b[J") {
+		t.Errorf("screen not drawn in place: %q", out)
+	}
+	plain := escape.ReplaceAllString(out, "")
+	for _, want := range []string{
+		"QTM CaptureStarted",
+		"frame 7",
+		"3D drop 0.5%",
+		"wand             not tracked",
+		"Markers 1/2 visible",
+		"○ Head",
+		"● Hip",
+	} {
+		if !strings.Contains(plain, want) {
+			t.Errorf("missing %q in\n%s", want, plain)
+		}
+	}
+}
@@ -0,0 +1,397 @@
+package osc
+
+import (
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/pkg/packets"
+	"github.com/mlveggo/qualisys-go/pkg/settings"
+)
+
+// DefaultMaxPacketSize keeps every datagram within one Ethernet frame. A frame
+// with more messages than fit is split into several bundles carrying the same
+// time tag, rather than relying on IP fragmentation, which some receivers and
+// many Wi-Fi links handle badly.
+const DefaultMaxPacketSize = 1472
+
+// Addresses holds the address template for each kind of data. An empty
+// template turns that kind off.
+//
+// Placeholders are replaced with names from the QTM settings, sanitized with
+// SanitizeName, or with a number when no name is known:
+//
+//   - Marker: {name} is the 3D label, or the marker ID for the NoLabels
+//     components; {index} is the position in the frame.
+//   - Body: {name} is the rigid body name; {index} is the position in the frame.
+//   - Segment: {skeleton} is the skeleton name and {segment} the segment name,
+//     falling back to the skeleton index and segment ID.
+//   - Analog: {device} is the device name, falling back to the device ID, and
+//     {channel} the channel label, falling back to the 1-based channel number.
+type Addresses struct {
+	Marker  string
+	Body    string
+	Segment string
+	Analog  string
+}
+
+// DefaultAddresses is what NewBridge starts with.
+var DefaultAddresses = Addresses{
+	Marker:  "/qtm/3d/{name}",
+	Body:    "/qtm/6d/{name}",
+	Segment: "/qtm/skeleton/{skeleton}/{segment}",
+	Analog:  "/qtm/analog/{device}/{channel}",
+}
+
+// Names carries the settings that give addresses their names. Use NamesFromXML
+// to fill it from a GetParameters reply.
+type Names struct {
+	Labels    []string
+	Bodies    []string
+	Skeletons []settings.Skeleton
+	Analog    []settings.AnalogDevice
+}
+
+// NamesFromXML reads the 3D, 6D, Skeleton and Analog sections of a settings
+// reply. Sections missing from xml leave the matching names empty.
+func NamesFromXML(xml string) (Names, error) {
+	var n Names
+	var err error
+	if n.Labels, err = settings.Parse3DLabelsFromXML(xml); err != nil {
+		return n, err
+	}
+	if n.Bodies, err = settings.Parse6DBodyNamesFromXML(xml); err != nil {
+		return n, err
+	}
+	if n.Skeletons, err = settings.ParseSkeletonsFromXML(xml); err != nil {
+		return n, err
+	}
+	if n.Analog, err = settings.ParseAnalogDevicesFromXML(xml); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// skeletonNames is a skeleton's settings reshaped for lookups by segment ID,
+// which is what the stream carries.
+type skeletonNames struct {
+	name     string
+	segments map[uint32]string
+}
+
+// Bridge turns data packets into OSC bundles and writes them to w, one bundle
+// per Write, so w is typically a connected UDP socket.
+//
+// Every message of a frame shares one time tag. By default the bridge anchors
+// the first frame it sees to the current wall-clock time and derives later tags
+// from the QTM timestamp, so tags advance exactly with the capture clock rather
+// than with network jitter. Set Epoch to pin the anchor instead.
+//
+// Arguments are float32 throughout:
+//
+//   - markers: x y z
+//   - 6D bodies: x y z and the 9 rotation matrix elements
+//   - 6D Euler bodies: x y z and the 3 angles
+//   - skeleton segments: x y z and the rotation quaternion x y z w
+//   - analog channels: every sample the frame carries for that channel
+//
+// Positions are in millimetres as QTM sends them. Occluded markers and
+// untracked bodies or segments, which QTM reports as NaN, are left out unless
+// SendOccluded is set.
+type Bridge struct {
+	w io.Writer
+
+	Addresses Addresses
+	// MaxPacketSize bounds the size of each bundle. A single message larger
+	// than this is still sent, alone in its own bundle.
+	MaxPacketSize int
+	// Epoch, when non-zero, is the wall-clock time of QTM timestamp zero.
+	Epoch time.Time
+	// Immediate sends every bundle with the Immediately time tag, for
+	// receivers that would otherwise delay bundles tagged in the future.
+	Immediate    bool
+	SendOccluded bool
+
+	mu        sync.Mutex
+	names     Names
+	skeletons []skeletonNames
+	analog    map[uint32]settings.AnalogDevice
+	anchor    time.Time
+	lastTime  uint64
+	anchored  bool
+}
+
+// NewBridge returns a bridge writing to w with DefaultAddresses.
+func NewBridge(w io.Writer) *Bridge {
+	return &Bridge{w: w, Addresses: DefaultAddresses, MaxPacketSize: DefaultMaxPacketSize}
+}
+
+// SetNames replaces the names used in addresses. It is safe to call while
+// another goroutine is sending.
+func (b *Bridge) SetNames(n Names) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.names = n
+	b.skeletons = make([]skeletonNames, len(n.Skeletons))
+	for i, sk := range n.Skeletons {
+		sn := skeletonNames{name: sk.Name, segments: make(map[uint32]string)}
+		for _, seg := range sk.AllSegments() {
+			sn.segments[seg.ID] = seg.Name
+		}
+		b.skeletons[i] = sn
+	}
+	b.analog = make(map[uint32]settings.AnalogDevice, len(n.Analog))
+	for _, dev := range n.Analog {
+		b.analog[dev.ID] = dev
+	}
+}
+
+// TimeTag returns the time tag for a frame with the given QTM timestamp, in
+// microseconds.
+func (b *Bridge) TimeTag(timestamp uint64) TimeTag {
+	if b.Immediate {
+		return Immediately
+	}
+	if !b.Epoch.IsZero() {
+		return NewTimeTag(b.Epoch.Add(time.Duration(timestamp) * time.Microsecond))
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// A timestamp going backwards means QTM started a new measurement or
+	// restarted; re-anchor so tags do not jump into the past.
+	if !b.anchored || timestamp < b.lastTime {
+		b.anchor = time.Now().Add(-time.Duration(timestamp) * time.Microsecond)
+		b.anchored = true
+	}
+	b.lastTime = timestamp
+	return NewTimeTag(b.anchor.Add(time.Duration(timestamp) * time.Microsecond))
+}
+
+// Messages maps a frame to OSC messages without sending them.
+func (b *Bridge) Messages(d *qualisys.DataPacket) []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []Message
+	for _, obj := range d.Components {
+		switch c := obj.(type) {
+		case *packets.Component3D:
+			out = b.markers(out, c.Markers, false)
+		case *packets.Component3DResidual:
+			out = b.markers(out, c.Markers, false)
+		case *packets.Component3DNoLabels:
+			out = b.markers(out, c.Markers, true)
+		case *packets.Component3DNoLabelsResidual:
+			out = b.markers(out, c.Markers, true)
+		case *packets.Component6D:
+			out = b.bodies(out, c.Bodies)
+		case *packets.Component6DResidual:
+			out = b.bodies(out, c.Bodies)
+		case *packets.Component6DEuler:
+			out = b.eulerBodies(out, c.Bodies)
+		case *packets.Component6DEulerResidual:
+			out = b.eulerBodies(out, c.Bodies)
+		case *packets.ComponentSkeleton:
+			out = b.segments(out, c.Skeletons)
+		case *packets.ComponentAnalog:
+			out = b.analogChannels(out, c.AnalogDevices)
+		case *packets.ComponentAnalogSingle:
+			out = b.analogChannels(out, c.AnalogDevices)
+		}
+	}
+	return out
+}
+
+// Send writes one frame as one or more bundles.
+func (b *Bridge) Send(d *qualisys.DataPacket) error {
+	msgs := b.Messages(d)
+	if len(msgs) == 0 {
+		return nil
+	}
+	tag := b.TimeTag(d.Timestamp)
+	limit := b.MaxPacketSize
+	if limit <= 0 {
+		limit = DefaultMaxPacketSize
+	}
+
+	// bundleHeader is "#bundle\0" plus the time tag.
+	const bundleHeader = 16
+	var bundle Bundle
+	size := bundleHeader
+	flush := func() error {
+		if len(bundle.Elements) == 0 {
+			return nil
+		}
+		p, err := bundle.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		bundle.Elements = bundle.Elements[:0]
+		size = bundleHeader
+		_, err = b.w.Write(p)
+		return err
+	}
+	bundle.Time = tag
+	for _, m := range msgs {
+		p, err := m.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		if size+4+len(p) > limit {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		bundle.Elements = append(bundle.Elements, encoded(p))
+		size += 4 + len(p)
+	}
+	return flush()
+}
+
+// encoded is an already-encoded message, so Send can measure each message
+// once and place it in a bundle without encoding it again.
+type encoded []byte
+
+func (e encoded) appendOSC(b []byte) ([]byte, error) { return append(b, e...), nil }
+
+func expand(template string, pairs ...string) string {
+	for i := range pairs {
+		if i%2 == 1 {
+			pairs[i] = SanitizeName(pairs[i])
+		}
+	}
+	return strings.NewReplacer(pairs...).Replace(template)
+}
+
+func occluded(p packets.Point) bool {
+	return math.IsNaN(float64(p.X)) || math.IsNaN(float64(p.Y)) || math.IsNaN(float64(p.Z))
+}
+
+func (b *Bridge) markers(out []Message, markers []packets.Marker, withID bool) []Message {
+	if b.Addresses.Marker == "" {
+		return out
+	}
+	for i, m := range markers {
+		if !b.SendOccluded && occluded(m.Point) {
+			continue
+		}
+		name := strconv.Itoa(i)
+		if withID {
+			name = strconv.FormatUint(uint64(m.ID), 10)
+		} else if i < len(b.names.Labels) {
+			name = b.names.Labels[i]
+		}
+		out = append(out, Message{
+			Address: expand(b.Addresses.Marker, "{name}", name, "{index}", strconv.Itoa(i)),
+			Args:    []any{m.Point.X, m.Point.Y, m.Point.Z},
+		})
+	}
+	return out
+}
+
+func (b *Bridge) bodyAddress(i int) string {
+	name := strconv.Itoa(i)
+	if i < len(b.names.Bodies) {
+		name = b.names.Bodies[i]
+	}
+	return expand(b.Addresses.Body, "{name}", name, "{index}", strconv.Itoa(i))
+}
+
+func (b *Bridge) bodies(out []Message, bodies []packets.BodyMatrix) []Message {
+	if b.Addresses.Body == "" {
+		return out
+	}
+	for i, body := range bodies {
+		if !b.SendOccluded && occluded(body.Point) {
+			continue
+		}
+		args := make([]any, 0, 12)
+		args = append(args, body.Point.X, body.Point.Y, body.Point.Z)
+		for _, r := range body.Rotation {
+			args = append(args, r)
+		}
+		out = append(out, Message{Address: b.bodyAddress(i), Args: args})
+	}
+	return out
+}
+
+func (b *Bridge) eulerBodies(out []Message, bodies []packets.BodyEuler) []Message {
+	if b.Addresses.Body == "" {
+		return out
+	}
+	for i, body := range bodies {
+		if !b.SendOccluded && occluded(body.Point) {
+			continue
+		}
+		out = append(out, Message{Address: b.bodyAddress(i), Args: []any{
+			body.Point.X, body.Point.Y, body.Point.Z,
+			body.Angles[0], body.Angles[1], body.Angles[2],
+		}})
+	}
+	return out
+}
+
+func (b *Bridge) segments(out []Message, skeletons []packets.Skeleton) []Message {
+	if b.Addresses.Segment == "" {
+		return out
+	}
+	for i, sk := range skeletons {
+		skName := strconv.Itoa(i)
+		var segNames map[uint32]string
+		if i < len(b.skeletons) {
+			skName = b.skeletons[i].name
+			segNames = b.skeletons[i].segments
+		}
+		for _, seg := range sk.Segments {
+			if !b.SendOccluded && occluded(seg.Position) {
+				continue
+			}
+			segName, ok := segNames[seg.ID]
+			if !ok {
+				segName = strconv.FormatUint(uint64(seg.ID), 10)
+			}
+			out = append(out, Message{
+				Address: expand(b.Addresses.Segment, "{skeleton}", skName, "{segment}", segName),
+				Args: []any{
+					seg.Position.X, seg.Position.Y, seg.Position.Z,
+					seg.Rotation.X, seg.Rotation.Y, seg.Rotation.Z, seg.Rotation.W,
+				},
+			})
+		}
+	}
+	return out
+}
+
+func (b *Bridge) analogChannels(out []Message, devices []packets.AnalogDevice) []Message {
+	if b.Addresses.Analog == "" {
+		return out
+	}
+	for _, dev := range devices {
+		named, ok := b.analog[dev.ID]
+		devName := strconv.FormatUint(uint64(dev.ID), 10)
+		if ok && named.Name != "" {
+			devName = named.Name
+		}
+		for ch, channel := range dev.Channels {
+			if len(channel.Samples) == 0 {
+				continue
+			}
+			chName := strconv.Itoa(ch + 1)
+			if ok && ch < len(named.Channels) && named.Channels[ch].Label != "" {
+				chName = named.Channels[ch].Label
+			}
+			args := make([]any, len(channel.Samples))
+			for s, sample := range channel.Samples {
+				args[s] = sample.Value
+			}
+			out = append(out, Message{
+				Address: expand(b.Addresses.Analog, "{device}", devName, "{channel}", chName),
+				Args:    args,
+			})
+		}
+	}
+	return out
+}
@@ -0,0 +1,186 @@
+// Package osc sends QTM data as Open Sound Control messages.
+//
+// Art and performance labs drive Max/MSP, TouchDesigner, Ableton and friends
+// with OSC rather than with the RT protocol, so the Bridge in this package
+// turns each DataPacket into a bundle of OSC messages with one address per
+// marker, body, skeleton segment or analog channel. The encoder is a plain
+// OSC 1.0 implementation on top of the standard library.
+package osc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// ErrInvalidAddress is returned when a message address does not start with a
+// slash, or contains characters OSC reserves for pattern matching.
+var ErrInvalidAddress = errors.New("osc: invalid address")
+
+// Element is anything that can be placed in a bundle: a Message or a Bundle.
+type Element interface {
+	appendOSC(b []byte) ([]byte, error)
+}
+
+// Message is one OSC message.
+//
+// Args may hold int32, int64, float32, float64, string, []byte or bool values;
+// they are encoded with the type tags i, h, f, d, s, b, and T or F. Plain int is
+// accepted too and sent as int32, since that is what every receiver supports.
+type Message struct {
+	Address string
+	Args    []any
+}
+
+// MarshalBinary encodes the message as an OSC packet.
+func (m Message) MarshalBinary() ([]byte, error) {
+	return m.appendOSC(nil)
+}
+
+func (m Message) appendOSC(b []byte) ([]byte, error) {
+	if err := checkAddress(m.Address); err != nil {
+		return nil, err
+	}
+	tags := make([]byte, 1, len(m.Args)+1)
+	tags[0] = ','
+	for _, a := range m.Args {
+		switch v := a.(type) {
+		case int, int32:
+			tags = append(tags, 'i')
+		case int64:
+			tags = append(tags, 'h')
+		case float32:
+			tags = append(tags, 'f')
+		case float64:
+			tags = append(tags, 'd')
+		case string:
+			tags = append(tags, 's')
+		case []byte:
+			tags = append(tags, 'b')
+		case bool:
+			if v {
+				tags = append(tags, 'T')
+			} else {
+				tags = append(tags, 'F')
+			}
+		default:
+			return nil, fmt.Errorf("osc: %s: unsupported argument type %T", m.Address, a)
+		}
+	}
+
+	b = appendString(b, m.Address)
+	b = appendString(b, string(tags))
+	for _, a := range m.Args {
+		switch v := a.(type) {
+		case int:
+			b = binary.BigEndian.AppendUint32(b, uint32(int32(v)))
+		case int32:
+			b = binary.BigEndian.AppendUint32(b, uint32(v))
+		case int64:
+			b = binary.BigEndian.AppendUint64(b, uint64(v))
+		case float32:
+			b = binary.BigEndian.AppendUint32(b, math.Float32bits(v))
+		case float64:
+			b = binary.BigEndian.AppendUint64(b, math.Float64bits(v))
+		case string:
+			b = appendString(b, v)
+		case []byte:
+			b = binary.BigEndian.AppendUint32(b, uint32(len(v)))
+			b = append(b, v...)
+			b = pad(b)
+		}
+	}
+	return b, nil
+}
+
+// checkAddress rejects addresses a receiver would read as a pattern, or not
+// read at all. Names coming from QTM settings should go through SanitizeName
+// before being placed in an address.
+func checkAddress(addr string) error {
+	if !strings.HasPrefix(addr, "/") || strings.ContainsAny(addr, " #*,?[]{}\x00") {
+		return fmt.Errorf("%w: %q", ErrInvalidAddress, addr)
+	}
+	return nil
+}
+
+// SanitizeName makes s usable as one address part by replacing every character
+// OSC gives a meaning to, including the separator '/', with an underscore.
+func SanitizeName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' || strings.ContainsRune("#*,/?[]{}", r) {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// appendString writes an OSC-string: the bytes, a terminating NUL, and NUL
+// padding up to a multiple of four.
+func appendString(b []byte, s string) []byte {
+	b = append(b, s...)
+	b = append(b, 0)
+	return pad(b)
+}
+
+func pad(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// TimeTag is an OSC time tag: a 64-bit NTP timestamp, seconds since 1900 in
+// the high word and a binary fraction of a second in the low word.
+type TimeTag uint64
+
+// Immediately is the reserved time tag asking the receiver to act on a bundle
+// as soon as it arrives.
+const Immediately TimeTag = 1
+
+// ntpEpochOffset is the number of seconds between 1900-01-01 and 1970-01-01.
+const ntpEpochOffset = 2208988800
+
+// NewTimeTag converts a wall-clock time to a time tag.
+func NewTimeTag(t time.Time) TimeTag {
+	secs := uint64(t.Unix() + ntpEpochOffset)
+	frac := (uint64(t.Nanosecond()) << 32) / uint64(time.Second)
+	return TimeTag(secs<<32 | frac)
+}
+
+// Time converts the tag back to wall-clock time. Immediately has no meaningful
+// time and converts to the NTP epoch.
+func (t TimeTag) Time() time.Time {
+	secs := int64(t>>32) - ntpEpochOffset
+	nsec := (int64(t&0xFFFFFFFF) * int64(time.Second)) >> 32
+	return time.Unix(secs, nsec)
+}
+
+// Bundle groups elements that a receiver should apply atomically, at Time.
+type Bundle struct {
+	Time     TimeTag
+	Elements []Element
+}
+
+// MarshalBinary encodes the bundle as an OSC packet.
+func (bd Bundle) MarshalBinary() ([]byte, error) {
+	return bd.appendOSC(nil)
+}
+
+func (bd Bundle) appendOSC(b []byte) ([]byte, error) {
+	b = appendString(b, "#bundle")
+	b = binary.BigEndian.AppendUint64(b, uint64(bd.Time))
+	for _, e := range bd.Elements {
+		// Reserve the size prefix, encode in place, then fill it in.
+		at := len(b)
+		b = append(b, 0, 0, 0, 0)
+		var err error
+		if b, err = e.appendOSC(b); err != nil {
+			return nil, err
+		}
+		binary.BigEndian.PutUint32(b[at:], uint32(len(b)-at-4))
+	}
+	return b, nil
+}
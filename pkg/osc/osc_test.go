@@ -0,0 +1,172 @@
+package osc_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/pkg/osc"
+	"github.com/mlveggo/qualisys-go/pkg/packets"
+)
+
+func TestMessageEncoding(t *testing.T) {
+	// The example message from the OSC 1.0 specification.
+	b, err := osc.Message{Address: "/oscillator/4/frequency", Args: []any{float32(440)}}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte("/oscillator/4/frequency\x00,f\x00\x00\x43\xdc\x00\x00")
+	if !bytes.Equal(b, want) {
+		t.Errorf("got  %q\nwant %q", b, want)
+	}
+
+	b, err = osc.Message{Address: "/x", Args: []any{int32(-1), "ab", []byte{1}, true}}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []byte("/x\x00\x00,isbT\x00\x00\x00\xff\xff\xff\xffab\x00\x00\x00\x00\x00\x01\x01\x00\x00\x00")
+	if !bytes.Equal(b, want) {
+		t.Errorf("got  %q\nwant %q", b, want)
+	}
+}
+
+func TestMessageRejectsBadInput(t *testing.T) {
+	if _, err := (osc.Message{Address: "/a b"}).MarshalBinary(); !errors.Is(err, osc.ErrInvalidAddress) {
+		t.Errorf("space in address: err = %v", err)
+	}
+	if _, err := (osc.Message{Address: "no-slash"}).MarshalBinary(); !errors.Is(err, osc.ErrInvalidAddress) {
+		t.Errorf("missing slash: err = %v", err)
+	}
+	if _, err := (osc.Message{Address: "/a", Args: []any{uint8(1)}}).MarshalBinary(); err == nil {
+		t.Error("unsupported argument type was accepted")
+	}
+	if got := osc.SanitizeName("Left Foot/#1"); got != "Left_Foot__1" {
+		t.Errorf("SanitizeName = %q", got)
+	}
+}
+
+func TestBundleAndTimeTag(t *testing.T) {
+	at := time.Date(2024, 3, 1, 12, 0, 0, 500_000_000, time.UTC)
+	tag := osc.NewTimeTag(at)
+	if uint32(tag) != 1<<31 {
+		t.Errorf("fraction = %#x, want half a second", uint32(tag))
+	}
+	if got := tag.Time(); got.Sub(at).Abs() > time.Microsecond {
+		t.Errorf("round trip = %v, want %v", got, at)
+	}
+
+	b, err := osc.Bundle{Time: osc.Immediately, Elements: []osc.Element{
+		osc.Message{Address: "/a"},
+	}}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte("#bundle\x00\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x08/a\x00\x00,\x00\x00\x00")
+	if !bytes.Equal(b, want) {
+		t.Errorf("got  %q\nwant %q", b, want)
+	}
+}
+
+// datagrams records each Write as one packet, like a UDP socket.
+type datagrams [][]byte
+
+func (d *datagrams) Write(p []byte) (int, error) {
+	*d = append(*d, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+// addresses extracts the message addresses from a bundle, relying on the
+// bridge only ever putting messages, not nested bundles, in one.
+func addresses(t *testing.T, bundle []byte) []string {
+	t.Helper()
+	if !bytes.HasPrefix(bundle, []byte("#bundle\x00")) {
+		t.Fatalf("not a bundle: %q", bundle)
+	}
+	var out []string
+	for rest := bundle[16:]; len(rest) > 0; {
+		n := binary.BigEndian.Uint32(rest)
+		msg := rest[4 : 4+n]
+		out = append(out, string(msg[:bytes.IndexByte(msg, 0)]))
+		rest = rest[4+n:]
+	}
+	return out
+}
+
+const settingsXML = `<QTM_Parameters_Ver_1.25>
+<The_3D><Label><Name>head</Name></Label><Label><Name>left toe</Name></Label></The_3D>
+<The_6D><Body><Name>wand</Name></Body></The_6D>
+<Skeletons><Skeleton Name="Alice"><Segments>
+  <Segment Name="Hips" ID="1"><Segment Name="Spine" ID="2" Parent_ID="1"/></Segment>
+</Segments></Skeleton></Skeletons>
+<Analog><Device><Device_ID>3</Device_ID><Device_Name>EMG</Device_Name>
+  <Channel><Label>biceps</Label></Channel></Device></Analog>
+</QTM_Parameters_Ver_1.25>`
+
+func TestBridgeSendsNamedBundles(t *testing.T) {
+	names, err := osc.NamesFromXML(settingsXML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out datagrams
+	b := osc.NewBridge(&out)
+	b.SetNames(names)
+	b.Epoch = time.Unix(1_700_000_000, 0)
+
+	nan := float32(math.NaN())
+	frame := qualisys.DataPacket{Timestamp: 2_000_000, Components: []qualisys.IDataObject{
+		&packets.Component3D{Markers: []packets.Marker{
+			{Point: packets.Point{X: nan, Y: nan, Z: nan}},
+			{Point: packets.Point{X: 1, Y: 2, Z: 3}},
+		}},
+		&packets.Component6D{Bodies: []packets.BodyMatrix{{}}},
+		&packets.ComponentSkeleton{Skeletons: []packets.Skeleton{{Segments: []packets.Segment{{ID: 2}, {ID: 9}}}}},
+		&packets.ComponentAnalog{AnalogDevices: []packets.AnalogDevice{{ID: 3, Channels: []packets.AnalogChannel{
+			{Samples: []packets.AnalogSample{{Value: 1}, {Value: 2}}},
+			{Samples: []packets.AnalogSample{{Value: 3}}},
+		}}}},
+	}}
+	if err := b.Send(&frame); err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 {
+		t.Fatalf("sent %d datagrams, want 1", len(out))
+	}
+	got := addresses(t, out[0])
+	want := []string{
+		"/qtm/3d/left_toe", "/qtm/6d/wand",
+		"/qtm/skeleton/Alice/Spine", "/qtm/skeleton/Alice/9",
+		"/qtm/analog/EMG/biceps", "/qtm/analog/EMG/2",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("addresses = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("address %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+	tag := osc.TimeTag(binary.BigEndian.Uint64(out[0][8:]))
+	if !tag.Time().Equal(b.Epoch.Add(2 * time.Second)) {
+		t.Errorf("time tag = %v, want epoch + 2s", tag.Time())
+	}
+
+	// A small packet limit splits the frame, and every piece carries the
+	// same time tag.
+	out = nil
+	b.MaxPacketSize = 64
+	if err := b.Send(&frame); err != nil {
+		t.Fatal(err)
+	}
+	if len(out) < 2 {
+		t.Fatalf("sent %d datagrams, want the frame split", len(out))
+	}
+	for _, p := range out {
+		if !bytes.Equal(p[8:16], out[0][8:16]) {
+			t.Error("split bundles carry different time tags")
+		}
+	}
+}
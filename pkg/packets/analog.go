@@ -1,6 +1,9 @@
 package packets
 
-import "fmt"
+import (
+	"fmt"
+	"math"
+)
 
 type AnalogSample struct {
 	Value float32
@@ -110,3 +113,50 @@ func (c *ComponentAnalogSingle) UnmarshalBinary(data []byte) error {
 	}
 	return cur.Err()
 }
+
+// MarshalBinary encodes the multi-sample analog component. Every channel of a
+// device must carry the same number of samples, since the wire format has a
+// single sample count per device.
+func (c ComponentAnalog) MarshalBinary() ([]byte, error) {
+	w := &writer{}
+	w.count(len(c.AnalogDevices))
+	for _, dev := range c.AnalogDevices {
+		sampleCount := 0
+		if len(dev.Channels) > 0 {
+			sampleCount = len(dev.Channels[0].Samples)
+		}
+		w.Uint32(dev.ID)
+		w.count(len(dev.Channels))
+		w.count(sampleCount)
+		w.Uint32(dev.SampleNumber)
+		for ch, channel := range dev.Channels {
+			if len(channel.Samples) != sampleCount {
+				return nil, fmt.Errorf("analog device %d: channel %d has %d samples, channel 0 has %d",
+					dev.ID, ch, len(channel.Samples), sampleCount)
+			}
+			for _, s := range channel.Samples {
+				w.Float32(s.Value)
+			}
+		}
+	}
+	return w.b, nil
+}
+
+// MarshalBinary encodes the single-sample analog component, taking the first
+// sample of each channel. A channel with no samples is written as NaN.
+func (c ComponentAnalogSingle) MarshalBinary() ([]byte, error) {
+	w := &writer{}
+	w.count(len(c.AnalogDevices))
+	for _, dev := range c.AnalogDevices {
+		w.Uint32(dev.ID)
+		w.count(len(dev.Channels))
+		for _, channel := range dev.Channels {
+			if len(channel.Samples) == 0 {
+				w.Float32(float32(math.NaN()))
+				continue
+			}
+			w.Float32(channel.Samples[0].Value)
+		}
+	}
+	return w.b, nil
+}
@@ -62,3 +62,20 @@ func (c *ComponentEyeTracker) UnmarshalBinary(data []byte) error {
 	}
 	return cur.Err()
 }
+
+func (c ComponentEyeTracker) MarshalBinary() ([]byte, error) {
+	w := &writer{}
+	w.count(len(c.EyeTrackers))
+	for _, et := range c.EyeTrackers {
+		w.count(len(et.Samples))
+		if len(et.Samples) == 0 {
+			continue
+		}
+		w.Uint32(et.SampleNumber)
+		for _, s := range et.Samples {
+			w.Float32(s.LeftPupilDiameter)
+			w.Float32(s.RightPupilDiameter)
+		}
+	}
+	return w.b, nil
+}
@@ -108,3 +108,37 @@ func (c *ComponentForceSingle) UnmarshalBinary(data []byte) error {
 	}
 	return cur.Err()
 }
+
+func (c ComponentForce) MarshalBinary() ([]byte, error) {
+	w := &writer{}
+	w.count(len(c.ForcePlates))
+	for _, fp := range c.ForcePlates {
+		w.Uint32(fp.ID)
+		w.count(len(fp.Samples))
+		w.Uint32(fp.Number)
+		for _, s := range fp.Samples {
+			w.Point(s.Force)
+			w.Point(s.Moment)
+			w.Point(s.CenterOfPressure)
+		}
+	}
+	return w.b, nil
+}
+
+// MarshalBinary encodes the single-sample force component from the first
+// sample of each plate. A plate with no samples is written as all zeros.
+func (c ComponentForceSingle) MarshalBinary() ([]byte, error) {
+	w := &writer{}
+	w.count(len(c.ForcePlates))
+	for _, fp := range c.ForcePlates {
+		var s ForceSample
+		if len(fp.Samples) > 0 {
+			s = fp.Samples[0]
+		}
+		w.Uint32(fp.ID)
+		w.Point(s.Force)
+		w.Point(s.Moment)
+		w.Point(s.CenterOfPressure)
+	}
+	return w.b, nil
+}
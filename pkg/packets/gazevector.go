@@ -68,3 +68,26 @@ func (c *ComponentGazeVector) UnmarshalBinary(data []byte) error {
 	}
 	return cur.Err()
 }
+
+// MarshalBinary encodes gaze vectors, omitting the sample number for devices
+// with no samples exactly as the decoder expects.
+func (c ComponentGazeVector) MarshalBinary() ([]byte, error) {
+	w := &writer{}
+	w.count(len(c.GazeVectors))
+	for _, gv := range c.GazeVectors {
+		w.count(len(gv.Samples))
+		if len(gv.Samples) == 0 {
+			continue
+		}
+		w.Uint32(gv.SampleNumber)
+		for _, s := range gv.Samples {
+			w.Float32(s.X)
+			w.Float32(s.Y)
+			w.Float32(s.Z)
+			w.Float32(s.PositionX)
+			w.Float32(s.PositionY)
+			w.Float32(s.PositionZ)
+		}
+	}
+	return w.b, nil
+}
@@ -82,3 +82,23 @@ func (c *ComponentImage) UnmarshalBinary(data []byte) error {
 	}
 	return cur.Err()
 }
+
+// MarshalBinary encodes camera images. Size is taken from len(Data) rather than
+// the Size field, so an image built by hand cannot claim bytes it lacks.
+func (c ComponentImage) MarshalBinary() ([]byte, error) {
+	w := &writer{}
+	w.count(len(c.Images))
+	for _, img := range c.Images {
+		w.Uint32(img.ID)
+		w.Uint32(uint32(img.Format))
+		w.Uint32(img.Width)
+		w.Uint32(img.Height)
+		w.Float32(img.LeftCrop)
+		w.Float32(img.TopCrop)
+		w.Float32(img.RightCrop)
+		w.Float32(img.BottomCrop)
+		w.count(len(img.Data))
+		w.Bytes(img.Data)
+	}
+	return w.b, nil
+}
@@ -85,3 +85,30 @@ func (c Component2DLinearized) String() string { return Component2D(c).String()
 func (c *Component2DLinearized) UnmarshalBinary(data []byte) error {
 	return unmarshal2D((*Component2D)(c), data)
 }
+
+// marshal2D is the inverse of unmarshal2D.
+func marshal2D(c Component2D) []byte {
+	w := &writer{}
+	w.count(len(c.Cameras))
+	w.Uint16(c.Droprate)
+	w.Uint16(c.OutOfSyncRate)
+	for _, cam := range c.Cameras {
+		w.count(len(cam.Markers))
+		w.Uint8(cam.Status)
+		for _, m := range cam.Markers {
+			w.Uint32(m.X)
+			w.Uint32(m.Y)
+			w.Uint16(m.DiameterX)
+			w.Uint16(m.DiameterY)
+		}
+	}
+	return w.b
+}
+
+func (c Component2D) MarshalBinary() ([]byte, error) {
+	return marshal2D(c), nil
+}
+
+func (c Component2DLinearized) MarshalBinary() ([]byte, error) {
+	return marshal2D(Component2D(c)), nil
+}
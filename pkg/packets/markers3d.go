@@ -91,3 +91,37 @@ func (c Component3DNoLabelsResidual) String() string { return Component3D(c).Str
 func (c *Component3DNoLabelsResidual) UnmarshalBinary(data []byte) error {
 	return unmarshal3D((*Component3D)(c), data, true, true)
 }
+
+// marshal3D is the inverse of unmarshal3D and shares its stride rules.
+func marshal3D(c Component3D, withID, withResidual bool) []byte {
+	w := &writer{}
+	w.count(len(c.Markers))
+	w.Uint16(c.Droprate)
+	w.Uint16(c.OutOfSyncRate)
+	for _, m := range c.Markers {
+		w.Point(m.Point)
+		if withID {
+			w.Uint32(m.ID)
+		}
+		if withResidual {
+			w.Float32(m.Residual)
+		}
+	}
+	return w.b
+}
+
+func (c Component3D) MarshalBinary() ([]byte, error) {
+	return marshal3D(c, false, false), nil
+}
+
+func (c Component3DResidual) MarshalBinary() ([]byte, error) {
+	return marshal3D(Component3D(c), false, true), nil
+}
+
+func (c Component3DNoLabels) MarshalBinary() ([]byte, error) {
+	return marshal3D(Component3D(c), true, false), nil
+}
+
+func (c Component3DNoLabelsResidual) MarshalBinary() ([]byte, error) {
+	return marshal3D(Component3D(c), true, true), nil
+}
@@ -142,3 +142,55 @@ func (c Component6DEulerResidual) String() string { return Component6DEuler(c).S
 func (c *Component6DEulerResidual) UnmarshalBinary(data []byte) error {
 	return unmarshal6DEuler((*Component6DEuler)(c), data, true)
 }
+
+// marshal6D is the inverse of unmarshal6D.
+func marshal6D(c Component6D, withResidual bool) []byte {
+	w := &writer{}
+	w.count(len(c.Bodies))
+	w.Uint16(c.Droprate)
+	w.Uint16(c.OutOfSyncRate)
+	for _, body := range c.Bodies {
+		w.Point(body.Point)
+		for _, r := range body.Rotation {
+			w.Float32(r)
+		}
+		if withResidual {
+			w.Float32(body.Residual)
+		}
+	}
+	return w.b
+}
+
+func (c Component6D) MarshalBinary() ([]byte, error) {
+	return marshal6D(c, false), nil
+}
+
+func (c Component6DResidual) MarshalBinary() ([]byte, error) {
+	return marshal6D(Component6D(c), true), nil
+}
+
+// marshal6DEuler is the inverse of unmarshal6DEuler.
+func marshal6DEuler(c Component6DEuler, withResidual bool) []byte {
+	w := &writer{}
+	w.count(len(c.Bodies))
+	w.Uint16(c.Droprate)
+	w.Uint16(c.OutOfSyncRate)
+	for _, body := range c.Bodies {
+		w.Point(body.Point)
+		for _, a := range body.Angles {
+			w.Float32(a)
+		}
+		if withResidual {
+			w.Float32(body.Residual)
+		}
+	}
+	return w.b
+}
+
+func (c Component6DEuler) MarshalBinary() ([]byte, error) {
+	return marshal6DEuler(c, false), nil
+}
+
+func (c Component6DEulerResidual) MarshalBinary() ([]byte, error) {
+	return marshal6DEuler(Component6DEuler(c), true), nil
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"math"
+	"reflect"
 	"testing"
 )
 
@@ -369,6 +370,74 @@ func TestTruncatedPayloadsDoNotPanic(t *testing.T) {
 	}
 }
 
+// TestMarshalBinaryRoundTrips checks every encoder against its decoder: a
+// component decoded from its own encoding must come back unchanged.
+func TestMarshalBinaryRoundTrips(t *testing.T) {
+	type codec interface {
+		MarshalBinary() ([]byte, error)
+		UnmarshalBinary([]byte) error
+	}
+	cases := []struct {
+		name string
+		in   codec
+		out  codec
+	}{
+		{"3DNoLabelsRes", &Component3DNoLabelsResidual{Droprate: 1, OutOfSyncRate: 2, Markers: []Marker{
+			{Point: Point{1, 2, 3}, ID: 4, Residual: 5},
+		}}, &Component3DNoLabelsResidual{}},
+		{"6DRes", &Component6DResidual{Bodies: []BodyMatrix{
+			{Point: Point{1, 2, 3}, Residual: 0.5, Rotation: [9]float32{1, 0, 0, 0, 1, 0, 0, 0, 1}},
+		}}, &Component6DResidual{}},
+		{"6DEuler", &Component6DEuler{Bodies: []BodyEuler{{Point: Point{4, 5, 6}, Angles: [3]float32{7, 8, 9}}}},
+			&Component6DEuler{}},
+		{"2D", &Component2D{Cameras: []Camera{{Status: 1, Markers: []Marker2D{{X: 1, Y: 2, DiameterX: 3, DiameterY: 4}}}, {Markers: []Marker2D{}}}},
+			&Component2D{}},
+		{"Analog", &ComponentAnalog{AnalogDevices: []AnalogDevice{{ID: 1, SampleNumber: 9, Channels: []AnalogChannel{
+			{Samples: []AnalogSample{{1}, {2}}}, {Samples: []AnalogSample{{3}, {4}}},
+		}}}}, &ComponentAnalog{}},
+		{"Force", &ComponentForce{ForcePlates: []ForcePlate{{ID: 2, Number: 3, Samples: []ForceSample{
+			{Force: Point{1, 2, 3}, Moment: Point{4, 5, 6}, CenterOfPressure: Point{7, 8, 9}},
+		}}}}, &ComponentForce{}},
+		{"Image", &ComponentImage{Images: []Image{{ID: 1, Format: ImageFormatTypePNG, Width: 2, Height: 3, RightCrop: 1,
+			BottomCrop: 1, Size: 2, Data: []byte{9, 8}}}}, &ComponentImage{}},
+		{"GazeVector", &ComponentGazeVector{GazeVectors: []GazeVector{{}, {SampleNumber: 4, Samples: []GazeVectorSample{{X: 1}}}}},
+			&ComponentGazeVector{}},
+		{"EyeTracker", &ComponentEyeTracker{EyeTrackers: []EyeTracker{{}, {SampleNumber: 4, Samples: []EyeTrackerSample{{1, 2}}}}},
+			&ComponentEyeTracker{}},
+		{"Timecode", &ComponentTimecode{Timecodes: []Timecode{
+			{Type: TimecodeTypeSMPTE, Smpte: SmpteTime{Hour: 1, Minute: 2, Second: 3, Frame: 4, SubFrame: 5}},
+			{Type: TimecodeTypeIRIG, Irig: IrigTime{Year: 24, Day: 300, Hour: 5, Minute: 6, Second: 7, Tenth: 8}},
+			{Type: TimecodeTypeCameraTime, CameraTime: 1<<40 + 12345},
+		}}, &ComponentTimecode{}},
+		{"Skeleton", &ComponentSkeleton{Skeletons: []Skeleton{{Segments: []Segment{
+			{ID: 1, Position: Point{1, 2, 3}, Rotation: Rotation{0, 0, 0, 1}},
+		}}}}, &ComponentSkeleton{}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := tc.in.MarshalBinary()
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			if err := tc.out.UnmarshalBinary(b); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if !reflect.DeepEqual(tc.in, tc.out) {
+				t.Errorf("round trip changed the component:\n got %+v\nwant %+v", tc.out, tc.in)
+			}
+		})
+	}
+}
+
+func TestComponentAnalogMarshalRejectsRaggedChannels(t *testing.T) {
+	c := ComponentAnalog{AnalogDevices: []AnalogDevice{{Channels: []AnalogChannel{
+		{Samples: []AnalogSample{{1}, {2}}}, {Samples: []AnalogSample{{3}}},
+	}}}}
+	if _, err := c.MarshalBinary(); err == nil {
+		t.Error("expected an error when channels carry different sample counts")
+	}
+}
+
 func TestCursorReportsShortReads(t *testing.T) {
 	c := newCursor([]byte{1, 2})
 	c.Uint32()
@@ -403,3 +472,40 @@ func TestCameraStringFieldOrder(t *testing.T) {
 		t.Errorf("got %q, want %q", got, want)
 	}
 }
+
+func TestQuaternionsAgreeBetweenMatrixAndEuler(t *testing.T) {
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	for _, angles := range [][3]float32{{0, 0, 0}, {90, 0, 0}, {30, -45, 120}, {179, 10, -170}} {
+		// Rx * Ry * Rz, written out row-major.
+		a, b, c := rad(float64(angles[0])), rad(float64(angles[1])), rad(float64(angles[2]))
+		sa, ca := math.Sincos(a)
+		sb, cb := math.Sincos(b)
+		sc, cc := math.Sincos(c)
+		m := [9]float64{
+			cb * cc, -cb * sc, sb,
+			ca*sc + sa*sb*cc, ca*cc - sa*sb*sc, -sa * cb,
+			sa*sc - ca*sb*cc, sa*cc + ca*sb*sc, ca * cb,
+		}
+		var body BodyMatrix
+		for i, v := range m {
+			body.Rotation[i] = float32(v)
+		}
+		got := body.Quaternion()
+		want := BodyEuler{Angles: angles}.Quaternion()
+		// q and -q are the same rotation.
+		dot := got.X*want.X + got.Y*want.Y + got.Z*want.Z + got.W*want.W
+		if math.Abs(math.Abs(float64(dot))-1) > 1e-5 {
+			t.Errorf("angles %v: matrix gives %+v, Euler gives %+v", angles, got, want)
+		}
+		for i, v := range want.Matrix() {
+			if math.Abs(float64(v-body.Rotation[i])) > 1e-5 {
+				t.Errorf("angles %v: Matrix() = %v, want %v", angles, want.Matrix(), body.Rotation)
+				break
+			}
+		}
+	}
+	nan := float32(math.NaN())
+	if q := (BodyMatrix{Rotation: [9]float32{nan, nan, nan, nan, nan, nan, nan, nan, nan}}).Quaternion(); !math.IsNaN(float64(q.W)) {
+		t.Errorf("untracked body gave %+v, want NaN", q)
+	}
+}
@@ -0,0 +1,83 @@
+package packets
+
+import "math"
+
+// Quaternion converts the body's rotation matrix to a unit quaternion in the
+// X, Y, Z, W layout skeleton segments already use.
+//
+// The matrix is read row-major, as documented on Component6D. An untracked
+// body, whose matrix QTM fills with NaN, yields a NaN quaternion.
+func (b BodyMatrix) Quaternion() Rotation {
+	var m [3][3]float64
+	for i, v := range b.Rotation {
+		m[i/3][i%3] = float64(v)
+	}
+	// Shepperd's method: divide by the largest of the four candidate terms
+	// so the result stays accurate for rotations near 180 degrees.
+	var x, y, z, w float64
+	switch trace := m[0][0] + m[1][1] + m[2][2]; {
+	case trace > 0:
+		s := math.Sqrt(trace+1) * 2
+		w = s / 4
+		x = (m[2][1] - m[1][2]) / s
+		y = (m[0][2] - m[2][0]) / s
+		z = (m[1][0] - m[0][1]) / s
+	case m[0][0] > m[1][1] && m[0][0] > m[2][2]:
+		s := math.Sqrt(1+m[0][0]-m[1][1]-m[2][2]) * 2
+		w = (m[2][1] - m[1][2]) / s
+		x = s / 4
+		y = (m[0][1] + m[1][0]) / s
+		z = (m[0][2] + m[2][0]) / s
+	case m[1][1] > m[2][2]:
+		s := math.Sqrt(1+m[1][1]-m[0][0]-m[2][2]) * 2
+		w = (m[0][2] - m[2][0]) / s
+		x = (m[0][1] + m[1][0]) / s
+		y = s / 4
+		z = (m[1][2] + m[2][1]) / s
+	default:
+		s := math.Sqrt(1+m[2][2]-m[0][0]-m[1][1]) * 2
+		w = (m[1][0] - m[0][1]) / s
+		x = (m[0][2] + m[2][0]) / s
+		y = (m[1][2] + m[2][1]) / s
+		z = s / 4
+	}
+	return Rotation{X: float32(x), Y: float32(y), Z: float32(z), W: float32(w)}
+}
+
+// Quaternion converts the body's Euler angles to a unit quaternion.
+//
+// It assumes QTM's default "Qualisys standard" convention: angles in degrees,
+// applied as rotations about the body's own X, then Y, then Z axis, so the
+// rotation is Rx(Angles[0]) * Ry(Angles[1]) * Rz(Angles[2]). A project set up
+// with custom Euler axes in its General settings needs its own conversion, or
+// can stream Component6D instead, whose matrix is convention-free.
+func (b BodyEuler) Quaternion() Rotation {
+	half := func(deg float32) (sin, cos float64) {
+		return math.Sincos(float64(deg) * math.Pi / 360)
+	}
+	sx, cx := half(b.Angles[0])
+	sy, cy := half(b.Angles[1])
+	sz, cz := half(b.Angles[2])
+	return Rotation{
+		X: float32(sx*cy*cz + cx*sy*sz),
+		Y: float32(cx*sy*cz - sx*cy*sz),
+		Z: float32(cx*cy*sz + sx*sy*cz),
+		W: float32(cx*cy*cz - sx*sy*sz),
+	}
+}
+
+// Matrix converts the quaternion to a row-major rotation matrix, the inverse
+// of BodyMatrix.Quaternion. The quaternion need not be normalized.
+func (r Rotation) Matrix() [9]float32 {
+	x, y, z, w := float64(r.X), float64(r.Y), float64(r.Z), float64(r.W)
+	n := x*x + y*y + z*z + w*w
+	if n == 0 {
+		return [9]float32{1, 0, 0, 0, 1, 0, 0, 0, 1}
+	}
+	s := 2 / n
+	return [9]float32{
+		float32(1 - s*(y*y+z*z)), float32(s * (x*y - z*w)), float32(s * (x*z + y*w)),
+		float32(s * (x*y + z*w)), float32(1 - s*(x*x+z*z)), float32(s * (y*z - x*w)),
+		float32(s * (x*z - y*w)), float32(s * (y*z + x*w)), float32(1 - s*(x*x+y*y)),
+	}
+}
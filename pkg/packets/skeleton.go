@@ -68,3 +68,20 @@ func (c *ComponentSkeleton) UnmarshalBinary(data []byte) error {
 	}
 	return cur.Err()
 }
+
+func (c ComponentSkeleton) MarshalBinary() ([]byte, error) {
+	w := &writer{}
+	w.count(len(c.Skeletons))
+	for _, sk := range c.Skeletons {
+		w.count(len(sk.Segments))
+		for _, s := range sk.Segments {
+			w.Uint32(s.ID)
+			w.Point(s.Position)
+			w.Float32(s.Rotation.X)
+			w.Float32(s.Rotation.Y)
+			w.Float32(s.Rotation.Z)
+			w.Float32(s.Rotation.W)
+		}
+	}
+	return w.b, nil
+}
@@ -27,6 +27,13 @@ func (i *IrigTime) Convert(high, low uint32) {
 	i.Tenth = 0xF & (low >> 17)
 }
 
+// Words is the inverse of Convert.
+func (i *IrigTime) Words() (high, low uint32) {
+	high = (0x7F & i.Year) | (0x1FF&i.Day)<<7
+	low = (0x1F & i.Hour) | (0x3F&i.Minute)<<5 | (0x3F&i.Second)<<11 | (0xF&i.Tenth)<<17
+	return high, low
+}
+
 func (i *IrigTime) String() string {
 	return fmt.Sprintf("%02d:%03d:%02d:%02d:%02d.%d", i.Year, i.Day, i.Hour, i.Minute, i.Second, i.Tenth)
 }
@@ -46,6 +53,13 @@ func (i *SmpteTime) Convert(_, low uint32) {
 	i.SubFrame = 0x1FF & (low >> 22)
 }
 
+// Words is the inverse of Convert. SMPTE uses only the low word.
+func (i *SmpteTime) Words() (high, low uint32) {
+	low = (0x1F & i.Hour) | (0x3F&i.Minute)<<5 | (0x3F&i.Second)<<11 |
+		(0x1F&i.Frame)<<17 | (0x1FF&i.SubFrame)<<22
+	return 0, low
+}
+
 func (i *SmpteTime) String() string {
 	return fmt.Sprintf("%02d:%02d:%02d:%02d", i.Hour, i.Minute, i.Second, i.Frame)
 }
@@ -73,6 +87,11 @@ func (i *CameraTime) Convert(high, low uint32) {
 	*i = CameraTime((uint64(high) << 32) | uint64(low))
 }
 
+// Words is the inverse of Convert.
+func (i *CameraTime) Words() (high, low uint32) {
+	return uint32(uint64(*i) >> 32), uint32(uint64(*i))
+}
+
 func (i *CameraTime) String() string {
 	const ticksPerSecond = 10000000
 	seconds := *i / ticksPerSecond
@@ -149,3 +168,23 @@ func (c *ComponentTimecode) UnmarshalBinary(data []byte) error {
 	}
 	return cur.Err()
 }
+
+func (c ComponentTimecode) MarshalBinary() ([]byte, error) {
+	w := &writer{}
+	w.count(len(c.Timecodes))
+	for _, tc := range c.Timecodes {
+		high, low := tc.High, tc.Low
+		switch tc.Type {
+		case TimecodeTypeSMPTE:
+			high, low = tc.Smpte.Words()
+		case TimecodeTypeIRIG:
+			high, low = tc.Irig.Words()
+		case TimecodeTypeCameraTime:
+			high, low = tc.CameraTime.Words()
+		}
+		w.Uint32(uint32(tc.Type))
+		w.Uint32(high)
+		w.Uint32(low)
+	}
+	return w.b, nil
+}
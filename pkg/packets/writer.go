@@ -0,0 +1,39 @@
+package packets
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// writer is the encoding counterpart of cursor: a little-endian appender that
+// emits exactly the layout the matching UnmarshalBinary reads.
+//
+// Re-encoding exists for tools that sit between QTM and other clients, such as
+// a gateway forwarding a subset of components or a relay speaking the RT
+// protocol itself. Keeping the field order next to the parser it mirrors is
+// what stops the two drifting apart.
+type writer struct {
+	b []byte
+}
+
+func (w *writer) Uint8(v uint8) { w.b = append(w.b, v) }
+
+func (w *writer) Uint16(v uint16) { w.b = binary.LittleEndian.AppendUint16(w.b, v) }
+
+func (w *writer) Uint32(v uint32) { w.b = binary.LittleEndian.AppendUint32(w.b, v) }
+
+func (w *writer) Uint64(v uint64) { w.b = binary.LittleEndian.AppendUint64(w.b, v) }
+
+func (w *writer) Float32(v float32) { w.Uint32(math.Float32bits(v)) }
+
+// Point writes three consecutive float32 as an X/Y/Z triple.
+func (w *writer) Point(p Point) {
+	w.Float32(p.X)
+	w.Float32(p.Y)
+	w.Float32(p.Z)
+}
+
+func (w *writer) Bytes(p []byte) { w.b = append(w.b, p...) }
+
+// count writes a slice length as the uint32 record count the protocol uses.
+func (w *writer) count(n int) { w.Uint32(uint32(n)) }
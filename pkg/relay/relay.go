@@ -0,0 +1,479 @@
+// Package relay re-serves one QTM connection to many RT protocol clients.
+//
+// A relay connects to QTM once and listens as an RT server itself. Downstream
+// tools, including this SDK's own Protocol, connect to it exactly as they
+// would to QTM: version negotiation, GetParameters, GetState, QTMVersion and
+// StreamFrames all work, answered from a cached snapshot of QTM's settings and
+// from the upstream stream, filtered to the components and rate each client
+// asked for and re-encoded. QTM itself sees a single client however many tools
+// are running, and a relay per lab subnet keeps streaming traffic off the
+// capture machine's network.
+//
+// The relay is read-only. Commands that would change QTM, such as TakeControl,
+// Start or SetParameters, are refused with an error packet, and it serves the
+// little-endian port only. Component options in a downstream StreamFrames, such
+// as analog channel lists, are accepted but the upstream stream's options
+// apply.
+package relay
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/pkg/fanout"
+)
+
+// DefaultWriteTimeout bounds how long one packet may take to reach a client.
+const DefaultWriteTimeout = 5 * time.Second
+
+// maxCommandSize bounds what a client may send. Commands are short strings;
+// XML is refused anyway.
+const maxCommandSize = 1 << 20
+
+// packetHeaderSize is the RT packet header: size and type, both uint32.
+const packetHeaderSize = 8
+
+// Server answers RT protocol clients from an Upstream snapshot and streams
+// frames published on a hub. It is safe for concurrent use.
+type Server struct {
+	hub *fanout.Hub
+
+	// WriteTimeout overrides DefaultWriteTimeout when positive.
+	WriteTimeout time.Duration
+	// Buffer is the per-client queue length; see fanout.Hub.Subscribe.
+	Buffer int
+	// OnError, if set, is told about client connections that ended with an
+	// error. As elsewhere in this SDK, the library never logs by itself.
+	OnError func(remoteAddr string, err error)
+
+	mu    sync.RWMutex
+	up    *Upstream
+	state qualisys.EventType
+}
+
+// NewServer serves frames published on hub. Until SetUpstream is called,
+// clients can connect but version negotiation fails, as it would against a
+// QTM that is still starting.
+func NewServer(hub *fanout.Hub) *Server {
+	return &Server{hub: hub, state: qualisys.EventTypeNone}
+}
+
+// SetUpstream replaces the snapshot clients are answered from, typically after
+// reconnecting to QTM. Clients connected earlier keep their negotiated version.
+func (s *Server) SetUpstream(u *Upstream) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.up = u
+	s.state = u.State
+}
+
+func (s *Server) upstream() (*Upstream, qualisys.EventType) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.up, s.state
+}
+
+// trackState follows QTM's events so GetState answers with the current state
+// rather than the one at the time of the snapshot.
+func (s *Server) trackState(sub *fanout.Subscription) {
+	for p := range sub.C() {
+		if p.Type != qualisys.PacketTypeEvent || p.Event == qualisys.EventTypeCameraSettingsChanged {
+			continue
+		}
+		s.mu.Lock()
+		s.state = p.Event
+		s.mu.Unlock()
+	}
+}
+
+// Serve accepts clients on ln until ctx is cancelled, and closes ln when it
+// returns.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() { ln.Close() })
+	defer stop()
+
+	states := s.hub.Subscribe(0)
+	defer states.Close()
+	go s.trackState(states)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handle(ctx, conn)
+		}()
+	}
+}
+
+func (s *Server) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	timeout := s.WriteTimeout
+	if timeout <= 0 {
+		timeout = DefaultWriteTimeout
+	}
+	c := &client{s: s, conn: conn, timeout: timeout}
+	defer c.close()
+
+	err := c.serve()
+	if ctx.Err() != nil || errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		err = nil
+	}
+	if err != nil && s.OnError != nil {
+		s.OnError(conn.RemoteAddr().String(), err)
+	}
+}
+
+// streamRequest is one client's StreamFrames or GetCurrentFrame.
+type streamRequest struct {
+	components []qualisys.ComponentType
+	decimator  *fanout.Decimator
+	// udp is set when the client asked for frames over UDP.
+	udp net.Conn
+}
+
+type client struct {
+	s       *Server
+	conn    net.Conn
+	timeout time.Duration
+
+	wmu sync.Mutex
+
+	// major and minor are only touched by the command loop.
+	major, minor int
+
+	mu      sync.Mutex
+	stream  *streamRequest
+	current *streamRequest
+	frames  *fanout.Subscription
+}
+
+func (c *client) write(p qualisys.Packet) error {
+	b, err := p.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if err := c.conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return err
+	}
+	_, err = c.conn.Write(b)
+	return err
+}
+
+func (c *client) command(s string) error {
+	return c.write(qualisys.Packet{Type: qualisys.PacketTypeCommand, CommandResponse: s})
+}
+
+func (c *client) error(s string) error {
+	return c.write(qualisys.Packet{Type: qualisys.PacketTypeError, ErrorResponse: s})
+}
+
+func (c *client) serve() error {
+	if err := c.command("QTM RT Interface connected"); err != nil {
+		return err
+	}
+	header := make([]byte, packetHeaderSize)
+	for {
+		if _, err := io.ReadFull(c.conn, header); err != nil {
+			return err
+		}
+		size := int(binary.LittleEndian.Uint32(header[0:]))
+		typ := qualisys.PacketType(binary.LittleEndian.Uint32(header[4:]))
+		if size < packetHeaderSize || size > maxCommandSize {
+			return fmt.Errorf("relay: invalid packet size %d", size)
+		}
+		body := make([]byte, size-packetHeaderSize)
+		if _, err := io.ReadFull(c.conn, body); err != nil {
+			return err
+		}
+		var err error
+		switch typ {
+		case qualisys.PacketTypeCommand:
+			err = c.handleCommand(strings.TrimRight(string(body), "\x00"))
+		case qualisys.PacketTypeXML:
+			err = c.error("Setting parameters is not supported by the relay")
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *client) handleCommand(cmd string) error {
+	fields := strings.Fields(cmd)
+	if len(fields) == 0 {
+		return c.error("Parse error")
+	}
+	up, state := c.s.upstream()
+	switch strings.ToLower(fields[0]) {
+	case "version":
+		return c.version(up, fields[1:])
+	case "qtmversion":
+		if up == nil {
+			return c.error("No connection to QTM")
+		}
+		return c.command(up.QTMVersion)
+	case "byteorder":
+		return c.command("Byte order is little endian")
+	case "getstate", "getlastevent":
+		return c.write(qualisys.Packet{Type: qualisys.PacketTypeEvent, Event: state})
+	case "getparameters":
+		if up == nil {
+			return c.error("No connection to QTM")
+		}
+		major, minor := c.major, c.minor
+		if major == 0 {
+			major, minor = up.Major, up.Minor
+		}
+		xml, ok := up.parameters(major, minor, fields[1:])
+		if !ok {
+			return c.error("Parameters not available (" + xml + ")")
+		}
+		return c.write(qualisys.Packet{Type: qualisys.PacketTypeXML, XMLResponse: xml})
+	case "streamframes":
+		return c.streamFrames(up, fields[1:])
+	case "getcurrentframe":
+		req, msg := parseComponents(up, fields[1:])
+		if req == nil {
+			return c.error(msg)
+		}
+		c.mu.Lock()
+		c.current = req
+		c.mu.Unlock()
+		c.startFrames()
+		return nil
+	}
+	return c.error(fields[0] + " is not supported by the relay")
+}
+
+// version negotiates like QTM: any version from the SDK's oldest supported one
+// up to what the relay itself negotiated upstream is accepted, since the
+// relay can only produce what it receives.
+func (c *client) version(up *Upstream, args []string) error {
+	if len(args) == 0 {
+		if c.major == 0 {
+			return c.error("Version not set")
+		}
+		return c.command(fmt.Sprintf("Version is %d.%d", c.major, c.minor))
+	}
+	major, minor, ok := parseVersion(args[0])
+	if !ok || up == nil || major != up.Major || minor > up.Minor || minor < qualisys.MinSupportedMinorVersion {
+		return c.error("Version not supported")
+	}
+	c.major, c.minor = major, minor
+	return c.command(fmt.Sprintf("Version set to %d.%d", major, minor))
+}
+
+func parseVersion(s string) (major, minor int, ok bool) {
+	a, b, found := strings.Cut(s, ".")
+	if !found {
+		return 0, 0, false
+	}
+	major, err1 := strconv.Atoi(a)
+	minor, err2 := strconv.Atoi(b)
+	return major, minor, err1 == nil && err2 == nil
+}
+
+// parseComponents reads component tokens, dropping any ":options" suffix. It
+// returns nil and an error message for the client when a component is unknown
+// or not part of the upstream stream.
+func parseComponents(up *Upstream, tokens []string) (*streamRequest, string) {
+	if up == nil {
+		return nil, "No connection to QTM"
+	}
+	if len(tokens) == 0 {
+		return nil, "No components requested"
+	}
+	req := &streamRequest{}
+	for _, t := range tokens {
+		name, _, _ := strings.Cut(t, ":")
+		ct, err := qualisys.ParseComponentType(name)
+		if err != nil {
+			return nil, "Parse error (" + t + ")"
+		}
+		if !slices.Contains(up.Components, ct) {
+			return nil, "Component " + name + " is not streamed by the relay"
+		}
+		req.components = append(req.components, ct)
+	}
+	return req, ""
+}
+
+// streamFrames parses "StreamFrames <rate> [UDP[:addr]:port] <components>" or
+// "StreamFrames Stop". Like QTM it replies only on error.
+func (c *client) streamFrames(up *Upstream, args []string) error {
+	if len(args) == 0 {
+		return c.error("Parse error")
+	}
+	if strings.EqualFold(args[0], "stop") {
+		c.stopStreaming()
+		return nil
+	}
+
+	rate, value := qualisys.StreamRateTypeAllFrames, 0
+	kind, arg, _ := strings.Cut(args[0], ":")
+	switch strings.ToLower(kind) {
+	case "allframes":
+	case "frequency", "frequencydivisor":
+		v, err := strconv.Atoi(arg)
+		if err != nil {
+			return c.error("Parse error (" + args[0] + ")")
+		}
+		rate, value = qualisys.StreamRateTypeFrequency, v
+		if strings.EqualFold(kind, "frequencydivisor") {
+			rate = qualisys.StreamRateTypeFrequencyDivisor
+		}
+	default:
+		return c.error("Parse error (" + args[0] + ")")
+	}
+	args = args[1:]
+
+	var udpTarget string
+	if len(args) > 0 && strings.HasPrefix(strings.ToUpper(args[0]), "UDP") {
+		parts := strings.Split(args[0], ":")
+		host, _, _ := net.SplitHostPort(c.conn.RemoteAddr().String())
+		switch len(parts) {
+		case 2:
+			udpTarget = net.JoinHostPort(host, parts[1])
+		case 3:
+			udpTarget = net.JoinHostPort(parts[1], parts[2])
+		default:
+			return c.error("Parse error (" + args[0] + ")")
+		}
+		args = args[1:]
+	}
+
+	req, msg := parseComponents(up, args)
+	if req == nil {
+		return c.error(msg)
+	}
+	dec, err := fanout.NewDecimator(rate, value)
+	if err != nil {
+		return c.error(err.Error())
+	}
+	req.decimator = dec
+	if udpTarget != "" {
+		var d net.Dialer
+		conn, err := d.DialContext(context.Background(), "udp", udpTarget)
+		if err != nil {
+			return c.error("Invalid UDP address (" + udpTarget + ")")
+		}
+		req.udp = conn
+	}
+
+	c.mu.Lock()
+	old := c.stream
+	c.stream = req
+	c.mu.Unlock()
+	if old != nil && old.udp != nil {
+		old.udp.Close()
+	}
+	c.startFrames()
+	return nil
+}
+
+func (c *client) stopStreaming() {
+	c.mu.Lock()
+	old := c.stream
+	c.stream = nil
+	c.mu.Unlock()
+	if old != nil && old.udp != nil {
+		old.udp.Close()
+	}
+}
+
+// close releases the client's subscription and UDP socket.
+func (c *client) close() {
+	c.stopStreaming()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.frames != nil {
+		c.frames.Close()
+	}
+}
+
+// startFrames subscribes to the hub the first time the client asks for data.
+// Events reach every client from then on, as they do from QTM; before that
+// there is nothing to forward and no reason to hold a queue.
+func (c *client) startFrames() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.frames != nil {
+		return
+	}
+	c.frames = c.s.hub.Subscribe(c.s.Buffer)
+	go c.forward(c.frames)
+}
+
+// forward writes packets from sub until the connection fails. A failed write
+// closes the connection, which ends the command loop as well.
+func (c *client) forward(sub *fanout.Subscription) {
+	defer sub.Close()
+	for p := range sub.C() {
+		if err := c.forwardPacket(p); err != nil {
+			c.conn.Close()
+			return
+		}
+	}
+}
+
+func (c *client) forwardPacket(p *qualisys.Packet) error {
+	switch p.Type {
+	case qualisys.PacketTypeEvent:
+		return c.write(*p)
+	case qualisys.PacketTypeData:
+	default:
+		return nil
+	}
+
+	c.mu.Lock()
+	stream, current := c.stream, c.current
+	c.current = nil
+	c.mu.Unlock()
+
+	if current != nil {
+		if err := c.write(qualisys.Packet{Type: qualisys.PacketTypeData, Data: p.Data.Select(current.components...)}); err != nil {
+			return err
+		}
+	}
+	if stream == nil || !stream.decimator.Keep(&p.Data) {
+		return nil
+	}
+	out := qualisys.Packet{Type: qualisys.PacketTypeData, Data: p.Data.Select(stream.components...)}
+	if stream.udp == nil {
+		return c.write(out)
+	}
+	b, err := out.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	// UDP delivery is best effort, as it is from QTM: a receiver that has
+	// gone away must not end the client's TCP session.
+	_, _ = stream.udp.Write(b)
+	return nil
+}
@@ -0,0 +1,131 @@
+package relay_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/internal/qtmtest"
+	"github.com/mlveggo/qualisys-go/pkg/fanout"
+	"github.com/mlveggo/qualisys-go/pkg/packets"
+	"github.com/mlveggo/qualisys-go/pkg/relay"
+)
+
+// startRelay serves a canned upstream and returns the hub frames are published
+// on and a downstream Protocol already connected through the relay.
+func startRelay(t *testing.T) (*fanout.Hub, *qualisys.Protocol) {
+	t.Helper()
+	var lc net.ListenConfig
+	ln, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub := fanout.NewHub()
+	srv := relay.NewServer(hub)
+	srv.SetUpstream(&relay.Upstream{
+		Major: 1, Minor: 25,
+		QTMVersion: "QTM Version is 2023.3 (build 9999)",
+		State:      qualisys.EventTypeConnected,
+		Components: []qualisys.ComponentType{qualisys.ComponentType3D, qualisys.ComponentType6D},
+		Parameters: map[string]string{
+			"3D": "<The_3D><Label><Name>head</Name></Label></The_3D>",
+			"6D": "<The_6D><Body><Name>wand</Name></Body></The_6D>",
+		},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(ctx, ln) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+		hub.Close()
+	})
+
+	return hub, qtmtest.Connect(t, ln.Addr())
+}
+
+func TestRelayAnswersLikeQTM(t *testing.T) {
+	_, rt := startRelay(t)
+
+	// The SDK asks for 1.28 first; the relay only has what QTM gave it.
+	if major, minor := rt.Version(); major != 1 || minor != 25 {
+		t.Errorf("negotiated %d.%d, want 1.25", major, minor)
+	}
+	if v, err := rt.GetQTMVersion(); err != nil || !strings.Contains(v, "2023.3") {
+		t.Errorf("QTMVersion = %q, %v", v, err)
+	}
+	if state, err := rt.GetState(); err != nil || state != qualisys.EventTypeConnected {
+		t.Errorf("GetState = %v, %v", state, err)
+	}
+
+	xml, err := rt.GetParameters(qualisys.ParameterType6D, qualisys.ParameterType3D)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "<QTM_Parameters_Ver_1.25><The_6D><Body><Name>wand</Name></Body></The_6D>" +
+		"<The_3D><Label><Name>head</Name></Label></The_3D></QTM_Parameters_Ver_1.25>"
+	if xml != want {
+		t.Errorf("GetParameters = %s", xml)
+	}
+	if _, err := rt.GetParameters(qualisys.ParameterTypeSkeleton); err == nil {
+		t.Error("GetParameters of an uncached section succeeded")
+	}
+	if err := rt.TakeControl(""); err == nil {
+		t.Error("TakeControl was accepted by a read-only relay")
+	}
+}
+
+func TestRelayStreamsFilteredFrames(t *testing.T) {
+	hub, rt := startRelay(t)
+	if err := rt.StreamFrames(qualisys.StreamRateTypeFrequencyDivisor, 2, qualisys.ComponentType6D); err != nil {
+		t.Fatal(err)
+	}
+	// StreamFrames has no reply, so wait until the relay has subscribed.
+	deadline := time.Now().Add(2 * time.Second)
+	for hub.Len() < 2 { // the state tracker plus this client
+		if time.Now().After(deadline) {
+			t.Fatal("relay never subscribed the client")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	for i := uint32(0); i < 4; i++ {
+		hub.Publish(&qualisys.Packet{Type: qualisys.PacketTypeData, Data: qualisys.DataPacket{
+			Frame: i,
+			Components: []qualisys.IDataObject{
+				&packets.Component3D{Markers: []packets.Marker{{}}},
+				&packets.Component6D{Bodies: []packets.BodyMatrix{{Point: packets.Point{X: float32(i)}}}},
+			},
+		}})
+	}
+	hub.Publish(&qualisys.Packet{Type: qualisys.PacketTypeEvent, Event: qualisys.EventTypeCaptureStarted})
+
+	var frames []uint32
+	for len(frames) < 2 {
+		p, err := rt.ReceiveTimeout(2 * time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Type != qualisys.PacketTypeData {
+			t.Fatalf("got %v before the frames", p.Type)
+		}
+		if p.Data.Markers3D() != nil {
+			t.Error("3D was relayed although only 6D was requested")
+		}
+		if bodies := p.Data.Bodies6D(); bodies == nil || bodies.Bodies[0].Point.X != float32(p.Data.Frame) {
+			t.Errorf("frame %d: 6D = %v", p.Data.Frame, bodies)
+		}
+		frames = append(frames, p.Data.Frame)
+	}
+	if frames[0] != 0 || frames[1] != 2 {
+		t.Errorf("frames = %v, want every second one", frames)
+	}
+	p, err := rt.ReceiveTimeout(2 * time.Second)
+	if err != nil || p.Type != qualisys.PacketTypeEvent || p.Event != qualisys.EventTypeCaptureStarted {
+		t.Errorf("event = %+v, %v", p, err)
+	}
+}
@@ -0,0 +1,129 @@
+package relay
+
+import (
+	"fmt"
+	"strings"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+)
+
+// Upstream is what the relay knows about the QTM it is connected to. It is a
+// snapshot: downstream clients are answered from it without involving QTM.
+type Upstream struct {
+	// Major and Minor are the protocol version negotiated with QTM, and the
+	// newest version downstream clients may ask for.
+	Major, Minor int
+	QTMVersion   string
+	State        qualisys.EventType
+	// Components lists what the upstream stream carries. Downstream clients
+	// may stream any subset.
+	Components []qualisys.ComponentType
+	// Parameters maps a GetParameters token, such as "3D", "All" or
+	// "Skeleton:global", to the settings XML for that section without the
+	// QTM_Parameters root element.
+	Parameters map[string]string
+}
+
+// parameterTokens are the sections FetchUpstream caches, in the spelling QTM
+// accepts.
+var parameterTokens = []struct {
+	token string
+	typ   qualisys.ParameterType
+	opts  qualisys.ParameterOptions
+}{
+	{"All", qualisys.ParameterTypeAll, qualisys.ParameterOptions{}},
+	{"General", qualisys.ParameterTypeGeneral, qualisys.ParameterOptions{}},
+	{"Calibration", qualisys.ParameterTypeCalibration, qualisys.ParameterOptions{}},
+	{"3D", qualisys.ParameterType3D, qualisys.ParameterOptions{}},
+	{"6D", qualisys.ParameterType6D, qualisys.ParameterOptions{}},
+	{"Analog", qualisys.ParameterTypeAnalog, qualisys.ParameterOptions{}},
+	{"Force", qualisys.ParameterTypeForce, qualisys.ParameterOptions{}},
+	{"Image", qualisys.ParameterTypeImage, qualisys.ParameterOptions{}},
+	{"GazeVector", qualisys.ParameterTypeGazeVector, qualisys.ParameterOptions{}},
+	{"EyeTracker", qualisys.ParameterTypeEyeTracker, qualisys.ParameterOptions{}},
+	{"Skeleton", qualisys.ParameterTypeSkeleton, qualisys.ParameterOptions{}},
+	{"Skeleton:global", qualisys.ParameterTypeSkeleton, qualisys.ParameterOptions{SkeletonGlobal: true}},
+}
+
+// FetchUpstream reads the version, state and every settings section from a
+// connected Protocol. Call it before starting the upstream stream: once frames
+// are flowing, replies to these commands would be interleaved with them.
+//
+// Sections QTM refuses, typically Calibration on a system that has never been
+// calibrated, are left out, and downstream requests for them get an error just
+// as they would from QTM.
+func FetchUpstream(rt *qualisys.Protocol, components ...qualisys.ComponentType) (*Upstream, error) {
+	u := &Upstream{
+		Components: components,
+		Parameters: make(map[string]string),
+		State:      rt.State(),
+	}
+	u.Major, u.Minor = rt.Version()
+
+	var err error
+	if u.QTMVersion, err = rt.GetQTMVersion(); err != nil {
+		return nil, fmt.Errorf("relay: %w", err)
+	}
+	if state, err := rt.GetState(); err == nil {
+		u.State = state
+	}
+	root := rt.ParametersElementName()
+	for _, p := range parameterTokens {
+		xml, err := rt.GetParametersWithOptions(p.opts, p.typ)
+		if err != nil {
+			if !rt.IsConnected() {
+				return nil, fmt.Errorf("relay: %w", err)
+			}
+			continue
+		}
+		u.Parameters[p.token] = innerParameters(xml, root)
+	}
+	return u, nil
+}
+
+// innerParameters strips the XML declaration, if any, and the versioned root
+// element, leaving the section elements. The root is rebuilt for each client
+// with the version that client negotiated.
+func innerParameters(xml, root string) string {
+	open, closing := "<"+root+">", "</"+root+">"
+	if i := strings.Index(xml, open); i >= 0 {
+		xml = xml[i+len(open):]
+	}
+	if i := strings.LastIndex(xml, closing); i >= 0 {
+		xml = xml[:i]
+	}
+	return strings.TrimSpace(xml)
+}
+
+// parameters assembles the reply to a GetParameters request for tokens. When a
+// section is not cached it returns that token and false.
+func (u *Upstream) parameters(major, minor int, tokens []string) (string, bool) {
+	if len(tokens) == 0 {
+		tokens = []string{"All"}
+	}
+	var b strings.Builder
+	root := fmt.Sprintf("QTM_Parameters_Ver_%d.%d", major, minor)
+	b.WriteString("<" + root + ">")
+	for _, t := range tokens {
+		inner, ok := u.lookup(t)
+		if !ok {
+			return t, false
+		}
+		b.WriteString(inner)
+	}
+	b.WriteString("</" + root + ">")
+	return b.String(), true
+}
+
+// lookup finds a cached section, ignoring case as QTM does.
+func (u *Upstream) lookup(token string) (string, bool) {
+	if xml, ok := u.Parameters[token]; ok {
+		return xml, true
+	}
+	for k, xml := range u.Parameters {
+		if strings.EqualFold(k, token) {
+			return xml, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,163 @@
+package rest
+
+import (
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// JobStatus is the lifecycle of an asynchronous operation.
+type JobStatus string
+
+const (
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+	JobFailed  JobStatus = "failed"
+)
+
+// maxFinishedJobs bounds how many completed jobs are kept for polling. Capture
+// downloads keep a file each, so keeping every job forever would fill the disk
+// of a control panel that runs all day.
+const maxFinishedJobs = 16
+
+// Job is an operation too long for one HTTP request: a calibration or a
+// capture download. Clients poll GET /jobs/{id} until Status is no longer
+// running, then fetch the result from GET /jobs/{id}/result.
+type Job struct {
+	ID       string     `json:"id"`
+	Kind     string     `json:"kind"`
+	Status   JobStatus  `json:"status"`
+	Error    string     `json:"error,omitempty"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	result result
+}
+
+// result is what a finished job produced: either data in memory or, for a
+// capture, a temporary file, which is removed when the job is dropped.
+type result struct {
+	data        []byte
+	path        string
+	contentType string
+}
+
+// remove deletes the result's file, if it has one.
+func (r result) remove() {
+	if r.path != "" {
+		_ = os.Remove(r.path)
+	}
+}
+
+type jobs struct {
+	mu     sync.Mutex
+	nextID int
+	byID   map[string]*Job
+	order  []string
+	// closed is set once the results are discarded; jobs finishing after
+	// that discard theirs at once.
+	closed bool
+}
+
+func newJobs() *jobs {
+	return &jobs{byID: make(map[string]*Job)}
+}
+
+// start registers a job and runs fn in the background.
+func (js *jobs) start(kind string, fn func() (result, error)) Job {
+	js.mu.Lock()
+	js.nextID++
+	j := &Job{ID: strconv.Itoa(js.nextID), Kind: kind, Status: JobRunning, Started: time.Now()}
+	js.byID[j.ID] = j
+	js.order = append(js.order, j.ID)
+	snapshot := *j
+	js.mu.Unlock()
+
+	go func() {
+		res, err := fn()
+		js.mu.Lock()
+		defer js.mu.Unlock()
+		now := time.Now()
+		j.Finished = &now
+		switch {
+		case err != nil:
+			j.Status = JobFailed
+			j.Error = err.Error()
+		case js.closed:
+			j.Status = JobDone
+			res.remove()
+		default:
+			j.Status = JobDone
+			j.result = res
+		}
+		js.prune()
+	}()
+	return snapshot
+}
+
+// prune drops the oldest finished jobs beyond maxFinishedJobs, with their
+// files. Running jobs are never dropped.
+func (js *jobs) prune() {
+	finished := 0
+	for _, id := range js.order {
+		if js.byID[id].Status != JobRunning {
+			finished++
+		}
+	}
+	kept := js.order[:0]
+	for _, id := range js.order {
+		if finished > maxFinishedJobs && js.byID[id].Status != JobRunning {
+			js.byID[id].result.remove()
+			delete(js.byID, id)
+			finished--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	js.order = kept
+}
+
+// close removes the files of every finished job, and of those still running
+// once they finish.
+func (js *jobs) close() {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	js.closed = true
+	for _, j := range js.byID {
+		j.result.remove()
+		j.result = result{}
+	}
+}
+
+// running reports whether any job has yet to finish.
+func (js *jobs) running() bool {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	for _, j := range js.byID {
+		if j.Status == JobRunning {
+			return true
+		}
+	}
+	return false
+}
+
+func (js *jobs) get(id string) (Job, bool) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	j, ok := js.byID[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *j, true
+}
+
+func (js *jobs) list() []Job {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	out := make([]Job, 0, len(js.order))
+	for _, id := range js.order {
+		out = append(out, *js.byID[id])
+	}
+	return out
+}
@@ -0,0 +1,600 @@
+// Package rest exposes QTM control over HTTP with JSON bodies.
+//
+// Web applications cannot speak the RT protocol, so Server wraps one Protocol
+// connection in a small REST API. Every request is serialized onto that single
+// connection, since Protocol is not safe for concurrent use and QTM would
+// interleave replies anyway. Calibration and capture downloads take far longer
+// than an HTTP request should, so they run as jobs the client polls.
+//
+//	GET    /state                   {"state":"CaptureStarted"}
+//	POST   /control                 {"password":""}      TakeControl
+//	DELETE /control                                      ReleaseControl
+//	POST   /measurement/new                              New
+//	POST   /measurement/close                            Close
+//	POST   /measurement/start       {"rtFromFile":false} Start
+//	POST   /measurement/stop                             Stop
+//	POST   /measurement/save        {"filename":"a.qtm","overwrite":false}
+//	POST   /measurement/load        {"filename":"a.qtm"}
+//	POST   /project/load            {"path":"C:\\Projects\\Lab"}
+//	POST   /events                  {"label":"heel strike"} SetQTMEvent
+//	POST   /trig                                         Trig
+//	POST   /leds                    {"camera":1,"mode":"Pulsing","color":"Green"}
+//	GET    /parameters?sections=3D,6D                    {"xml":"..."}
+//	POST   /calibration             {"refine":false}     202, a Job
+//	POST   /captures                {"format":"c3d"}     202, a Job
+//	GET    /jobs                                         every Job
+//	GET    /jobs/{id}                                    one Job
+//	GET    /jobs/{id}/result                             calibration XML or capture file
+//
+// Errors are reported as {"error":"..."} with a 4xx status for bad requests,
+// 409 while a job holds the connection, 502 when QTM rejects a command and 503
+// when there is no connection.
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+)
+
+// DefaultPollInterval is how often Run drains QTM's events when the connection
+// is otherwise idle.
+const DefaultPollInterval = 100 * time.Millisecond
+
+// ErrBusy is returned while another operation, typically a job, is using the
+// connection.
+var ErrBusy = errors.New("rest: connection busy")
+
+// lockWait is how long a request waits for the connection before giving up
+// with ErrBusy. Ordinary commands finish well within it; a calibration does
+// not, and the client is better told so than left hanging for minutes.
+const lockWait = 2 * qualisys.DefaultCommandTimeout
+
+// Server is an http.Handler controlling QTM through rt.
+type Server struct {
+	rt *qualisys.Protocol
+	// sem is a one-slot semaphore rather than a mutex so acquiring it can
+	// give up on a deadline or a cancelled request.
+	sem  chan struct{}
+	jobs *jobs
+	mux  *http.ServeMux
+
+	// last is what the connection last reported, kept so that GET /state
+	// can answer without waiting for the connection, which a job may hold
+	// for minutes.
+	lastMu sync.Mutex
+	last   status
+
+	// PollInterval overrides DefaultPollInterval when positive.
+	PollInterval time.Duration
+	// CalibrationTimeout is passed to Protocol.Calibrate; zero uses the
+	// SDK default.
+	CalibrationTimeout time.Duration
+	// TempDir is where capture downloads are kept until their job is
+	// dropped; empty uses os.TempDir.
+	TempDir string
+}
+
+// NewServer controls QTM through rt, which must already be connected. The
+// Server takes over rt: nothing else may use it while the Server is in use.
+func NewServer(rt *qualisys.Protocol) *Server {
+	s := &Server{rt: rt, sem: make(chan struct{}, 1), jobs: newJobs(), mux: http.NewServeMux()}
+	s.remember()
+
+	s.mux.HandleFunc("GET /state", s.handleState)
+	s.mux.HandleFunc("POST /control", s.handleTakeControl)
+	s.mux.HandleFunc("DELETE /control", s.command(func(rt *qualisys.Protocol) error { return rt.ReleaseControl() }))
+	s.mux.HandleFunc("POST /measurement/new", s.command(func(rt *qualisys.Protocol) error { return rt.New() }))
+	s.mux.HandleFunc("POST /measurement/close", s.command(func(rt *qualisys.Protocol) error { return rt.Close() }))
+	s.mux.HandleFunc("POST /measurement/start", s.handleStart)
+	s.mux.HandleFunc("POST /measurement/stop", s.command(func(rt *qualisys.Protocol) error { return rt.Stop() }))
+	s.mux.HandleFunc("POST /measurement/save", s.handleSave)
+	s.mux.HandleFunc("POST /measurement/load", s.handleLoad)
+	s.mux.HandleFunc("POST /project/load", s.handleLoadProject)
+	s.mux.HandleFunc("POST /events", s.handleEvent)
+	s.mux.HandleFunc("POST /trig", s.command(func(rt *qualisys.Protocol) error { return rt.Trig() }))
+	s.mux.HandleFunc("POST /leds", s.handleLed)
+	s.mux.HandleFunc("GET /parameters", s.handleParameters)
+	s.mux.HandleFunc("POST /calibration", s.handleCalibrate)
+	s.mux.HandleFunc("POST /captures", s.handleCapture)
+	s.mux.HandleFunc("GET /jobs", s.handleJobs)
+	s.mux.HandleFunc("GET /jobs/{id}", s.handleJob)
+	s.mux.HandleFunc("GET /jobs/{id}/result", s.handleJobResult)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Close removes the capture files jobs have kept. Results of jobs finished
+// afterwards are discarded, as they would otherwise be left behind.
+func (s *Server) Close() error {
+	s.jobs.close()
+	return nil
+}
+
+// acquire takes the connection, giving up when ctx ends or lockWait passes.
+// While a job runs it fails at once instead: the job may hold the connection
+// for minutes, and the client should hear so now.
+func (s *Server) acquire(ctx context.Context) error {
+	if s.jobs.running() {
+		return ErrBusy
+	}
+	t := time.NewTimer(lockWait)
+	defer t.Stop()
+	select {
+	case s.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return ErrBusy
+	}
+}
+
+func (s *Server) release() { <-s.sem }
+
+// do runs fn with exclusive use of the connection.
+func (s *Server) do(ctx context.Context, fn func(rt *qualisys.Protocol) error) error {
+	if err := s.acquire(ctx); err != nil {
+		return err
+	}
+	defer s.release()
+	defer s.remember()
+	if !s.rt.IsConnected() {
+		return qualisys.ErrNotConnected
+	}
+	return fn(s.rt)
+}
+
+// status is the state of the connection as GET /state reports it.
+type status struct {
+	connected    bool
+	state        qualisys.EventType
+	major, minor int
+}
+
+// remember records the connection's status. It must be called holding the
+// connection.
+func (s *Server) remember() {
+	st := status{connected: s.rt.IsConnected(), state: s.rt.State()}
+	st.major, st.minor = s.rt.Version()
+	s.lastMu.Lock()
+	s.last = st
+	s.lastMu.Unlock()
+}
+
+// Run keeps the reported state current by draining QTM's event packets
+// whenever the connection is idle, until ctx is cancelled. QTM pushes events
+// to every client unprompted; without this they would sit in the socket until
+// the next command, and GET /state would lag behind the capture.
+func (s *Server) Run(ctx context.Context) error {
+	interval := s.PollInterval
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+		select {
+		case s.sem <- struct{}{}:
+		default:
+			continue // a command is running and will see the events itself
+		}
+		err := s.drain()
+		s.remember()
+		s.release()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// drain reads whatever packets are already waiting. Only events are expected
+// here; Protocol records them as it reads.
+func (s *Server) drain() error {
+	if !s.rt.IsConnected() {
+		return qualisys.ErrNotConnected
+	}
+	for {
+		p, err := s.rt.ReceiveTimeout(time.Millisecond)
+		if err != nil {
+			return err
+		}
+		if p.Type == qualisys.PacketTypeNoMoreData {
+			return nil
+		}
+	}
+}
+
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// badRequest marks errors caused by the request rather than by QTM.
+type badRequest struct{ error }
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusBadGateway
+	var bad badRequest
+	switch {
+	case errors.As(err, &bad):
+		status = http.StatusBadRequest
+	case errors.Is(err, ErrBusy):
+		status = http.StatusConflict
+	case errors.Is(err, qualisys.ErrNotConnected):
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, errorBody{Error: err.Error()})
+}
+
+// decode reads an optional JSON body into v. An empty body leaves v at its
+// zero value, so endpoints whose fields all have sensible defaults can be
+// called without one.
+func decode(r *http.Request, v any) error {
+	dec := json.NewDecoder(io.LimitReader(r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return badRequest{fmt.Errorf("invalid JSON body: %w", err)}
+	}
+	return nil
+}
+
+type okBody struct {
+	OK    bool   `json:"ok"`
+	State string `json:"state"`
+}
+
+// command adapts a Protocol call without arguments to a handler.
+func (s *Server) command(fn func(rt *qualisys.Protocol) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.respond(w, r, fn)
+	}
+}
+
+// respond runs fn and answers with the outcome and the resulting state.
+func (s *Server) respond(w http.ResponseWriter, r *http.Request, fn func(rt *qualisys.Protocol) error) {
+	var state qualisys.EventType
+	err := s.do(r.Context(), func(rt *qualisys.Protocol) error {
+		err := fn(rt)
+		state = rt.State()
+		return err
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, okBody{OK: true, State: state.String()})
+}
+
+// handleState answers with the state last seen rather than taking the
+// connection, so that it works while a job runs, which is when clients most
+// want to know it.
+func (s *Server) handleState(w http.ResponseWriter, _ *http.Request) {
+	s.lastMu.Lock()
+	st := s.last
+	s.lastMu.Unlock()
+	if !st.connected {
+		writeError(w, qualisys.ErrNotConnected)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		State   string `json:"state"`
+		Version string `json:"protocolVersion"`
+	}{st.state.String(), fmt.Sprintf("%d.%d", st.major, st.minor)})
+}
+
+func (s *Server) handleTakeControl(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Password string `json:"password"`
+	}
+	if err := decode(r, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+	s.respond(w, r, func(rt *qualisys.Protocol) error { return rt.TakeControl(body.Password) })
+}
+
+func (s *Server) handleStart(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		RTFromFile bool `json:"rtFromFile"`
+	}
+	if err := decode(r, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+	s.respond(w, r, func(rt *qualisys.Protocol) error { return rt.Start(body.RTFromFile) })
+}
+
+func (s *Server) handleSave(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Filename  string `json:"filename"`
+		Overwrite bool   `json:"overwrite"`
+	}
+	if err := decode(r, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+	if body.Filename == "" {
+		writeError(w, badRequest{errors.New("filename is required")})
+		return
+	}
+	s.respond(w, r, func(rt *qualisys.Protocol) error { return rt.Save(body.Filename, body.Overwrite) })
+}
+
+func (s *Server) handleLoad(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Filename string `json:"filename"`
+	}
+	if err := decode(r, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+	if body.Filename == "" {
+		writeError(w, badRequest{errors.New("filename is required")})
+		return
+	}
+	s.respond(w, r, func(rt *qualisys.Protocol) error { return rt.Load(body.Filename) })
+}
+
+func (s *Server) handleLoadProject(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Path string `json:"path"`
+	}
+	if err := decode(r, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+	if body.Path == "" {
+		writeError(w, badRequest{errors.New("path is required")})
+		return
+	}
+	s.respond(w, r, func(rt *qualisys.Protocol) error { return rt.LoadProject(body.Path) })
+}
+
+func (s *Server) handleEvent(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Label string `json:"label"`
+	}
+	if err := decode(r, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+	if body.Label == "" {
+		writeError(w, badRequest{errors.New("label is required")})
+		return
+	}
+	s.respond(w, r, func(rt *qualisys.Protocol) error { return rt.SetQTMEvent(body.Label) })
+}
+
+func parseLedMode(s string) (qualisys.LedMode, bool) {
+	for m := qualisys.LedModeOn; m <= qualisys.LedModePulsing; m++ {
+		if strings.EqualFold(m.String(), s) {
+			return m, true
+		}
+	}
+	return 0, false
+}
+
+func parseLedColor(s string) (qualisys.LedColor, bool) {
+	for c := qualisys.LedColorAmber; c <= qualisys.LedColorAll; c++ {
+		if strings.EqualFold(c.String(), s) {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+func (s *Server) handleLed(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Camera int    `json:"camera"`
+		Mode   string `json:"mode"`
+		Color  string `json:"color"`
+	}
+	if err := decode(r, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+	mode, ok := parseLedMode(body.Mode)
+	if !ok {
+		writeError(w, badRequest{fmt.Errorf("unknown LED mode %q", body.Mode)})
+		return
+	}
+	color, ok := parseLedColor(body.Color)
+	if !ok {
+		writeError(w, badRequest{fmt.Errorf("unknown LED color %q", body.Color)})
+		return
+	}
+	if body.Camera < 1 {
+		writeError(w, badRequest{errors.New("camera must be 1 or greater")})
+		return
+	}
+	s.respond(w, r, func(rt *qualisys.Protocol) error { return rt.Led(body.Camera, mode, color) })
+}
+
+// parameterTypes maps the section names accepted in ?sections= to the SDK's
+// parameter types, using the same spelling as the RT protocol.
+var parameterTypes = map[string]qualisys.ParameterType{
+	"all":         qualisys.ParameterTypeAll,
+	"general":     qualisys.ParameterTypeGeneral,
+	"calibration": qualisys.ParameterTypeCalibration,
+	"3d":          qualisys.ParameterType3D,
+	"6d":          qualisys.ParameterType6D,
+	"analog":      qualisys.ParameterTypeAnalog,
+	"force":       qualisys.ParameterTypeForce,
+	"image":       qualisys.ParameterTypeImage,
+	"gazevector":  qualisys.ParameterTypeGazeVector,
+	"eyetracker":  qualisys.ParameterTypeEyeTracker,
+	"skeleton":    qualisys.ParameterTypeSkeleton,
+}
+
+func (s *Server) handleParameters(w http.ResponseWriter, r *http.Request) {
+	var types []qualisys.ParameterType
+	for _, name := range strings.FieldsFunc(r.URL.Query().Get("sections"), func(r rune) bool { return r == ',' || r == ' ' }) {
+		t, ok := parameterTypes[strings.ToLower(name)]
+		if !ok {
+			writeError(w, badRequest{fmt.Errorf("unknown settings section %q", name)})
+			return
+		}
+		types = append(types, t)
+	}
+	var xml string
+	err := s.do(r.Context(), func(rt *qualisys.Protocol) error {
+		var err error
+		xml, err = rt.GetParameters(types...)
+		return err
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		XML string `json:"xml"`
+	}{xml})
+}
+
+// startJob runs fn as a job holding the connection. The connection is taken
+// before answering, so a client learns with 409 that another job is still
+// running, rather than getting a job that fails later.
+func (s *Server) startJob(w http.ResponseWriter, r *http.Request, kind string, fn func(rt *qualisys.Protocol) (result, error)) {
+	if err := s.acquire(r.Context()); err != nil {
+		writeError(w, err)
+		return
+	}
+	if !s.rt.IsConnected() {
+		s.release()
+		writeError(w, qualisys.ErrNotConnected)
+		return
+	}
+	job := s.jobs.start(kind, func() (result, error) {
+		defer s.release()
+		defer s.remember()
+		return fn(s.rt)
+	})
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (s *Server) handleCalibrate(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Refine bool `json:"refine"`
+	}
+	if err := decode(r, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+	s.startJob(w, r, "calibration", func(rt *qualisys.Protocol) (result, error) {
+		xml, err := rt.Calibrate(body.Refine, s.CalibrationTimeout)
+		return result{data: []byte(xml), contentType: "application/xml"}, err
+	})
+}
+
+func (s *Server) handleCapture(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Format string `json:"format"`
+	}
+	if err := decode(r, &body); err != nil {
+		writeError(w, err)
+		return
+	}
+	var download func(rt *qualisys.Protocol, ctx context.Context, w io.Writer, opts qualisys.DownloadOptions) (int64, error)
+	format := strings.ToLower(body.Format)
+	switch format {
+	case "", "c3d":
+		format, download = "c3d", (*qualisys.Protocol).DownloadCaptureC3D
+	case "qtm":
+		download = (*qualisys.Protocol).DownloadCaptureQTM
+	default:
+		writeError(w, badRequest{fmt.Errorf("unknown capture format %q", body.Format)})
+		return
+	}
+	s.startJob(w, r, "capture", func(rt *qualisys.Protocol) (result, error) {
+		return s.downloadCapture(rt, format, download)
+	})
+}
+
+// downloadCapture streams a capture to a temporary file rather than into
+// memory, so neither its size nor the number of jobs kept is limited by RAM.
+func (s *Server) downloadCapture(rt *qualisys.Protocol, format string,
+	download func(*qualisys.Protocol, context.Context, io.Writer, qualisys.DownloadOptions) (int64, error),
+) (result, error) {
+	f, err := os.CreateTemp(s.TempDir, "qtm-capture-*."+format)
+	if err != nil {
+		return result{}, err
+	}
+	res := result{path: f.Name(), contentType: "application/octet-stream"}
+	_, err = download(rt, context.Background(), f, qualisys.DownloadOptions{})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		res.remove()
+		return result{}, err
+	}
+	return res, nil
+}
+
+func (s *Server) handleJobs(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, s.jobs.list())
+}
+
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.jobs.get(r.PathValue("id"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorBody{Error: "no such job"})
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (s *Server) handleJobResult(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.jobs.get(r.PathValue("id"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, errorBody{Error: "no such job"})
+		return
+	}
+	switch job.Status {
+	case JobRunning:
+		writeJSON(w, http.StatusConflict, errorBody{Error: "job is still running"})
+	case JobFailed:
+		writeJSON(w, http.StatusConflict, errorBody{Error: job.Error})
+	case JobDone:
+		w.Header().Set("Content-Type", job.result.contentType)
+		if job.result.path == "" {
+			_, _ = w.Write(job.result.data)
+			return
+		}
+		f, err := os.Open(job.result.path)
+		if err != nil {
+			// Close ran, or the job was dropped since it was looked up.
+			writeJSON(w, http.StatusNotFound, errorBody{Error: "job result is gone"})
+			return
+		}
+		defer f.Close()
+		http.ServeContent(w, r, filepath.Base(job.result.path), *job.Finished, f)
+	}
+}
@@ -0,0 +1,242 @@
+package rest_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/internal/qtmtest"
+	"github.com/mlveggo/qualisys-go/pkg/rest"
+)
+
+// fakeQTM answers the handful of commands the tests use. Calibration results
+// are held back until release is closed, so a test can observe a running job.
+type fakeQTM struct {
+	release chan struct{}
+}
+
+func (f *fakeQTM) reply(conn net.Conn, cmd string) []byte {
+	switch {
+	case cmd == "TakeControl secret":
+		return qtmtest.Text(qualisys.PacketTypeCommand, "You are now master")
+	case strings.HasPrefix(cmd, "TakeControl"):
+		return qtmtest.Text(qualisys.PacketTypeError, "Wrong or missing password")
+	case cmd == "Start":
+		return append(qtmtest.Text(qualisys.PacketTypeCommand, "Starting measurement"),
+			qtmtest.Event(qualisys.EventTypeCaptureStarted)...)
+	case cmd == "GetParameters 3D":
+		return qtmtest.Text(qualisys.PacketTypeXML, "<QTM_Parameters_Ver_1.28><The_3D/></QTM_Parameters_Ver_1.28>")
+	case cmd == "GetCaptureC3D":
+		c3d := make([]byte, 1024)
+		c3d[1] = 0x50
+		return append(qtmtest.Text(qualisys.PacketTypeCommand, "Sending capture"), qtmtest.Packet(qualisys.PacketTypeC3DFile, c3d)...)
+	case cmd == "Calibrate":
+		_, _ = conn.Write(qtmtest.Text(qualisys.PacketTypeCommand, "Starting calibration"))
+		<-f.release
+		return qtmtest.Text(qualisys.PacketTypeXML, "<calibration/>")
+	}
+	return qtmtest.Text(qualisys.PacketTypeError, "Parse error")
+}
+
+// startServer connects a Protocol to a fake QTM and serves it over HTTP.
+func startServer(t *testing.T) (*fakeQTM, *rest.Server, *httptest.Server) {
+	t.Helper()
+	f := &fakeQTM{release: make(chan struct{})}
+	rt := qtmtest.Connect(t, qtmtest.Listen(t, f.reply))
+
+	api := rest.NewServer(rt)
+	api.PollInterval = 5 * time.Millisecond
+	api.TempDir = t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- api.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Run: %v", err)
+		}
+	})
+
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	return f, api, srv
+}
+
+func call(t *testing.T, srv *httptest.Server, method, path, body string, out any) int {
+	t.Helper()
+	req, err := http.NewRequestWithContext(context.Background(), method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: decode: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestCommands(t *testing.T) {
+	_, _, srv := startServer(t)
+
+	var state struct{ State string }
+	if code := call(t, srv, "GET", "/state", "", &state); code != http.StatusOK || state.State != "Connected" {
+		t.Errorf("GET /state = %d %+v", code, state)
+	}
+
+	var failed struct{ Error string }
+	if code := call(t, srv, "POST", "/control", `{"password":"nope"}`, &failed); code != http.StatusBadGateway ||
+		!strings.Contains(failed.Error, "Wrong or missing password") {
+		t.Errorf("TakeControl with a bad password = %d %+v", code, failed)
+	}
+	if code := call(t, srv, "POST", "/control", `{"password":"secret"}`, nil); code != http.StatusOK {
+		t.Errorf("TakeControl = %d", code)
+	}
+
+	var started struct{ OK bool }
+	if code := call(t, srv, "POST", "/measurement/start", "", &started); code != http.StatusOK || !started.OK {
+		t.Errorf("Start = %d %+v", code, started)
+	}
+	// QTM announces the new state with an event after the reply; Run picks it
+	// up without another command being sent.
+	deadline := time.Now().Add(2 * time.Second)
+	for call(t, srv, "GET", "/state", "", &state); state.State != "CaptureStarted"; call(t, srv, "GET", "/state", "", &state) {
+		if time.Now().After(deadline) {
+			t.Fatalf("state stuck at %s after Start", state.State)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	var params struct{ XML string }
+	if code := call(t, srv, "GET", "/parameters?sections=3d", "", &params); code != http.StatusOK ||
+		!strings.Contains(params.XML, "<The_3D/>") {
+		t.Errorf("GET /parameters = %d %+v", code, params)
+	}
+}
+
+func TestBadRequests(t *testing.T) {
+	_, _, srv := startServer(t)
+	for _, tc := range []struct{ method, path, body string }{
+		{"POST", "/measurement/save", `{}`},
+		{"POST", "/measurement/start", `{"rtFromFile":`},
+		{"POST", "/control", `{"pasword":"typo"}`},
+		{"POST", "/leds", `{"camera":1,"mode":"blinking","color":"green"}`},
+		{"GET", "/parameters?sections=3D,bogus", ""},
+		{"POST", "/captures", `{"format":"avi"}`},
+	} {
+		if code := call(t, srv, tc.method, tc.path, tc.body, nil); code != http.StatusBadRequest {
+			t.Errorf("%s %s %s = %d, want 400", tc.method, tc.path, tc.body, code)
+		}
+	}
+}
+
+func TestCalibrationJob(t *testing.T) {
+	f, _, srv := startServer(t)
+
+	var job rest.Job
+	if code := call(t, srv, "POST", "/calibration", "", &job); code != http.StatusAccepted || job.ID == "" {
+		t.Fatalf("POST /calibration = %d %+v", code, job)
+	}
+
+	// The connection belongs to the job until calibration finishes.
+	if code := call(t, srv, "POST", "/trig", "", nil); code != http.StatusConflict {
+		t.Errorf("Trig during calibration = %d, want 409", code)
+	}
+	if code := call(t, srv, "GET", "/jobs/"+job.ID+"/result", "", nil); code != http.StatusConflict {
+		t.Errorf("result of a running job = %d, want 409", code)
+	}
+	// The state is still there to read.
+	var state struct{ State string }
+	if code := call(t, srv, "GET", "/state", "", &state); code != http.StatusOK || state.State != "Connected" {
+		t.Errorf("GET /state during calibration = %d %+v", code, state)
+	}
+	close(f.release)
+
+	waitForJob(t, srv, &job)
+	if job.Status != rest.JobDone || job.Finished == nil {
+		t.Fatalf("job = %+v", job)
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), "GET", srv.URL+"/jobs/"+job.ID+"/result", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	if string(b) != "<calibration/>" || resp.Header.Get("Content-Type") != "application/xml" {
+		t.Errorf("result = %q (%s)", b, resp.Header.Get("Content-Type"))
+	}
+
+	if code := call(t, srv, "GET", "/jobs/999", "", nil); code != http.StatusNotFound {
+		t.Errorf("unknown job = %d, want 404", code)
+	}
+}
+
+// waitForJob polls a job until it is no longer running.
+func waitForJob(t *testing.T, srv *httptest.Server, job *rest.Job) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for job.Status == rest.JobRunning {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s job never finished", job.Kind)
+		}
+		time.Sleep(5 * time.Millisecond)
+		call(t, srv, "GET", "/jobs/"+job.ID, "", job)
+	}
+}
+
+func TestCaptureJob(t *testing.T) {
+	_, api, srv := startServer(t)
+
+	var job rest.Job
+	if code := call(t, srv, "POST", "/captures", `{"format":"c3d"}`, &job); code != http.StatusAccepted {
+		t.Fatalf("POST /captures = %d %+v", code, job)
+	}
+	waitForJob(t, srv, &job)
+	if job.Status != rest.JobDone {
+		t.Fatalf("job = %+v", job)
+	}
+
+	// The file is served from disk, ranges included.
+	req, err := http.NewRequestWithContext(context.Background(), "GET", srv.URL+"/jobs/"+job.ID+"/result", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Range", "bytes=0-1")
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || string(b) != "\x00\x50" {
+		t.Errorf("first two bytes = %d %q", resp.StatusCode, b)
+	}
+	if files, _ := os.ReadDir(api.TempDir); len(files) != 1 {
+		t.Fatalf("%d files kept for one capture", len(files))
+	}
+
+	if err := api.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := os.ReadDir(api.TempDir); len(files) != 0 {
+		t.Errorf("%d files left after Close", len(files))
+	}
+}
@@ -0,0 +1,142 @@
+// Package rig describes the layout of a QTM camera system, where each camera
+// is, which way it looks and what it sees, and exports it for viewing and
+// planning coverage.
+//
+// The layout comes from the General and calibration settings together:
+//
+//	doc, err := rt.GetParameters(qualisys.ParameterTypeGeneral, qualisys.ParameterTypeCalibration)
+//	if err != nil { ... }
+//	r, err := rig.Parse(doc)
+//	if err != nil { ... }
+//	err = r.WriteGLTF(f, rig.DefaultDepth)
+//
+// Positions are in millimetres in the QTM lab coordinate system.
+package rig
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+
+	"github.com/mlveggo/qualisys-go/pkg/calibration"
+	"github.com/mlveggo/qualisys-go/pkg/settings"
+)
+
+// Camera is one camera of the rig. Cameras the calibration does not cover
+// have Calibrated false and no position.
+type Camera struct {
+	// ID is the camera's number in QTM, or 0 for a calibrated camera the
+	// General settings do not list.
+	ID         uint32     `json:"id"`
+	Serial     string     `json:"serial"`
+	Model      string     `json:"model,omitempty"`
+	Mode       string     `json:"mode,omitempty"`
+	Calibrated bool       `json:"calibrated"`
+	Position   [3]float64 `json:"position"`
+	// Rotation is the row-major matrix turning camera coordinates into lab
+	// coordinates, and Quaternion the same rotation as X, Y, Z, W.
+	Rotation   [9]float64 `json:"rotation"`
+	Quaternion [4]float64 `json:"quaternion"`
+	// FocalLength is the lens focal length in millimetres.
+	FocalLength float64 `json:"focalLength,omitempty"`
+	// Width and Height are the marker image size in pixels, and
+	// HorizontalFOV and VerticalFOV the angles it spans in degrees, zero
+	// when the calibration has no lens model for the camera.
+	Width         int     `json:"width,omitempty"`
+	Height        int     `json:"height,omitempty"`
+	HorizontalFOV float64 `json:"horizontalFov,omitempty"`
+	VerticalFOV   float64 `json:"verticalFov,omitempty"`
+}
+
+// Rig is the camera system as calibrated.
+type Rig struct {
+	// Calibrated is when the calibration was made, as QTM wrote it.
+	Calibrated string   `json:"calibrated,omitempty"`
+	Cameras    []Camera `json:"cameras"`
+}
+
+// New combines the General camera list with a calibration, matching cameras
+// by serial number. Cameras are in the order General lists them, followed by
+// any only the calibration has.
+func New(general settings.General, c *calibration.Calibration) *Rig {
+	r := &Rig{Calibrated: c.Created}
+	seen := map[string]bool{}
+	for _, gc := range general.Cameras {
+		cam := Camera{ID: gc.ID, Serial: gc.Serial, Model: gc.Model, Mode: gc.Mode}
+		if cc := c.Camera(gc.Serial); cc != nil {
+			cam.place(*cc)
+			seen[gc.Serial] = true
+		}
+		r.Cameras = append(r.Cameras, cam)
+	}
+	for _, cc := range c.Cameras {
+		if !seen[cc.Serial] {
+			cam := Camera{Serial: cc.Serial, Model: cc.Model}
+			cam.place(cc)
+			r.Cameras = append(r.Cameras, cam)
+		}
+	}
+	return r
+}
+
+// Parse builds the rig from a GetParameters reply holding both the General
+// and the calibration settings.
+func Parse(doc string) (*Rig, error) {
+	general, err := settings.ParseGeneralFromXML(doc)
+	if err != nil {
+		return nil, err
+	}
+	c, err := calibration.Parse(doc)
+	if err != nil {
+		return nil, err
+	}
+	return New(general, c), nil
+}
+
+// place fills in what the calibration knows of the camera.
+func (cam *Camera) place(cc calibration.Camera) {
+	if cam.Model == "" {
+		cam.Model = cc.Model
+	}
+	cam.Calibrated = cc.Calibrated
+	if !cc.Calibrated {
+		return
+	}
+	cam.Position = cc.Transform.Position()
+	cam.Rotation = cc.Transform.Matrix()
+	q := cc.Transform.Quaternion()
+	cam.Quaternion = [4]float64{float64(q.X), float64(q.Y), float64(q.Z), float64(q.W)}
+
+	in := cc.Intrinsic
+	cam.FocalLength = in.FocalLength
+	fov, full := cc.FOVMarker, cc.FOVMarkerMax
+	if fov.Right <= fov.Left || fov.Bottom <= fov.Top {
+		return
+	}
+	cam.Width, cam.Height = fov.Right-fov.Left+1, fov.Bottom-fov.Top+1
+	if full.Right <= full.Left || full.Bottom <= full.Top {
+		full = fov
+	}
+	if in.FocalLengthU <= 0 || in.FocalLengthV <= 0 {
+		return
+	}
+	// The lens model is in subpixels over the whole sensor; the marker
+	// image may be cropped from it.
+	scaleU := (in.SensorMaxU - in.SensorMinU) / float64(full.Right-full.Left+1)
+	scaleV := (in.SensorMaxV - in.SensorMinV) / float64(full.Bottom-full.Top+1)
+	cam.HorizontalFOV = angle(float64(cam.Width)*scaleU, in.FocalLengthU)
+	cam.VerticalFOV = angle(float64(cam.Height)*scaleV, in.FocalLengthV)
+}
+
+// angle returns the angle in degrees an image size spans at a focal length,
+// both in the same units, taking the principal point as centred.
+func angle(size, focal float64) float64 {
+	return 2 * math.Atan(size/2/focal) * 180 / math.Pi
+}
+
+// WriteJSON writes the rig as indented JSON.
+func (r *Rig) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
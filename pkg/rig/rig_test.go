@@ -0,0 +1,146 @@
+package rig_test
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/mlveggo/qualisys-go/pkg/rig"
+)
+
+// params is a GetParameters reply for General and Calibration. Camera 1 looks
+// straight down from 1 m with a 90 degree field of view both ways, camera 2
+// has no lens model and camera 3 was never calibrated.
+const params = `<QTM_Parameters_Ver_1.25>
+  <General>
+    <Frequency>100</Frequency>
+    <Camera><ID>1</ID><Model>Miqus M3</Model><Serial>28001</Serial><Mode>Marker</Mode></Camera>
+    <Camera><ID>2</ID><Model>Miqus M3</Model><Serial>28002</Serial><Mode>Marker</Mode></Camera>
+    <Camera><ID>3</ID><Model>Miqus Video</Model><Serial>28003</Serial><Mode>Video</Mode></Camera>
+  </General>
+  <calibration created="2026-10-19 08:15:02" type="regular" wandLength="601">
+    <cameras>
+      <camera active="1" calibrated="true" point-count="4000" avg-residual="0.4" serial="28001" model="Miqus M3">
+        <fov_marker left="0" top="0" right="1823" bottom="1087"/>
+        <fov_marker_max left="0" top="0" right="1823" bottom="1087"/>
+        <transform x="0" y="0" z="1000" r11="1" r12="0" r13="0" r21="0" r22="1" r23="0" r31="0" r32="0" r33="1"/>
+        <intrinsic focallength="8" sensorMinU="0" sensorMaxU="116736" sensorMinV="0" sensorMaxV="69632" focalLengthU="58368" focalLengthV="34816" centerPointU="58368" centerPointV="34816"/>
+      </camera>
+      <camera active="1" calibrated="true" point-count="3000" avg-residual="0.5" serial="28002" model="Miqus M3">
+        <transform x="2000" y="0" z="2500" r11="1" r12="0" r13="0" r21="0" r22="1" r23="0" r31="0" r32="0" r33="1"/>
+      </camera>
+    </cameras>
+  </calibration>
+</QTM_Parameters_Ver_1.25>`
+
+func TestParse(t *testing.T) {
+	r, err := rig.Parse(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Cameras) != 3 || r.Calibrated != "2026-10-19 08:15:02" {
+		t.Fatalf("rig %+v", r)
+	}
+	c := r.Cameras[0]
+	if c.ID != 1 || c.Mode != "Marker" || !c.Calibrated || c.Position != [3]float64{0, 0, 1000} ||
+		c.Width != 1824 || c.Height != 1088 || c.FocalLength != 8 {
+		t.Errorf("camera 1 %+v", c)
+	}
+	if math.Abs(c.HorizontalFOV-90) > 1e-9 || math.Abs(c.VerticalFOV-90) > 1e-9 {
+		t.Errorf("field of view %v x %v, want 90 x 90", c.HorizontalFOV, c.VerticalFOV)
+	}
+	if c.Quaternion != [4]float64{0, 0, 0, 1} {
+		t.Errorf("quaternion %v", c.Quaternion)
+	}
+	if c := r.Cameras[1]; !c.Calibrated || c.HorizontalFOV != 0 {
+		t.Errorf("camera 2 %+v", c)
+	}
+	if c := r.Cameras[2]; c.Calibrated || c.Model != "Miqus Video" {
+		t.Errorf("camera 3 %+v", c)
+	}
+	var b strings.Builder
+	if err := r.WriteJSON(&b); err != nil || !strings.Contains(b.String(), `"horizontalFov": 90`) {
+		t.Errorf("json %v:\n%s", err, b.String())
+	}
+}
+
+func TestWriteOBJ(t *testing.T) {
+	r, err := rig.Parse(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := r.WriteOBJ(&b, 1000); err != nil {
+		t.Fatal(err)
+	}
+	obj := b.String()
+	for _, s := range []string{
+		"o camera_1_28001\nv 0 0 1000\nv -1000 1000 0\n",
+		"l 1 2\n", "l 2 3 4 5 2\n",
+		"o camera_2_28002\nv 2000 0 2500\np 6\n",
+	} {
+		if !strings.Contains(obj, s) {
+			t.Errorf("OBJ lacks %q:\n%s", s, obj)
+		}
+	}
+	if strings.Contains(obj, "28003") {
+		t.Errorf("uncalibrated camera exported:\n%s", obj)
+	}
+}
+
+func TestWriteGLTF(t *testing.T) {
+	r, err := rig.Parse(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := r.WriteGLTF(&b, 1000); err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Nodes []struct {
+			Name     string `json:"name"`
+			Children []int  `json:"children"`
+			Mesh     *int   `json:"mesh"`
+			Camera   *int   `json:"camera"`
+		} `json:"nodes"`
+		Cameras []struct {
+			Perspective struct {
+				AspectRatio float64 `json:"aspectRatio"`
+				YFov        float64 `json:"yfov"`
+			} `json:"perspective"`
+		} `json:"cameras"`
+		Buffers []struct {
+			ByteLength int    `json:"byteLength"`
+			URI        string `json:"uri"`
+		} `json:"buffers"`
+	}
+	if err := json.Unmarshal([]byte(b.String()), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Nodes) != 3 || len(doc.Nodes[0].Children) != 2 || doc.Nodes[1].Mesh == nil || doc.Nodes[2].Mesh != nil {
+		t.Fatalf("nodes %+v", doc.Nodes)
+	}
+	if len(doc.Cameras) != 1 || math.Abs(doc.Cameras[0].Perspective.YFov-math.Pi/2) > 1e-9 ||
+		math.Abs(doc.Cameras[0].Perspective.AspectRatio-1) > 1e-9 {
+		t.Errorf("cameras %+v", doc.Cameras)
+	}
+	if len(doc.Buffers) != 1 || doc.Buffers[0].ByteLength != 5*12+16*2 {
+		t.Fatalf("buffers %+v", doc.Buffers)
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(doc.Buffers[0].URI, "data:application/octet-stream;base64,"))
+	if err != nil || len(data) != doc.Buffers[0].ByteLength {
+		t.Fatalf("buffer %d bytes, %v", len(data), err)
+	}
+	// The first corner, in camera coordinates.
+	var corner [3]float32
+	for i := range corner {
+		corner[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[12+4*i:]))
+	}
+	if corner != [3]float32{-1000, 1000, -1000} {
+		t.Errorf("corner %v", corner)
+	}
+}
@@ -0,0 +1,241 @@
+package rig
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+)
+
+// DefaultDepth is how far, in millimetres, the exported frustums reach: about
+// the far side of a lab-sized capture volume.
+const DefaultDepth = 5000
+
+// corners returns the four corners of the camera's view depth millimetres
+// out, in camera coordinates, or nil when the field of view is unknown.
+//
+// A camera looks along its local -Z axis with +Y up in the image, the
+// convention glTF cameras use as well.
+func (cam Camera) corners(depth float64) [][3]float64 {
+	if cam.HorizontalFOV == 0 || cam.VerticalFOV == 0 {
+		return nil
+	}
+	w := depth * math.Tan(cam.HorizontalFOV*math.Pi/360)
+	h := depth * math.Tan(cam.VerticalFOV*math.Pi/360)
+	return [][3]float64{{-w, h, -depth}, {w, h, -depth}, {w, -h, -depth}, {-w, -h, -depth}}
+}
+
+func (cam Camera) toLab(p [3]float64) [3]float64 {
+	r := cam.Rotation
+	var out [3]float64
+	for i := range 3 {
+		out[i] = r[i*3]*p[0] + r[i*3+1]*p[1] + r[i*3+2]*p[2] + cam.Position[i]
+	}
+	return out
+}
+
+// name labels the camera in exported scenes.
+func (cam Camera) name() string {
+	if cam.ID == 0 {
+		return "camera_" + cam.Serial
+	}
+	return fmt.Sprintf("camera_%d_%s", cam.ID, cam.Serial)
+}
+
+// WriteOBJ writes the calibrated cameras as a Wavefront OBJ file, one object
+// per camera drawn as lines from its position to the corners of its view
+// depth millimetres out. A camera with an unknown field of view is a point.
+// Coordinates are the lab's, in millimetres.
+func (r *Rig) WriteOBJ(w io.Writer, depth float64) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# QTM camera rig, calibrated %s\n# millimetres, lab coordinates\n", r.Calibrated)
+	next := 1
+	for _, cam := range r.Cameras {
+		if !cam.Calibrated {
+			continue
+		}
+		fmt.Fprintf(bw, "o %s\n", cam.name())
+		vs := [][3]float64{cam.Position}
+		for _, c := range cam.corners(depth) {
+			vs = append(vs, cam.toLab(c))
+		}
+		for _, v := range vs {
+			fmt.Fprintf(bw, "v %g %g %g\n", v[0], v[1], v[2])
+		}
+		if len(vs) == 1 {
+			fmt.Fprintf(bw, "p %d\n", next)
+		} else {
+			for i := 1; i <= 4; i++ {
+				fmt.Fprintf(bw, "l %d %d\n", next, next+i)
+			}
+			fmt.Fprintf(bw, "l %d %d %d %d %d\n", next+1, next+2, next+3, next+4, next+1)
+		}
+		next += len(vs)
+	}
+	return bw.Flush()
+}
+
+// The parts of glTF 2.0 the export uses.
+type (
+	gltf struct {
+		Asset       gltfAsset        `json:"asset"`
+		Scene       int              `json:"scene"`
+		Scenes      []gltfScene      `json:"scenes"`
+		Nodes       []gltfNode       `json:"nodes"`
+		Meshes      []gltfMesh       `json:"meshes,omitempty"`
+		Cameras     []gltfCamera     `json:"cameras,omitempty"`
+		Accessors   []gltfAccessor   `json:"accessors,omitempty"`
+		BufferViews []gltfBufferView `json:"bufferViews,omitempty"`
+		Buffers     []gltfBuffer     `json:"buffers,omitempty"`
+	}
+	gltfAsset struct {
+		Version   string `json:"version"`
+		Generator string `json:"generator,omitempty"`
+	}
+	gltfScene struct {
+		Name  string `json:"name,omitempty"`
+		Nodes []int  `json:"nodes"`
+	}
+	gltfNode struct {
+		Name        string      `json:"name,omitempty"`
+		Children    []int       `json:"children,omitempty"`
+		Translation *[3]float64 `json:"translation,omitempty"`
+		Rotation    *[4]float64 `json:"rotation,omitempty"`
+		Scale       *[3]float64 `json:"scale,omitempty"`
+		Mesh        *int        `json:"mesh,omitempty"`
+		Camera      *int        `json:"camera,omitempty"`
+	}
+	gltfMesh struct {
+		Name       string          `json:"name,omitempty"`
+		Primitives []gltfPrimitive `json:"primitives"`
+	}
+	gltfPrimitive struct {
+		Attributes map[string]int `json:"attributes"`
+		Indices    int            `json:"indices"`
+		Mode       int            `json:"mode"`
+	}
+	gltfCamera struct {
+		Type        string          `json:"type"`
+		Perspective gltfPerspective `json:"perspective"`
+	}
+	gltfPerspective struct {
+		AspectRatio float64 `json:"aspectRatio"`
+		YFov        float64 `json:"yfov"`
+		ZNear       float64 `json:"znear"`
+	}
+	gltfAccessor struct {
+		BufferView    int       `json:"bufferView"`
+		ByteOffset    int       `json:"byteOffset"`
+		ComponentType int       `json:"componentType"`
+		Count         int       `json:"count"`
+		Type          string    `json:"type"`
+		Min           []float32 `json:"min,omitempty"`
+		Max           []float32 `json:"max,omitempty"`
+	}
+	gltfBufferView struct {
+		Buffer     int `json:"buffer"`
+		ByteOffset int `json:"byteOffset"`
+		ByteLength int `json:"byteLength"`
+		Target     int `json:"target"`
+	}
+	gltfBuffer struct {
+		ByteLength int    `json:"byteLength"`
+		URI        string `json:"uri"`
+	}
+)
+
+const (
+	gltfFloat         = 5126
+	gltfUnsignedShort = 5123
+	gltfLines         = 1
+	gltfArrayBuffer   = 34962
+	gltfElementBuffer = 34963
+)
+
+// frustumLines are the vertex pairs of a frustum's edges: from the position
+// to each corner, then around the far rectangle.
+var frustumLines = []uint16{0, 1, 0, 2, 0, 3, 0, 4, 1, 2, 2, 3, 3, 4, 4, 1}
+
+// WriteGLTF writes the calibrated cameras as a glTF 2.0 scene with the
+// binary data embedded, so the one file opens in any glTF viewer. Each
+// camera is a node holding a perspective camera and a frustum reaching depth
+// millimetres, drawn as lines. A root node scales the lab's millimetres to
+// metres and turns its Z up to glTF's Y up.
+func (r *Rig) WriteGLTF(w io.Writer, depth float64) error {
+	doc := gltf{
+		Asset:  gltfAsset{Version: "2.0", Generator: "qualisys-go rig"},
+		Scenes: []gltfScene{{Name: "QTM rig", Nodes: []int{0}}},
+		Nodes: []gltfNode{{
+			Name:     "lab",
+			Rotation: &[4]float64{-math.Sqrt2 / 2, 0, 0, math.Sqrt2 / 2},
+			Scale:    &[3]float64{0.001, 0.001, 0.001},
+		}},
+	}
+	var positions, indices []byte
+	for _, cam := range r.Cameras {
+		if !cam.Calibrated {
+			continue
+		}
+		translation, rotation := cam.Position, cam.Quaternion
+		node := gltfNode{Name: cam.name(), Translation: &translation, Rotation: &rotation}
+		if corners := cam.corners(depth); corners != nil {
+			pos := gltfAccessor{
+				BufferView: 0, ByteOffset: len(positions), ComponentType: gltfFloat, Count: 5, Type: "VEC3",
+				Min: []float32{math.MaxFloat32, math.MaxFloat32, math.MaxFloat32},
+				Max: []float32{-math.MaxFloat32, -math.MaxFloat32, -math.MaxFloat32},
+			}
+			for _, v := range append([][3]float64{{0, 0, 0}}, corners...) {
+				for i, f := range v {
+					pos.Min[i] = min(pos.Min[i], float32(f))
+					pos.Max[i] = max(pos.Max[i], float32(f))
+					positions = binary.LittleEndian.AppendUint32(positions, math.Float32bits(float32(f)))
+				}
+			}
+			idx := gltfAccessor{
+				BufferView: 1, ByteOffset: len(indices), ComponentType: gltfUnsignedShort,
+				Count: len(frustumLines), Type: "SCALAR",
+			}
+			for _, i := range frustumLines {
+				indices = binary.LittleEndian.AppendUint16(indices, i)
+			}
+			doc.Accessors = append(doc.Accessors, pos, idx)
+			doc.Meshes = append(doc.Meshes, gltfMesh{
+				Name: cam.name(),
+				Primitives: []gltfPrimitive{{
+					Attributes: map[string]int{"POSITION": len(doc.Accessors) - 2},
+					Indices:    len(doc.Accessors) - 1,
+					Mode:       gltfLines,
+				}},
+			})
+			doc.Cameras = append(doc.Cameras, gltfCamera{
+				Type: "perspective",
+				Perspective: gltfPerspective{
+					AspectRatio: math.Tan(cam.HorizontalFOV*math.Pi/360) / math.Tan(cam.VerticalFOV*math.Pi/360),
+					YFov:        cam.VerticalFOV * math.Pi / 180,
+					ZNear:       0.01,
+				},
+			})
+			mesh, camera := len(doc.Meshes)-1, len(doc.Cameras)-1
+			node.Mesh, node.Camera = &mesh, &camera
+		}
+		doc.Nodes[0].Children = append(doc.Nodes[0].Children, len(doc.Nodes))
+		doc.Nodes = append(doc.Nodes, node)
+	}
+	if len(positions) > 0 {
+		buf := append(positions, indices...)
+		doc.BufferViews = []gltfBufferView{
+			{Buffer: 0, ByteOffset: 0, ByteLength: len(positions), Target: gltfArrayBuffer},
+			{Buffer: 0, ByteOffset: len(positions), ByteLength: len(indices), Target: gltfElementBuffer},
+		}
+		doc.Buffers = []gltfBuffer{{
+			ByteLength: len(buf),
+			URI:        "data:application/octet-stream;base64," + base64.StdEncoding.EncodeToString(buf),
+		}}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
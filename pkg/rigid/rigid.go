@@ -0,0 +1,254 @@
+// Package rigid fits rigid body poses to labeled markers on the client.
+//
+// A Body lists its points in local coordinates, as the 6D settings do. Fit
+// finds the rotation and translation that best map those points onto where
+// the markers were measured, in the least-squares sense, with Horn's closed
+// form quaternion method. A Solver applies it to every frame of a labeled 3D
+// stream, which tracks objects QTM has no 6DOF body for:
+//
+//	body := rigid.Body{Name: "Wand", Points: []rigid.Point{
+//		{Name: "WandTip", Position: packets.Point{}},
+//		{Name: "WandMid", Position: packets.Point{X: 120}},
+//		{Name: "WandSide", Position: packets.Point{Y: 60}},
+//	}}
+//	labels, _ := settings.Parse3DLabelsFromXML(xml)
+//	solver, _ := rigid.NewSolver(body, labels)
+//	...
+//	pose, err := solver.Solve(p.Data.Markers3D())
+package rigid
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/mlveggo/qualisys-go/pkg/packets"
+	"github.com/mlveggo/qualisys-go/pkg/settings"
+)
+
+var (
+	// ErrTooFewPoints is returned when fewer than three point pairs are
+	// available; a rotation needs at least three.
+	ErrTooFewPoints = errors.New("rigid: fewer than three points")
+	// ErrDegenerate is returned when the points lie on a line, leaving the
+	// rotation about that line undetermined.
+	ErrDegenerate = errors.New("rigid: points are collinear")
+)
+
+// Point is one marker of a body definition.
+type Point struct {
+	// Name is the marker's 3D label.
+	Name string
+	// Position is in the body's local coordinate system, in millimetres.
+	Position packets.Point
+}
+
+// Body is a rigid body definition.
+type Body struct {
+	Name   string
+	Points []Point
+	// MinMarkers is how many of the points must be measured for a pose; it
+	// is raised to three if lower.
+	MinMarkers int
+	// MaxResidual is the largest RMS fit residual, in millimetres, a pose
+	// may have. Zero means no limit.
+	MaxResidual float64
+}
+
+// BodyFromSettings converts a 6D settings body definition, including its
+// minimum marker count and maximum residual when they are set.
+func BodyFromSettings(b settings.Body) Body {
+	body := Body{Name: b.Name}
+	body.MinMarkers, _ = strconv.Atoi(strings.TrimSpace(b.MinimumMarkersInBody))
+	body.MaxResidual, _ = strconv.ParseFloat(strings.TrimSpace(b.MaximumResidual), 64)
+	for _, p := range b.Points.Points {
+		body.Points = append(body.Points, Point{
+			Name:     p.Name,
+			Position: packets.Point{X: float32(p.X), Y: float32(p.Y), Z: float32(p.Z)},
+		})
+	}
+	return body
+}
+
+// Point returns the index of the point with the given name, or -1.
+func (b Body) Point(name string) int {
+	for i, p := range b.Points {
+		if p.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Transform is a rigid motion: a point p maps to Rotation·p + Translation.
+type Transform struct {
+	// Rotation is row-major, like BodyMatrix.Rotation.
+	Rotation    [9]float64
+	Translation [3]float64
+}
+
+// Apply maps p through the transform.
+func (t Transform) Apply(p packets.Point) packets.Point {
+	v := [3]float64{float64(p.X), float64(p.Y), float64(p.Z)}
+	r := t.Rotation
+	return packets.Point{
+		X: float32(r[0]*v[0] + r[1]*v[1] + r[2]*v[2] + t.Translation[0]),
+		Y: float32(r[3]*v[0] + r[4]*v[1] + r[5]*v[2] + t.Translation[1]),
+		Z: float32(r[6]*v[0] + r[7]*v[1] + r[8]*v[2] + t.Translation[2]),
+	}
+}
+
+func vec(p packets.Point) [3]float64 {
+	return [3]float64{float64(p.X), float64(p.Y), float64(p.Z)}
+}
+
+func centroid(ps []packets.Point) [3]float64 {
+	var c [3]float64
+	for _, p := range ps {
+		v := vec(p)
+		for i := range c {
+			c[i] += v[i]
+		}
+	}
+	for i := range c {
+		c[i] /= float64(len(ps))
+	}
+	return c
+}
+
+func sub(a, b [3]float64) [3]float64 {
+	return [3]float64{a[0] - b[0], a[1] - b[1], a[2] - b[2]}
+}
+
+func norm(a [3]float64) float64 {
+	return math.Sqrt(a[0]*a[0] + a[1]*a[1] + a[2]*a[2])
+}
+
+func cross(a, b [3]float64) [3]float64 {
+	return [3]float64{a[1]*b[2] - a[2]*b[1], a[2]*b[0] - a[0]*b[2], a[0]*b[1] - a[1]*b[0]}
+}
+
+// collinear reports whether the points, relative to their centroid c, lie on
+// one line to within a millionth of their spread.
+func collinear(ps []packets.Point, c [3]float64) bool {
+	var far [3]float64
+	spread := 0.0
+	for _, p := range ps {
+		if d := sub(vec(p), c); norm(d) > spread {
+			far, spread = d, norm(d)
+		}
+	}
+	if spread == 0 {
+		return true
+	}
+	for _, p := range ps {
+		if norm(cross(far, sub(vec(p), c))) > 1e-6*spread*spread {
+			return false
+		}
+	}
+	return true
+}
+
+// Fit returns the transform that maps model[i] onto observed[i] with the least
+// sum of squared distances, and the RMS distance remaining, in millimetres.
+func Fit(model, observed []packets.Point) (Transform, float64, error) {
+	if len(model) != len(observed) || len(model) < 3 {
+		return Transform{}, 0, ErrTooFewPoints
+	}
+	cm, co := centroid(model), centroid(observed)
+	if collinear(model, cm) {
+		return Transform{}, 0, ErrDegenerate
+	}
+
+	// s[a][b] sums the products of coordinate a of the centred model and
+	// coordinate b of the centred observation.
+	var s [3][3]float64
+	for i := range model {
+		m, o := sub(vec(model[i]), cm), sub(vec(observed[i]), co)
+		for a := 0; a < 3; a++ {
+			for b := 0; b < 3; b++ {
+				s[a][b] += m[a] * o[b]
+			}
+		}
+	}
+	// Horn (1987): the optimal rotation is the unit quaternion (w, x, y, z)
+	// along the eigenvector of n with the largest eigenvalue.
+	n := [4][4]float64{
+		{s[0][0] + s[1][1] + s[2][2], s[1][2] - s[2][1], s[2][0] - s[0][2], s[0][1] - s[1][0]},
+		{s[1][2] - s[2][1], s[0][0] - s[1][1] - s[2][2], s[0][1] + s[1][0], s[2][0] + s[0][2]},
+		{s[2][0] - s[0][2], s[0][1] + s[1][0], -s[0][0] + s[1][1] - s[2][2], s[1][2] + s[2][1]},
+		{s[0][1] - s[1][0], s[2][0] + s[0][2], s[1][2] + s[2][1], -s[0][0] - s[1][1] + s[2][2]},
+	}
+	q := largestEigenvector(n)
+	w, x, y, z := q[0], q[1], q[2], q[3]
+
+	var t Transform
+	t.Rotation = [9]float64{
+		1 - 2*(y*y+z*z), 2 * (x*y - z*w), 2 * (x*z + y*w),
+		2 * (x*y + z*w), 1 - 2*(x*x+z*z), 2 * (y*z - x*w),
+		2 * (x*z - y*w), 2 * (y*z + x*w), 1 - 2*(x*x+y*y),
+	}
+	r := t.Rotation
+	for i := range 3 {
+		t.Translation[i] = co[i] - (r[3*i]*cm[0] + r[3*i+1]*cm[1] + r[3*i+2]*cm[2])
+	}
+
+	var sum float64
+	for i := range model {
+		d := sub(vec(t.Apply(model[i])), vec(observed[i]))
+		sum += d[0]*d[0] + d[1]*d[1] + d[2]*d[2]
+	}
+	return t, math.Sqrt(sum / float64(len(model))), nil
+}
+
+// largestEigenvector returns the unit eigenvector of the symmetric matrix a
+// with the largest eigenvalue, by cyclic Jacobi rotations.
+func largestEigenvector(a [4][4]float64) [4]float64 {
+	var v [4][4]float64
+	for i := range v {
+		v[i][i] = 1
+	}
+	for sweep := 0; sweep < 50; sweep++ {
+		off, diag := 0.0, 0.0
+		for p := 0; p < 4; p++ {
+			diag += a[p][p] * a[p][p]
+			for q := p + 1; q < 4; q++ {
+				off += a[p][q] * a[p][q]
+			}
+		}
+		if off <= 1e-24*diag {
+			break
+		}
+		for p := 0; p < 4; p++ {
+			for q := p + 1; q < 4; q++ {
+				if a[p][q] == 0 {
+					continue
+				}
+				theta := (a[q][q] - a[p][p]) / (2 * a[p][q])
+				t := math.Copysign(1, theta) / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				c := 1 / math.Sqrt(t*t+1)
+				s := t * c
+				for k := 0; k < 4; k++ {
+					akp, akq := a[k][p], a[k][q]
+					a[k][p], a[k][q] = c*akp-s*akq, s*akp+c*akq
+				}
+				for k := 0; k < 4; k++ {
+					apk, aqk := a[p][k], a[q][k]
+					a[p][k], a[q][k] = c*apk-s*aqk, s*apk+c*aqk
+				}
+				for k := 0; k < 4; k++ {
+					vkp, vkq := v[k][p], v[k][q]
+					v[k][p], v[k][q] = c*vkp-s*vkq, s*vkp+c*vkq
+				}
+			}
+		}
+	}
+	best := 0
+	for i := 1; i < 4; i++ {
+		if a[i][i] > a[best][best] {
+			best = i
+		}
+	}
+	return [4]float64{v[0][best], v[1][best], v[2][best], v[3][best]}
+}
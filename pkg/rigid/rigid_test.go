@@ -0,0 +1,150 @@
+package rigid_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/mlveggo/qualisys-go/pkg/packets"
+	"github.com/mlveggo/qualisys-go/pkg/rigid"
+	"github.com/mlveggo/qualisys-go/pkg/settings"
+)
+
+const settingsXML = `<QTM_Parameters_Ver_1.28><The_6D><Body>
+  <Name>Wand</Name>
+  <Points>
+    <Point X="0" Y="0" Z="0" Virtual="0" PhysicalId="0" Name="Tip"/>
+    <Point X="100" Y="0" Z="0" Virtual="0" PhysicalId="0" Name="Mid"/>
+    <Point X="0" Y="80" Z="0" Virtual="0" PhysicalId="0" Name="Side"/>
+    <Point X="0" Y="0" Z="50" Virtual="0" PhysicalId="0" Name="Top"/>
+  </Points>
+</Body></The_6D></QTM_Parameters_Ver_1.28>`
+
+func dist(a, b packets.Point) float64 {
+	dx, dy, dz := float64(a.X-b.X), float64(a.Y-b.Y), float64(a.Z-b.Z)
+	return math.Sqrt(dx*dx + dy*dy + dz*dz)
+}
+
+func TestFitRecoversPose(t *testing.T) {
+	bodies, err := settings.Parse6DBodiesFromXML(settingsXML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := rigid.BodyFromSettings(bodies[0])
+	if body.Name != "Wand" || len(body.Points) != 4 || body.Point("Side") != 2 ||
+		body.Points[2].Position != (packets.Point{Y: 80}) {
+		t.Fatalf("body = %+v", body)
+	}
+
+	// 120 degrees about (1, 1, 1), which cycles the axes, then a shift.
+	want := rigid.Transform{Rotation: [9]float64{0, 0, 1, 1, 0, 0, 0, 1, 0}, Translation: [3]float64{500, -20, 900}}
+	var model, observed []packets.Point
+	for _, p := range body.Points {
+		model = append(model, p.Position)
+		observed = append(observed, want.Apply(p.Position))
+	}
+	got, rms, err := rigid.Fit(model, observed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rms > 1e-3 {
+		t.Errorf("rms = %v", rms)
+	}
+	for i := range want.Rotation {
+		if math.Abs(got.Rotation[i]-want.Rotation[i]) > 1e-6 {
+			t.Fatalf("rotation = %v, want %v", got.Rotation, want.Rotation)
+		}
+	}
+	if p := got.Apply(packets.Point{X: 10, Y: 20, Z: 30}); dist(p, want.Apply(packets.Point{X: 10, Y: 20, Z: 30})) > 1e-3 {
+		t.Errorf("maps to %v", p)
+	}
+
+	// A marker off by 2 mm shows in the residual.
+	observed[1].X += 2
+	if _, rms, _ := rigid.Fit(model, observed); rms < 0.2 || rms > 2 {
+		t.Errorf("rms with a displaced marker = %v", rms)
+	}
+}
+
+func TestFitRejectsDegenerateInput(t *testing.T) {
+	two := []packets.Point{{}, {X: 1}}
+	if _, _, err := rigid.Fit(two, two); !errors.Is(err, rigid.ErrTooFewPoints) {
+		t.Errorf("two points: %v", err)
+	}
+	line := []packets.Point{{}, {X: 1}, {X: 5}}
+	if _, _, err := rigid.Fit(line, line); !errors.Is(err, rigid.ErrDegenerate) {
+		t.Errorf("collinear points: %v", err)
+	}
+}
+
+func TestSolver(t *testing.T) {
+	body := rigid.Body{Name: "Wand", MinMarkers: 3, MaxResidual: 1, Points: []rigid.Point{
+		{Name: "Tip", Position: packets.Point{}},
+		{Name: "Mid", Position: packets.Point{X: 100}},
+		{Name: "Side", Position: packets.Point{Y: 80}},
+		{Name: "Top", Position: packets.Point{Z: 50}},
+	}}
+	labels := []string{"Top", "Elsewhere", "Tip", "Side", "Mid"}
+	s, err := rigid.NewSolver(body, labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pose := rigid.Transform{Rotation: [9]float64{0, -1, 0, 1, 0, 0, 0, 0, 1}, Translation: [3]float64{10, 20, 30}}
+	frame := func() *packets.Component3D {
+		c := &packets.Component3D{Markers: make([]packets.Marker, len(labels))}
+		for l, name := range labels {
+			if i := body.Point(name); i >= 0 {
+				c.Markers[l].Point = pose.Apply(body.Points[i].Position)
+			} else {
+				c.Markers[l].Point = packets.Point{X: 9999}
+			}
+		}
+		return c
+	}
+
+	c := frame()
+	got, err := s.Solve(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Markers != 4 || got.Body.Point != (packets.Point{X: 10, Y: 20, Z: 30}) || got.Body.Residual > 1e-3 {
+		t.Errorf("pose = %+v", got)
+	}
+	for i, v := range got.Body.Rotation {
+		if math.Abs(float64(v)-pose.Rotation[i]) > 1e-5 {
+			t.Fatalf("rotation = %v", got.Body.Rotation)
+		}
+	}
+
+	// A mislabeled marker 20 mm off is left out rather than spoiling the
+	// pose.
+	c.Markers[0].Point.Z += 20
+	got, err = s.Solve(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Markers != 3 || !math.IsNaN(got.Residuals[3]) || math.Abs(float64(got.Body.Point.X-10)) > 1e-3 {
+		t.Errorf("pose with an outlier = %+v", got)
+	}
+
+	// With only two markers seen the body is untracked.
+	c = frame()
+	nan := float32(math.NaN())
+	c.Markers[0].Point = packets.Point{X: nan, Y: nan, Z: nan}
+	c.Markers[3].Point = packets.Point{X: nan, Y: nan, Z: nan}
+	if got, err := s.Solve(c); !errors.Is(err, rigid.ErrTooFewMarkers) || !math.IsNaN(float64(got.Body.Point.X)) {
+		t.Errorf("got %+v, %v", got.Body, err)
+	}
+
+	six := rigid.Solve6D([]*rigid.Solver{s, s}, frame())
+	if len(six.Bodies) != 2 || six.Bodies[1].Point != (packets.Point{X: 10, Y: 20, Z: 30}) {
+		t.Errorf("6D component = %+v", six)
+	}
+}
+
+func TestNewSolverNeedsLabels(t *testing.T) {
+	body := rigid.Body{Points: []rigid.Point{{Name: "A"}, {Name: "B", Position: packets.Point{X: 1}}, {Name: "C", Position: packets.Point{Y: 1}}}}
+	if _, err := rigid.NewSolver(body, []string{"A", "B"}); err == nil {
+		t.Error("a body with two labeled points was accepted")
+	}
+}
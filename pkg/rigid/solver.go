@@ -0,0 +1,165 @@
+package rigid
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/mlveggo/qualisys-go/pkg/packets"
+)
+
+var (
+	// ErrTooFewMarkers is returned when fewer of a body's markers are
+	// measured in a frame than its MinMarkers.
+	ErrTooFewMarkers = errors.New("rigid: too few markers measured")
+	// ErrResidual is returned when no fit of the measured markers stays
+	// within the body's MaxResidual.
+	ErrResidual = errors.New("rigid: residual above the maximum")
+)
+
+// Solver computes the pose of one body from the labeled markers of each
+// frame, like QTM's 6DOF tracking but on the client, so that bodies can be
+// defined without changing the QTM project. It is safe for concurrent use.
+type Solver struct {
+	Body Body
+	// index maps each body point to its position in the 3D label list, or
+	// -1 for points without a label.
+	index []int
+}
+
+// NewSolver prepares a solver for body against the 3D labels in stream
+// order, as returned by settings.Parse3DLabelsFromXML. Body points are
+// matched to labels by name; it is an error if fewer than the body's minimum
+// can be matched, since such a body could never be solved.
+func NewSolver(body Body, labels []string) (*Solver, error) {
+	s := &Solver{Body: body, index: make([]int, len(body.Points))}
+	matched := 0
+	for i, p := range body.Points {
+		s.index[i] = -1
+		for l, label := range labels {
+			if label == p.Name {
+				s.index[i] = l
+				matched++
+				break
+			}
+		}
+	}
+	if matched < s.minMarkers() {
+		return nil, fmt.Errorf("rigid: body %q: %d of its points are among the labels, %d needed",
+			body.Name, matched, s.minMarkers())
+	}
+	return s, nil
+}
+
+func (s *Solver) minMarkers() int {
+	return max(s.Body.MinMarkers, 3)
+}
+
+// Pose is a solved body pose.
+type Pose struct {
+	// Body holds the position of the body origin, the rotation from body to
+	// lab coordinates and the RMS residual of the fit, as QTM streams them.
+	Body packets.BodyMatrix
+	// Markers is how many markers the fit used.
+	Markers int
+	// Residuals is the distance, per body point, between the measured
+	// marker and where the pose places it. It is NaN for points that were
+	// not measured or were left out as outliers.
+	Residuals []float64
+}
+
+// Solve fits the body to the markers of c, which must be a labeled 3D
+// component. When the residual exceeds MaxResidual the marker fitting worst
+// is left out and the fit repeated, as long as MinMarkers remain. When no pose
+// can be found, the returned Body is NaN, the way QTM streams an untracked
+// body, and the error says why.
+func (s *Solver) Solve(c *packets.Component3D) (Pose, error) {
+	pose := Pose{Body: untracked(), Residuals: make([]float64, len(s.Body.Points))}
+	for i := range pose.Residuals {
+		pose.Residuals[i] = math.NaN()
+	}
+	if c == nil {
+		return pose, ErrTooFewMarkers
+	}
+
+	var used []int
+	for i, l := range s.index {
+		if l >= 0 && l < len(c.Markers) && !missing(c.Markers[l].Point) {
+			used = append(used, i)
+		}
+	}
+	for {
+		if len(used) < s.minMarkers() {
+			return pose, ErrTooFewMarkers
+		}
+		model := make([]packets.Point, len(used))
+		observed := make([]packets.Point, len(used))
+		for j, i := range used {
+			model[j] = s.Body.Points[i].Position
+			observed[j] = c.Markers[s.index[i]].Point
+		}
+		t, rms, err := Fit(model, observed)
+		if err != nil {
+			return pose, err
+		}
+
+		worst := 0
+		residuals := make([]float64, len(used))
+		for j := range used {
+			residuals[j] = norm(sub(vec(t.Apply(model[j])), vec(observed[j])))
+			if residuals[j] > residuals[worst] {
+				worst = j
+			}
+		}
+		if s.Body.MaxResidual > 0 && rms > s.Body.MaxResidual {
+			if len(used) == s.minMarkers() {
+				return pose, ErrResidual
+			}
+			used = append(used[:worst], used[worst+1:]...)
+			continue
+		}
+
+		pose.Markers = len(used)
+		for j, i := range used {
+			pose.Residuals[i] = residuals[j]
+		}
+		pose.Body.Point = packets.Point{
+			X: float32(t.Translation[0]),
+			Y: float32(t.Translation[1]),
+			Z: float32(t.Translation[2]),
+		}
+		for i, v := range t.Rotation {
+			pose.Body.Rotation[i] = float32(v)
+		}
+		pose.Body.Residual = float32(rms)
+		return pose, nil
+	}
+}
+
+func missing(p packets.Point) bool {
+	return math.IsNaN(float64(p.X)) || math.IsNaN(float64(p.Y)) || math.IsNaN(float64(p.Z))
+}
+
+func untracked() packets.BodyMatrix {
+	nan := float32(math.NaN())
+	b := packets.BodyMatrix{Point: packets.Point{X: nan, Y: nan, Z: nan}, Residual: nan}
+	for i := range b.Rotation {
+		b.Rotation[i] = nan
+	}
+	return b
+}
+
+// Solve6D solves every body for one frame and returns them as a 6DOF
+// component, in solver order, so code written for QTM's own 6DOF stream can
+// consume client-side bodies unchanged. Bodies that cannot be solved are NaN.
+func Solve6D(solvers []*Solver, c *packets.Component3D) *packets.Component6DResidual {
+	out := &packets.Component6DResidual{Bodies: make([]packets.BodyMatrix, len(solvers))}
+	if c != nil {
+		out.Droprate, out.OutOfSyncRate = c.Droprate, c.OutOfSyncRate
+	}
+	for i, s := range solvers {
+		pose, _ := s.Solve(c)
+		out.Bodies[i] = pose.Body
+	}
+	return out
+}
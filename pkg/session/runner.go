@@ -0,0 +1,297 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/pkg/settings"
+)
+
+// Entry records one attempt at a step.
+type Entry struct {
+	Time time.Time
+	// Step is the 1-based position of the step in Steps, or in OnError when
+	// OnError is set.
+	Step    int
+	OnError bool
+	Action  Action
+	Attempt int
+	// Detail says what the step did, such as the expanded path or the event
+	// that ended a wait.
+	Detail  string
+	Elapsed time.Duration
+	Err     error
+}
+
+func (e Entry) String() string {
+	var b strings.Builder
+	b.WriteString(e.Time.Format("15:04:05.000"))
+	if e.OnError {
+		fmt.Fprintf(&b, " onError %d", e.Step)
+	} else {
+		fmt.Fprintf(&b, " step %d", e.Step)
+	}
+	fmt.Fprintf(&b, " %s", e.Action)
+	if e.Attempt > 1 {
+		fmt.Fprintf(&b, " (attempt %d)", e.Attempt)
+	}
+	if e.Detail != "" {
+		fmt.Fprintf(&b, " %s", e.Detail)
+	}
+	fmt.Fprintf(&b, " in %v", e.Elapsed.Round(time.Millisecond))
+	if e.Err != nil {
+		fmt.Fprintf(&b, ": %v", e.Err)
+	}
+	return b.String()
+}
+
+// Runner executes scripts.
+type Runner struct {
+	// Vars are the variables paths and labels may name, such as
+	// participant and trial.
+	Vars map[string]string
+	// Dir is the directory relative setParameters and download paths are
+	// taken from, usually the script's own. Paths on the QTM machine, for
+	// loadProject, load and save, are passed to QTM as they are.
+	Dir string
+	// Log, when set, is called with every entry as it happens.
+	Log func(Entry)
+}
+
+var variable = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+
+// expand replaces the {name} variables in s.
+func expand(s string, vars map[string]string) (string, error) {
+	var missing []string
+	out := variable.ReplaceAllStringFunc(s, func(m string) string {
+		name := m[1 : len(m)-1]
+		v, ok := vars[name]
+		if !ok {
+			missing = append(missing, name)
+		}
+		return v
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("undefined variable %s in %q", strings.Join(missing, ", "), s)
+	}
+	return out, nil
+}
+
+// Run executes the script's steps in order on rt, which must be connected,
+// retrying a failing step as the script allows. When a step fails for good,
+// or ctx is cancelled, Run runs the OnError steps and returns the step's
+// error. The entries record every attempt, including those of OnError.
+func (r *Runner) Run(ctx context.Context, rt *qualisys.Protocol, s *Script) ([]Entry, error) {
+	start := time.Now()
+	vars := map[string]string{
+		"session": s.Name,
+		"date":    start.Format("2006-01-02"),
+		"time":    start.Format("150405"),
+	}
+	for k, v := range r.Vars {
+		vars[k] = v
+	}
+	run := &run{Runner: r, rt: rt, script: s, vars: vars}
+
+	for i, st := range s.Steps {
+		err := run.step(ctx, i+1, false, st)
+		if err == nil {
+			continue
+		}
+		err = fmt.Errorf("session: step %d (%s): %w", i+1, st.Action, err)
+		// The cleanup runs even when the operator cancelled the session,
+		// which is when it matters most.
+		cleanup := context.WithoutCancel(ctx)
+		for j, st := range s.OnError {
+			_ = run.step(cleanup, j+1, true, st)
+		}
+		return run.entries, err
+	}
+	return run.entries, nil
+}
+
+type run struct {
+	*Runner
+	rt      *qualisys.Protocol
+	script  *Script
+	vars    map[string]string
+	entries []Entry
+}
+
+func (r *run) log(e Entry) {
+	r.entries = append(r.entries, e)
+	if r.Log != nil {
+		r.Log(e)
+	}
+}
+
+// step runs one step with its retries. It returns nil for a failure the step
+// is allowed to continue past.
+func (r *run) step(ctx context.Context, n int, onError bool, st Step) error {
+	retries := r.script.Retries
+	if st.Retries != nil {
+		retries = *st.Retries
+	}
+	if onError {
+		retries = 0
+	}
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		detail, err := r.do(ctx, st)
+		r.log(Entry{
+			Time: start, Step: n, OnError: onError, Action: st.Action, Attempt: attempt,
+			Detail: detail, Elapsed: time.Since(start), Err: err,
+		})
+		if err == nil || st.Action == ActionReleaseControl {
+			return nil
+		}
+		// Retrying on a Protocol that disconnected, as a broken download
+		// leaves it, could only fail again with ErrNotConnected.
+		var permanent permanentError
+		if attempt > retries || ctx.Err() != nil || errors.As(err, &permanent) || !r.rt.IsConnected() {
+			if st.ContinueOnError && ctx.Err() == nil {
+				return nil
+			}
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(r.script.RetryDelay)):
+		}
+	}
+}
+
+// permanentError is a failure retrying cannot fix, such as a script naming a
+// variable the run was not given.
+type permanentError struct{ error }
+
+func (e permanentError) Unwrap() error { return e.error }
+
+// localPath expands a path on this machine and makes it relative to Dir.
+func (r *run) localPath(p string) (string, error) {
+	p, err := expand(p, r.vars)
+	if err != nil {
+		return "", permanentError{err}
+	}
+	if r.Dir != "" && !filepath.IsAbs(p) {
+		p = filepath.Join(r.Dir, p)
+	}
+	return p, nil
+}
+
+// remotePath expands a path on the QTM machine.
+func (r *run) remotePath(p string) (string, error) {
+	p, err := expand(p, r.vars)
+	if err != nil {
+		return "", permanentError{err}
+	}
+	return p, nil
+}
+
+// do performs a step once, returning what to log about it.
+func (r *run) do(ctx context.Context, st Step) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	rt := r.rt
+	switch st.Action {
+	case ActionTakeControl:
+		return "", rt.TakeControl(r.script.Password)
+	case ActionReleaseControl:
+		return "", rt.ReleaseControl()
+	case ActionNew:
+		return "", rt.New()
+	case ActionClose:
+		return "", rt.Close()
+	case ActionStart:
+		return "", rt.Start(st.RTFromFile)
+	case ActionStop:
+		return "", rt.Stop()
+	case ActionTrig:
+		return "", rt.Trig()
+	case ActionLoadProject, ActionLoad, ActionSave:
+		p, err := r.remotePath(st.Path)
+		if err != nil {
+			return "", err
+		}
+		switch st.Action {
+		case ActionLoadProject:
+			return p, rt.LoadProject(p)
+		case ActionLoad:
+			return p, rt.Load(p)
+		}
+		return p, rt.Save(p, st.Overwrite)
+	case ActionEvent:
+		label, err := expand(st.Label, r.vars)
+		if err != nil {
+			return "", permanentError{err}
+		}
+		return label, rt.SetQTMEvent(label)
+	case ActionSetParameters:
+		p, err := r.localPath(st.Path)
+		if err != nil {
+			return "", err
+		}
+		doc, err := os.ReadFile(p)
+		if err != nil {
+			return p, permanentError{err}
+		}
+		inner, err := settings.Fragment(string(doc))
+		if err != nil {
+			return p, permanentError{err}
+		}
+		return p, rt.SetParameters(inner)
+	case ActionDownloadC3D, ActionDownloadQTM:
+		p, err := r.localPath(st.Path)
+		if err != nil {
+			return "", err
+		}
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			return p, err
+		}
+		// The download goes through a temporary file renamed into place, and
+		// cancelling the session ends it rather than waiting for a capture
+		// that can run to gigabytes.
+		download := rt.DownloadCaptureQTMFile
+		if st.Action == ActionDownloadC3D {
+			download = rt.DownloadCaptureC3DFile
+		}
+		_, err = download(ctx, p, qualisys.DownloadOptions{})
+		return p, err
+	case ActionWait:
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(time.Duration(st.Duration)):
+			return "", nil
+		}
+	case ActionWaitForEvent:
+		want := make([]qualisys.EventType, len(st.Events))
+		for i, name := range st.Events {
+			e, err := qualisys.ParseEventType(name)
+			if err != nil {
+				return "", permanentError{err}
+			}
+			want[i] = e
+		}
+		if st.Timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(st.Timeout))
+			defer cancel()
+		}
+		e, err := rt.WaitForEvent(ctx, want...)
+		if err != nil {
+			return "", err
+		}
+		return e.Type.String(), nil
+	}
+	return "", permanentError{fmt.Errorf("unknown action %q", st.Action)}
+}
@@ -0,0 +1,213 @@
+// Package session runs scripted capture sessions: the same sequence of QTM
+// commands for every participant, described in a JSON file rather than a shell
+// script.
+//
+//	{
+//	  "name": "gait",
+//	  "password": "secret",
+//	  "retries": 2,
+//	  "retryDelay": "1s",
+//	  "steps": [
+//	    {"action": "loadProject", "path": "C:\\Projects\\Gait"},
+//	    {"action": "takeControl"},
+//	    {"action": "new"},
+//	    {"action": "setParameters", "path": "gait-settings.xml"},
+//	    {"action": "waitForEvent", "events": ["Trigger"], "timeout": "5m"},
+//	    {"action": "start"},
+//	    {"action": "wait", "duration": "10s"},
+//	    {"action": "stop"},
+//	    {"action": "save", "path": "{participant}_{trial}.qtm", "overwrite": true},
+//	    {"action": "downloadC3D", "path": "c3d/{participant}_{trial}.c3d"},
+//	    {"action": "releaseControl"}
+//	  ],
+//	  "onError": [
+//	    {"action": "stop"},
+//	    {"action": "releaseControl"}
+//	  ]
+//	}
+//
+// Paths and labels may name variables in braces: those the Runner is given,
+// and the built-ins {session} for the script name, {date} as 2006-01-02 and
+// {time} as 150405, both taken when the run starts.
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+)
+
+// ErrInvalid is returned for a script that cannot be run, such as one naming
+// an unknown action or leaving out a step's path.
+var ErrInvalid = errors.New("session: invalid script")
+
+// Action names a step's operation.
+type Action string
+
+const (
+	// ActionLoadProject loads the QTM project at Path.
+	ActionLoadProject Action = "loadProject"
+	ActionTakeControl Action = "takeControl"
+	// ActionReleaseControl gives control back. Its errors are only logged,
+	// since there is no way to be more released.
+	ActionReleaseControl Action = "releaseControl"
+	ActionNew            Action = "new"
+	ActionClose          Action = "close"
+	// ActionLoad opens the measurement at Path.
+	ActionLoad Action = "load"
+	// ActionSetParameters applies the settings document at Path, as saved
+	// by GetParameters or written as QTM_Settings.
+	ActionSetParameters Action = "setParameters"
+	// ActionStart starts a measurement, or RT from file when RTFromFile is
+	// set.
+	ActionStart Action = "start"
+	ActionStop  Action = "stop"
+	// ActionSave saves the measurement in QTM as Path, replacing an existing
+	// file when Overwrite is set.
+	ActionSave Action = "save"
+	// ActionDownloadC3D and ActionDownloadQTM download the current capture
+	// to Path on this machine, creating its directory as needed. The file
+	// only appears at Path once complete, and cancelling the run ends the
+	// download. A broken download disconnects, so it is not retried.
+	ActionDownloadC3D Action = "downloadC3D"
+	ActionDownloadQTM Action = "downloadQTM"
+	ActionTrig        Action = "trig"
+	// ActionEvent inserts an event named Label into the measurement.
+	ActionEvent Action = "event"
+	// ActionWait pauses for Duration.
+	ActionWait Action = "wait"
+	// ActionWaitForEvent waits up to Timeout, or forever when it is zero,
+	// for QTM to send one of Events. Events that arrived during the previous
+	// command count, so start followed by a wait for CaptureStarted works.
+	ActionWaitForEvent Action = "waitForEvent"
+)
+
+// Duration is a time.Duration written in JSON as a string such as "1m30s".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"10s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Step is one operation of a script. Which fields apply depends on Action.
+type Step struct {
+	Action     Action   `json:"action"`
+	Path       string   `json:"path,omitempty"`
+	Overwrite  bool     `json:"overwrite,omitempty"`
+	RTFromFile bool     `json:"rtFromFile,omitempty"`
+	Label      string   `json:"label,omitempty"`
+	Events     []string `json:"events,omitempty"`
+	Duration   Duration `json:"duration,omitempty"`
+	Timeout    Duration `json:"timeout,omitempty"`
+	// Retries overrides the script's retry count for this step.
+	Retries *int `json:"retries,omitempty"`
+	// ContinueOnError logs the step's failure and carries on instead of
+	// aborting the session.
+	ContinueOnError bool `json:"continueOnError,omitempty"`
+}
+
+// Script is a parsed session description.
+type Script struct {
+	Name string `json:"name,omitempty"`
+	// Password is passed to TakeControl.
+	Password string `json:"password,omitempty"`
+	// Retries is how many times a failing step is tried again before the
+	// session aborts; RetryDelay is the pause before each retry.
+	Retries    int      `json:"retries,omitempty"`
+	RetryDelay Duration `json:"retryDelay,omitempty"`
+	Steps      []Step   `json:"steps"`
+	// OnError runs, best effort, after a step has failed for good, to leave
+	// QTM in a known state.
+	OnError []Step `json:"onError,omitempty"`
+}
+
+// Parse reads a script and checks every step has what its action needs.
+// Unknown fields are errors, so a misspelt option does not silently do
+// nothing.
+func Parse(r io.Reader) (*Script, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	var s Script
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if s.Retries < 0 {
+		return nil, fmt.Errorf("%w: negative retries", ErrInvalid)
+	}
+	for i, st := range s.Steps {
+		if err := st.validate(); err != nil {
+			return nil, fmt.Errorf("%w: step %d: %v", ErrInvalid, i+1, err)
+		}
+	}
+	for i, st := range s.OnError {
+		if err := st.validate(); err != nil {
+			return nil, fmt.Errorf("%w: onError step %d: %v", ErrInvalid, i+1, err)
+		}
+	}
+	return &s, nil
+}
+
+// Load parses the script in the named file.
+func Load(path string) (*Script, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+func (s Step) validate() error {
+	if s.Retries != nil && *s.Retries < 0 {
+		return fmt.Errorf("%s: negative retries", s.Action)
+	}
+	switch s.Action {
+	case ActionTakeControl, ActionReleaseControl, ActionNew, ActionClose,
+		ActionStart, ActionStop, ActionTrig:
+	case ActionLoadProject, ActionLoad, ActionSetParameters, ActionSave,
+		ActionDownloadC3D, ActionDownloadQTM:
+		if s.Path == "" {
+			return fmt.Errorf("%s needs a path", s.Action)
+		}
+	case ActionEvent:
+		if s.Label == "" {
+			return fmt.Errorf("%s needs a label", s.Action)
+		}
+	case ActionWait:
+		if s.Duration <= 0 {
+			return fmt.Errorf("%s needs a duration", s.Action)
+		}
+	case ActionWaitForEvent:
+		if len(s.Events) == 0 {
+			return fmt.Errorf("%s needs events", s.Action)
+		}
+		for _, e := range s.Events {
+			if _, err := qualisys.ParseEventType(e); err != nil {
+				return fmt.Errorf("%s: %w", s.Action, err)
+			}
+		}
+	case "":
+		return errors.New("missing action")
+	default:
+		return fmt.Errorf("unknown action %q", s.Action)
+	}
+	return nil
+}
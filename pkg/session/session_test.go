@@ -0,0 +1,233 @@
+package session_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/internal/qtmtest"
+	"github.com/mlveggo/qualisys-go/pkg/session"
+)
+
+// fakeQTM answers the commands a capture session sends and records them. The
+// first failNew New commands are refused.
+type fakeQTM struct {
+	mu       sync.Mutex
+	commands []string
+	failNew  int
+}
+
+func (f *fakeQTM) received() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.commands)
+}
+
+func (f *fakeQTM) reply(conn net.Conn, cmd string) []byte {
+	f.mu.Lock()
+	f.commands = append(f.commands, cmd)
+	failNew := cmd == "New" && f.failNew > 0
+	if failNew {
+		f.failNew--
+	}
+	f.mu.Unlock()
+
+	switch {
+	case cmd == "TakeControl secret":
+		return qtmtest.Text(qualisys.PacketTypeCommand, "You are now master")
+	case cmd == "ReleaseControl":
+		return qtmtest.Text(qualisys.PacketTypeCommand, "You are now a regular client")
+	case failNew:
+		return qtmtest.Text(qualisys.PacketTypeError, "Measurement in progress")
+	case cmd == "New":
+		return qtmtest.Text(qualisys.PacketTypeCommand, "Creating new connection")
+	case cmd == "Trig":
+		// The trigger event follows the reply, as from a real trigger
+		// input a moment later.
+		return append(qtmtest.Text(qualisys.PacketTypeCommand, "Trig ok"), qtmtest.Event(qualisys.EventTypeTrigger)...)
+	case cmd == "Start":
+		return append(qtmtest.Text(qualisys.PacketTypeCommand, "Starting measurement"), qtmtest.Event(qualisys.EventTypeCaptureStarted)...)
+	case cmd == "Stop":
+		return qtmtest.Text(qualisys.PacketTypeCommand, "Stopping measurement")
+	case strings.HasPrefix(cmd, "Save "):
+		return qtmtest.Text(qualisys.PacketTypeCommand, "Measurement saved")
+	case cmd == "GetCaptureC3D":
+		// The connection breaks a hundred bytes into the file.
+		file := qtmtest.Packet(qualisys.PacketTypeC3DFile, make([]byte, 1000))[:100]
+		_, _ = conn.Write(append(qtmtest.Text(qualisys.PacketTypeCommand, "Sending capture"), file...))
+		conn.Close()
+		return nil
+	}
+	return qtmtest.Text(qualisys.PacketTypeError, "Parse error")
+}
+
+func connect(t *testing.T, f *fakeQTM) *qualisys.Protocol {
+	t.Helper()
+	return qtmtest.Connect(t, qtmtest.Listen(t, f.reply))
+}
+
+func parse(t *testing.T, script string) *session.Script {
+	t.Helper()
+	s, err := session.Parse(strings.NewReader(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestRunCaptureSession(t *testing.T) {
+	f := &fakeQTM{failNew: 1}
+	rt := connect(t, f)
+	s := parse(t, `{
+		"name": "gait",
+		"password": "secret",
+		"retries": 1,
+		"retryDelay": "1ms",
+		"steps": [
+			{"action": "takeControl"},
+			{"action": "new"},
+			{"action": "trig"},
+			{"action": "waitForEvent", "events": ["Trigger"], "timeout": "2s"},
+			{"action": "start"},
+			{"action": "wait", "duration": "10ms"},
+			{"action": "stop"},
+			{"action": "save", "path": "{session}_{participant}_{trial}.qtm", "overwrite": true},
+			{"action": "releaseControl"}
+		]
+	}`)
+
+	var logged []string
+	r := &session.Runner{
+		Vars: map[string]string{"participant": "P01", "trial": "3"},
+		Log:  func(e session.Entry) { logged = append(logged, e.String()) },
+	}
+	entries, err := r.Run(context.Background(), rt, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"TakeControl secret", "New", "New", "Trig", "Start", "Stop",
+		"Save gait_P01_3.qtm Overwrite", "ReleaseControl",
+	}
+	if got := f.received(); !slices.Equal(got, want) {
+		t.Errorf("commands %q, want %q", got, want)
+	}
+	if len(entries) != 10 || len(logged) != len(entries) {
+		t.Fatalf("%d entries, %d logged:\n%s", len(entries), len(logged), strings.Join(logged, "\n"))
+	}
+	if e := entries[1]; e.Err == nil || !errors.Is(e.Err, qualisys.ErrRejected) {
+		t.Errorf("first New: %v", e.Err)
+	}
+	if e := entries[2]; e.Action != session.ActionNew || e.Attempt != 2 || e.Err != nil {
+		t.Errorf("retried New: %+v", e)
+	}
+	if e := entries[4]; e.Detail != "Trigger" {
+		t.Errorf("wait ended with %q", e.Detail)
+	}
+	if !strings.Contains(logged[2], "step 2 new (attempt 2)") {
+		t.Errorf("log line %q", logged[2])
+	}
+}
+
+func TestRunAbortsAndRunsOnError(t *testing.T) {
+	f := &fakeQTM{}
+	rt := connect(t, f)
+	s := parse(t, `{
+		"password": "secret",
+		"retries": 3,
+		"steps": [
+			{"action": "takeControl"},
+			{"action": "start"},
+			{"action": "save", "path": "{participant}.qtm"},
+			{"action": "releaseControl"}
+		],
+		"onError": [
+			{"action": "stop"},
+			{"action": "releaseControl"}
+		]
+	}`)
+	entries, err := (&session.Runner{}).Run(context.Background(), rt, s)
+	if err == nil || !strings.Contains(err.Error(), "step 3 (save)") || !strings.Contains(err.Error(), "participant") {
+		t.Fatalf("err = %v", err)
+	}
+	// An undefined variable is not worth retrying.
+	want := []string{"TakeControl secret", "Start", "Stop", "ReleaseControl"}
+	if got := f.received(); !slices.Equal(got, want) {
+		t.Errorf("commands %q, want %q", got, want)
+	}
+	if last := entries[len(entries)-1]; !last.OnError || last.Action != session.ActionReleaseControl {
+		t.Errorf("last entry %+v", last)
+	}
+}
+
+func TestRunWaitForEventTimesOut(t *testing.T) {
+	rt := connect(t, &fakeQTM{})
+	s := parse(t, `{"steps": [{"action": "waitForEvent", "events": ["CaptureStopped"], "timeout": "50ms"}]}`)
+	_, err := (&session.Runner{}).Run(context.Background(), rt, s)
+	if !errors.Is(err, qualisys.ErrTimeout) {
+		t.Errorf("err = %v, want ErrTimeout", err)
+	}
+}
+
+func TestRunDoesNotRetryBrokenDownload(t *testing.T) {
+	dir := t.TempDir()
+	f := &fakeQTM{}
+	rt := connect(t, f)
+	s := parse(t, `{"retries": 2, "steps": [{"action": "downloadC3D", "path": "walk.c3d"}]}`)
+	entries, err := (&session.Runner{Dir: dir}).Run(context.Background(), rt, s)
+	if !errors.Is(err, qualisys.ErrTruncated) {
+		t.Fatalf("err = %v, want ErrTruncated", err)
+	}
+	// The truncated download disconnected the Protocol; retrying could only
+	// fail with ErrNotConnected.
+	if len(entries) != 1 {
+		t.Errorf("%d attempts, want 1: %+v", len(entries), entries)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("%d files left by a failed download", len(files))
+	}
+}
+
+func TestRunLocalPathsAreRelativeToDir(t *testing.T) {
+	dir := t.TempDir()
+	settings := filepath.Join(dir, "settings.xml")
+	if err := os.WriteFile(settings, []byte("<QTM_Settings><General/></QTM_Settings>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeQTM{}
+	rt := connect(t, f)
+	s := parse(t, `{"steps": [{"action": "setParameters", "path": "settings.xml", "continueOnError": true}]}`)
+	entries, err := (&session.Runner{Dir: dir}).Run(context.Background(), rt, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The fake refuses settings, but the file was found and sent.
+	if len(entries) != 1 || entries[0].Detail != settings || !errors.Is(entries[0].Err, qualisys.ErrRejected) {
+		t.Errorf("entries %+v", entries)
+	}
+	if got := f.received(); len(got) != 1 || got[0] != "<QTM_Settings><General/></QTM_Settings>" {
+		t.Errorf("sent %q", got)
+	}
+}
+
+func TestParseRejectsBadScripts(t *testing.T) {
+	for _, script := range []string{
+		`{"steps": [{"action": "save"}]}`,
+		`{"steps": [{"action": "dance"}]}`,
+		`{"steps": [{"action": "new", "overwite": true}]}`,
+		`{"steps": [{"action": "waitForEvent", "events": ["CaptureExploded"]}]}`,
+		`{"steps": [{"action": "wait", "duration": 10}]}`,
+		`{"steps": [], "onError": [{}]}`,
+	} {
+		if _, err := session.Parse(strings.NewReader(script)); !errors.Is(err, session.ErrInvalid) {
+			t.Errorf("Parse(%s) = %v, want ErrInvalid", script, err)
+		}
+	}
+}
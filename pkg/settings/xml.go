@@ -1,11 +1,37 @@
 package settings
 
-import "encoding/xml"
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+)
 
 type QXml struct {
 	// XMLName xml.Name `xml:"QTM_Parameters_Ver_1.22"`
-	Q6DXml Q6DXml `xml:"The_6D"`
-	Q3DXml Q3DXml `xml:"The_3D"`
+	General   General   `xml:"General"`
+	Q6DXml    Q6DXml    `xml:"The_6D"`
+	Q3DXml    Q3DXml    `xml:"The_3D"`
+	Skeletons Skeletons `xml:"Skeletons"`
+	Analog    Analog    `xml:"Analog"`
+	Force     Force     `xml:"Force"`
+	Image     Image     `xml:"Image"`
+}
+
+// General holds the parts of the General settings the SDK uses. Frequency is
+// the camera capture rate in Hz, which every frame-based component runs at.
+type General struct {
+	Frequency float64  `xml:"Frequency"`
+	Cameras   []Camera `xml:"Camera"`
+}
+
+// Camera identifies one camera of the system. Mode is what the camera
+// captures, such as "Marker" or "Video".
+type Camera struct {
+	ID     uint32 `xml:"ID"`
+	Model  string `xml:"Model"`
+	Serial string `xml:"Serial"`
+	Mode   string `xml:"Mode"`
 }
 
 type Q3DXml struct {
@@ -44,8 +70,13 @@ type Points struct {
 	Points []Point `xml:"Point"`
 }
 
+// Point is one marker of a 6DOF body definition, at X, Y, Z in the body's
+// local coordinate system.
 type Point struct {
-	Name string `xml:"Name,attr"`
+	Name string  `xml:"Name,attr"`
+	X    float64 `xml:"X,attr"`
+	Y    float64 `xml:"Y,attr"`
+	Z    float64 `xml:"Z,attr"`
 }
 
 // Parse3DLabelsFromXML unmarshals 3D labels from XML string.
@@ -73,3 +104,248 @@ func Parse6DBodyNamesFromXML(s string) ([]string, error) {
 	}
 	return st, nil
 }
+
+// Parse6DBodiesFromXML unmarshals 6D body definitions, with their points,
+// from XML string.
+func Parse6DBodiesFromXML(s string) ([]Body, error) {
+	var qxml QXml
+	if err := xml.Unmarshal([]byte(s), &qxml); err != nil {
+		return nil, err
+	}
+	return qxml.Q6DXml.Bodies, nil
+}
+
+type Skeletons struct {
+	Skeletons []Skeleton `xml:"Skeleton"`
+}
+
+// Skeleton is one skeleton definition. From RT protocol 1.22 the segments are
+// nested inside a Segments element, each child inside its parent; earlier
+// versions list them flat directly under Skeleton. Both forms are decoded, and
+// AllSegments flattens either one.
+type Skeleton struct {
+	Name     string    `xml:"Name,attr"`
+	Segments []Segment `xml:"Segments>Segment"`
+	Flat     []Segment `xml:"Segment"`
+}
+
+type Segment struct {
+	Name     string    `xml:"Name,attr"`
+	ID       uint32    `xml:"ID,attr"`
+	ParentID uint32    `xml:"Parent_ID,attr"`
+	Children []Segment `xml:"Segment"`
+}
+
+// AllSegments returns every segment of the skeleton in document order, which
+// is also the order QTM streams them in.
+func (s Skeleton) AllSegments() []Segment {
+	var out []Segment
+	var walk func(segs []Segment)
+	walk = func(segs []Segment) {
+		for _, seg := range segs {
+			out = append(out, seg)
+			walk(seg.Children)
+		}
+	}
+	walk(s.Segments)
+	walk(s.Flat)
+	return out
+}
+
+type Analog struct {
+	Devices []AnalogDevice `xml:"Device"`
+}
+
+// AnalogDevice is one analog board. Frequency is its sample rate in Hz, often
+// a multiple of the camera frequency, and Range the input voltage span.
+type AnalogDevice struct {
+	ID           uint32          `xml:"Device_ID"`
+	Name         string          `xml:"Device_Name"`
+	ChannelCount int             `xml:"Channels"`
+	Frequency    float64         `xml:"Frequency"`
+	Range        AnalogRange     `xml:"Range"`
+	Channels     []AnalogChannel `xml:"Channel"`
+}
+
+type AnalogRange struct {
+	Min float64 `xml:"Min"`
+	Max float64 `xml:"Max"`
+}
+
+type AnalogChannel struct {
+	Label string `xml:"Label"`
+	Unit  string `xml:"Unit"`
+}
+
+// ParseSkeletonsFromXML unmarshals skeleton definitions from XML string.
+func ParseSkeletonsFromXML(s string) ([]Skeleton, error) {
+	var qxml QXml
+	if err := xml.Unmarshal([]byte(s), &qxml); err != nil {
+		return nil, err
+	}
+	return qxml.Skeletons.Skeletons, nil
+}
+
+// ParseAnalogDevicesFromXML unmarshals analog devices and their channel labels
+// from XML string.
+func ParseAnalogDevicesFromXML(s string) ([]AnalogDevice, error) {
+	var qxml QXml
+	if err := xml.Unmarshal([]byte(s), &qxml); err != nil {
+		return nil, err
+	}
+	return qxml.Analog.Devices, nil
+}
+
+// ParseGeneralFromXML unmarshals the General settings from XML string.
+func ParseGeneralFromXML(s string) (General, error) {
+	var qxml QXml
+	if err := xml.Unmarshal([]byte(s), &qxml); err != nil {
+		return General{}, err
+	}
+	return qxml.General, nil
+}
+
+// Force holds the force plate settings. UnitLength is the length unit of the
+// plate dimensions, origins and streamed centres of pressure, such as "mm".
+type Force struct {
+	UnitLength string       `xml:"Unit_Length"`
+	UnitForce  string       `xml:"Unit_Force"`
+	Plates     []ForcePlate `xml:"Plate"`
+}
+
+// ForcePlate is one plate's geometry. Protocol versions before 1.8 identify a
+// plate by Plate_ID, later ones by Force_Plate_Index; PlateID returns
+// whichever is set, which is the ID the Force component streams.
+type ForcePlate struct {
+	ID        uint32             `xml:"Plate_ID"`
+	Index     uint32             `xml:"Force_Plate_Index"`
+	Name      string             `xml:"Name"`
+	Type      string             `xml:"Type"`
+	Frequency float64            `xml:"Frequency"`
+	Length    float64            `xml:"Length"`
+	Width     float64            `xml:"Width"`
+	Location  ForcePlateLocation `xml:"Location"`
+	// Origin is the calibrated sensor origin in plate coordinates, the point
+	// the plate's moments are measured about, in Force.UnitLength.
+	Origin Vector `xml:"Origin"`
+}
+
+func (p ForcePlate) PlateID() uint32 {
+	if p.Index != 0 {
+		return p.Index
+	}
+	return p.ID
+}
+
+// ForcePlateLocation holds the plate corners in the lab coordinate system.
+type ForcePlateLocation struct {
+	Corner1 Vector `xml:"Corner1"`
+	Corner2 Vector `xml:"Corner2"`
+	Corner3 Vector `xml:"Corner3"`
+	Corner4 Vector `xml:"Corner4"`
+}
+
+type Vector struct {
+	X float64 `xml:"X"`
+	Y float64 `xml:"Y"`
+	Z float64 `xml:"Z"`
+}
+
+// ParseForceFromXML unmarshals the Force settings from XML string.
+func ParseForceFromXML(s string) (Force, error) {
+	var qxml QXml
+	if err := xml.Unmarshal([]byte(s), &qxml); err != nil {
+		return Force{}, err
+	}
+	return qxml.Force, nil
+}
+
+// ParseForcePlatesFromXML unmarshals force plate definitions from XML string.
+func ParseForcePlatesFromXML(s string) ([]ForcePlate, error) {
+	var qxml QXml
+	if err := xml.Unmarshal([]byte(s), &qxml); err != nil {
+		return nil, err
+	}
+	return qxml.Force.Plates, nil
+}
+
+type Image struct {
+	Cameras []ImageCamera `xml:"Camera"`
+}
+
+// ImageCamera is one camera's image streaming settings. Format is one of
+// "RAWGrayscale", "RAWBGR", "JPG" or "PNG"; the crop values are the image
+// edges as fractions of the sensor, 0 at the left or top and 1 at the right
+// or bottom.
+type ImageCamera struct {
+	ID         uint32  `xml:"ID"`
+	Enabled    bool    `xml:"Enabled"`
+	Format     string  `xml:"Format,omitempty"`
+	Width      uint32  `xml:"Width,omitempty"`
+	Height     uint32  `xml:"Height,omitempty"`
+	LeftCrop   float64 `xml:"Left_Crop,omitempty"`
+	TopCrop    float64 `xml:"Top_Crop,omitempty"`
+	RightCrop  float64 `xml:"Right_Crop,omitempty"`
+	BottomCrop float64 `xml:"Bottom_Crop,omitempty"`
+}
+
+// ParseImageCamerasFromXML unmarshals the image settings of every camera
+// from XML string.
+func ParseImageCamerasFromXML(s string) ([]ImageCamera, error) {
+	var qxml QXml
+	if err := xml.Unmarshal([]byte(s), &qxml); err != nil {
+		return nil, err
+	}
+	return qxml.Image.Cameras, nil
+}
+
+// ImageSettingsXML encodes camera image settings as a fragment for
+// Protocol.SetParameters. Zero-valued optional fields are left out, so QTM
+// keeps its current values for them; Enabled is always sent.
+func ImageSettingsXML(cameras []ImageCamera) (string, error) {
+	b, err := xml.Marshal(struct {
+		XMLName xml.Name      `xml:"Image"`
+		Cameras []ImageCamera `xml:"Camera"`
+	}{Cameras: cameras})
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// IsWrapper reports whether an element name only wraps the settings sections:
+// the version-specific root of a GetParameters reply, or the QTM_Settings
+// element SetParameters sends.
+func IsWrapper(name string) bool {
+	return name == "QTM_Settings" || strings.HasPrefix(name, "QTM_Parameters_Ver_")
+}
+
+// Fragment strips the XML declaration and wrapper elements from a settings
+// document, leaving the sections Protocol.SetParameters expects. Both a saved
+// GetParameters reply and a hand-written QTM_Settings document work.
+func Fragment(doc string) (string, error) {
+	doc = strings.TrimSpace(doc)
+	if strings.HasPrefix(doc, "<?xml") {
+		end := strings.Index(doc, "?>")
+		if end < 0 {
+			return "", errors.New("settings: unterminated XML declaration")
+		}
+		doc = strings.TrimSpace(doc[end+2:])
+	}
+	for strings.HasPrefix(doc, "<") {
+		end := strings.IndexByte(doc, '>')
+		if end < 0 {
+			return "", errors.New("settings: unterminated element")
+		}
+		name := doc[1:end]
+		if !IsWrapper(name) {
+			break
+		}
+		closing := "</" + name + ">"
+		if !strings.HasSuffix(doc, closing) {
+			return "", fmt.Errorf("settings: %s is not closed at the end of the document", name)
+		}
+		doc = strings.TrimSpace(doc[end+1 : len(doc)-len(closing)])
+	}
+	return doc, nil
+}
@@ -0,0 +1,210 @@
+package trace
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+)
+
+// pcapng and IP header constants. Every packet uses LINKTYPE_RAW, which
+// carries bare IPv4 or IPv6 packets, so no Ethernet framing has to be made up.
+const (
+	blockSectionHeader    = 0x0A0D0D0A
+	blockInterface        = 0x00000001
+	blockEnhancedPacket   = 0x00000006
+	byteOrderMagic        = 0x1A2B3C4D
+	linkTypeRaw           = 101
+	optionEndOfOptions    = 0
+	optionTimestampResol  = 9
+	nanosecondResolution  = 9
+	ipProtocolTCP         = 6
+	ipProtocolUDP         = 17
+	maxSegmentPayload     = 65000
+	ipv4HeaderSize        = 20
+	ipv6HeaderSize        = 40
+	tcpHeaderSize         = 20
+	udpHeaderSize         = 8
+	tcpFlagsPushAndAck    = 0x18
+	defaultTimeToLive     = 64
+	ipv4DontFragmentFlags = 0x4000
+)
+
+// PcapngWriter converts trace records to a pcapng capture that Wireshark and
+// tcpdump can open.
+//
+// The trace holds the RT protocol packets, not the IP traffic, so the writer
+// synthesizes IP and TCP or UDP headers around them from the recorded
+// addresses. TCP sequence numbers are counted per direction so Wireshark can
+// reassemble the stream; packets too large for one IP packet, such as images
+// and capture files, are split into several segments.
+type PcapngWriter struct {
+	w   io.Writer
+	seq map[string]uint32
+	id  uint16
+}
+
+// NewPcapngWriter writes the pcapng section and interface headers to w.
+func NewPcapngWriter(w io.Writer) (*PcapngWriter, error) {
+	pw := &PcapngWriter{w: w, seq: make(map[string]uint32)}
+
+	var shb []byte
+	shb = binary.LittleEndian.AppendUint32(shb, byteOrderMagic)
+	shb = binary.LittleEndian.AppendUint16(shb, 1) // major version
+	shb = binary.LittleEndian.AppendUint16(shb, 0) // minor version
+	shb = binary.LittleEndian.AppendUint64(shb, ^uint64(0))
+	if err := pw.block(blockSectionHeader, shb); err != nil {
+		return nil, err
+	}
+
+	var idb []byte
+	idb = binary.LittleEndian.AppendUint16(idb, linkTypeRaw)
+	idb = binary.LittleEndian.AppendUint16(idb, 0) // reserved
+	idb = binary.LittleEndian.AppendUint32(idb, 0) // no snapshot length limit
+	idb = binary.LittleEndian.AppendUint16(idb, optionTimestampResol)
+	idb = binary.LittleEndian.AppendUint16(idb, 1)
+	idb = append(idb, nanosecondResolution, 0, 0, 0)
+	idb = binary.LittleEndian.AppendUint16(idb, optionEndOfOptions)
+	idb = binary.LittleEndian.AppendUint16(idb, 0)
+	if err := pw.block(blockInterface, idb); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+// block writes a pcapng block: type, total length, body padded to four bytes
+// and the total length again.
+func (pw *PcapngWriter) block(blockType uint32, body []byte) error {
+	padded := (len(body) + 3) &^ 3
+	total := uint32(12 + padded)
+	b := make([]byte, 0, total)
+	b = binary.LittleEndian.AppendUint32(b, blockType)
+	b = binary.LittleEndian.AppendUint32(b, total)
+	b = append(b, body...)
+	b = append(b, make([]byte, padded-len(body))...)
+	b = binary.LittleEndian.AppendUint32(b, total)
+	if _, err := pw.w.Write(b); err != nil {
+		return fmt.Errorf("trace: write pcapng: %w", err)
+	}
+	return nil
+}
+
+// endpoint parses a recorded host:port. Addresses that do not parse, which a
+// trace only holds if the connection could not report one, become the
+// unspecified address.
+func endpoint(s string) netip.AddrPort {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return netip.AddrPortFrom(netip.IPv4Unspecified(), 0)
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		addr = netip.IPv4Unspecified()
+	}
+	p, _ := strconv.ParseUint(port, 10, 16)
+	return netip.AddrPortFrom(addr.Unmap(), uint16(p))
+}
+
+// WriteRecord appends r as one or more packets.
+func (pw *PcapngWriter) WriteRecord(r Record) error {
+	src, dst := endpoint(r.Local), endpoint(r.Remote)
+	if r.Direction == qualisys.DirectionReceived {
+		src, dst = dst, src
+	}
+	// Mixing families would make an invalid IP header; fall back to IPv6
+	// with both addresses mapped.
+	if src.Addr().Is4() != dst.Addr().Is4() {
+		src = netip.AddrPortFrom(netip.AddrFrom16(src.Addr().As16()), src.Port())
+		dst = netip.AddrPortFrom(netip.AddrFrom16(dst.Addr().As16()), dst.Port())
+	}
+
+	// A UDP datagram already fits one IP packet.
+	if r.Network == "udp" {
+		return pw.writePacket(r, src, dst, r.Data)
+	}
+	data := r.Data
+	for {
+		chunk := data[:min(len(data), maxSegmentPayload)]
+		if err := pw.writePacket(r, src, dst, chunk); err != nil {
+			return err
+		}
+		data = data[len(chunk):]
+		if len(data) == 0 {
+			return nil
+		}
+	}
+}
+
+func (pw *PcapngWriter) writePacket(r Record, src, dst netip.AddrPort, payload []byte) error {
+	var transport []byte
+	protocol := byte(ipProtocolTCP)
+	if r.Network == "udp" {
+		protocol = ipProtocolUDP
+		transport = binary.BigEndian.AppendUint16(transport, src.Port())
+		transport = binary.BigEndian.AppendUint16(transport, dst.Port())
+		transport = binary.BigEndian.AppendUint16(transport, uint16(udpHeaderSize+len(payload)))
+		transport = binary.BigEndian.AppendUint16(transport, 0) // checksum not computed
+	} else {
+		forward, backward := src.String()+">"+dst.String(), dst.String()+">"+src.String()
+		seq := pw.seq[forward]
+		pw.seq[forward] = seq + uint32(len(payload))
+		transport = binary.BigEndian.AppendUint16(transport, src.Port())
+		transport = binary.BigEndian.AppendUint16(transport, dst.Port())
+		transport = binary.BigEndian.AppendUint32(transport, seq)
+		transport = binary.BigEndian.AppendUint32(transport, pw.seq[backward])
+		transport = append(transport, tcpHeaderSize/4<<4, tcpFlagsPushAndAck)
+		transport = binary.BigEndian.AppendUint16(transport, 0xFFFF) // window
+		transport = binary.BigEndian.AppendUint16(transport, 0)      // checksum not computed
+		transport = binary.BigEndian.AppendUint16(transport, 0)      // urgent pointer
+	}
+
+	pw.id++
+	var packet []byte
+	if src.Addr().Is4() {
+		packet = make([]byte, 0, ipv4HeaderSize+len(transport)+len(payload))
+		packet = append(packet, 0x45, 0)
+		packet = binary.BigEndian.AppendUint16(packet, uint16(ipv4HeaderSize+len(transport)+len(payload)))
+		packet = binary.BigEndian.AppendUint16(packet, pw.id)
+		packet = binary.BigEndian.AppendUint16(packet, ipv4DontFragmentFlags)
+		packet = append(packet, defaultTimeToLive, protocol, 0, 0)
+		s, d := src.Addr().As4(), dst.Addr().As4()
+		packet = append(packet, s[:]...)
+		packet = append(packet, d[:]...)
+		binary.BigEndian.PutUint16(packet[10:12], ipv4Checksum(packet[:ipv4HeaderSize]))
+	} else {
+		packet = make([]byte, 0, ipv6HeaderSize+len(transport)+len(payload))
+		packet = binary.BigEndian.AppendUint32(packet, 6<<28)
+		packet = binary.BigEndian.AppendUint16(packet, uint16(len(transport)+len(payload)))
+		packet = append(packet, protocol, defaultTimeToLive)
+		s, d := src.Addr().As16(), dst.Addr().As16()
+		packet = append(packet, s[:]...)
+		packet = append(packet, d[:]...)
+	}
+	packet = append(packet, transport...)
+	packet = append(packet, payload...)
+
+	ts := uint64(r.Time.UnixNano())
+	var epb []byte
+	epb = binary.LittleEndian.AppendUint32(epb, 0) // interface ID
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(ts>>32))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(ts))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(len(packet)))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(len(packet)))
+	epb = append(epb, packet...)
+	return pw.block(blockEnhancedPacket, epb)
+}
+
+func ipv4Checksum(header []byte) uint16 {
+	var sum uint32
+	for i := 0; i < len(header); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(header[i:]))
+	}
+	for sum > 0xFFFF {
+		sum = sum>>16 + sum&0xFFFF
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,199 @@
+// Package trace records the packets a Protocol exchanges with QTM and replays
+// or converts those recordings.
+//
+// A Writer is a qualisys.Tracer that appends every packet, with its direction,
+// transport, addresses and timestamp, to a trace file:
+//
+//	f, _ := os.Create("session.qtmtrace")
+//	tw := trace.NewWriter(f)
+//	rt := qualisys.NewProtocol(ip, port, qualisys.WithTracer(tw))
+//
+// A Reader reads the records back, and PcapngWriter converts them to pcapng
+// so the exchange can be inspected in Wireshark. cmd/tracedump does both.
+//
+// The trace file starts with the 8 byte magic "QTMTRC01". Each record is, in
+// little-endian order: the timestamp in Unix nanoseconds (int64), the
+// direction (uint8), the network (uint8, 1 for TCP and 2 for UDP), the local
+// and remote addresses (each a uint16 length then the text), the packet
+// length (uint32) and the packet bytes.
+package trace
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+)
+
+const magic = "QTMTRC01"
+
+// maxRecordSize bounds the packet length a Reader accepts, matching the
+// largest packet a Protocol accepts by default.
+const maxRecordSize = qualisys.DefaultMaxPacketSize
+
+// ErrFormat is returned for input that is not a trace file or is corrupt.
+var ErrFormat = errors.New("trace: invalid trace file")
+
+const (
+	networkTCP = 1
+	networkUDP = 2
+)
+
+// Record is one traced packet.
+type Record struct {
+	Time      time.Time
+	Direction qualisys.Direction
+	// Network is "tcp" or "udp".
+	Network string
+	// Local and Remote are addresses in host:port form, as reported by the
+	// connection.
+	Local  string
+	Remote string
+	// Data is the whole packet, header included.
+	Data []byte
+}
+
+// Writer records packets to an io.Writer. It is safe for concurrent use, so
+// the TCP and UDP paths of one Protocol, or several Protocols, may share it.
+type Writer struct {
+	mu      sync.Mutex
+	w       io.Writer
+	started bool
+	err     error
+}
+
+// NewWriter records to w. Nothing is written until the first packet.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// TracePacket implements qualisys.Tracer. A write error stops recording; it
+// is reported by Err.
+func (tw *Writer) TracePacket(t time.Time, dir qualisys.Direction, network string, local, remote net.Addr, data []byte) {
+	_ = tw.Write(Record{Time: t, Direction: dir, Network: network, Local: addrString(local), Remote: addrString(remote), Data: data})
+}
+
+func addrString(a net.Addr) string {
+	if a == nil {
+		return ""
+	}
+	return a.String()
+}
+
+// Write appends r to the trace.
+func (tw *Writer) Write(r Record) error {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.err != nil {
+		return tw.err
+	}
+	var b []byte
+	if !tw.started {
+		b = append(b, magic...)
+	}
+	b = binary.LittleEndian.AppendUint64(b, uint64(r.Time.UnixNano()))
+	network := byte(networkTCP)
+	if r.Network == "udp" {
+		network = networkUDP
+	}
+	b = append(b, byte(r.Direction), network)
+	for _, s := range []string{r.Local, r.Remote} {
+		b = binary.LittleEndian.AppendUint16(b, uint16(len(s)))
+		b = append(b, s...)
+	}
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(r.Data)))
+	b = append(b, r.Data...)
+	if _, err := tw.w.Write(b); err != nil {
+		tw.err = fmt.Errorf("trace: write: %w", err)
+		return tw.err
+	}
+	tw.started = true
+	return nil
+}
+
+// Err returns the error that stopped recording, if any.
+func (tw *Writer) Err() error {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	return tw.err
+}
+
+// Reader reads records from a trace file.
+type Reader struct {
+	r *bufio.Reader
+}
+
+// NewReader checks the trace file header and returns a Reader positioned at
+// the first record.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	head := make([]byte, len(magic))
+	if _, err := io.ReadFull(br, head); err != nil {
+		if errors.Is(err, io.EOF) {
+			// An empty file is a trace in which nothing happened.
+			return &Reader{r: br}, nil
+		}
+		return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	if string(head) != magic {
+		return nil, fmt.Errorf("%w: bad magic %q", ErrFormat, head)
+	}
+	return &Reader{r: br}, nil
+}
+
+// Next returns the next record, or io.EOF after the last one.
+func (tr *Reader) Next() (Record, error) {
+	var fixed [10]byte
+	if _, err := io.ReadFull(tr.r, fixed[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return Record{}, io.EOF
+		}
+		return Record{}, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	r := Record{
+		Time:      time.Unix(0, int64(binary.LittleEndian.Uint64(fixed[0:8]))),
+		Direction: qualisys.Direction(fixed[8]),
+		Network:   "tcp",
+	}
+	if fixed[9] == networkUDP {
+		r.Network = "udp"
+	}
+	var err error
+	if r.Local, err = tr.readString(); err != nil {
+		return Record{}, err
+	}
+	if r.Remote, err = tr.readString(); err != nil {
+		return Record{}, err
+	}
+	var n [4]byte
+	if _, err := io.ReadFull(tr.r, n[:]); err != nil {
+		return Record{}, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	size := binary.LittleEndian.Uint32(n[:])
+	if size > maxRecordSize {
+		return Record{}, fmt.Errorf("%w: record of %d bytes", ErrFormat, size)
+	}
+	r.Data = make([]byte, size)
+	if _, err := io.ReadFull(tr.r, r.Data); err != nil {
+		return Record{}, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	return r, nil
+}
+
+func (tr *Reader) readString() (string, error) {
+	var n [2]byte
+	if _, err := io.ReadFull(tr.r, n[:]); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	b := make([]byte, binary.LittleEndian.Uint16(n[:]))
+	if _, err := io.ReadFull(tr.r, b); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	return string(b), nil
+}
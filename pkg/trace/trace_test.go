@@ -0,0 +1,137 @@
+package trace_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/pkg/trace"
+)
+
+func TestWriterReaderRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w := trace.NewWriter(&buf)
+	local := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 50000}
+	remote := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 22223}
+	at := time.Unix(1700000000, 123456789)
+	w.TracePacket(at, qualisys.DirectionSent, "tcp", local, remote, []byte("GetState"))
+	w.TracePacket(at.Add(time.Millisecond), qualisys.DirectionReceived, "udp", local, nil, []byte{1, 2, 3})
+	if err := w.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := trace.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first.Time.Equal(at) || first.Direction != qualisys.DirectionSent || first.Network != "tcp" ||
+		first.Local != "10.0.0.2:50000" || first.Remote != "10.0.0.1:22223" || string(first.Data) != "GetState" {
+		t.Errorf("first = %+v", first)
+	}
+	second, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Network != "udp" || second.Remote != "" || !bytes.Equal(second.Data, []byte{1, 2, 3}) {
+		t.Errorf("second = %+v", second)
+	}
+	if _, err := r.Next(); !errors.Is(err, io.EOF) {
+		t.Errorf("after the last record: %v", err)
+	}
+}
+
+func TestReaderRejectsOtherFiles(t *testing.T) {
+	if _, err := trace.NewReader(strings.NewReader("GIF89a..")); !errors.Is(err, trace.ErrFormat) {
+		t.Errorf("got %v, want ErrFormat", err)
+	}
+}
+
+// blocks splits a pcapng file into its block types and bodies.
+func blocks(t *testing.T, b []byte) (types []uint32, bodies [][]byte) {
+	t.Helper()
+	for len(b) > 0 {
+		if len(b) < 12 {
+			t.Fatalf("%d trailing bytes", len(b))
+		}
+		n := binary.LittleEndian.Uint32(b[4:8])
+		if n%4 != 0 || int(n) > len(b) || binary.LittleEndian.Uint32(b[n-4:n]) != n {
+			t.Fatalf("bad block length %d", n)
+		}
+		types = append(types, binary.LittleEndian.Uint32(b[0:4]))
+		bodies = append(bodies, b[8:n-4])
+		b = b[n:]
+	}
+	return types, bodies
+}
+
+func TestPcapng(t *testing.T) {
+	var buf bytes.Buffer
+	pw, err := trace.NewPcapngWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Unix(0, 1_500_000_000_000_000_123)
+	big := bytes.Repeat([]byte{0xAB}, 70000)
+	for _, r := range []trace.Record{
+		{Time: at, Direction: qualisys.DirectionSent, Network: "tcp", Local: "10.0.0.2:50000", Remote: "10.0.0.1:22223", Data: []byte("Version 1.28\x00")},
+		{Time: at, Direction: qualisys.DirectionReceived, Network: "tcp", Local: "10.0.0.2:50000", Remote: "10.0.0.1:22223", Data: big},
+		{Time: at, Direction: qualisys.DirectionReceived, Network: "udp", Local: "[::1]:6000", Remote: "[::1]:22222", Data: []byte{9}},
+	} {
+		if err := pw.WriteRecord(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	types, bodies := blocks(t, buf.Bytes())
+	want := []uint32{0x0A0D0D0A, 1, 6, 6, 6, 6}
+	if len(types) != len(want) {
+		t.Fatalf("block types = %x, want %x", types, want)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatalf("block types = %x, want %x", types, want)
+		}
+	}
+	if binary.LittleEndian.Uint16(bodies[1][0:2]) != 101 {
+		t.Error("interface is not LINKTYPE_RAW")
+	}
+
+	packet := func(i int) []byte { return bodies[i][20 : 20+binary.LittleEndian.Uint32(bodies[i][12:16])] }
+	sent := packet(2)
+	if ts := uint64(binary.LittleEndian.Uint32(bodies[2][4:8]))<<32 | uint64(binary.LittleEndian.Uint32(bodies[2][8:12])); ts != uint64(at.UnixNano()) {
+		t.Errorf("timestamp = %d", ts)
+	}
+	if sent[0] != 0x45 || sent[9] != 6 || !net.IP(sent[12:16]).Equal(net.IPv4(10, 0, 0, 2)) ||
+		binary.BigEndian.Uint16(sent[22:24]) != 22223 || string(sent[40:]) != "Version 1.28\x00" {
+		t.Errorf("sent packet = % x", sent[:40])
+	}
+
+	// The large reply is split in two segments from QTM, with consecutive
+	// sequence numbers acknowledging the command.
+	first, second := packet(3), packet(4)
+	if !net.IP(first[12:16]).Equal(net.IPv4(10, 0, 0, 1)) || binary.BigEndian.Uint16(first[20:22]) != 22223 {
+		t.Errorf("reply header = % x", first[:40])
+	}
+	seq1, seq2 := binary.BigEndian.Uint32(first[24:28]), binary.BigEndian.Uint32(second[24:28])
+	if seq2-seq1 != uint32(len(first)-40) || len(first)-40+len(second)-40 != len(big) {
+		t.Errorf("segments of %d and %d bytes at seq %d and %d", len(first)-40, len(second)-40, seq1, seq2)
+	}
+	if ack := binary.BigEndian.Uint32(first[28:32]); ack != uint32(len("Version 1.28\x00")) {
+		t.Errorf("ack = %d", ack)
+	}
+
+	udp := packet(5)
+	if udp[0]>>4 != 6 || udp[6] != 17 || binary.BigEndian.Uint16(udp[40:42]) != 22222 || udp[48] != 9 {
+		t.Errorf("udp packet = % x", udp)
+	}
+}
@@ -0,0 +1,96 @@
+package trajectory
+
+import "github.com/mlveggo/qualisys-go/pkg/packets"
+
+// Frame is one frame released by a Realtime filler.
+type Frame struct {
+	Number uint32
+	// Points and Synthesized are indexed by label.
+	Points      []packets.Point
+	Synthesized []bool
+}
+
+// Realtime fills gaps on a live stream with a bounded latency. Each frame is
+// released Delay frames after it arrives, by which time any gap it is part of
+// that closes within the delay can be interpolated; longer gaps stay missing.
+// RigidBody needs no later frames, so it works with a Delay of zero. It is not
+// safe for concurrent use.
+type Realtime struct {
+	Options
+	Delay int
+
+	numbers []uint32
+	window  [][]packets.Point
+	// released counts the frames at the front of window that have been
+	// released and are kept only as history for later gaps.
+	released int
+}
+
+// NewRealtime returns a filler releasing frames delay frames late.
+func NewRealtime(delay int, opts Options) *Realtime {
+	return &Realtime{Options: opts, Delay: max(delay, 0)}
+}
+
+// history is how many released frames are kept as the left side of gaps:
+// enough to reach across the longest gap filled plus the spline's context.
+func (r *Realtime) history() int {
+	return r.maxGap() + SplineContext
+}
+
+// Push adds the labeled markers of frame number and returns the frames ready
+// for release, usually one. c may be nil for a frame without 3D data. Frames
+// missing between two pushes, such as frames dropped on UDP, are inserted with
+// every marker missing, so their markers are filled like any other gap. A
+// frame number that goes backwards, or leaps further than the history, starts
+// over after releasing the frames held back.
+func (r *Realtime) Push(number uint32, c *packets.Component3D) []Frame {
+	var out []Frame
+	if n := len(r.numbers); n > 0 {
+		last := r.numbers[n-1]
+		step := int64(number) - int64(last)
+		if step <= 0 || step > int64(r.history()) {
+			out = r.Flush()
+			r.numbers, r.window, r.released = r.numbers[:0], r.window[:0], 0
+		} else {
+			for k := int64(1); k < step; k++ {
+				r.numbers = append(r.numbers, last+uint32(k))
+				r.window = append(r.window, nil)
+			}
+		}
+	}
+	var points []packets.Point
+	if c != nil {
+		points = make([]packets.Point, len(c.Markers))
+		for i, m := range c.Markers {
+			points[i] = m.Point
+		}
+	}
+	r.numbers = append(r.numbers, number)
+	r.window = append(r.window, points)
+	return append(out, r.release(len(r.window)-r.released-r.Delay)...)
+}
+
+// Flush releases the frames still held back, at the end of a stream. Gaps
+// running into the end stay missing.
+func (r *Realtime) Flush() []Frame {
+	return r.release(len(r.window) - r.released)
+}
+
+// release fills the window and returns its next n unreleased frames.
+func (r *Realtime) release(n int) []Frame {
+	if n <= 0 {
+		return nil
+	}
+	res := Fill(r.window, r.Options)
+	out := make([]Frame, 0, n)
+	for f := r.released; f < r.released+n; f++ {
+		out = append(out, Frame{Number: r.numbers[f], Points: res.Frames[f], Synthesized: res.Synthesized[f]})
+	}
+	r.released += n
+	if drop := r.released - r.history(); drop > 0 {
+		r.numbers = r.numbers[drop:]
+		r.window = r.window[drop:]
+		r.released -= drop
+	}
+	return out
+}
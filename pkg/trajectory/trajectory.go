@@ -0,0 +1,323 @@
+// Package trajectory finds and fills gaps in labeled 3D marker trajectories.
+//
+// QTM streams an occluded labeled marker as NaN until it is seen again. Fill
+// repairs a recorded capture after the fact; a Realtime filler does the same
+// on a live stream, holding frames back by a fixed delay so that gaps which
+// close within it can be interpolated. Both report which samples were
+// synthesized, so consumers can weight them accordingly:
+//
+//	res := trajectory.Fill(frames, trajectory.Options{Method: trajectory.Spline})
+//	for f := range res.Frames {
+//		for label, p := range res.Frames[f] {
+//			weight := 1.0
+//			if res.Synthesized[f][label] {
+//				weight = 0.5
+//			}
+//			...
+//		}
+//	}
+package trajectory
+
+import (
+	"math"
+
+	"github.com/mlveggo/qualisys-go/pkg/packets"
+	"github.com/mlveggo/qualisys-go/pkg/rigid"
+)
+
+//go:generate stringer -type Method
+
+// Method is how a gap is filled.
+type Method int
+
+const (
+	// Linear interpolates straight between the samples either side.
+	Linear Method = iota + 1
+	// Spline fits a natural cubic spline through up to SplineContext samples
+	// either side, which follows curved paths better than Linear.
+	Spline
+	// RigidBody places the marker by the other markers of a rigid body
+	// definition it belongs to, frame by frame. It needs no samples after the
+	// gap, so it adds no latency in real time, and is not limited by MaxGap.
+	RigidBody
+)
+
+// DefaultMaxGap is the longest gap, in frames, that Linear and Spline fill
+// when Options.MaxGap is zero.
+const DefaultMaxGap = 10
+
+// SplineContext is how many valid samples on each side of a gap the spline
+// is fitted through.
+const SplineContext = 4
+
+// Gap is a run of frames in which a labeled marker is missing.
+type Gap struct {
+	Label int
+	// Start is the index of the first missing frame.
+	Start  int
+	Length int
+}
+
+// Options configures Fill and Realtime.
+type Options struct {
+	Method Method
+	// MaxGap is the longest gap Linear and Spline fill; zero means
+	// DefaultMaxGap.
+	MaxGap int
+	// Labels and Bodies are needed by RigidBody: Labels names the markers in
+	// stream order, and a body's points are matched to them by name.
+	Labels []string
+	Bodies []rigid.Body
+}
+
+func (o Options) maxGap() int {
+	if o.MaxGap <= 0 {
+		return DefaultMaxGap
+	}
+	return o.MaxGap
+}
+
+// Result is a capture with its gaps filled.
+type Result struct {
+	// Frames holds the positions, indexed by frame and label.
+	Frames [][]packets.Point
+	// Synthesized flags, with the same indices, the positions Fill made up.
+	Synthesized [][]bool
+	// Unfilled lists the gaps, or the parts of them, left missing: gaps
+	// longer than MaxGap, at either end of the capture, or without enough
+	// body markers visible.
+	Unfilled []Gap
+}
+
+func missing(p packets.Point) bool {
+	return math.IsNaN(float64(p.X)) || math.IsNaN(float64(p.Y)) || math.IsNaN(float64(p.Z))
+}
+
+// Gaps returns the gaps in a capture indexed by frame and label, per label in
+// frame order.
+func Gaps(frames [][]packets.Point) []Gap {
+	labels := 0
+	for _, f := range frames {
+		labels = max(labels, len(f))
+	}
+	var gaps []Gap
+	for l := 0; l < labels; l++ {
+		gaps = append(gaps, labelGaps(frames, l)...)
+	}
+	return gaps
+}
+
+func labelGaps(frames [][]packets.Point, label int) []Gap {
+	var gaps []Gap
+	for f := 0; f < len(frames); f++ {
+		if label < len(frames[f]) && !missing(frames[f][label]) {
+			continue
+		}
+		start := f
+		for f < len(frames) && (label >= len(frames[f]) || missing(frames[f][label])) {
+			f++
+		}
+		gaps = append(gaps, Gap{Label: label, Start: start, Length: f - start})
+	}
+	return gaps
+}
+
+// Fill fills the gaps of a recorded capture, indexed by frame and label. The
+// input is not modified. Frames shorter than the longest are treated as
+// missing the trailing labels.
+func Fill(frames [][]packets.Point, opts Options) *Result {
+	labels := 0
+	for _, f := range frames {
+		labels = max(labels, len(f))
+	}
+	res := &Result{
+		Frames:      make([][]packets.Point, len(frames)),
+		Synthesized: make([][]bool, len(frames)),
+	}
+	nan := float32(math.NaN())
+	for f := range frames {
+		res.Frames[f] = make([]packets.Point, labels)
+		res.Synthesized[f] = make([]bool, labels)
+		for l := range res.Frames[f] {
+			res.Frames[f][l] = packets.Point{X: nan, Y: nan, Z: nan}
+		}
+		copy(res.Frames[f], frames[f])
+	}
+
+	for l := 0; l < labels; l++ {
+		for _, g := range labelGaps(frames, l) {
+			var filled []bool
+			switch opts.Method {
+			case RigidBody:
+				filled = fillRigid(frames, res.Frames, g, opts)
+			case Spline, Linear:
+				if g.Length <= opts.maxGap() {
+					filled = interpolate(frames, res.Frames, g, opts.Method)
+				}
+			}
+			res.Unfilled = append(res.Unfilled, mark(res.Synthesized, g, filled)...)
+		}
+	}
+	return res
+}
+
+// mark flags the filled frames of g and returns the runs left unfilled.
+func mark(synthesized [][]bool, g Gap, filled []bool) []Gap {
+	var unfilled []Gap
+	for i := 0; i < g.Length; i++ {
+		if i < len(filled) && filled[i] {
+			synthesized[g.Start+i][g.Label] = true
+			continue
+		}
+		if n := len(unfilled); n > 0 && unfilled[n-1].Start+unfilled[n-1].Length == g.Start+i {
+			unfilled[n-1].Length++
+		} else {
+			unfilled = append(unfilled, Gap{Label: g.Label, Start: g.Start + i, Length: 1})
+		}
+	}
+	return unfilled
+}
+
+// knots collects up to SplineContext measured samples of label on each side
+// of g, stopping at the next gap, as frame indices and positions.
+func knots(frames [][]packets.Point, g Gap, context int) (before, after []int) {
+	for f := g.Start - 1; f >= 0 && len(before) < context; f-- {
+		if g.Label >= len(frames[f]) || missing(frames[f][g.Label]) {
+			break
+		}
+		before = append([]int{f}, before...)
+	}
+	for f := g.Start + g.Length; f < len(frames) && len(after) < context; f++ {
+		if g.Label >= len(frames[f]) || missing(frames[f][g.Label]) {
+			break
+		}
+		after = append(after, f)
+	}
+	return before, after
+}
+
+func interpolate(frames, out [][]packets.Point, g Gap, method Method) []bool {
+	context := 1
+	if method == Spline {
+		context = SplineContext
+	}
+	before, after := knots(frames, g, context)
+	if len(before) == 0 || len(after) == 0 {
+		return nil
+	}
+	xs := make([]float64, 0, len(before)+len(after))
+	ys := [3][]float64{}
+	for _, f := range append(before, after...) {
+		p := frames[f][g.Label]
+		xs = append(xs, float64(f))
+		ys[0] = append(ys[0], float64(p.X))
+		ys[1] = append(ys[1], float64(p.Y))
+		ys[2] = append(ys[2], float64(p.Z))
+	}
+	var curves [3]func(float64) float64
+	for axis := range curves {
+		if len(xs) == 2 {
+			curves[axis] = line(xs, ys[axis])
+		} else {
+			curves[axis] = naturalSpline(xs, ys[axis])
+		}
+	}
+	filled := make([]bool, g.Length)
+	for i := range filled {
+		x := float64(g.Start + i)
+		out[g.Start+i][g.Label] = packets.Point{
+			X: float32(curves[0](x)),
+			Y: float32(curves[1](x)),
+			Z: float32(curves[2](x)),
+		}
+		filled[i] = true
+	}
+	return filled
+}
+
+func line(xs, ys []float64) func(float64) float64 {
+	return func(x float64) float64 {
+		return ys[0] + (ys[1]-ys[0])*(x-xs[0])/(xs[1]-xs[0])
+	}
+}
+
+// naturalSpline returns the natural cubic spline through the points, which
+// must have increasing x.
+func naturalSpline(xs, ys []float64) func(float64) float64 {
+	n := len(xs)
+	// Solve the tridiagonal system for the second derivatives m, with
+	// m[0] = m[n-1] = 0.
+	m := make([]float64, n)
+	c := make([]float64, n)
+	d := make([]float64, n)
+	for i := 1; i < n-1; i++ {
+		h0, h1 := xs[i]-xs[i-1], xs[i+1]-xs[i]
+		a, b := h0, 2*(h0+h1)
+		r := 6 * ((ys[i+1]-ys[i])/h1 - (ys[i]-ys[i-1])/h0)
+		denom := b - a*c[i-1]
+		c[i] = h1 / denom
+		d[i] = (r - a*d[i-1]) / denom
+	}
+	for i := n - 2; i > 0; i-- {
+		m[i] = d[i] - c[i]*m[i+1]
+	}
+	return func(x float64) float64 {
+		i := 0
+		for i < n-2 && x > xs[i+1] {
+			i++
+		}
+		h := xs[i+1] - xs[i]
+		a, b := (xs[i+1]-x)/h, (x-xs[i])/h
+		return a*ys[i] + b*ys[i+1] + ((a*a*a-a)*m[i]+(b*b*b-b)*m[i+1])*h*h/6
+	}
+}
+
+// fillRigid places the marker of g in each frame from the other measured
+// markers of the first body that contains it.
+func fillRigid(frames, out [][]packets.Point, g Gap, opts Options) []bool {
+	if g.Label >= len(opts.Labels) {
+		return nil
+	}
+	name := opts.Labels[g.Label]
+	var body rigid.Body
+	target := -1
+	for _, b := range opts.Bodies {
+		if target = b.Point(name); target >= 0 {
+			body = b
+			break
+		}
+	}
+	if target < 0 {
+		return nil
+	}
+	index := make([]int, len(body.Points))
+	for i, p := range body.Points {
+		index[i] = -1
+		for l, label := range opts.Labels {
+			if label == p.Name {
+				index[i] = l
+			}
+		}
+	}
+
+	filled := make([]bool, g.Length)
+	for i := range filled {
+		f := g.Start + i
+		var model, observed []packets.Point
+		for j, p := range body.Points {
+			l := index[j]
+			if j == target || l < 0 || l >= len(frames[f]) || missing(frames[f][l]) {
+				continue
+			}
+			model = append(model, p.Position)
+			observed = append(observed, frames[f][l])
+		}
+		t, _, err := rigid.Fit(model, observed)
+		if err != nil {
+			continue
+		}
+		out[f][g.Label] = t.Apply(body.Points[target].Position)
+		filled[i] = true
+	}
+	return filled
+}
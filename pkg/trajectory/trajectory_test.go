@@ -0,0 +1,175 @@
+package trajectory_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/mlveggo/qualisys-go/pkg/packets"
+	"github.com/mlveggo/qualisys-go/pkg/rigid"
+	"github.com/mlveggo/qualisys-go/pkg/trajectory"
+)
+
+var nan = float32(math.NaN())
+
+var lost = packets.Point{X: nan, Y: nan, Z: nan}
+
+func near(a, b packets.Point, tol float64) bool {
+	return math.Abs(float64(a.X-b.X)) <= tol && math.Abs(float64(a.Y-b.Y)) <= tol && math.Abs(float64(a.Z-b.Z)) <= tol
+}
+
+// capture returns n frames of one marker at path(f), lost in the given frames.
+func capture(n int, path func(f int) packets.Point, missing ...int) [][]packets.Point {
+	frames := make([][]packets.Point, n)
+	for f := range frames {
+		frames[f] = []packets.Point{path(f)}
+	}
+	for _, f := range missing {
+		frames[f][0] = lost
+	}
+	return frames
+}
+
+func straight(f int) packets.Point { return packets.Point{X: float32(10 * f), Y: 5, Z: float32(-f)} }
+
+func TestGaps(t *testing.T) {
+	frames := capture(10, straight, 0, 4, 5, 9)
+	frames[2] = nil // a frame without 3D data
+	want := []trajectory.Gap{{Start: 0, Length: 1}, {Start: 2, Length: 1}, {Start: 4, Length: 2}, {Start: 9, Length: 1}}
+	got := trajectory.Gaps(frames)
+	if len(got) != len(want) {
+		t.Fatalf("gaps = %+v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("gap %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestLinearFill(t *testing.T) {
+	frames := capture(20, straight, 0, 5, 6, 7, 10, 11, 12, 13, 14)
+	res := trajectory.Fill(frames, trajectory.Options{Method: trajectory.Linear, MaxGap: 4})
+	for f := 5; f <= 7; f++ {
+		if !res.Synthesized[f][0] || !near(res.Frames[f][0], straight(f), 1e-3) {
+			t.Errorf("frame %d = %v, synthesized %v", f, res.Frames[f][0], res.Synthesized[f][0])
+		}
+	}
+	if res.Synthesized[4][0] || res.Frames[4][0] != straight(4) {
+		t.Error("a measured sample was touched")
+	}
+	if !math.IsNaN(float64(frames[6][0].X)) {
+		t.Error("the input was modified")
+	}
+	// The leading gap has no left side and the five frame gap exceeds MaxGap.
+	want := []trajectory.Gap{{Start: 0, Length: 1}, {Start: 10, Length: 5}}
+	if len(res.Unfilled) != 2 || res.Unfilled[0] != want[0] || res.Unfilled[1] != want[1] {
+		t.Errorf("unfilled = %+v, want %+v", res.Unfilled, want)
+	}
+}
+
+func TestSplineFollowsCurves(t *testing.T) {
+	arc := func(f int) packets.Point {
+		a := float64(f) / 10
+		return packets.Point{X: float32(1000 * math.Cos(a)), Y: float32(1000 * math.Sin(a))}
+	}
+	frames := capture(30, arc, 13, 14, 15, 16, 17)
+	spline := trajectory.Fill(frames, trajectory.Options{Method: trajectory.Spline})
+	linear := trajectory.Fill(frames, trajectory.Options{Method: trajectory.Linear})
+	var splineErr, linearErr float64
+	for f := 13; f <= 17; f++ {
+		if !spline.Synthesized[f][0] {
+			t.Fatalf("frame %d not filled", f)
+		}
+		splineErr = max(splineErr, math.Hypot(float64(spline.Frames[f][0].X-arc(f).X), float64(spline.Frames[f][0].Y-arc(f).Y)))
+		linearErr = max(linearErr, math.Hypot(float64(linear.Frames[f][0].X-arc(f).X), float64(linear.Frames[f][0].Y-arc(f).Y)))
+	}
+	if splineErr > 1 || splineErr >= linearErr {
+		t.Errorf("spline error %v mm, linear %v mm", splineErr, linearErr)
+	}
+}
+
+func TestRigidBodyFill(t *testing.T) {
+	body := rigid.Body{Name: "Plate", Points: []rigid.Point{
+		{Name: "A", Position: packets.Point{}},
+		{Name: "B", Position: packets.Point{X: 100}},
+		{Name: "C", Position: packets.Point{Y: 100}},
+		{Name: "D", Position: packets.Point{X: 100, Y: 100, Z: 20}},
+	}}
+	pose := func(f int) rigid.Transform {
+		s, c := math.Sincos(float64(f) / 5)
+		return rigid.Transform{Rotation: [9]float64{c, -s, 0, s, c, 0, 0, 0, 1}, Translation: [3]float64{float64(20 * f), 0, 300}}
+	}
+	// Labels in stream order differ from the body's order, and include a
+	// marker not on the body.
+	labels := []string{"D", "Other", "A", "B", "C"}
+	frames := make([][]packets.Point, 40)
+	for f := range frames {
+		p := pose(f)
+		frames[f] = []packets.Point{
+			p.Apply(body.Points[3].Position), {X: 1},
+			p.Apply(body.Points[0].Position), p.Apply(body.Points[1].Position), p.Apply(body.Points[2].Position),
+		}
+	}
+	// D is lost for longer than interpolation would bridge, and in frame 25
+	// too few other markers are seen to place it.
+	for f := 5; f < 30; f++ {
+		frames[f][0] = lost
+	}
+	frames[25][2], frames[25][3] = lost, lost
+
+	res := trajectory.Fill(frames, trajectory.Options{Method: trajectory.RigidBody, Labels: labels, Bodies: []rigid.Body{body}})
+	for f := 5; f < 30; f++ {
+		if f == 25 {
+			continue
+		}
+		if !res.Synthesized[f][0] || !near(res.Frames[f][0], pose(f).Apply(body.Points[3].Position), 0.01) {
+			t.Errorf("frame %d: D = %v", f, res.Frames[f][0])
+		}
+	}
+	want := []trajectory.Gap{{Label: 0, Start: 25, Length: 1}}
+	if len(res.Unfilled) < 1 || res.Unfilled[0] != want[0] {
+		t.Errorf("unfilled = %+v", res.Unfilled)
+	}
+}
+
+func TestRealtimeBoundedDelay(t *testing.T) {
+	rt := trajectory.NewRealtime(3, trajectory.Options{Method: trajectory.Linear})
+	var out []trajectory.Frame
+	push := func(f int) {
+		var c *packets.Component3D
+		switch f {
+		case 6, 7:
+			c = &packets.Component3D{Markers: []packets.Marker{{Point: lost}}}
+		case 12:
+			return // dropped on the way
+		default:
+			c = &packets.Component3D{Markers: []packets.Marker{{Point: straight(f)}}}
+		}
+		released := rt.Push(uint32(100+f), c)
+		// Each push releases the frame three behind it; the push after the
+		// dropped frame also releases the one its absence held back.
+		for _, fr := range released {
+			if int(fr.Number)-100 < f-3 && f != 13 {
+				t.Errorf("frame %d released after frame %d arrived", fr.Number, 100+f)
+			}
+		}
+		out = append(out, released...)
+	}
+	for f := 0; f < 20; f++ {
+		push(f)
+	}
+	out = append(out, rt.Flush()...)
+
+	if len(out) != 20 {
+		t.Fatalf("released %d frames", len(out))
+	}
+	for i, fr := range out {
+		if fr.Number != uint32(100+i) {
+			t.Fatalf("frame %d released as %d", i, fr.Number)
+		}
+		synthesized := i == 6 || i == 7 || i == 12
+		if fr.Synthesized[0] != synthesized || !near(fr.Points[0], straight(i), 1e-3) {
+			t.Errorf("frame %d = %v, synthesized %v", i, fr.Points[0], fr.Synthesized[0])
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package vrpn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// DefaultPort is the well-known VRPN port, used for both the TCP listener and
+// the UDP connection requests.
+const DefaultPort = 3883
+
+// cookie is what each side sends first. The trailing digit is the remote
+// logging mode, which this server never asks for. Clients check the major
+// version and warn, but continue, on a minor mismatch, so announcing 07.35
+// works with every 7.x client in use.
+const (
+	magic        = "vrpn: ver. 07.35"
+	magicMajor   = "vrpn: ver. 07"
+	cookieLength = 24
+)
+
+func cookie() []byte {
+	c := make([]byte, cookieLength)
+	copy(c, magic+"  0")
+	return c
+}
+
+func checkCookie(c []byte) error {
+	if !bytes.HasPrefix(c, []byte(magicMajor)) {
+		return fmt.Errorf("vrpn: unexpected cookie %q", bytes.TrimRight(c, "\x00"))
+	}
+	return nil
+}
+
+// Message types VRPN reserves for the connection itself.
+const (
+	typeSenderDescription int32 = -1
+	typeTypeDescription   int32 = -2
+	typeDisconnect        int32 = -5
+)
+
+// The message types this server sends, with the fixed IDs it describes them
+// under at the start of every connection.
+const (
+	typeNamePosQuat = "vrpn_Tracker Pos_Quat"
+	typeNamePing    = "vrpn_Base ping_message"
+	typeNamePong    = "vrpn_Base pong_message"
+
+	typePosQuat int32 = 0
+	typePong    int32 = 1
+)
+
+var localTypes = []string{typeNamePosQuat, typeNamePong}
+
+// headerLength is five big-endian int32 (total length, seconds, microseconds,
+// sender, type) padded to VRPN's eight-byte alignment.
+const (
+	headerLength = 24
+	align        = 8
+)
+
+// maxMessage bounds what a client may send. Clients only send descriptions and
+// pings, so anything large is a protocol error rather than a real message.
+const maxMessage = 64 * 1024
+
+func padded(n int) int { return (n + align - 1) / align * align }
+
+// appendMessage encodes one message. The length field counts the header and
+// the unpadded payload; the payload is then padded to the alignment.
+func appendMessage(b []byte, t time.Time, sender, typ int32, payload []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(headerLength+len(payload)))
+	b = binary.BigEndian.AppendUint32(b, uint32(t.Unix()))
+	b = binary.BigEndian.AppendUint32(b, uint32(t.Nanosecond()/1000))
+	b = binary.BigEndian.AppendUint32(b, uint32(sender))
+	b = binary.BigEndian.AppendUint32(b, uint32(typ))
+	b = append(b, 0, 0, 0, 0)
+	b = append(b, payload...)
+	for len(b)%align != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// description is the payload of a sender or type description: the length of
+// the name including its NUL, then the name and the NUL. The ID being
+// described travels in the header's sender field.
+func description(name string) []byte {
+	p := binary.BigEndian.AppendUint32(nil, uint32(len(name)+1))
+	p = append(p, name...)
+	return append(p, 0)
+}
+
+func parseDescription(p []byte) (string, error) {
+	if len(p) < 4 {
+		return "", errors.New("vrpn: short description")
+	}
+	n := int(binary.BigEndian.Uint32(p))
+	if n < 1 || 4+n > len(p) {
+		return "", errors.New("vrpn: bad description length")
+	}
+	return string(bytes.TrimRight(p[4:4+n], "\x00")), nil
+}
+
+// posQuat is a tracker report: the sensor twice (the second copy is alignment
+// padding in the reference implementation), then position and a quaternion
+// in X, Y, Z, W order, all big-endian float64.
+func posQuat(sensor int32, pos [3]float64, quat [4]float64) []byte {
+	p := make([]byte, 0, 64)
+	p = binary.BigEndian.AppendUint32(p, uint32(sensor))
+	p = binary.BigEndian.AppendUint32(p, uint32(sensor))
+	for _, v := range pos {
+		p = binary.BigEndian.AppendUint64(p, math.Float64bits(v))
+	}
+	for _, v := range quat {
+		p = binary.BigEndian.AppendUint64(p, math.Float64bits(v))
+	}
+	return p
+}
+
+type message struct {
+	sender, typ int32
+	payload     []byte
+}
+
+func readMessage(r io.Reader) (message, error) {
+	var h [headerLength]byte
+	if _, err := io.ReadFull(r, h[:]); err != nil {
+		return message{}, err
+	}
+	n := int(binary.BigEndian.Uint32(h[0:]))
+	if n < headerLength || n > maxMessage {
+		return message{}, fmt.Errorf("vrpn: bad message length %d", n)
+	}
+	m := message{
+		sender: int32(binary.BigEndian.Uint32(h[12:])),
+		typ:    int32(binary.BigEndian.Uint32(h[16:])),
+	}
+	buf := make([]byte, padded(n-headerLength))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return message{}, err
+	}
+	m.payload = buf[:n-headerLength]
+	return m, nil
+}
@@ -0,0 +1,465 @@
+// Package vrpn serves QTM rigid bodies to VRPN clients.
+//
+// Many VR applications only speak VRPN and expect a tracker device per rigid
+// body. Server speaks the VRPN 7 connection protocol itself, so those
+// applications can read QTM directly instead of through a separate VRPN server
+// process: every 6D body becomes a tracker device named after the body, and
+// a client opens it as "<body>@<host>".
+//
+// Only what tracker clients use is implemented: the cookie exchange, sender
+// and type descriptions, position reports and ping replies. All messages go
+// over the TCP connection; a client's offer of a UDP channel for low-latency
+// messages is ignored, which every client accepts.
+package vrpn
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/pkg/fanout"
+	"github.com/mlveggo/qualisys-go/pkg/packets"
+)
+
+// DefaultWriteTimeout bounds how long one frame may take to reach a client.
+const DefaultWriteTimeout = 2 * time.Second
+
+// handshakeTimeout bounds the cookie exchange and the connect-back dial.
+const handshakeTimeout = 5 * time.Second
+
+// Server publishes the 6D bodies of frames from a hub as VRPN trackers.
+//
+// Positions are converted from QTM's millimetres to the metres VRPN clients
+// expect, and rotations to quaternions, but axes are passed through unchanged:
+// QTM's Z-up global frame is what clients see. Bodies QTM is not tracking in a
+// frame are left out of it rather than sent as NaN.
+type Server struct {
+	hub *fanout.Hub
+
+	// Tracker, when set, additionally serves every body as a sensor of one
+	// device with this name, sensor N being body N, for clients that expect
+	// a single multi-sensor tracker.
+	Tracker string
+	// WriteTimeout overrides DefaultWriteTimeout when positive.
+	WriteTimeout time.Duration
+	// Buffer is the per-client queue length; see fanout.Hub.Subscribe.
+	Buffer int
+	// OnError, if set, is told about client connections that ended with an
+	// error. As elsewhere in this SDK, the library never logs by itself.
+	OnError func(remoteAddr string, err error)
+
+	mu        sync.Mutex
+	bodies    []string
+	senders   []string
+	senderIDs map[string]int32
+	dialing   map[string]bool
+}
+
+// NewServer serves bodies from frames published on hub.
+func NewServer(hub *fanout.Hub) *Server {
+	return &Server{hub: hub, senderIDs: make(map[string]int32), dialing: make(map[string]bool)}
+}
+
+// SetNames sets the 6D body names, in settings order, that devices are named
+// after. Bodies without a name are served as "body<N>".
+func (s *Server) SetNames(bodies []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bodies = bodies
+}
+
+// DeviceName is the name a client uses for a body, which is the body name with
+// the characters VRPN gives a meaning to ('@' and whitespace) replaced.
+func DeviceName(body string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '@' || r <= ' ' {
+			return '_'
+		}
+		return r
+	}, body)
+}
+
+// senderID returns the stable ID for a device name. IDs are shared by all
+// connections and only ever grow, so a connection can describe the new ones
+// by comparing counts.
+func (s *Server) senderID(name string) int32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id, ok := s.senderIDs[name]; ok {
+		return id
+	}
+	id := int32(len(s.senders))
+	s.senders = append(s.senders, name)
+	s.senderIDs[name] = id
+	return id
+}
+
+// servedID returns the ID of a device the server serves: one already reported,
+// a named body or the combined tracker. Other names get no ID, so a client
+// cannot add devices every other connection then has to describe.
+func (s *Server) servedID(name string) (int32, bool) {
+	s.mu.Lock()
+	id, ok := s.senderIDs[name]
+	served := name == DeviceName(s.Tracker) && s.Tracker != ""
+	for _, b := range s.bodies {
+		served = served || (b != "" && name == DeviceName(b))
+	}
+	s.mu.Unlock()
+	if ok {
+		return id, true
+	}
+	if served {
+		return s.senderID(name), true
+	}
+	return 0, false
+}
+
+func (s *Server) sendersFrom(n int) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.senders[n:]...)
+}
+
+type report struct {
+	sender int32
+	sensor int32
+	pos    [3]float64
+	quat   [4]float64
+}
+
+// reports extracts the tracker reports for one frame.
+func (s *Server) reports(d *qualisys.DataPacket) []report {
+	type pose struct {
+		point packets.Point
+		rot   packets.Rotation
+	}
+	var poses []pose
+	for _, obj := range d.Components {
+		switch c := obj.(type) {
+		case *packets.Component6D:
+			for _, b := range c.Bodies {
+				poses = append(poses, pose{b.Point, b.Quaternion()})
+			}
+		case *packets.Component6DResidual:
+			for _, b := range c.Bodies {
+				poses = append(poses, pose{b.Point, b.Quaternion()})
+			}
+		case *packets.Component6DEuler:
+			for _, b := range c.Bodies {
+				poses = append(poses, pose{b.Point, b.Quaternion()})
+			}
+		case *packets.Component6DEulerResidual:
+			for _, b := range c.Bodies {
+				poses = append(poses, pose{b.Point, b.Quaternion()})
+			}
+		}
+		if poses != nil {
+			// A frame carrying both a matrix and an Euler variant holds the
+			// same bodies twice; the first one is enough.
+			break
+		}
+	}
+
+	s.mu.Lock()
+	names := s.bodies
+	s.mu.Unlock()
+
+	var out []report
+	for i, p := range poses {
+		if math.IsNaN(float64(p.point.X)) || math.IsNaN(float64(p.rot.W)) {
+			continue
+		}
+		r := report{
+			pos:  [3]float64{float64(p.point.X) / 1000, float64(p.point.Y) / 1000, float64(p.point.Z) / 1000},
+			quat: [4]float64{float64(p.rot.X), float64(p.rot.Y), float64(p.rot.Z), float64(p.rot.W)},
+		}
+		name := fmt.Sprintf("body%d", i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		r.sender = s.senderID(DeviceName(name))
+		out = append(out, r)
+		if s.Tracker != "" {
+			r.sender = s.senderID(DeviceName(s.Tracker))
+			r.sensor = int32(i)
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// Serve accepts clients on tcp, and, when udp is not nil, connection requests
+// on udp, until ctx is cancelled. It closes both when it returns.
+//
+// Clients reach a VRPN server in one of two ways. Newer ones simply connect
+// over TCP. The classic way is a UDP datagram to the server naming a TCP port
+// the client listens on, which the server then connects back to; that is why
+// the UDP socket is needed to serve older applications.
+func (s *Server) Serve(ctx context.Context, tcp net.Listener, udp net.PacketConn) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	// Cancelling on the way out also ends every client connection, so the
+	// wait above cannot outlive Serve's own failure.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stop := context.AfterFunc(ctx, func() {
+		tcp.Close()
+		if udp != nil {
+			udp.Close()
+		}
+	})
+	defer stop()
+
+	if udp != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serveRequests(ctx, udp, &wg)
+		}()
+	}
+
+	for {
+		conn, err := tcp.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handle(ctx, conn)
+		}()
+	}
+}
+
+// serveRequests answers UDP connection requests. A request is the client's
+// host name and TCP port as text, "host port". The server only ever calls back
+// the address the request came from, whatever host it names, so a forged
+// datagram cannot point it at some other machine. Clients repeat the request
+// until the server calls back, so requests for a target already being served
+// are ignored.
+func (s *Server) serveRequests(ctx context.Context, udp net.PacketConn, wg *sync.WaitGroup) {
+	buf := make([]byte, 512)
+	for {
+		n, from, err := udp.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(strings.TrimRight(string(buf[:n]), "\x00"))
+		if len(fields) != 2 {
+			continue
+		}
+		src, ok := from.(*net.UDPAddr)
+		port, err := strconv.ParseUint(fields[1], 10, 16)
+		if !ok || err != nil || port == 0 {
+			continue
+		}
+		target := net.JoinHostPort(src.IP.String(), fields[1])
+
+		s.mu.Lock()
+		busy := s.dialing[target]
+		s.dialing[target] = true
+		s.mu.Unlock()
+		if busy {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				s.mu.Lock()
+				delete(s.dialing, target)
+				s.mu.Unlock()
+			}()
+			d := net.Dialer{Timeout: handshakeTimeout}
+			conn, err := d.DialContext(ctx, "tcp", target)
+			if err != nil {
+				s.report(target, err)
+				return
+			}
+			s.handle(ctx, conn)
+		}()
+	}
+}
+
+func (s *Server) report(remote string, err error) {
+	if err != nil && s.OnError != nil {
+		s.OnError(remote, err)
+	}
+}
+
+func (s *Server) handle(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	err := s.serveConn(conn)
+	if ctx.Err() != nil || errors.Is(err, io.EOF) {
+		err = nil
+	}
+	s.report(conn.RemoteAddr().String(), err)
+}
+
+// client is the state of one connection. Everything except pongs is owned by
+// the goroutine running serveConn.
+type client struct {
+	conn      net.Conn
+	timeout   time.Duration
+	described int
+	buf       []byte
+}
+
+func (c *client) flush() error {
+	if len(c.buf) == 0 {
+		return nil
+	}
+	if err := c.conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(c.buf)
+	c.buf = c.buf[:0]
+	return err
+}
+
+// describeSenders queues descriptions for devices that appeared since the
+// last call. A device must be described before its first message.
+func (c *client) describeSenders(s *Server, now time.Time) {
+	for _, name := range s.sendersFrom(c.described) {
+		c.buf = appendMessage(c.buf, now, int32(c.described), typeSenderDescription, description(name))
+		c.described++
+	}
+}
+
+func (s *Server) serveConn(conn net.Conn) error {
+	timeout := s.WriteTimeout
+	if timeout <= 0 {
+		timeout = DefaultWriteTimeout
+	}
+	c := &client{conn: conn, timeout: timeout}
+
+	// Both sides send their cookie first, then read the other's.
+	if err := conn.SetDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		return err
+	}
+	if _, err := conn.Write(cookie()); err != nil {
+		return err
+	}
+	br := bufio.NewReader(conn)
+	peer := make([]byte, cookieLength)
+	if _, err := io.ReadFull(br, peer); err != nil {
+		return fmt.Errorf("vrpn: read cookie: %w", err)
+	}
+	if err := checkCookie(peer); err != nil {
+		return err
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for id, name := range localTypes {
+		c.buf = appendMessage(c.buf, now, int32(id), typeTypeDescription, description(name))
+	}
+	c.describeSenders(s, now)
+	if err := c.flush(); err != nil {
+		return err
+	}
+
+	sub := s.hub.Subscribe(s.Buffer)
+	defer sub.Close()
+
+	// The reader learns the client's sender and type names, which it needs to
+	// recognise pings, and hands the name of each pinged device to the writer.
+	pings := make(chan string, 16)
+	closed := make(chan error, 1)
+	go func() {
+		closed <- readLoop(br, pings)
+	}()
+
+	for {
+		select {
+		case err := <-closed:
+			return err
+		case name := <-pings:
+			id, ok := s.servedID(name)
+			if !ok {
+				continue
+			}
+			now := time.Now()
+			c.describeSenders(s, now)
+			c.buf = appendMessage(c.buf, now, id, typePong, nil)
+			if err := c.flush(); err != nil {
+				return err
+			}
+		case p, ok := <-sub.C():
+			if !ok {
+				return nil
+			}
+			if p.Type != qualisys.PacketTypeData {
+				continue
+			}
+			reports := s.reports(&p.Data)
+			if len(reports) == 0 {
+				continue
+			}
+			now := time.Now()
+			c.describeSenders(s, now)
+			for _, r := range reports {
+				c.buf = appendMessage(c.buf, now, r.sender, typePosQuat, posQuat(r.sensor, r.pos, r.quat))
+			}
+			if err := c.flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// readLoop reads the client's messages until it disconnects. Sender and type
+// IDs are chosen by each side independently, so the client's pings arrive
+// under its own IDs and must be translated by name.
+func readLoop(r io.Reader, pings chan<- string) error {
+	senders := make(map[int32]string)
+	types := make(map[int32]string)
+	for {
+		m, err := readMessage(r)
+		if err != nil {
+			return err
+		}
+		switch m.typ {
+		case typeSenderDescription:
+			name, err := parseDescription(m.payload)
+			if err != nil {
+				return err
+			}
+			senders[m.sender] = name
+		case typeTypeDescription:
+			name, err := parseDescription(m.payload)
+			if err != nil {
+				return err
+			}
+			types[m.sender] = name
+		case typeDisconnect:
+			return io.EOF
+		default:
+			if types[m.typ] == typeNamePing && senders[m.sender] != "" {
+				select {
+				case pings <- senders[m.sender]:
+				default:
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package vrpn_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	qualisys "github.com/mlveggo/qualisys-go"
+	"github.com/mlveggo/qualisys-go/pkg/fanout"
+	"github.com/mlveggo/qualisys-go/pkg/packets"
+	"github.com/mlveggo/qualisys-go/pkg/vrpn"
+)
+
+// vrpnClient plays the client side of a VRPN connection, just far enough to
+// check what the server sends.
+type vrpnClient struct {
+	t       *testing.T
+	conn    net.Conn
+	senders map[int32]string
+	types   map[int32]string
+}
+
+type msg struct {
+	sender, typ int32
+	payload     []byte
+}
+
+func newClient(t *testing.T, conn net.Conn) *vrpnClient {
+	t.Helper()
+	t.Cleanup(func() { conn.Close() })
+	c := &vrpnClient{t: t, conn: conn, senders: map[int32]string{}, types: map[int32]string{}}
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	cookie := make([]byte, 24)
+	copy(cookie, "vrpn: ver. 07.35  0")
+	if _, err := conn.Write(cookie); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, 24)
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("read cookie: %v", err)
+	}
+	if !bytes.HasPrefix(got, []byte("vrpn: ver. 07.")) {
+		t.Fatalf("cookie = %q", got)
+	}
+	return c
+}
+
+func (c *vrpnClient) send(sender, typ int32, payload []byte) {
+	b := binary.BigEndian.AppendUint32(nil, uint32(24+len(payload)))
+	b = append(b, make([]byte, 8)...) // time
+	b = binary.BigEndian.AppendUint32(b, uint32(sender))
+	b = binary.BigEndian.AppendUint32(b, uint32(typ))
+	b = append(b, make([]byte, 4)...)
+	b = append(b, payload...)
+	for len(b)%8 != 0 {
+		b = append(b, 0)
+	}
+	if _, err := c.conn.Write(b); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func describe(name string) []byte {
+	p := binary.BigEndian.AppendUint32(nil, uint32(len(name)+1))
+	return append(append(p, name...), 0)
+}
+
+// next returns the next message that is not a description, recording the
+// descriptions on the way.
+func (c *vrpnClient) next() msg {
+	c.t.Helper()
+	for {
+		var h [24]byte
+		if _, err := io.ReadFull(c.conn, h[:]); err != nil {
+			c.t.Fatalf("read header: %v", err)
+		}
+		n := int(binary.BigEndian.Uint32(h[:])) - 24
+		body := make([]byte, (n+7)/8*8)
+		if _, err := io.ReadFull(c.conn, body); err != nil {
+			c.t.Fatalf("read body: %v", err)
+		}
+		m := msg{int32(binary.BigEndian.Uint32(h[12:])), int32(binary.BigEndian.Uint32(h[16:])), body[:n]}
+		switch m.typ {
+		case -1:
+			c.senders[m.sender] = string(m.payload[4 : len(m.payload)-1])
+		case -2:
+			c.types[m.sender] = string(m.payload[4 : len(m.payload)-1])
+		default:
+			return m
+		}
+	}
+}
+
+func frame(bodies ...packets.BodyMatrix) *qualisys.Packet {
+	return &qualisys.Packet{Type: qualisys.PacketTypeData, Data: qualisys.DataPacket{
+		Components: []qualisys.IDataObject{&packets.Component6D{Bodies: bodies}},
+	}}
+}
+
+func startServer(t *testing.T, udp net.PacketConn) (*fanout.Hub, *vrpn.Server, net.Listener) {
+	t.Helper()
+	var lc net.ListenConfig
+	ln, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub := fanout.NewHub()
+	srv := vrpn.NewServer(hub)
+	srv.SetNames([]string{"wand", "left hand"})
+	srv.Tracker = "QTM"
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(ctx, ln, udp) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+		hub.Close()
+	})
+	return hub, srv, ln
+}
+
+func waitForSubscriber(t *testing.T, hub *fanout.Hub) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for hub.Len() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("client never subscribed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestTrackerReports(t *testing.T) {
+	hub, _, ln := startServer(t, nil)
+	var d net.Dialer
+	conn, err := d.DialContext(context.Background(), "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newClient(t, conn)
+	waitForSubscriber(t, hub)
+
+	nan := float32(math.NaN())
+	identity := [9]float32{1, 0, 0, 0, 1, 0, 0, 0, 1}
+	hub.Publish(frame(
+		packets.BodyMatrix{Point: packets.Point{X: nan, Y: nan, Z: nan}, Rotation: [9]float32{nan}},
+		packets.BodyMatrix{Point: packets.Point{X: 1000, Y: -500, Z: 250}, Rotation: identity},
+	))
+
+	// The untracked first body is skipped; the second is reported both as
+	// its own device and as sensor 1 of the combined tracker.
+	for _, want := range []struct {
+		device string
+		sensor int32
+	}{{"left_hand", 0}, {"QTM", 1}} {
+		m := c.next()
+		if c.types[m.typ] != "vrpn_Tracker Pos_Quat" {
+			t.Fatalf("message type %q, want a tracker report", c.types[m.typ])
+		}
+		if c.senders[m.sender] != want.device {
+			t.Errorf("device = %q, want %q", c.senders[m.sender], want.device)
+		}
+		if len(m.payload) != 64 {
+			t.Fatalf("payload is %d bytes, want 64", len(m.payload))
+		}
+		if sensor := int32(binary.BigEndian.Uint32(m.payload)); sensor != want.sensor {
+			t.Errorf("sensor = %d, want %d", sensor, want.sensor)
+		}
+		var v [7]float64
+		for i := range v {
+			v[i] = math.Float64frombits(binary.BigEndian.Uint64(m.payload[8+8*i:]))
+		}
+		if v != [7]float64{1, -0.5, 0.25, 0, 0, 0, 1} {
+			t.Errorf("pos/quat = %v, want metres and the identity quaternion", v)
+		}
+	}
+
+	// A ping under the client's own IDs is answered with a pong from the
+	// device of the same name. Pings for devices the server does not serve
+	// go unanswered and add no device.
+	c.send(5, -1, describe("nonexistent"))
+	c.send(7, -1, describe("left_hand"))
+	c.send(3, -2, describe("vrpn_Base ping_message"))
+	c.send(5, 3, nil)
+	c.send(7, 3, nil)
+	m := c.next()
+	if c.types[m.typ] != "vrpn_Base pong_message" || c.senders[m.sender] != "left_hand" {
+		t.Errorf("reply = %q from %q, want a pong from left_hand", c.types[m.typ], c.senders[m.sender])
+	}
+	for _, name := range c.senders {
+		if name == "nonexistent" {
+			t.Error("a ping for an unknown device added it")
+		}
+	}
+}
+
+func TestUDPConnectionRequest(t *testing.T) {
+	var lc net.ListenConfig
+	udp, err := lc.ListenPacket(context.Background(), "udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub, _, _ := startServer(t, udp)
+
+	// The classic client listens and asks the server to call back.
+	back, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer back.Close()
+	var d net.Dialer
+	req, err := d.DialContext(context.Background(), "udp", udp.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer req.Close()
+	// The host named is ignored: the server calls back the request's source.
+	port := back.Addr().(*net.TCPAddr).Port
+	if _, err := fmt.Fprintf(req, "192.0.2.1 %d\x00", port); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := back.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newClient(t, conn)
+	waitForSubscriber(t, hub)
+	hub.Publish(frame(packets.BodyMatrix{Rotation: [9]float32{1, 0, 0, 0, 1, 0, 0, 0, 1}}))
+	if m := c.next(); c.senders[m.sender] != "wand" {
+		t.Errorf("device = %q, want wand", c.senders[m.sender])
+	}
+}
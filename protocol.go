@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"strconv"
 	"time"
@@ -67,7 +68,9 @@ const (
 	// SDK. A camera calibration routinely takes minutes; the previous
 	// hard-coded one second read deadline made Calibrate unusable.
 	DefaultCalibrationTimeout = 10 * time.Minute
-	// DefaultFileTimeout is how long to wait for a C3D or QTM file transfer.
+	// DefaultFileTimeout is how long a C3D or QTM file transfer may stall,
+	// waiting for the file to start or for its next bytes. A long capture
+	// takes as long as it takes.
 	DefaultFileTimeout = 30 * time.Second
 )
 
@@ -91,6 +94,14 @@ var (
 	// ErrVersionNotSupported means QTM rejected every protocol version this
 	// SDK is willing to speak.
 	ErrVersionNotSupported = errors.New("qualisys: no mutually supported protocol version")
+	// ErrRejected means QTM understood the request and refused it, with an
+	// error packet or a response other than the ones that mean success: a
+	// command sent without control, a file that does not exist, a capture
+	// already running. The connection itself is fine.
+	ErrRejected = errors.New("qualisys: rejected by QTM")
+	// ErrInvalidFile means a downloaded capture is not a file of the format
+	// asked for.
+	ErrInvalidFile = errors.New("qualisys: invalid capture file")
 )
 
 const packetHeaderSize = 8
@@ -131,6 +142,19 @@ type Protocol struct {
 	// swallowed while waiting for a command response.
 	lastEvent EventType
 	state     EventType
+
+	metrics Metrics
+	logger  *slog.Logger
+	tracer  Tracer
+	// lastCommand names the most recent command sent, for logging events
+	// that arrive while its response is awaited.
+	lastCommand string
+	// swallowed holds the events read while the response to lastCommand
+	// was awaited, for WaitForEvent.
+	swallowed []Event
+	// download, while a capture download runs, receives the file instead of
+	// the packet buffer, and its context ends every read made for it.
+	download *download
 }
 
 // Option configures a Protocol. Options are applied in NewProtocol.
@@ -247,8 +271,10 @@ func (rt *Protocol) Connect() error {
 	// the form that can carry a context once one is plumbed through the public
 	// API. Connect takes no context today, so this passes a background one.
 	dialer := net.Dialer{Timeout: rt.connectTimeout}
+	rt.log(slog.LevelInfo, "connecting", slog.String("addr", addr))
 	conn, err := dialer.DialContext(context.Background(), "tcp", addr)
 	if err != nil {
+		rt.log(slog.LevelWarn, "connect failed", slog.String("addr", addr), slog.Any("error", err))
 		return fmt.Errorf("connect: dial %s: %w", addr, err)
 	}
 	rt.conn = conn
@@ -260,6 +286,7 @@ func (rt *Protocol) Connect() error {
 	}
 	const qtmConnectedResponse = "QTM RT Interface connected"
 	if p.CommandResponse != qtmConnectedResponse {
+		rt.log(slog.LevelWarn, "unexpected welcome message", slog.String("response", p.CommandResponse))
 		rt.Disconnect()
 		return fmt.Errorf("connect: unexpected welcome message (%q)", p.CommandResponse)
 	}
@@ -267,12 +294,19 @@ func (rt *Protocol) Connect() error {
 	var lastErr error
 	for _, v := range rt.versionCandidates() {
 		if err := rt.SetVersion(v[0], v[1]); err != nil {
+			rt.log(slog.LevelInfo, "protocol version rejected",
+				slog.String("version", fmt.Sprintf("%d.%d", v[0], v[1])), slog.Any("error", err))
 			lastErr = err
 			continue
 		}
+		rt.log(slog.LevelInfo, "connected",
+			slog.String("addr", addr), slog.String("version", fmt.Sprintf("%d.%d", v[0], v[1])))
 		// Prime the cached state the same way the C++ SDK does after a
 		// successful handshake. A failure here is not fatal.
 		_, _ = rt.GetState()
+		if rt.metrics != nil {
+			rt.metrics.Connected()
+		}
 		return nil
 	}
 
@@ -280,6 +314,7 @@ func (rt *Protocol) Connect() error {
 	if lastErr == nil {
 		lastErr = ErrVersionNotSupported
 	}
+	rt.log(slog.LevelWarn, "no mutually supported protocol version", slog.String("addr", addr))
 	return fmt.Errorf("connect: %w (tried %d.%d down to %d.%d): %v",
 		ErrVersionNotSupported, rt.wantMajor, rt.wantMinor,
 		DefaultMajorVersion, MinSupportedMinorVersion, lastErr)
@@ -301,6 +336,7 @@ func (rt *Protocol) Disconnect() {
 	}
 	rt.conn.Close()
 	rt.conn = nil
+	rt.log(slog.LevelInfo, "disconnected")
 	rt.majorVersion = 0
 	rt.minorVersion = 0
 }
@@ -328,6 +364,14 @@ func (rt *Protocol) LocalAddr() net.Addr {
 	return rt.conn.LocalAddr()
 }
 
+// RemoteAddr returns the address of QTM at the far end of the TCP connection.
+func (rt *Protocol) RemoteAddr() net.Addr {
+	if rt.conn == nil {
+		return nil
+	}
+	return rt.conn.RemoteAddr()
+}
+
 // State returns the most recent event QTM reported, including events observed
 // while waiting for a command response.
 func (rt *Protocol) State() EventType {
@@ -335,11 +379,22 @@ func (rt *Protocol) State() EventType {
 }
 
 // setReadDeadline applies d, or clears the deadline when d is zero or negative.
+// While a download whose context is done runs, the deadline is now instead, so
+// the read returns at once.
 func (rt *Protocol) setReadDeadline(d time.Duration) error {
-	if d <= 0 {
-		return rt.conn.SetReadDeadline(time.Time{})
+	deadline := time.Time{}
+	if d > 0 {
+		deadline = time.Now().Add(d)
 	}
-	return rt.conn.SetReadDeadline(time.Now().Add(d))
+	if err := rt.conn.SetReadDeadline(deadline); err != nil {
+		return err
+	}
+	// Checked after the deadline is set: a cancellation from then on has
+	// the download's AfterFunc move the deadline itself.
+	if rt.download != nil && rt.download.ctx.Err() != nil {
+		return rt.conn.SetReadDeadline(time.Now())
+	}
+	return nil
 }
 
 func isTimeout(err error) bool {
@@ -366,6 +421,13 @@ func (rt *Protocol) Receive() (*Packet, error) {
 // ReceiveTimeout reads the next packet, waiting at most d for it to start
 // arriving. A non-positive d blocks indefinitely.
 func (rt *Protocol) ReceiveTimeout(d time.Duration) (*Packet, error) {
+	p, err := rt.receive(d)
+	rt.observeReceive(p, err)
+	rt.logReceive(p, err, "tcp")
+	return p, err
+}
+
+func (rt *Protocol) receive(d time.Duration) (*Packet, error) {
 	if !rt.IsConnected() {
 		return &Packet{Type: PacketTypeNone}, ErrNotConnected
 	}
@@ -394,6 +456,11 @@ func (rt *Protocol) ReceiveTimeout(d time.Duration) (*Packet, error) {
 		return &Packet{Type: PacketTypeNone},
 			fmt.Errorf("receive: invalid packet size %d", size)
 	}
+	// A capture download streams the file to its writer, however large it
+	// is, rather than into the buffer.
+	if rt.download != nil && (ptype == PacketTypeC3DFile || ptype == PacketTypeQTMFile) {
+		return rt.download.receive(rt, size, ptype)
+	}
 	if size > rt.maxPacketSize {
 		return &Packet{Type: PacketTypeNone},
 			fmt.Errorf("receive: packet size %d exceeds limit %d", size, rt.maxPacketSize)
@@ -402,6 +469,7 @@ func (rt *Protocol) ReceiveTimeout(d time.Duration) (*Packet, error) {
 	// A header-only packet carries no payload; PacketTypeNoMoreData arrives
 	// this way.
 	if size == packetHeaderSize {
+		rt.traceTCP(DirectionReceived, rt.buffer[:size])
 		return &Packet{Size: size, Type: ptype, order: rt.order}, nil
 	}
 
@@ -425,6 +493,8 @@ func (rt *Protocol) ReceiveTimeout(d time.Duration) (*Packet, error) {
 		return &Packet{Type: PacketTypeNone}, fmt.Errorf("receive: read body: %w", err)
 	}
 
+	rt.traceTCP(DirectionReceived, rt.buffer[:size])
+
 	p := &Packet{order: rt.order}
 	if err := p.UnmarshalBinary(rt.buffer[:size]); err != nil {
 		return &Packet{Type: PacketTypeNone}, fmt.Errorf("receive: unmarshal: %w", err)
@@ -440,7 +510,7 @@ func (rt *Protocol) ReceiveTimeout(d time.Duration) (*Packet, error) {
 	}
 
 	if p.Type == PacketTypeError {
-		return p, fmt.Errorf("receive: error packet returned (%s)", p.ErrorResponse)
+		return p, fmt.Errorf("receive: %w: error packet returned (%s)", ErrRejected, p.ErrorResponse)
 	}
 	return p, nil
 }
@@ -469,8 +539,12 @@ func (rt *Protocol) receiveSkippingEvents(timeout time.Duration) (*Packet, error
 		}
 		switch p.Type {
 		case PacketTypeEvent:
+			rt.swallowEvent(p)
 			continue
 		case PacketTypeNoMoreData:
+			if rt.download != nil && rt.download.ctx.Err() != nil {
+				return p, fmt.Errorf("download abandoned: %w", rt.download.ctx.Err())
+			}
 			if timeout > 0 && !time.Now().Before(deadline) {
 				return p, ErrTimeout
 			}
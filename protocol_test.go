@@ -1,15 +1,21 @@
 package qualisys
 
 import (
+	"bytes"
 	"context"
 	"encoding/binary"
 	"errors"
 	"io"
+	"log/slog"
 	"net"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/mlveggo/qualisys-go/pkg/discover"
 )
 
 // dialTest and listenTest wrap the context-aware net APIs the linter requires.
@@ -560,3 +566,569 @@ func TestReceiveReturnsNonNilPacketOnError(t *testing.T) {
 	}
 	_ = p.EndOfData()
 }
+
+// recordingMetrics keeps what a Protocol reported to it.
+type recordingMetrics struct {
+	connects int
+	packets  []PacketType
+	failures []error
+	commands []string
+}
+
+func (m *recordingMetrics) Connected()               { m.connects++ }
+func (m *recordingMetrics) PacketReceived(p *Packet) { m.packets = append(m.packets, p.Type) }
+func (m *recordingMetrics) ReceiveFailed(err error)  { m.failures = append(m.failures, err) }
+func (m *recordingMetrics) CommandCompleted(command string, _ time.Duration, err error) {
+	if err != nil {
+		command += " failed"
+	}
+	m.commands = append(m.commands, command)
+}
+
+func TestWithMetricsReportsCommandsAndPackets(t *testing.T) {
+	f := newFakeQTM(t)
+	accept := acceptVersion(1, 25)
+	f.handler = func(cmd string) []byte {
+		if cmd == "TakeControl secret" {
+			return commandPacket("You are now master")
+		}
+		return accept(cmd)
+	}
+	f.start()
+
+	m := &recordingMetrics{}
+	rt := NewProtocol("127.0.0.1", f.basePort(), WithMetrics(m))
+	if err := rt.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer rt.Disconnect()
+	if err := rt.TakeControl("secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	if m.connects != 1 {
+		t.Errorf("Connected called %d times, want 1", m.connects)
+	}
+	// Rejected versions are failed commands; the password is never reported.
+	want := []string{"Version failed", "Version failed", "Version failed", "Version", "GetState", "TakeControl"}
+	if strings.Join(m.commands, ",") != strings.Join(want, ",") {
+		t.Errorf("commands = %q, want %q", m.commands, want)
+	}
+	// Welcome, four version replies, the state event and TakeControl's reply.
+	if len(m.packets) != 7 || m.packets[1] != PacketTypeError || m.packets[5] != PacketTypeEvent {
+		t.Errorf("packets = %v", m.packets)
+	}
+	if len(m.failures) != 0 {
+		t.Errorf("failures = %v", m.failures)
+	}
+
+	// Polling after a disconnect is not a receive failure, but a command that
+	// cannot be sent is a failed command.
+	rt.Disconnect()
+	_, _ = rt.Receive()
+	if _, err := rt.SendCommand("GetState"); err == nil {
+		t.Fatal("SendCommand succeeded while disconnected")
+	}
+	if len(m.failures) != 0 {
+		t.Errorf("failures after disconnect = %v", m.failures)
+	}
+	if last := m.commands[len(m.commands)-1]; last != "GetState failed" {
+		t.Errorf("last command = %q, want the failed send", last)
+	}
+}
+
+func TestWithLoggerRecordsHandshakeAndCommands(t *testing.T) {
+	f := newFakeQTM(t)
+	accept := acceptVersion(1, 25)
+	f.handler = func(cmd string) []byte {
+		if cmd == "TakeControl secret" {
+			// An event arriving ahead of the reply is swallowed by the SDK.
+			return append(eventPacket(EventTypeCaptureStarted), commandPacket("You are now master")...)
+		}
+		return accept(cmd)
+	}
+	f.start()
+
+	var buf strings.Builder
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	rt := NewProtocol("127.0.0.1", f.basePort(), WithLogger(logger))
+	if err := rt.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	if err := rt.TakeControl("secret"); err != nil {
+		t.Fatal(err)
+	}
+	rt.Disconnect()
+
+	out := buf.String()
+	for _, want := range []string{
+		"level=INFO msg=connecting",
+		`level=INFO msg="protocol version rejected" version=1.28`,
+		`level=INFO msg=connected addr=127.0.0.1:`,
+		"version=1.25",
+		`level=DEBUG msg="command sent" command="Version 1.25"`,
+		`level=DEBUG msg="response received" response="Version set to 1.25"`,
+		`level=DEBUG msg="command sent" command="TakeControl ***"`,
+		`level=DEBUG msg="event swallowed while waiting for response" event=CaptureStarted command=TakeControl`,
+		"level=INFO msg=disconnected",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log lacks %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "secret") {
+		t.Errorf("password was logged:\n%s", out)
+	}
+}
+
+type tracedPacket struct {
+	dir     Direction
+	network string
+	data    string
+}
+
+type recordingTracer struct{ packets []tracedPacket }
+
+func (r *recordingTracer) TracePacket(_ time.Time, dir Direction, network string, _, _ net.Addr, data []byte) {
+	r.packets = append(r.packets, tracedPacket{dir, network, string(data)})
+}
+
+func TestWithTracerSeesRawPackets(t *testing.T) {
+	f := newFakeQTM(t)
+	f.handler = acceptVersion(DefaultMajorVersion, DefaultMinorVersion)
+	f.start()
+
+	tr := &recordingTracer{}
+	rt := NewProtocol("127.0.0.1", f.basePort(), WithTracer(tr))
+	if err := rt.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer rt.Disconnect()
+
+	want := []tracedPacket{
+		{DirectionReceived, "tcp", string(commandPacket("QTM RT Interface connected"))},
+		{DirectionSent, "tcp", string(commandPacket("Version 1.28"))},
+		{DirectionReceived, "tcp", string(commandPacket("Version set to 1.28"))},
+		{DirectionSent, "tcp", string(commandPacket("GetState"))},
+		{DirectionReceived, "tcp", string(eventPacket(EventTypeConnected))},
+	}
+	if len(tr.packets) != len(want) {
+		t.Fatalf("traced %d packets, want %d", len(tr.packets), len(want))
+	}
+	for i := range want {
+		if tr.packets[i] != want[i] {
+			t.Errorf("packet %d = %+v, want %+v", i, tr.packets[i], want[i])
+		}
+	}
+}
+
+func TestDialConnectsToSelectedQTM(t *testing.T) {
+	f := newFakeQTM(t)
+	f.handler = acceptVersion(DefaultMajorVersion, DefaultMinorVersion)
+	f.start()
+
+	// Two simulated hosts answer discovery; only one has enough cameras.
+	var targets []string
+	for _, r := range []discover.Response{
+		{Hostname: "small", QtmVersion: "QTM 2025.1", Cameras: 2, BasePort: 1},
+		{Hostname: "big", QtmVersion: "QTM 2025.1", Cameras: 16, BasePort: f.basePort()},
+	} {
+		var lc net.ListenConfig
+		conn, err := lc.ListenPacket(context.Background(), "udp4", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		responder, err := discover.NewResponder(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		go func() { _ = responder.Serve(ctx, conn) }()
+		targets = append(targets, conn.LocalAddr().String())
+	}
+	d := discover.NewDiscovery(0, 200*time.Millisecond)
+	d.Targets = targets
+
+	if _, err := Dial(context.Background(), d, discover.Selector{}); !errors.Is(err, discover.ErrAmbiguous) {
+		t.Fatalf("dial without a selector: %v", err)
+	}
+	rt, err := Dial(context.Background(), d, discover.Selector{MinCameras: 8})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer rt.Disconnect()
+	if !rt.IsConnected() {
+		t.Error("not connected")
+	}
+
+	// An address skips discovery altogether.
+	direct, err := DialAddr(context.Background(), "127.0.0.1", f.basePort(), discover.Selector{Hostname: "elsewhere"})
+	if err != nil {
+		t.Fatalf("dial address: %v", err)
+	}
+	defer direct.Disconnect()
+	if got := direct.RemoteAddr().String(); got != net.JoinHostPort("127.0.0.1", itoa(f.basePort()+1)) {
+		t.Errorf("remote address %s", got)
+	}
+}
+
+func TestRefusedCommandsAreErrRejected(t *testing.T) {
+	f := newFakeQTM(t)
+	f.handler = func(cmd string) []byte {
+		switch {
+		case strings.HasPrefix(cmd, "Version "):
+			return commandPacket("Version set to 1.28")
+		case cmd == "GetState":
+			return eventPacket(EventTypeConnected)
+		case strings.HasPrefix(cmd, "TakeControl"):
+			return commandPacket("Wrong or missing password")
+		case strings.HasPrefix(cmd, "GetParameters"):
+			return errorPacket("Parse error")
+		}
+		return nil
+	}
+	f.start()
+
+	rt := NewProtocol("127.0.0.1", f.basePort())
+	if err := rt.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer rt.Disconnect()
+
+	if err := rt.TakeControl("guess"); !errors.Is(err, ErrRejected) {
+		t.Errorf("TakeControl: %v", err)
+	}
+	if _, err := rt.GetParameters(ParameterTypeGeneral); !errors.Is(err, ErrRejected) {
+		t.Errorf("GetParameters: %v", err)
+	}
+	if !rt.IsConnected() {
+		t.Error("a refused command dropped the connection")
+	}
+}
+
+func TestWaitForEvent(t *testing.T) {
+	f := newFakeQTM(t)
+	emptyFrame := encodePacket(PacketTypeData, make([]byte, 16))
+	f.handler = func(cmd string) []byte {
+		switch {
+		case strings.HasPrefix(cmd, "Version "):
+			return commandPacket("Version set to 1.28")
+		case cmd == "GetState":
+			return eventPacket(EventTypeConnected)
+		case cmd == "Start":
+			// The event overtakes the reply, so Start swallows it.
+			return append(eventPacket(EventTypeCaptureStarted), commandPacket("Starting measurement")...)
+		case cmd == "Stop":
+			return append(eventPacket(EventTypeCaptureStopped), commandPacket("Stopping measurement")...)
+		case cmd == "Trig":
+			// Frames are streaming while the trigger arrives.
+			out := commandPacket("Trig ok")
+			out = append(out, emptyFrame...)
+			out = append(out, emptyFrame...)
+			return append(out, eventPacket(EventTypeTrigger)...)
+		}
+		return nil
+	}
+	f.start()
+
+	rt := NewProtocol("127.0.0.1", f.basePort())
+	if err := rt.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer rt.Disconnect()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	before := time.Now()
+	if err := rt.Start(false); err != nil {
+		t.Fatal(err)
+	}
+	e, err := rt.WaitForEvent(ctx, EventTypeCaptureStarted)
+	if err != nil || e.Type != EventTypeCaptureStarted || e.Time.Before(before) {
+		t.Errorf("after Start: %+v, %v", e, err)
+	}
+
+	if err := rt.Trig(); err != nil {
+		t.Fatal(err)
+	}
+	e, err = rt.WaitForEventFunc(ctx, func(e EventType) bool {
+		return e == EventTypeTrigger || e == EventTypeCaptureStopped
+	})
+	if err != nil || e.Type != EventTypeTrigger {
+		t.Errorf("after Trig: %+v, %v", e, err)
+	}
+
+	// The CaptureStopped swallowed by Stop is stale once Trig is sent.
+	if err := rt.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.Trig(); err != nil {
+		t.Fatal(err)
+	}
+	short, cancelShort := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancelShort()
+	_, err = rt.WaitForEvent(short, EventTypeCaptureStopped)
+	if !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("stale event: %v, want a timeout", err)
+	}
+}
+
+func TestCalibrateWaitsForResult(t *testing.T) {
+	f := newFakeQTM(t)
+	f.handler = func(cmd string) []byte {
+		switch {
+		case strings.HasPrefix(cmd, "Version "):
+			return commandPacket("Version set to 1.28")
+		case cmd == "GetState":
+			return eventPacket(EventTypeConnected)
+		case cmd == "Calibrate":
+			out := commandPacket("Starting calibration")
+			out = append(out, eventPacket(EventTypeCalibrationStarted)...)
+			out = append(out, eventPacket(EventTypeCalibrationStopped)...)
+			return append(out, xmlPacket("<calibration/>")...)
+		case cmd == "Calibrate Refine":
+			return append(commandPacket("Starting calibration"), eventPacket(EventTypeQTMShuttingDown)...)
+		}
+		return nil
+	}
+	f.start()
+
+	rt := NewProtocol("127.0.0.1", f.basePort())
+	if err := rt.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer rt.Disconnect()
+
+	doc, err := rt.Calibrate(false, 2*time.Second)
+	if err != nil || doc != "<calibration/>" {
+		t.Fatalf("calibrate: %q, %v", doc, err)
+	}
+	// Events read while waiting for the result are still there to wait for.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if e, err := rt.WaitForEvent(ctx, EventTypeCalibrationStopped); err != nil || e.Type != EventTypeCalibrationStopped {
+		t.Errorf("after calibrate: %+v, %v", e, err)
+	}
+
+	if _, err := rt.Calibrate(true, 2*time.Second); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("QTM shutting down mid-calibration: %v", err)
+	}
+}
+
+// captureQTM answers the capture download commands with the given replies,
+// and Trig, to show the connection is still usable afterwards.
+func captureQTM(t *testing.T, replies map[string][]byte) *fakeQTM {
+	f := newFakeQTM(t)
+	f.handler = func(cmd string) []byte {
+		switch {
+		case strings.HasPrefix(cmd, "Version "):
+			return commandPacket("Version set to 1.28")
+		case cmd == "GetState":
+			return eventPacket(EventTypeConnected)
+		case cmd == "Trig":
+			return commandPacket("Trig ok")
+		}
+		if reply, ok := replies[cmd]; ok {
+			return append(commandPacket("Sending capture"), reply...)
+		}
+		return errorPacket("Parse error")
+	}
+	f.start()
+	return f
+}
+
+func TestDownloadCaptureStreamsPastPacketLimit(t *testing.T) {
+	c3d := make([]byte, 600_000)
+	for i := range c3d {
+		c3d[i] = byte(i)
+	}
+	c3d[0], c3d[1] = 2, 0x50
+	f := captureQTM(t, map[string][]byte{
+		"GetCaptureC3D": append(eventPacket(EventTypeCaptureSaved), encodePacket(PacketTypeC3DFile, c3d)...),
+		"GetCaptureQTM": encodePacket(PacketTypeQTMFile, []byte("qtm file")),
+	})
+	rt := NewProtocol("127.0.0.1", f.basePort(), WithMaxPacketSize(64<<10))
+	if err := rt.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer rt.Disconnect()
+
+	var b bytes.Buffer
+	var calls int
+	var last [2]int64
+	n, err := rt.DownloadCaptureC3D(context.Background(), &b, DownloadOptions{
+		Progress: func(received, total int64) { calls++; last = [2]int64{received, total} },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(c3d)) || !bytes.Equal(b.Bytes(), c3d) {
+		t.Errorf("downloaded %d bytes, want %d", n, len(c3d))
+	}
+	if calls < 2 || last != [2]int64{int64(len(c3d)), int64(len(c3d))} {
+		t.Errorf("%d progress calls, last %v", calls, last)
+	}
+
+	path := filepath.Join(t.TempDir(), "walk.qtm")
+	if err := rt.SaveCaptureQTM(path); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(path); err != nil || string(got) != "qtm file" {
+		t.Errorf("saved %q, %v", got, err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("%d files left beside the capture", len(entries))
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestDownloadCaptureFailuresKeepConnection(t *testing.T) {
+	notC3D := make([]byte, 1024)
+	f := captureQTM(t, map[string][]byte{
+		"GetCaptureC3D": encodePacket(PacketTypeC3DFile, notC3D),
+		"GetCaptureQTM": encodePacket(PacketTypeQTMFile, []byte("qtm file")),
+	})
+	rt := NewProtocol("127.0.0.1", f.basePort())
+	if err := rt.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer rt.Disconnect()
+
+	path := filepath.Join(t.TempDir(), "walk.c3d")
+	if err := rt.SaveCaptureC3D(path); !errors.Is(err, ErrInvalidFile) {
+		t.Errorf("invalid C3D: %v", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("invalid capture saved: %v", err)
+	}
+	// A failed download leaves an earlier file of the same name alone.
+	if err := os.WriteFile(path, []byte("earlier"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rt.DownloadCaptureC3DFile(context.Background(), path, DownloadOptions{}); !errors.Is(err, ErrInvalidFile) {
+		t.Errorf("invalid C3D over an existing file: %v", err)
+	}
+	if got, err := os.ReadFile(path); err != nil || string(got) != "earlier" {
+		t.Errorf("existing file now %q, %v", got, err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("%d files left beside the capture", len(entries))
+	}
+	if _, err := rt.DownloadCaptureQTM(context.Background(), failingWriter{}, DownloadOptions{}); err == nil || !strings.Contains(err.Error(), "disk full") {
+		t.Errorf("failing writer: %v", err)
+	}
+	if err := rt.Trig(); err != nil {
+		t.Errorf("connection out of step after failed downloads: %v", err)
+	}
+}
+
+func TestDownloadCaptureStallIsTruncated(t *testing.T) {
+	stalled := encodePacket(PacketTypeQTMFile, make([]byte, 1000))[:100]
+	f := captureQTM(t, map[string][]byte{"GetCaptureQTM": stalled})
+	rt := NewProtocol("127.0.0.1", f.basePort())
+	if err := rt.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer rt.Disconnect()
+
+	var b bytes.Buffer
+	_, err := rt.DownloadCaptureQTM(context.Background(), &b, DownloadOptions{IdleTimeout: 50 * time.Millisecond})
+	if !errors.Is(err, ErrTruncated) || !errors.Is(err, ErrTimeout) {
+		t.Errorf("got %v, want ErrTruncated and ErrTimeout", err)
+	}
+	if b.Len() != 92 {
+		t.Errorf("wrote %d bytes before the stall, want 92", b.Len())
+	}
+	if rt.IsConnected() {
+		t.Error("still connected after a truncated download")
+	}
+}
+
+func TestDownloadCaptureCancelUnblocksRead(t *testing.T) {
+	stalled := encodePacket(PacketTypeQTMFile, make([]byte, 1000))[:100]
+	f := captureQTM(t, map[string][]byte{"GetCaptureQTM": stalled})
+	tr := &recordingTracer{}
+	rt := NewProtocol("127.0.0.1", f.basePort(), WithTracer(tr))
+	if err := rt.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer rt.Disconnect()
+
+	// Cancel while the read for the rest of the file is blocked.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	time.AfterFunc(100*time.Millisecond, cancel)
+	var b bytes.Buffer
+	start := time.Now()
+	_, err := rt.DownloadCaptureQTM(ctx, &b, DownloadOptions{IdleTimeout: time.Minute})
+	if !errors.Is(err, ErrTruncated) || !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want ErrTruncated and context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("cancelled download returned after %v", elapsed)
+	}
+	if rt.IsConnected() {
+		t.Error("still connected after a cancelled download")
+	}
+	// The file's header is traced, its contents are not.
+	last := tr.packets[len(tr.packets)-1]
+	if last.dir != DirectionReceived || last.data != string(stalled[:packetHeaderSize]) {
+		t.Errorf("last traced packet %+v, want the file header", last)
+	}
+}
+
+func TestDownloadCaptureCancelBeforeFile(t *testing.T) {
+	// QTM acknowledges the command but the file never starts.
+	f := captureQTM(t, map[string][]byte{"GetCaptureC3D": nil})
+	rt := NewProtocol("127.0.0.1", f.basePort())
+	if err := rt.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer rt.Disconnect()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := rt.DownloadCaptureC3D(ctx, io.Discard, DownloadOptions{IdleTimeout: time.Minute})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("cancelled download returned after %v", elapsed)
+	}
+	if rt.IsConnected() {
+		t.Error("still connected with the file still to come")
+	}
+	if _, err := rt.DownloadCaptureC3D(ctx, io.Discard, DownloadOptions{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("download with a cancelled context: %v", err)
+	}
+}
+
+func TestGetCaptureKeepsPacketLimit(t *testing.T) {
+	c3d := make([]byte, 100_000)
+	c3d[1] = 0x50
+	f := captureQTM(t, map[string][]byte{
+		"GetCaptureC3D": encodePacket(PacketTypeC3DFile, c3d),
+		"GetCaptureQTM": encodePacket(PacketTypeQTMFile, []byte("qtm file")),
+	})
+	rt := NewProtocol("127.0.0.1", f.basePort(), WithMaxPacketSize(64<<10))
+	if err := rt.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer rt.Disconnect()
+
+	if fp, err := rt.GetCaptureQTM(); err != nil || string(fp.File) != "qtm file" {
+		t.Fatalf("within the limit: %v", err)
+	}
+	if _, err := rt.GetCaptureC3D(); err == nil || !strings.Contains(err.Error(), "exceeds limit") {
+		t.Errorf("over the limit: %v", err)
+	}
+	if rt.IsConnected() {
+		t.Error("still connected with the rest of the file unread")
+	}
+}
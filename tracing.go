@@ -0,0 +1,37 @@
+package qualisys
+
+import (
+	"net"
+	"time"
+)
+
+//go:generate stringer -type Direction -trimprefix Direction
+type Direction uint8
+
+const (
+	DirectionSent Direction = iota + 1
+	DirectionReceived
+)
+
+// Tracer receives a copy of every packet a Protocol sends or receives, over
+// TCP and UDP, exactly as it crossed the wire and before any decoding, so
+// packets the SDK fails to decode are traced too. data is only valid for the
+// duration of the call. File packets from capture downloads are the exception:
+// only their 8 byte header, which carries the file's size, is traced, as the
+// file can run to gigabytes. Package trace provides a Tracer that records to a file
+// and converts recordings to pcapng.
+type Tracer interface {
+	TracePacket(t time.Time, dir Direction, network string, local, remote net.Addr, data []byte)
+}
+
+// WithTracer sends a copy of every packet to t, except for the contents of
+// downloaded capture files, of which only the header is sent.
+func WithTracer(t Tracer) Option {
+	return func(p *Protocol) { p.tracer = t }
+}
+
+func (rt *Protocol) traceTCP(dir Direction, data []byte) {
+	if rt.tracer != nil && rt.conn != nil {
+		rt.tracer.TracePacket(time.Now(), dir, "tcp", rt.conn.LocalAddr(), rt.conn.RemoteAddr(), data)
+	}
+}